@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// adminServer serves the debug endpoints on their own port, so they
+// never have to be reachable from wherever the public API is
+func (app *application) adminServer() *http.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("POST /debug/dump", app.dumpHandler)
+	mux.HandleFunc("GET /v1/admin/routes", app.listRoutesHandler)
+	mux.HandleFunc("GET /v1/api-keys", app.listAPIKeysHandler)
+	mux.HandleFunc("POST /v1/api-keys", app.createAPIKeyHandler)
+	mux.HandleFunc("PATCH /v1/api-keys/{id}", app.updateAPIKeyPlanHandler)
+	mux.HandleFunc("GET /v1/api-keys/{id}/usage", app.adminAPIKeyUsageHandler)
+	mux.HandleFunc("DELETE /v1/api-keys/{id}", app.deleteAPIKeyHandler)
+	mux.HandleFunc("GET /v1/tenants", app.listTenantsHandler)
+	mux.HandleFunc("POST /v1/tenants", app.createTenantHandler)
+	mux.HandleFunc("POST /v1/admin/backup", app.createBackupHandler)
+	mux.HandleFunc("POST /v1/admin/restore", app.restoreBackupHandler)
+	mux.HandleFunc("GET /v1/admin/operations/{id}", app.showAdminOperationHandler)
+	mux.HandleFunc("GET /v1/admin/explain/{shape}", app.explainMoviesHandler)
+
+	return &http.Server{
+		Addr:        fmt.Sprintf(":%d", app.config.admin.port),
+		Handler:     app.recoverPanic(app.requireAdmin(mux)),
+		IdleTimeout: time.Minute,
+		ReadTimeout: 5 * time.Second,
+		// CPU profiles and traces take ?seconds=30 by default
+		WriteTimeout: 2 * time.Minute,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+}
+
+// requireAdmin checks basic auth credentials against -admin-user/-admin-password.
+// Both sides are hashed first so the comparison takes the same time for any input
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	wantUser := sha256.Sum256([]byte(app.config.admin.user))
+	wantPassword := sha256.Sum256([]byte(app.config.admin.password))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			gotUser := sha256.Sum256([]byte(user))
+			gotPassword := sha256.Sum256([]byte(password))
+
+			userMatch := subtle.ConstantTimeCompare(gotUser[:], wantUser[:]) == 1
+			passwordMatch := subtle.ConstantTimeCompare(gotPassword[:], wantPassword[:]) == 1
+
+			if userMatch && passwordMatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		app.invalidCredentialsResponse(w, r)
+	})
+}
+
+// listRoutesHandler shows what this binary actually serves on the public port
+func (app *application) listRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"routes": app.routeInfo()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dumpHandler writes a goroutine and a heap dump to -admin-dump-dir.
+// Unlike /debug/pprof they stay on disk, so they can be compared later
+func (app *application) dumpHandler(w http.ResponseWriter, r *http.Request) {
+	stamp := time.Now().UTC().Format("20060102T150405")
+
+	// Heap profile only includes up to the last GC
+	runtime.GC()
+
+	dumps := map[string]string{}
+
+	for _, profile := range []struct {
+		name  string
+		debug int
+		ext   string
+	}{
+		{"goroutine", 2, "txt"}, // full stacks as text, like an unrecovered panic prints them
+		{"heap", 0, "pprof"},
+	} {
+		path := filepath.Join(app.config.admin.dumpDir, fmt.Sprintf("%s-%s.%s", profile.name, stamp, profile.ext))
+
+		err := writeProfile(path, profile.name, profile.debug)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		dumps[profile.name] = path
+	}
+
+	app.logger.Info("wrote debug dumps", "goroutine", dumps["goroutine"], "heap", dumps["heap"])
+
+	err := app.writeJSON(w, r, http.StatusCreated, envelope{"dumps": dumps}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func writeProfile(path, name string, debug int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = rpprof.Lookup(name).WriteTo(f, debug)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
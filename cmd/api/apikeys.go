@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+type contextKey string
+
+const apiKeyContextKey = contextKey("apiKey")
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns nil for requests without an API key
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// Routes need the "read" scope for GET and "write" for everything else
+// unless they're listed here. Paths leave out the version prefix,
+// "" means the route doesn't look at API keys at all
+var apiKeyRouteScopes = map[string]string{
+	"GET /healthcheck":              "",
+	"GET /readiness":                "",
+	"GET /healthz":                  "",
+	"GET /readyz":                   "",
+	"PATCH /admin/genres/{id}":      "admin",
+	"POST /admin/genres/{id}/merge": "admin",
+}
+
+// apiKeyUsed is true for the routes that look at API keys
+func (app *application) apiKeyUsed(method, path string) bool {
+	_, ok := app.apiKeyScope(method, path)
+	return ok
+}
+
+func (app *application) apiKeyScope(method, path string) (string, bool) {
+	scope, listed := apiKeyRouteScopes[method+" "+path]
+	if !listed {
+		scope = "write"
+		if method == http.MethodGet {
+			scope = "read"
+		}
+	}
+
+	return scope, scope != ""
+}
+
+// authenticateAPIKey checks the X-API-Key header. Requests without one
+// go through anonymously unless -api-keys-required is set, except on
+// routes that need more than read or write. Those always need a key,
+// otherwise leaving the header out would be more than any key can do.
+// Keys only work for the tenant they were created for
+func (app *application) authenticateAPIKey(scope string, next http.Handler) http.Handler {
+	keyRequired := app.config.apiKeys.required || (scope != "read" && scope != "write")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plaintext := r.Header.Get("X-API-Key")
+
+		if plaintext == "" {
+			if keyRequired {
+				app.apiKeyRequiredResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := app.models.APIKeys.GetByPlaintext(plaintext)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAPIKeyResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if tenant := app.contextGetTenant(r); tenant != nil && key.TenantID != tenant.ID {
+			app.invalidAPIKeyResponse(w, r)
+			return
+		}
+
+		if !slices.Contains(key.Scopes, scope) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetAPIKey(r, key))
+	})
+}
+
+// The API key handlers are served on the admin port, there are no users
+// on the public API who could own the keys
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Tenant    string   `json:"tenant"` // slug, the default tenant when empty
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit int      `json:"rate_limit"`
+		Plan      string   `json:"plan"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:      input.Name,
+		Scopes:    input.Scopes,
+		RateLimit: input.RateLimit,
+		Plan:      input.Plan,
+	}
+
+	if key.Plan == "" {
+		key.Plan = data.DefaultAPIKeyPlan
+	}
+
+	if input.Tenant == "" {
+		input.Tenant = data.DefaultTenantSlug
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tenant, err := app.models.Tenants.GetBySlug(input.Tenant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("tenant", "must be an existing tenant")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	key.TenantID = tenant.ID
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// This is the only time the plaintext key is ever shown
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateAPIKeyPlanHandler is for billing, moving a key between plans is
+// the only change a key can take
+func (app *application) updateAPIKeyPlanHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	var input struct {
+		Plan string `json:"plan"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKeyPlan(v, input.Plan); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.UpdatePlan(id, input.Plan)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "api key plan successfully updated"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
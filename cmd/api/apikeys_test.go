@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"greenlight.brainwhat/internal/data"
+)
+
+// insertTestAPIKey adds a key to tenantID and returns its plaintext
+func insertTestAPIKey(t testing.TB, app *application, tenantID int64, scopes ...string) string {
+	t.Helper()
+
+	key := &data.APIKey{Name: "test", Scopes: scopes, Plan: data.DefaultAPIKeyPlan, TenantID: tenantID}
+
+	err := app.models.APIKeys.Insert(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.Plaintext
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.apiKeys.required = true
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	other := &data.Tenant{Slug: "acme", Name: "Acme"}
+	if err := app.models.Tenants.Insert(other); err != nil {
+		t.Fatal(err)
+	}
+
+	read := insertTestAPIKey(t, app, data.DefaultTenantID, "read")
+	write := insertTestAPIKey(t, app, data.DefaultTenantID, "read", "write")
+	admin := insertTestAPIKey(t, app, data.DefaultTenantID, "admin")
+	acme := insertTestAPIKey(t, app, other.ID, "read")
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "no key", method: http.MethodGet, target: "/v1/movies/1", wantStatus: http.StatusUnauthorized, wantBody: "X-API-Key"},
+		{name: "unknown key", method: http.MethodGet, target: "/v1/movies/1", headers: map[string]string{"X-API-Key": "glk_nope"}, wantStatus: http.StatusUnauthorized, wantBody: "invalid"},
+		{name: "read", method: http.MethodGet, target: "/v1/movies/1", headers: map[string]string{"X-API-Key": read}, wantStatus: http.StatusOK},
+		{name: "write without scope", method: http.MethodPatch, target: "/v1/movies/1", body: `{"year":2017}`, headers: map[string]string{"X-API-Key": read}, wantStatus: http.StatusForbidden},
+		{name: "write", method: http.MethodPatch, target: "/v1/movies/1", body: `{"year":2017}`, headers: map[string]string{"X-API-Key": write}, wantStatus: http.StatusOK},
+		{name: "other tenant's key", method: http.MethodGet, target: "/v1/movies/1", headers: map[string]string{"X-API-Key": acme}, wantStatus: http.StatusUnauthorized},
+		{name: "key of the tenant", method: http.MethodGet, target: "/v1/movies", headers: map[string]string{"X-API-Key": acme, "X-Tenant": "acme"}, wantStatus: http.StatusOK},
+		{name: "unknown tenant", method: http.MethodGet, target: "/v1/movies", headers: map[string]string{"X-API-Key": read, "X-Tenant": "nope"}, wantStatus: http.StatusNotFound},
+		{name: "admin without scope", method: http.MethodPatch, target: "/v1/admin/genres/1", body: `{"name":"cartoon"}`, headers: map[string]string{"X-API-Key": write}, wantStatus: http.StatusForbidden},
+		{name: "admin", method: http.MethodPatch, target: "/v1/admin/genres/1", body: `{"name":"cartoon"}`, headers: map[string]string{"X-API-Key": admin}, wantStatus: http.StatusOK},
+		{name: "health without key", method: http.MethodGet, target: "/v1/healthcheck", wantStatus: http.StatusOK},
+		{name: "own usage", method: http.MethodGet, target: "/v1/api-keys/1/usage?days=7", headers: map[string]string{"X-API-Key": read}, wantStatus: http.StatusOK, wantBody: `"api_key_id":1`},
+		{name: "other key's usage", method: http.MethodGet, target: "/v1/api-keys/2/usage", headers: map[string]string{"X-API-Key": read}, wantStatus: http.StatusNotFound},
+		{name: "usage bad window", method: http.MethodGet, target: "/v1/api-keys/1/usage?days=365", headers: map[string]string{"X-API-Key": read}, wantStatus: http.StatusUnprocessableEntity, wantBody: `"days"`},
+	})
+}
+
+// Admin routes need a key even when anonymous requests are let through
+func TestAdminScopeWithoutRequiredKeys(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "anonymous read", method: http.MethodGet, target: "/v1/movies/1", wantStatus: http.StatusOK},
+		{name: "anonymous rename", method: http.MethodPatch, target: "/v1/admin/genres/1", body: `{"name":"cartoon"}`, wantStatus: http.StatusUnauthorized},
+		{name: "anonymous merge", method: http.MethodPost, target: "/v1/admin/genres/1/merge", body: `{"into":2}`, wantStatus: http.StatusUnauthorized},
+	})
+}
+
+func TestQuotas(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.apiKeys.required = true
+	app.quotas = data.NewUsageCounter(app.models.Quotas)
+	h := app.routes()
+
+	key := insertTestAPIKey(t, app, data.DefaultTenantID, "read")
+	headers := map[string]string{"X-API-Key": key}
+
+	daily := data.APIKeyPlans[data.DefaultAPIKeyPlan].Daily
+	for range daily {
+		serve(t, h, http.MethodGet, "/v1/movies", nil, headers)
+	}
+
+	rr := serve(t, h, http.MethodGet, "/v1/movies", nil, headers)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d after %d requests, want %d", rr.Code, daily, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("X-Quota-Remaining") != "0" || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("got headers %v", rr.Header())
+	}
+
+	// Upgrading takes effect right away
+	if err := app.models.APIKeys.UpdatePlan(1, "pro"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = serve(t, h, http.MethodGet, "/v1/movies", nil, headers)
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Quota-Plan") != "pro" {
+		t.Errorf("after upgrading got status %d, headers %v", rr.Code, rr.Header())
+	}
+}
+
+func TestAdminHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.admin.password = "secret"
+	h := app.adminServer().Handler
+
+	auth := map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"} // admin:secret
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "no credentials", method: http.MethodGet, target: "/v1/api-keys", wantStatus: http.StatusUnauthorized},
+		{name: "wrong credentials", method: http.MethodGet, target: "/v1/api-keys", headers: map[string]string{"Authorization": "Basic YWRtaW46bm9wZQ=="}, wantStatus: http.StatusUnauthorized},
+
+		{name: "create key", method: http.MethodPost, target: "/v1/api-keys", body: `{"name":"ci","scopes":["read"]}`, headers: auth, wantStatus: http.StatusCreated, wantBody: `"key":"glk_`},
+		{name: "create key bad scope", method: http.MethodPost, target: "/v1/api-keys", body: `{"name":"ci","scopes":["root"]}`, headers: auth, wantStatus: http.StatusUnprocessableEntity, wantBody: `"scopes"`},
+		{name: "create key unknown tenant", method: http.MethodPost, target: "/v1/api-keys", body: `{"tenant":"nope","name":"ci","scopes":["read"]}`, headers: auth, wantStatus: http.StatusUnprocessableEntity, wantBody: `"tenant"`},
+		{name: "create key malformed", method: http.MethodPost, target: "/v1/api-keys", body: `{"name":`, headers: auth, wantStatus: http.StatusBadRequest},
+		{name: "list keys", method: http.MethodGet, target: "/v1/api-keys", headers: auth, wantStatus: http.StatusOK, wantBody: `"name":"ci"`},
+		{name: "change plan", method: http.MethodPatch, target: "/v1/api-keys/1", body: `{"plan":"pro"}`, headers: auth, wantStatus: http.StatusOK},
+		{name: "change plan unknown", method: http.MethodPatch, target: "/v1/api-keys/1", body: `{"plan":"gold"}`, headers: auth, wantStatus: http.StatusUnprocessableEntity, wantBody: `"plan"`},
+		{name: "change plan missing key", method: http.MethodPatch, target: "/v1/api-keys/999", body: `{"plan":"pro"}`, headers: auth, wantStatus: http.StatusNotFound},
+		{name: "usage", method: http.MethodGet, target: "/v1/api-keys/1/usage", headers: auth, wantStatus: http.StatusOK},
+		{name: "revoke key", method: http.MethodDelete, target: "/v1/api-keys/1", headers: auth, wantStatus: http.StatusOK},
+		{name: "revoke key again", method: http.MethodDelete, target: "/v1/api-keys/1", headers: auth, wantStatus: http.StatusNotFound},
+
+		{name: "create tenant", method: http.MethodPost, target: "/v1/tenants", body: `{"slug":"acme","name":"Acme"}`, headers: auth, wantStatus: http.StatusCreated, wantBody: `"slug":"acme"`},
+		{name: "create tenant twice", method: http.MethodPost, target: "/v1/tenants", body: `{"slug":"acme","name":"Acme"}`, headers: auth, wantStatus: http.StatusUnprocessableEntity, wantBody: `"slug"`},
+		{name: "list tenants", method: http.MethodGet, target: "/v1/tenants", headers: auth, wantStatus: http.StatusOK, wantBody: `"slug":"acme"`},
+
+		{name: "explain unknown shape", method: http.MethodGet, target: "/v1/admin/explain/nope", headers: auth, wantStatus: http.StatusNotFound},
+		{name: "explain without postgres", method: http.MethodGet, target: "/v1/admin/explain/list", headers: auth, wantStatus: http.StatusServiceUnavailable},
+		{name: "backup without postgres", method: http.MethodPost, target: "/v1/admin/backup", headers: auth, wantStatus: http.StatusServiceUnavailable},
+		{name: "routes", method: http.MethodGet, target: "/v1/admin/routes", headers: auth, wantStatus: http.StatusOK},
+	})
+}
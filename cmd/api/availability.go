@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// How many movies one run of the sync task looks up
+const availabilitySyncBatch = 100
+
+// syncAvailability refreshes the movies that were synced longest ago.
+// An error stops the run, the remaining movies are tried on the next one
+func (app *application) syncAvailability(ctx context.Context) error {
+	targets, err := app.models.Availability.Stale(ctx, app.config.availability.maxAge, availabilitySyncBatch)
+	if err != nil {
+		return err
+	}
+
+	synced := 0
+
+	for _, target := range targets {
+		_, err := app.syncMovieAvailability(ctx, target)
+		if err != nil {
+			if synced > 0 {
+				app.logger.Info("synced movie availability", "count", synced)
+			}
+			return err
+		}
+
+		synced++
+	}
+
+	if synced > 0 {
+		app.logger.Info("synced movie availability", "count", synced)
+	}
+
+	return nil
+}
+
+// syncMovieAvailability looks one movie up and stores its offers.
+// It returns how many offers were found
+func (app *application) syncMovieAvailability(ctx context.Context, target *data.SyncTarget) (int, error) {
+	offers, err := app.availability.Lookup(ctx, target.Title, target.Year)
+	if err != nil {
+		return 0, err
+	}
+
+	availability := make([]*data.Availability, 0, len(offers))
+	for _, offer := range offers {
+		availability = append(availability, &data.Availability{
+			Provider: offer.Provider,
+			Country:  offer.Country,
+			Type:     offer.Type,
+			URL:      offer.URL,
+		})
+	}
+
+	err = app.models.Availability.Replace(ctx, target.MovieID, availability)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(availability), nil
+}
+
+// Most movies one sync request may name, leave movie_ids out to sync all
+const maxAvailabilitySyncMovies = 1000
+
+type availabilitySyncParams struct {
+	MovieIDs []int64 `json:"movie_ids,omitempty"`
+}
+
+// syncAvailabilityHandler refreshes availability right away instead of
+// waiting for the scheduler, e.g. after an import. It starts an operation,
+// {"movie_ids": [1, 2]} syncs those movies, {} every movie of the tenant
+func (app *application) syncAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	if app.availability == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the availability API isn't configured")
+		return
+	}
+
+	var input availabilitySyncParams
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.MovieIDs) <= maxAvailabilitySyncMovies, "movie_ids", fmt.Sprintf("must contain a maximum of %d movies", maxAvailabilitySyncMovies))
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.startOperation(w, r, "availability.sync", input)
+}
+
+// syncAvailabilityOperation syncs the movies the operation names, or all
+// of the tenant's, regardless of when they were last synced
+func (app *application) syncAvailabilityOperation(ctx context.Context, op *data.Operation, progress func(data.OperationProgress)) (any, error) {
+	if app.availability == nil {
+		return nil, fmt.Errorf("the availability API isn't configured")
+	}
+
+	var params availabilitySyncParams
+
+	err := json.Unmarshal(op.Params, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	models := app.models.ForTenant(op.TenantID)
+
+	var result struct {
+		Synced int `json:"synced"`
+		Offers int `json:"offers"`
+	}
+
+	sync := func(movies []*data.Movie, total int64) error {
+		for _, movie := range movies {
+			offers, err := app.syncMovieAvailability(ctx, &data.SyncTarget{MovieID: movie.ID, Title: movie.Title, Year: movie.Year})
+			if err != nil {
+				return fmt.Errorf("movie %d: %w", movie.ID, err)
+			}
+
+			result.Synced++
+			result.Offers += offers
+			progress(data.OperationProgress{Done: int64(result.Synced), Total: total})
+		}
+		return nil
+	}
+
+	if len(params.MovieIDs) > 0 {
+		// Ids that don't exist (anymore) are skipped
+		movies, err := models.Movies.GetByIDs(params.MovieIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		return result, sync(movies, int64(len(movies)))
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 100, Sort: "id", SortSafelist: []string{"id"}}
+
+	for {
+		movies, metadata, err := models.Movies.GetAll(data.MovieSearch{}, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		err = sync(movies, int64(metadata.TotalRecords))
+		if err != nil {
+			return nil, err
+		}
+
+		if filters.Page >= metadata.LastPage {
+			return result, nil
+		}
+		filters.Page++
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/storage"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Names end up in storage keys, so nothing that could leave backups/
+var backupNameRX = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+func backupKey(name string) string {
+	return "backups/" + name + ".ndjson.gz"
+}
+
+type backupParams struct {
+	Name string `json:"name"`
+}
+
+func validateBackupName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 100, "name", "must not be more than 100 characters")
+	v.Check(validator.MatchesRX(name, backupNameRX), "name", "must only contain letters, digits, dots, dashes and underscores")
+}
+
+// createBackupHandler snapshots the database to storage as
+// backups/<name>.ndjson.gz. The name defaults to the current time.
+// Like the rest of the admin server it isn't scoped to a tenant, the
+// backup has every tenant in it
+func (app *application) createBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if app.db == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "backups need -db-driver=postgres")
+		return
+	}
+
+	var input backupParams
+
+	// The body is optional here
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestReponse(w, r, err)
+			return
+		}
+	}
+
+	if input.Name == "" {
+		input.Name = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	v := validator.New()
+	if validateBackupName(v, input.Name); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	existing, _, err := app.storage.Get(r.Context(), backupKey(input.Name))
+	switch {
+	case err == nil:
+		existing.Close()
+		v.AddError("name", "a backup with this name already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, storage.ErrNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.startAdminOperation(w, r, "backup.create", input)
+}
+
+// restoreBackupHandler replaces the data of every tenant with the named
+// backup. Only a backup from the same schema version can be restored
+func (app *application) restoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if app.db == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "backups need -db-driver=postgres")
+		return
+	}
+
+	var input backupParams
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateBackupName(v, input.Name); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	backup, _, err := app.storage.Get(r.Context(), backupKey(input.Name))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			v.AddError("name", "no backup with this name exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	backup.Close()
+
+	app.startAdminOperation(w, r, "backup.restore", input)
+}
+
+// startAdminOperation is startOperation for operations that belong to
+// no tenant. They're polled on the admin server
+func (app *application) startAdminOperation(w http.ResponseWriter, r *http.Request, kind string, params any) {
+	op, err := app.queueOperation(app.models.Operations, kind, params)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/operations/%d", op.ID))
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"operation": op}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showAdminOperationHandler(w http.ResponseWriter, r *http.Request) {
+	app.showOperation(w, r, app.models.Operations)
+}
+
+// backupResult is what backup operations report when they're done
+type backupResult struct {
+	Name          string    `json:"name"`
+	Key           string    `json:"key"`
+	Rows          int64     `json:"rows"`
+	SchemaVersion int64     `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (app *application) createBackupOperation(ctx context.Context, op *data.Operation, progress func(data.OperationProgress)) (any, error) {
+	var params backupParams
+
+	err := json.Unmarshal(op.Params, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Storage needs the size up front, so the backup is written to disk first
+	file, err := os.CreateTemp("", "greenlight-backup-*.ndjson.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	zw := gzip.NewWriter(file)
+
+	header, err := data.Backup(ctx, app.db, zw, func(done, total int64) {
+		progress(data.OperationProgress{Done: done, Total: total})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	key := backupKey(params.Name)
+
+	err = app.storage.Put(ctx, key, file, size, "application/gzip")
+	if err != nil {
+		return nil, err
+	}
+
+	return backupResult{
+		Name:          params.Name,
+		Key:           key,
+		Rows:          header.Rows,
+		SchemaVersion: header.SchemaVersion,
+		CreatedAt:     header.CreatedAt,
+	}, nil
+}
+
+func (app *application) restoreBackupOperation(ctx context.Context, op *data.Operation, progress func(data.OperationProgress)) (any, error) {
+	var params backupParams
+
+	err := json.Unmarshal(op.Params, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	key := backupKey(params.Name)
+
+	backup, _, err := app.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer backup.Close()
+
+	zr, err := gzip.NewReader(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := data.Restore(ctx, app.db, zr, func(done, total int64) {
+		progress(data.OperationProgress{Done: done, Total: total})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app.logger.Info("restored backup", "name", params.Name, "rows", header.Rows)
+
+	return backupResult{
+		Name:          params.Name,
+		Key:           key,
+		Rows:          header.Rows,
+		SchemaVersion: header.SchemaVersion,
+		CreatedAt:     header.CreatedAt,
+	}, nil
+}
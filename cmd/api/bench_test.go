@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// newBenchmarkApplication is a test app with a catalog big enough that
+// lists fill their pages
+func newBenchmarkApplication(b *testing.B) (*application, http.Handler) {
+	app := newTestApplication(b)
+	h := app.routes()
+
+	genres := []string{"action", "comedy", "drama", "animation", "thriller"}
+	for i := range 200 {
+		insertTestMovie(b, app, fmt.Sprintf("Movie %d", i), int32(1950+i%70), genres[i%len(genres)], genres[(i+1)%len(genres)])
+	}
+
+	return app, h
+}
+
+// benchmarkRequest serves the same request b.N times, the numbers to
+// watch are allocs/op and B/op
+func benchmarkRequest(b *testing.B, h http.Handler, test handlerTest) {
+	var body []byte
+	if test.body != "" {
+		body = []byte(test.body)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		rr := serve(b, h, test.method, test.target, body, test.headers)
+		if rr.Code != test.wantStatus {
+			b.Fatalf("got status %d, want %d, body %s", rr.Code, test.wantStatus, rr.Body)
+		}
+	}
+}
+
+func BenchmarkListMovies(b *testing.B) {
+	_, h := newBenchmarkApplication(b)
+	benchmarkRequest(b, h, handlerTest{method: http.MethodGet, target: "/v1/movies?page_size=20", wantStatus: http.StatusOK})
+}
+
+func BenchmarkListMoviesJSONAPI(b *testing.B) {
+	_, h := newBenchmarkApplication(b)
+	benchmarkRequest(b, h, handlerTest{
+		method:     http.MethodGet,
+		target:     "/v1/movies?page_size=20",
+		headers:    map[string]string{"Accept": jsonAPIMediaType},
+		wantStatus: http.StatusOK,
+	})
+}
+
+func BenchmarkSearchMovies(b *testing.B) {
+	_, h := newBenchmarkApplication(b)
+	benchmarkRequest(b, h, handlerTest{method: http.MethodGet, target: "/v1/movies?title=movie+1&genres=drama&sort=-year", wantStatus: http.StatusOK})
+}
+
+func BenchmarkShowMovie(b *testing.B) {
+	app, h := newBenchmarkApplication(b)
+	movie := insertTestMovie(b, app, "Shown", 2001, "drama")
+	benchmarkRequest(b, h, handlerTest{method: http.MethodGet, target: fmt.Sprintf("/v1/movies/%d", movie.ID), wantStatus: http.StatusOK})
+}
+
+func BenchmarkCreateMovie(b *testing.B) {
+	_, h := newBenchmarkApplication(b)
+	benchmarkRequest(b, h, handlerTest{
+		method:     http.MethodPost,
+		target:     "/v1/movies",
+		body:       `{"title":"Created","year":2020,"runtime":"102 mins","genres":["drama","comedy"]}`,
+		headers:    map[string]string{"Content-Type": "application/json"},
+		wantStatus: http.StatusCreated,
+	})
+}
+
+func BenchmarkHealthcheck(b *testing.B) {
+	_, h := newBenchmarkApplication(b)
+	benchmarkRequest(b, h, handlerTest{method: http.MethodGet, target: "/v1/healthcheck", wantStatus: http.StatusOK})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Both can be set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.buildTime=$(date -u +%FT%TZ)" ./cmd/api
+//
+// Anything left empty is filled in from the build info Go embeds
+var (
+	version   string
+	buildTime string
+)
+
+var build = readBuildInfo()
+
+type buildInfo struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision,omitempty"`
+	Modified   bool   `json:"modified,omitempty"` // built with uncommitted changes
+	CommitTime string `json:"commit_time,omitempty"`
+	BuildTime  string `json:"build_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+}
+
+// readBuildInfo only finds vcs.* settings when the binary was built
+// from a git checkout with go build, not with go run
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, BuildTime: buildTime, GoVersion: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b.withDefaults()
+	}
+
+	// Tagged builds get the module version, untagged ones a pseudo-version or (devel)
+	if b.Version == "" && info.Main.Version != "(devel)" {
+		b.Version = info.Main.Version
+	}
+
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.CommitTime = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+
+	return b.withDefaults()
+}
+
+func (b buildInfo) withDefaults() buildInfo {
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	return b
+}
+
+func (app *application) versionHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"build": build}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"greenlight.brainwhat/internal/breaker"
+	"greenlight.brainwhat/internal/migrate"
+	"greenlight.brainwhat/internal/ratelimit"
+	"greenlight.brainwhat/migrations"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, logger *slog.Logger) error
+}
+
+// commands is a function and not a var because help needs the list itself
+func commands() []command {
+	return []command{
+		{"serve", "Run the API server (the default)", serveCommand},
+		{"migrate", "Apply migrations: migrate [flags] [up | down N | version]", migrateCommand},
+		{"seed", "Insert the development movies, existing ones are skipped", seedCommand},
+		{"config", "Print the effective settings of serve: config validate [flags]", configCommand},
+		{"routes", "List every route with its handler and middleware", routesCommand},
+		{"version", "Print the version", versionCommand},
+		{"help", "Show this help", helpCommand},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	bin := filepath.Base(os.Args[0])
+
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", bin)
+
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands() {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(os.Stderr, "\nRun %s [command] -h for the flags of a command\n", bin)
+}
+
+func helpCommand(args []string, logger *slog.Logger) error {
+	usage()
+	return nil
+}
+
+func versionCommand(args []string, logger *slog.Logger) error {
+	fmt.Printf("version:     %s\n", build.Version)
+	fmt.Printf("revision:    %s\n", build.Revision)
+	fmt.Printf("modified:    %t\n", build.Modified)
+	fmt.Printf("commit time: %s\n", build.CommitTime)
+	fmt.Printf("build time:  %s\n", build.BuildTime)
+	fmt.Printf("go version:  %s\n", build.GoVersion)
+	return nil
+}
+
+// routesCommand mounts the routes without starting anything, so it
+// needs no database. It shows the default -limiter-enabled setting
+func routesCommand(args []string, logger *slog.Logger) error {
+	app := &application{logger: logger, limiter: ratelimit.NewMemoryStore()}
+	app.routes()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tNAME\tHANDLER\tMIDDLEWARE")
+
+	for _, route := range app.routeInfo() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", route.Method, route.Path, route.Name, route.Handler, strings.Join(route.Middleware, " > "))
+	}
+
+	return tw.Flush()
+}
+
+func migrateCommand(args []string, logger *slog.Logger) error {
+	var cfg config
+
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbFlags(fs, &cfg)
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg, logger, breaker.New(cfg.db.breaker.threshold, cfg.db.breaker.cooldown))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch action := fs.Arg(0); action {
+	case "", "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrations applied", "count", applied)
+	case "down":
+		n, err := strconv.Atoi(fs.Arg(1))
+		if err != nil || n < 1 {
+			return fmt.Errorf("migrate down needs the number of migrations to roll back")
+		}
+
+		rolledBack, err := migrator.Down(ctx, n)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrations rolled back", "count", rolledBack)
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate action %q, must be up, down or version", action)
+	}
+
+	current, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("database version", "version", current, "dirty", dirty)
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		Name:        input.Name,
+		Description: input.Description,
+	}
+
+	v := validator.New()
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.tenantModels(r).Collections.Insert(collection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.url("collection", "id", collection.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	collection, err := app.tenantModels(r).Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// attachCollectionMovieHandler adds a movie to the collection or moves it
+// to a new position. Without a position the movie goes to the end
+func (app *application) attachCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	movieID, err := app.readInt64Param(r, "movie_id")
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	var input struct {
+		Position int `json:"position"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Position >= 0, "position", "must not be negative"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Deleted movies still have their row, so the foreign key alone isn't enough
+	_, err = app.tenantModels(r).Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.tenantModels(r).Collections.AttachMovie(id, movieID, input.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	collection, err := app.tenantModels(r).Collections.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) detachCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	movieID, err := app.readInt64Param(r, "movie_id")
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	err = app.tenantModels(r).Collections.DetachMovie(id, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully removed from collection"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCollectionHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "create", method: http.MethodPost, target: "/v1/collections", body: `{"name":"Disney","description":"Animated"}`, wantStatus: http.StatusCreated, wantBody: `"name":"Disney"`},
+		{name: "create invalid", method: http.MethodPost, target: "/v1/collections", body: `{"name":""}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"name"`},
+		{name: "create malformed", method: http.MethodPost, target: "/v1/collections", body: `{"name":1}`, wantStatus: http.StatusBadRequest},
+
+		{name: "attach", method: http.MethodPut, target: "/v1/collections/1/movies/1", body: `{"position":0}`, wantStatus: http.StatusOK, wantBody: "Moana"},
+		{name: "attach negative position", method: http.MethodPut, target: "/v1/collections/1/movies/1", body: `{"position":-1}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "attach missing movie", method: http.MethodPut, target: "/v1/collections/1/movies/999", body: `{"position":0}`, wantStatus: http.StatusNotFound},
+		{name: "attach missing collection", method: http.MethodPut, target: "/v1/collections/999/movies/1", body: `{"position":0}`, wantStatus: http.StatusNotFound},
+
+		{name: "show", method: http.MethodGet, target: "/v1/collections/1", wantStatus: http.StatusOK, wantBody: "Moana"},
+		{name: "show missing", method: http.MethodGet, target: "/v1/collections/999", wantStatus: http.StatusNotFound},
+
+		{name: "detach", method: http.MethodDelete, target: "/v1/collections/1/movies/1", wantStatus: http.StatusOK},
+		{name: "detach again", method: http.MethodDelete, target: "/v1/collections/1/movies/1", wantStatus: http.StatusNotFound},
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bodies smaller than this aren't worth compressing, the gzip header
+// and the CPU time cost more than we'd save
+const compressMinSize = 1024
+
+// Content types that are compressed already, running gzip over them
+// again only makes them bigger
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/octet-stream",
+}
+
+var (
+	gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibPool = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding.
+// Empty string means the response is sent as is
+func negotiateEncoding(header string) string {
+	// Quality of every listed encoding, q=0 means the client refuses it
+	quality := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.ToLower(strings.ReplaceAll(params, " ", "")), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		quality[name] = q
+	}
+
+	// * stands for every encoding that isn't listed on its own
+	for _, encoding := range []string{"gzip", "deflate"} {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+
+		if ok && q > 0 {
+			return encoding
+		}
+	}
+
+	return ""
+}
+
+// compressWriter holds the body back until it's big enough to be worth
+// compressing, then either switches to a compressor or flushes it unchanged
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	status     int
+	buf        []byte
+	compressor io.WriteCloser
+	decided    bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.compressor != nil {
+			return cw.compressor.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) < compressMinSize {
+		return len(b), nil
+	}
+
+	err := cw.decide(true)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+
+	h := cw.Header()
+
+	if bigEnough && cw.compressible() {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+
+		switch cw.encoding {
+		case "gzip":
+			gz := gzipPool.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.compressor = gz
+		case "deflate":
+			zw := zlibPool.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.compressor = zw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(cw.buf)
+	} else if len(cw.buf) > 0 {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	if cw.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := cw.Header().Get("Content-Type")
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FlushError sends what's buffered so far, for handlers that stream.
+// A body that gets flushed is compressed even when it's still small,
+// there's probably more to come
+func (cw *compressWriter) FlushError() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		err := cw.decide(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if flusher, ok := cw.compressor.(interface{ Flush() error }); ok {
+		err := flusher.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish has to be called once the handler returns to send whatever is still buffered
+func (cw *compressWriter) finish() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		return cw.decide(false)
+	}
+
+	if cw.compressor == nil {
+		return nil
+	}
+
+	err := cw.compressor.Close()
+
+	switch c := cw.compressor.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(c)
+	case *zlib.Writer:
+		zlibPool.Put(c)
+	}
+
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "deflate", want: "deflate"},
+		{header: "gzip, deflate, br", want: "gzip"},
+		{header: "br", want: ""},
+		{header: "identity", want: ""},
+		{header: "*", want: "gzip"},
+		{header: "*;q=0", want: ""},
+		{header: "gzip;q=0", want: ""},
+		{header: "gzip;q=0, deflate", want: "deflate"},
+		{header: "gzip;q=0, *", want: "deflate"},
+		{header: "gzip;q=0, deflate;q=0, *", want: ""},
+		{header: "gzip;q=0.5, *;q=0", want: "gzip"},
+		{header: "deflate, *;q=0", want: "deflate"},
+		{header: "GZIP", want: "gzip"},
+		{header: "GZip;Q=0, Deflate", want: "deflate"},
+		{header: "gzip; q=0", want: ""},
+		{header: "gzip;q=0.0", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/secrets"
+)
+
+// parseFlags parses the command line and fills in what it didn't set
+// from -config. The command line wins over the file, the file wins over
+// environment variables and defaults.
+//
+// Config files are YAML or TOML (by extension) and only use a small part
+// of either: keys are flag names, sections are prefixes of them, so
+// db-dsn can also be dsn in a db section. Repeatable flags take lists.
+// ${VAR} and ${VAR:-default} in values are replaced from the environment,
+// except in single quoted ones
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	path := fs.String("config", os.Getenv("GREENLIGHT_CONFIG"), "YAML or TOML file with flag values, see config validate")
+
+	fs.Parse(args)
+
+	if *path != "" {
+		err := loadConfigFile(fs, *path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return resolveSecrets(fs)
+}
+
+// Secret flags can be references like file:/run/secrets/db_dsn, see
+// package secrets. config validate prints them as <redacted>
+var secretFlags = map[string]bool{
+	"db-dsn":                true,
+	"db-password":           true,
+	"admin-password":        true,
+	"availability-token":    true,
+	"storage-s3-access-key": true,
+	"storage-s3-secret-key": true,
+}
+
+// resolveSecrets replaces the references in secret flags with the secrets
+func resolveSecrets(fs *flag.FlagSet) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for name := range secretFlags {
+		f := fs.Lookup(name)
+		if f == nil || !secrets.IsRef(f.Value.String()) {
+			continue
+		}
+
+		value, err := secrets.Resolve(ctx, f.Value.String())
+		if err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+
+		fs.Set(name, value)
+	}
+
+	return nil
+}
+
+// secretEnv is the default of a secret flag: the variable itself, or with
+// NAME_FILE set, a reference to the file (how Docker and Kubernetes
+// mount secrets)
+func secretEnv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		return "file:" + path
+	}
+
+	return ""
+}
+
+// configEntry is one value of a config file, line is for errors
+type configEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entries []configEntry
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		entries, err = parseYAMLConfig(string(src))
+	case ".toml":
+		entries, err = parseTOMLConfig(string(src))
+	default:
+		return fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Flags given on the command line win
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	// migrate and seed share the file with serve, they skip what's only for serve
+	all := serveFlags("", new(config))
+
+	for _, entry := range entries {
+		if entry.key == "config" || all.Lookup(entry.key) == nil {
+			return fmt.Errorf("%s: line %d: unknown setting %q", path, entry.line, entry.key)
+		}
+
+		if given[entry.key] || fs.Lookup(entry.key) == nil {
+			continue
+		}
+
+		err = fs.Set(entry.key, entry.value)
+		if err != nil {
+			return fmt.Errorf("%s: line %d: %s: %w", path, entry.line, entry.key, err)
+		}
+	}
+
+	return nil
+}
+
+// parseYAMLConfig reads key: value lines. A key without a value starts a
+// section of indented key: value lines or a list of "- value" lines
+func parseYAMLConfig(src string) ([]configEntry, error) {
+	var entries []configEntry
+	var section string
+
+	for i, raw := range strings.Split(src, "\n") {
+		n := i + 1
+
+		line := stripConfigComment(raw)
+		text := strings.TrimSpace(line)
+		if text == "" || text == "---" {
+			continue
+		}
+
+		indented := line[0] == ' ' || line[0] == '\t'
+		if !indented {
+			section = ""
+		}
+
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			if section == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", n)
+			}
+
+			value, err := configValue(strings.TrimSpace(text[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+
+			entries = append(entries, configEntry{section, value, n})
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+
+		key = configKey(key)
+		if indented {
+			if section == "" {
+				return nil, fmt.Errorf("line %d: unexpected indentation", n)
+			}
+			key = section + "-" + key
+		}
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			section = key
+			continue
+		}
+
+		values, err := configValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		for _, value := range values {
+			entries = append(entries, configEntry{key, value, n})
+		}
+	}
+
+	return entries, nil
+}
+
+// parseTOMLConfig reads key = value lines, [name] starts a section
+func parseTOMLConfig(src string) ([]configEntry, error) {
+	var entries []configEntry
+	var section string
+
+	for i, raw := range strings.Split(src, "\n") {
+		n := i + 1
+
+		text := strings.TrimSpace(stripConfigComment(raw))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = configKey(text[1 : len(text)-1])
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+
+		key = configKey(key)
+		if section != "" {
+			key = section + "-" + key
+		}
+
+		values, err := configValues(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		for _, value := range values {
+			entries = append(entries, configEntry{key, value, n})
+		}
+	}
+
+	return entries, nil
+}
+
+// Keys can use underscores too, db_dsn is db-dsn
+func configKey(key string) string {
+	return strings.ReplaceAll(strings.Trim(strings.TrimSpace(key), `"'`), "_", "-")
+}
+
+// stripConfigComment cuts off a # comment that isn't inside quotes
+func stripConfigComment(line string) string {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++ // an escaped quote doesn't end the string
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			line = line[:i]
+		}
+	}
+
+	return strings.TrimRight(line, " \t\r")
+}
+
+// configValues is configValue for what may also be a [a, b] list
+func configValues(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		value, err := configValue(s)
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+
+	items := s[1 : len(s)-1]
+
+	var values []string
+	var quote byte
+	start := 0
+
+	for i := 0; i <= len(items); i++ {
+		if i < len(items) {
+			c := items[i]
+
+			switch {
+			case quote != 0:
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+
+		item := strings.TrimSpace(items[start:min(i, len(items))])
+		start = i + 1
+
+		if item == "" {
+			continue
+		}
+
+		value, err := configValue(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// configValue unquotes s and replaces ${VAR} in it, single quotes keep
+// it as it is
+func configValue(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", s)
+		}
+		s = unquoted
+	}
+
+	return expandConfigEnv(s)
+}
+
+var configEnvRX = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+func expandConfigEnv(s string) (string, error) {
+	var missing string
+
+	expanded := configEnvRX.ReplaceAllStringFunc(s, func(ref string) string {
+		m := configEnvRX.FindStringSubmatch(ref)
+
+		value, ok := os.LookupEnv(m[1])
+		switch {
+		case ok && value != "":
+			return value
+		case m[2] != "":
+			return m[3]
+		case !ok && missing == "":
+			missing = m[1]
+		}
+		return value
+	})
+
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s isn't set", missing)
+	}
+
+	return expanded, nil
+}
+
+// configCommand prints the settings serve would run with, from the
+// command line, -config and the environment, once they passed validation.
+// The output is a valid config file itself
+func configCommand(args []string, logger *slog.Logger) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("unknown config action, must be validate")
+	}
+
+	var cfg config
+
+	fs := serveFlags("config validate", &cfg)
+
+	err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		value := f.Value.String()
+		switch {
+		case value == "":
+		case f.Name == "db-dsn":
+			value = redactDSN(value)
+		case secretFlags[f.Name]:
+			value = "<redacted>"
+		}
+
+		fmt.Printf("%s: %s\n", f.Name, quoteConfigValue(value))
+	})
+
+	return nil
+}
+
+// redactDSN hides the password of a postgres:// URL or a key=value DSN
+func redactDSN(dsn string) string {
+	// Not url.Parse, it fails on the passwords that most need hiding
+	if scheme, rest, ok := strings.Cut(dsn, "://"); ok {
+		at := strings.LastIndex(rest, "@")
+		if user, _, ok := strings.Cut(rest[:max(at, 0)], ":"); ok {
+			return scheme + "://" + user + ":xxxxx" + rest[at:]
+		}
+		return dsn
+	}
+
+	fields := strings.Fields(dsn)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "password=") {
+			fields[i] = "password=xxxxx"
+		}
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// Values are quoted when reading them back would change them.
+// Single quotes keep a $ from being taken for a variable
+func quoteConfigValue(value string) string {
+	switch {
+	case strings.Contains(value, "$"):
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	case value == "" || strings.ContainsAny(value, "#:'\"[] "):
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"greenlight.brainwhat/internal/jsonschema"
+)
+
+// What each route answers with when it works, paths leave out the version
+// prefix like requestSchemas. Errors all use error_response.json
+var responseSchemas = map[string]string{
+	"POST /movies":       "movie_response.json",
+	"GET /movies":        "movie_list_response.json",
+	"GET /movies/{id}":   "movie_response.json",
+	"PATCH /movies/{id}": "movie_response.json",
+	"PUT /movies/{id}/releases/{country}/{type}": "release_response.json",
+	"POST /collections":                          "collection_response.json",
+	"GET /collections/{id}":                      "collection_response.json",
+	"PUT /collections/{id}/movies/{movie_id}":    "collection_response.json",
+}
+
+func parseSchema(t *testing.T, file string) *jsonschema.Schema {
+	t.Helper()
+
+	b, err := schemaFS.ReadFile("schemas/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := jsonschema.Parse(b)
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+
+	return schema
+}
+
+// TestContracts replays recorded requests through the handlers and checks
+// both sides against schemas/: a request the schema accepts has to work
+// and one it rejects has to fail, and every response has to match the
+// route's response schema. The requests run in order against one app, so
+// later ones see what earlier ones created
+func TestContracts(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation", "adventure")
+	insertTestMovie(t, app, "Black Panther", 2018, "action")
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		route      string
+		body       string
+		wantStatus int
+	}{
+		{name: "create movie", method: http.MethodPost, target: "/v1/movies", route: "POST /movies", body: `{"title":"Deadpool","year":2016,"runtime":"108 mins","genres":["action","comedy"],"certifications":{"US":"R"},"budget":58000000,"currency":"USD"}`, wantStatus: http.StatusCreated},
+		{name: "create invalid movie", method: http.MethodPost, target: "/v1/movies", route: "POST /movies", body: `{"title":"","year":1500,"runtime":-1,"genres":[]}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "update movie", method: http.MethodPatch, target: "/v1/movies/2", route: "PATCH /movies/{id}", body: `{"year":2019,"runtime":134}`, wantStatus: http.StatusOK},
+		{name: "update movie without genres", method: http.MethodPatch, target: "/v1/movies/2", route: "PATCH /movies/{id}", body: `{"genres":[]}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "set release", method: http.MethodPut, target: "/v1/movies/1/releases/US/theatrical", route: "PUT /movies/{id}/releases/{country}/{type}", body: `{"date":"2016-11-23"}`, wantStatus: http.StatusOK},
+		{name: "set release with a bad date", method: http.MethodPut, target: "/v1/movies/1/releases/US/theatrical", route: "PUT /movies/{id}/releases/{country}/{type}", body: `{"date":"23/11/2016"}`, wantStatus: http.StatusBadRequest},
+		{name: "create collection", method: http.MethodPost, target: "/v1/collections", route: "POST /collections", body: `{"name":"Disney","description":"Animated"}`, wantStatus: http.StatusCreated},
+		{name: "create collection without a name", method: http.MethodPost, target: "/v1/collections", route: "POST /collections", body: `{"description":"Animated"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "attach movie", method: http.MethodPut, target: "/v1/collections/1/movies/1", route: "PUT /collections/{id}/movies/{movie_id}", body: `{"position":1}`, wantStatus: http.StatusOK},
+		{name: "show collection", method: http.MethodGet, target: "/v1/collections/1", route: "GET /collections/{id}", wantStatus: http.StatusOK},
+		{name: "show movie", method: http.MethodGet, target: "/v1/movies/1", route: "GET /movies/{id}", wantStatus: http.StatusOK},
+		{name: "show movie with includes", method: http.MethodGet, target: "/v1/movies/1?include=collection,availability", route: "GET /movies/{id}", wantStatus: http.StatusOK},
+		{name: "list movies", method: http.MethodGet, target: "/v1/movies?page_size=2&sort=-year", route: "GET /movies", wantStatus: http.StatusOK},
+		{name: "list movies without total", method: http.MethodGet, target: "/v1/movies?page_size=2&include_total=false", route: "GET /movies", wantStatus: http.StatusOK},
+		{name: "search movies", method: http.MethodGet, target: "/v1/movies?title=moana&genres=animation", route: "GET /movies", wantStatus: http.StatusOK},
+		{name: "missing movie", method: http.MethodGet, target: "/v1/movies/999", route: "GET /movies/{id}", wantStatus: http.StatusNotFound},
+		{name: "bad query", method: http.MethodGet, target: "/v1/movies?page=0", route: "GET /movies", wantStatus: http.StatusUnprocessableEntity},
+		{name: "method not allowed", method: http.MethodPut, target: "/v1/movies/1", route: "PUT /movies/{id}", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	errorSchema := parseSchema(t, "error_response.json")
+	covered := make(map[string]bool)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantOK := tt.wantStatus < 400
+
+			if file, ok := requestSchemas[tt.route]; ok && tt.body != "" {
+				errs, err := parseSchema(t, file).Validate([]byte(tt.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// The handler has to agree with the schema about the request
+				if valid := errs == nil; valid != wantOK {
+					t.Errorf("%s says the request is valid: %t (%v), but the handler answers %d", file, valid, errs, tt.wantStatus)
+				}
+			}
+
+			var body []byte
+			if tt.body != "" {
+				body = []byte(tt.body)
+			}
+
+			rr := serve(t, h, tt.method, tt.target, body, nil)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d\n%s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			schema := errorSchema
+			if wantOK {
+				file, ok := responseSchemas[tt.route]
+				if !ok {
+					t.Fatalf("no response schema for %s", tt.route)
+				}
+				schema = parseSchema(t, file)
+				covered[tt.route] = true
+			}
+
+			errs, err := schema.Validate(rr.Body.Bytes())
+			if err != nil {
+				t.Fatalf("response isn't JSON: %v\n%s", err, rr.Body)
+			}
+			for pointer, message := range errs {
+				t.Errorf("response %s: %s\n%s", pointer, message, rr.Body)
+			}
+		})
+	}
+
+	// A schema without a request that works against it checks nothing
+	for _, schemas := range []map[string]string{requestSchemas, responseSchemas} {
+		for route := range schemas {
+			if !covered[route] {
+				t.Errorf("no successful request to %s", route)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Routes that get no deadline at all. Paths leave out the version prefix.
+// Exports stream for as long as they need to, imports and posters have
+// their bodies to read first
+var requestTimeoutExempt = map[string]bool{
+	"GET /movies/export":      true,
+	"POST /movies/import":     true,
+	"PUT /movies/{id}/poster": true,
+}
+
+func (app *application) requestTimeoutApplies(method, path string) bool {
+	return app.config.requestTimeout.timeout > 0 && !requestTimeoutExempt[method+" "+path]
+}
+
+// requestTimeout gives the request a deadline of -request-timeout. Clients
+// can ask for a different one with X-Request-Timeout, in seconds or as a
+// duration like 500ms, up to -request-timeout-max. The deadline reaches
+// the queries through tenantModels, a request past it gets a 504
+func (app *application) requestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := app.config.requestTimeout.timeout
+
+		if header := r.Header.Get("X-Request-Timeout"); header != "" {
+			requested, ok := parseRequestTimeout(header)
+			if !ok {
+				app.errorResponse(w, r, http.StatusBadRequest, "X-Request-Timeout must be a positive number of seconds or a duration like 500ms")
+				return
+			}
+
+			timeout = min(requested, app.config.requestTimeout.max)
+		}
+
+		ctx, cancel := context.WithTimeoutCause(r.Context(), timeout, errRequestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// errRequestTimeout is the cause of a request that ran out of time. Queries
+// made with the request's context that fail because of it aren't the
+// database's fault, the breaker doesn't count them
+var errRequestTimeout = errors.New("request timeout")
+
+func parseRequestTimeout(s string) (time.Duration, bool) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), seconds > 0 && seconds < math.MaxInt64/float64(time.Second)
+	}
+
+	d, err := time.ParseDuration(s)
+	return d, err == nil && d > 0
+}
+
+// deadlineExceeded reports whether the request ran out of time, so
+// whatever failed failed because of it
+func deadlineExceeded(r *http.Request) bool {
+	return r.Context().Err() == context.DeadlineExceeded
+}
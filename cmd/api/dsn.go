@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// The sslmodes lib/pq knows, it has no prefer or allow
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// errTLSMismatch means the server and the client disagree about TLS,
+// trying again won't help
+var errTLSMismatch = errors.New("TLS mismatch")
+
+// databaseDSN is -db-dsn, or the DSN built from -db-host and the other
+// parts. Hand written DSNs break on passwords with @, / or # in them,
+// built ones are escaped properly. A host starting with / is the
+// directory of a unix socket, like /var/run/postgresql
+func databaseDSN(cfg config) (string, error) {
+	if cfg.db.host == "" {
+		return cfg.db.dsn, nil
+	}
+
+	if cfg.db.dsn != "" {
+		return "", errors.New("-db-dsn and -db-host can't be used together")
+	}
+
+	u := url.URL{Scheme: "postgres", Path: "/" + cfg.db.name}
+	query := url.Values{}
+
+	if strings.HasPrefix(cfg.db.host, "/") {
+		// The port is part of the socket's file name
+		query.Set("host", cfg.db.host)
+		query.Set("port", strconv.Itoa(cfg.db.port))
+	} else {
+		u.Host = net.JoinHostPort(cfg.db.host, strconv.Itoa(cfg.db.port))
+	}
+
+	switch {
+	case cfg.db.user != "" && cfg.db.password != "":
+		u.User = url.UserPassword(cfg.db.user, cfg.db.password)
+	case cfg.db.user != "":
+		u.User = url.User(cfg.db.user)
+	}
+
+	for name, value := range map[string]string{
+		"sslmode":     cfg.db.sslmode,
+		"sslrootcert": cfg.db.sslrootcert,
+		"sslcert":     cfg.db.sslcert,
+		"sslkey":      cfg.db.sslkey,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	// lib/pq defaults to require, which a unix socket never supports
+	if strings.HasPrefix(cfg.db.host, "/") && cfg.db.sslmode == "" {
+		query.Set("sslmode", "disable")
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// checkDSN catches settings that would only fail once the first
+// connection is made, or fail with an error that doesn't say why
+func checkDSN(dsn string) error {
+	opts, err := parseDSN(dsn)
+	if err != nil {
+		return fmt.Errorf("invalid database DSN: %w", err)
+	}
+
+	// Like libpq, lib/pq defaults to require
+	sslmode := opts["sslmode"]
+	if sslmode == "" {
+		sslmode = "require"
+	}
+
+	if !slices.Contains(sslModes, sslmode) {
+		return fmt.Errorf("invalid sslmode %q, must be one of %s", sslmode, strings.Join(sslModes, ", "))
+	}
+
+	if strings.HasPrefix(opts["host"], "/") {
+		info, err := os.Stat(opts["host"])
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("unix socket directory %s doesn't exist", opts["host"])
+		}
+
+		if sslmode != "disable" {
+			return fmt.Errorf("sslmode %s doesn't work over a unix socket, postgres has no TLS there. Use sslmode=disable", sslmode)
+		}
+	}
+
+	if (opts["sslcert"] == "") != (opts["sslkey"] == "") {
+		return errors.New("sslcert and sslkey have to be set together")
+	}
+
+	for _, name := range []string{"sslrootcert", "sslcert", "sslkey"} {
+		path := opts[name]
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// lib/pq refuses keys others can read, with a less helpful error
+		if name == "sslkey" && info.Mode().Perm()&0o007 != 0 {
+			return fmt.Errorf("sslkey %s can be read by anyone, chmod 600 it", filepath.Clean(path))
+		}
+	}
+
+	if opts["sslrootcert"] != "" && sslmode == "disable" {
+		return errors.New("sslrootcert is set but sslmode is disable, the certificate would never be checked")
+	}
+
+	return nil
+}
+
+// parseDSN reads a postgres:// URL or a key=value DSN into its options
+func parseDSN(dsn string) (map[string]string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts := make(map[string]string)
+
+	// The key=value syntax of libpq: values can be single quoted,
+	// backslash escapes the next character
+	s := []rune(dsn)
+	for i := 0; i < len(s); {
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+
+		start := i
+		for i < len(s) && s[i] != '=' && !unicode.IsSpace(s[i]) {
+			i++
+		}
+		key := string(s[start:i])
+
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+		if i == len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("missing = after %q", key)
+		}
+		i++
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+
+		var value []rune
+		quoted := i < len(s) && s[i] == '\''
+		if quoted {
+			i++
+		}
+
+		for ; i < len(s); i++ {
+			if quoted && s[i] == '\'' {
+				quoted = false
+				i++
+				break
+			}
+			if !quoted && unicode.IsSpace(s[i]) {
+				break
+			}
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			value = append(value, s[i])
+		}
+		if quoted {
+			return nil, errors.New("unterminated quoted value")
+		}
+
+		opts[key] = string(value)
+	}
+
+	return opts, nil
+}
+
+// explainConnectError adds what to do to the errors of a TLS mismatch.
+// A server that requires TLS rejects plain connections in pg_hba.conf
+// with "no pg_hba.conf entry ... SSL off"
+func explainConnectError(err error) error {
+	var pqErr *pq.Error
+
+	switch {
+	case errors.Is(err, pq.ErrSSLNotSupported):
+		return fmt.Errorf("%w: the server doesn't support TLS, connect with sslmode=disable: %w", errTLSMismatch, err)
+	case errors.As(err, &pqErr) && pqErr.Code == "28000" && strings.Contains(pqErr.Message, "SSL off"):
+		return fmt.Errorf("%w: the server requires TLS, connect with sslmode=require or verify-full: %w", errTLSMismatch, err)
+	}
+
+	return err
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
+
+	"greenlight.brainwhat/internal/breaker"
+	"greenlight.brainwhat/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -11,13 +18,28 @@ func (app *application) logError(r *http.Request, err error) {
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	args := []any{"method", method, "uri", uri}
+	if span := requestSpan(r); span != nil {
+		args = append(args, "trace_id", span.TraceID.String())
+	}
+
+	app.logger.Error(err.Error(), args...)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
+	var headers http.Header
 
-	err := app.writeJSON(w, status, env, nil)
+	switch {
+	case wantsJSONAPI(r):
+		env = jsonAPIErrors(status, message)
+		headers = http.Header{"Content-Type": []string{jsonAPIMediaType}}
+	case app.config.errorFormat == "problem":
+		env = problemDetails(r, status, message)
+		headers = http.Header{"Content-Type": []string{"application/problem+json"}}
+	}
+
+	err := app.writeJSON(w, r, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -25,7 +47,42 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 
 }
 
+// problemDetails builds RFC 7807 body. String messages go to "detail",
+// validation errors are added as an "errors" extension member
+func problemDetails(r *http.Request, status int, message any) envelope {
+	problem := envelope{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": r.URL.Path,
+	}
+
+	switch m := message.(type) {
+	case string:
+		problem["detail"] = m
+	case map[string]string:
+		problem["detail"] = "the request contains invalid fields"
+		problem["errors"] = m
+	default:
+		problem["detail"] = m
+	}
+
+	return problem
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// Database is known to be down, no need to log every request about it
+	if errors.Is(err, breaker.ErrOpen) {
+		app.databaseUnavailableResponse(w, r)
+		return
+	}
+
+	// Whatever failed once the request's deadline passed failed because of it
+	if deadlineExceeded(r) || errors.Is(err, context.DeadlineExceeded) {
+		app.gatewayTimeoutResponse(w, r)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
@@ -37,15 +94,31 @@ func (app *application) notFoundError(w http.ResponseWriter, r *http.Request) {
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
-func (app *application) methodNotAllowedError(w http.ResponseWriter, r *http.Request) {
+// methodNotAllowedError has to say which methods would have worked,
+// RFC 9110 requires the Allow header on a 405
+func (app *application) methodNotAllowedError(w http.ResponseWriter, r *http.Request, allow []string) {
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+
 	message := fmt.Sprintf("the %s method is not allowed", r.Method)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
 func (app *application) badRequestReponse(w http.ResponseWriter, r *http.Request, err error) {
+	// A body we can't decompress isn't a malformed one, the client can
+	// send it again with an encoding we take
+	if errors.Is(err, errUnsupportedContentEncoding) {
+		app.unsupportedContentEncodingResponse(w, r, err)
+		return
+	}
+
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+func (app *application) unsupportedContentEncodingResponse(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Accept-Encoding", "gzip")
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+}
+
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -54,3 +127,68 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update record due to an edit conflict, try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since the If-Unmodified-Since date"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+
+	message := "invalid or missing authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) apiKeyRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this API needs a key, send it in the X-API-Key header"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or revoked API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your API key doesn't have the scope needed for this"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// quotaExceededResponse sends a 429 when the key is out of requests for
+// today and a 402 when it's out for the month, that one takes a plan
+// upgrade to get out of before it resets
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request, result data.QuotaResult, retryAfter float64) {
+	if !result.Monthly {
+		w.Header().Set("Retry-After", fmt.Sprint(max(retryAfter, 1)))
+		app.errorResponse(w, r, http.StatusTooManyRequests, "daily request quota of your API key exceeded")
+		return
+	}
+
+	app.errorResponse(w, r, http.StatusPaymentRequired, "monthly request quota of your API key exceeded, upgrade its plan for more")
+}
+
+func (app *application) tenantNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested tenant could not be found"
+	app.errorResponse(w, r, http.StatusNotFound, message)
+}
+
+func (app *application) databaseUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	retryAfter := math.Ceil(app.breaker.RetryAfter().Seconds())
+	w.Header().Set("Retry-After", fmt.Sprint(max(retryAfter, 1)))
+
+	message := "the database is temporarily unavailable, try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) gatewayTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request took too long to process, try again or with a longer X-Request-Timeout"
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter float64) {
+	w.Header().Set("Retry-After", fmt.Sprint(max(retryAfter, 1)))
+
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
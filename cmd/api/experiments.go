@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/experiment"
+)
+
+const experimentsContextKey = contextKey("experiments")
+
+// contextGetVariant returns the request's variant of an experiment,
+// "" when the experiment isn't running
+func (app *application) contextGetVariant(r *http.Request, name string) string {
+	variants, _ := r.Context().Value(experimentsContextKey).(map[string]string)
+	return variants[name]
+}
+
+// experimentSubject is what clients are bucketed by: their API key, or
+// the IP for anonymous clients. Keys are tenant specific already, the
+// same IP calling two tenants counts as two clients
+func (app *application) experimentSubject(r *http.Request) string {
+	if key := app.contextGetAPIKey(r); key != nil {
+		return "apikey:" + strconv.FormatInt(key.ID, 10)
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if tenant := app.contextGetTenant(r); tenant != nil {
+		return "tenant:" + strconv.FormatInt(tenant.ID, 10) + ":" + ip
+	}
+
+	return ip
+}
+
+// assignExperiments puts the variant of every running experiment into the
+// context and tells the client with X-Experiment, e.g.
+// "similar-ranking=control". It runs inside authenticateAPIKey so clients
+// with a key keep their variant when their IP changes
+func (app *application) assignExperiments(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := app.experimentSubject(r)
+
+		variants := make(map[string]string, len(app.config.experiments))
+		header := make([]string, 0, len(app.config.experiments))
+
+		for _, e := range app.config.experiments {
+			variant := e.Assign(subject)
+			variants[e.Name] = variant
+			header = append(header, e.Name+"="+variant)
+		}
+
+		w.Header().Set("X-Experiment", strings.Join(header, ", "))
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), experimentsContextKey, variants)))
+
+		duration := time.Since(start)
+		failed := sw.status >= http.StatusInternalServerError
+
+		for name, variant := range variants {
+			experiment.Record(name, variant, duration, failed)
+		}
+	})
+}
+
+// statusWriter remembers the status the handler sent
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
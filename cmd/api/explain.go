@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// explainMoviesHandler shows the plan of one of the movie list queries,
+// e.g. GET /v1/admin/explain/list?tenant=acme&genres=drama&sort=-year
+// to check that an index is used. It takes the same filters as the
+// endpoint the shape belongs to, see data.ExplainShapes. The query is
+// planned but never run
+func (app *application) explainMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	shape := r.PathValue("shape")
+	if !validator.PermittedValue(shape, data.ExplainShapes...) {
+		app.notFoundError(w, r)
+		return
+	}
+
+	if app.db == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "explain needs -db-driver=postgres")
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := app.readMovieSearch(qs, v)
+	search.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: movieSortSafelist,
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Like the backups the admin server belongs to no tenant, it's picked here
+	tenant, err := app.models.Tenants.GetBySlug(app.readString(qs, "tenant", data.DefaultTenantSlug))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.tenantNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movies := data.MovieModel{
+		DB:                  app.db,
+		SimilarityThreshold: app.config.search.similarityThreshold,
+		TenantID:            tenant.ID,
+	}
+
+	plan, err := data.ExplainMovies(r.Context(), app.db, movies, shape, search, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"shape": shape, "tenant": tenant.Slug, "plan": plan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Movies fetched per query while exporting
+const exportPageSize = 500
+
+// exportPosition is what an export's resume token holds
+type exportPosition struct {
+	After int64 `json:"after"` // last exported movie id
+}
+
+// exportCheckpoint lines are mixed into the export. Imports skip them,
+// so an export can be imported as it is
+type exportCheckpoint struct {
+	Checkpoint string `json:"checkpoint"`
+	Done       bool   `json:"done,omitempty"`
+}
+
+// exportMoviesHandler streams every movie as newline delimited JSON,
+// ordered by id. Every ?checkpoint_every movies (1000 by default) comes a
+// {"checkpoint": "..."} line. When the connection drops the client keeps
+// what it got up to the last checkpoint and asks for ?resume=<checkpoint>.
+// The last line is a checkpoint with "done": true, without it the export
+// is incomplete
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	format := app.readString(qs, "format", "ndjson")
+	every := app.readInt(qs, "checkpoint_every", 1000, v)
+
+	var position exportPosition
+	if token := qs.Get("resume"); token != "" {
+		err := decodeResumeToken(token, &position)
+		v.Check(err == nil, "resume", "must be a checkpoint of an earlier export")
+	}
+
+	v.Check(format == "ndjson", "format", "must be ndjson")
+	v.Check(every > 0, "checkpoint_every", "must be greater than zero")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	models := app.tenantModels(r)
+
+	movies, err := models.Movies.GetAllAfter(position.After, exportPageSize)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The server's write timeout is for normal responses, an export of
+	// the whole catalog can take a lot longer
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	// The body would be thrown away, no need to read the whole catalog for it
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	exported := 0
+
+	for {
+		for _, movie := range movies {
+			err = enc.Encode(movie)
+			if err != nil {
+				return // the client went away
+			}
+
+			position.After = movie.ID
+			exported++
+
+			if exported%every == 0 {
+				err = enc.Encode(exportCheckpoint{Checkpoint: encodeResumeToken(position)})
+				if err != nil {
+					return
+				}
+				rc.Flush()
+			}
+		}
+
+		if len(movies) < exportPageSize {
+			break
+		}
+
+		movies, err = models.Movies.GetAllAfter(position.After, exportPageSize)
+		if err != nil {
+			// Too late for an error response, the missing "done" tells the client
+			app.logError(r, err)
+			return
+		}
+	}
+
+	enc.Encode(exportCheckpoint{Checkpoint: encodeResumeToken(position), Done: true})
+}
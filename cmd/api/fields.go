@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Fields that can be requested with ?fields=. They are the JSON names,
+// projection happens after the movie is serialized, so SQL isn't affected
+var movieFieldsSafelist = []string{"id", "title", "year", "runtime", "genres", "version", "certifications", "content_warnings", "budget", "revenue", "currency"}
+
+func (app *application) readFields(qs url.Values, safelist []string, v *validator.Validator) []string {
+	fields := app.readCSV(qs, "fields", nil)
+
+	for _, field := range fields {
+		if !validator.PermittedValue(field, safelist...) {
+			v.AddError("fields", "unknown field "+field)
+		}
+	}
+
+	return fields
+}
+
+// project serializes value (a struct or a slice of structs) and keeps only
+// the requested keys. Fields tagged omitempty are still left out when they
+// are empty, so a client asking for year can get an object without it.
+// Without fields the value is returned unchanged
+func project(value any, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	pick := func(full map[string]json.RawMessage) map[string]json.RawMessage {
+		sparse := make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if v, ok := full[field]; ok {
+				sparse[field] = v
+			}
+		}
+		return sparse
+	}
+
+	if len(js) > 0 && js[0] == '[' {
+		var items []map[string]json.RawMessage
+
+		err = json.Unmarshal(js, &items)
+		if err != nil {
+			return nil, err
+		}
+
+		sparse := make([]map[string]json.RawMessage, len(items))
+		for i, item := range items {
+			sparse[i] = pick(item)
+		}
+		return sparse, nil
+	}
+
+	var item map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &item)
+	if err != nil {
+		return nil, err
+	}
+
+	return pick(item), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Malformed input must come back as an error, never as a panic. These run
+// their seeds with plain go test, go test -fuzz=FuzzReadJSON ./cmd/api
+// goes looking for more
+
+func FuzzReadJSON(f *testing.F) {
+	f.Add([]byte(`{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"]}`), "strict")
+	f.Add([]byte(`{"title":"Moana","runtime":107.5}`), "lenient")
+	f.Add([]byte(`{"title":"Moana"}{"title":"Moana"}`), "strict")
+	f.Add([]byte(`{"title":"Moana","rating":"PG"}`), "strict")
+	f.Add([]byte(`{"runtime":"1h47m30s","certifications":{"US":["PG"]}}`), "lenient")
+	f.Add([]byte(`["title"]`), "")
+	f.Add([]byte(``), "bogus")
+
+	app := newTestApplication(f)
+
+	f.Fuzz(func(t *testing.T, body []byte, mode string) {
+		var input struct {
+			Title          string            `json:"title"`
+			Year           int32             `json:"year"`
+			Runtime        data.Runtime      `json:"runtime"`
+			Genres         []string          `json:"genres"`
+			Certifications map[string]string `json:"certifications"`
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", bytes.NewReader(body))
+		r.Header.Set("X-Input-Mode", mode)
+
+		app.readJSON(httptest.NewRecorder(), r, &input)
+	})
+}
+
+func FuzzReadQuery(f *testing.F) {
+	f.Add("genres=drama,comedy&page=2&ids=1,5,9&include_total=false")
+	f.Add("page=-1&ids=0,-5&include_total=maybe")
+	f.Add("ids=,,&page=99999999999999999999&genres=")
+	f.Add("ids=1%2C2&page=%zz")
+
+	app := newTestApplication(f)
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		qs, err := url.ParseQuery(raw)
+		if err != nil {
+			return
+		}
+
+		v := validator.New()
+
+		app.readCSV(qs, "genres", nil)
+		app.readInt(qs, "page", 1, v)
+		app.readBool(qs, "include_total", true, v)
+
+		for _, id := range app.readIDList(qs, "ids", v) {
+			if id < 1 {
+				t.Fatalf("readIDList(%q) returned id %d", qs.Get("ids"), id)
+			}
+		}
+	})
+}
+
+// FuzzParseSort goes through the list handler, sort keys end up in the SQL
+// so anything that gets past ValidateFilters has to be safe to sort by
+func FuzzParseSort(f *testing.F) {
+	f.Add("id")
+	f.Add("-year,title")
+	f.Add("-year,title,-runtime,revenue,id")
+	f.Add("year,-year")
+	f.Add("title;DROP TABLE movies")
+	f.Add(",")
+	f.Add("--id")
+
+	h := newTestApplication(f).routes()
+
+	f.Fuzz(func(t *testing.T, sort string) {
+		rr := serve(t, h, http.MethodGet, "/v1/movies?sort="+url.QueryEscape(sort), nil, nil)
+
+		if rr.Code != http.StatusOK && rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("sort=%q: got status %d, body %s", sort, rr.Code, rr.Body)
+		}
+	})
+}
+
+func FuzzDecodeResumeToken(f *testing.F) {
+	f.Add(encodeResumeToken(map[string]int64{"after_id": 42}))
+	f.Add("eyJhZnRlcl9pZCI6")
+	f.Add("not base64!")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, token string) {
+		var position struct {
+			AfterID int64 `json:"after_id"`
+		}
+
+		decodeResumeToken(token, &position)
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGenreHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.apiKeys.required = true
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation", "adventure")
+	insertTestMovie(t, app, "Up", 2009, "cartoon")
+
+	admin := map[string]string{"X-API-Key": insertTestAPIKey(t, app, 1, "read", "admin")}
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "list", method: http.MethodGet, target: "/v1/genres", headers: admin, wantStatus: http.StatusOK, wantBody: "adventure"},
+		{name: "rename", method: http.MethodPatch, target: "/v1/admin/genres/2", body: `{"name":"quest"}`, headers: admin, wantStatus: http.StatusOK, wantBody: "quest"},
+		{name: "rename invalid", method: http.MethodPatch, target: "/v1/admin/genres/2", body: `{"name":""}`, headers: admin, wantStatus: http.StatusUnprocessableEntity, wantBody: `"name"`},
+		{name: "rename malformed", method: http.MethodPatch, target: "/v1/admin/genres/2", body: `{"name":[]}`, headers: admin, wantStatus: http.StatusBadRequest},
+		{name: "rename missing", method: http.MethodPatch, target: "/v1/admin/genres/999", body: `{"name":"quest"}`, headers: admin, wantStatus: http.StatusNotFound},
+		{name: "merge into itself", method: http.MethodPost, target: "/v1/admin/genres/3/merge", body: `{"into":3}`, headers: admin, wantStatus: http.StatusUnprocessableEntity, wantBody: `"into"`},
+		{name: "merge", method: http.MethodPost, target: "/v1/admin/genres/3/merge", body: `{"into":1}`, headers: admin, wantStatus: http.StatusOK},
+		{name: "merged away", method: http.MethodPost, target: "/v1/admin/genres/3/merge", body: `{"into":1}`, headers: admin, wantStatus: http.StatusNotFound},
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestResponseShapes compares whole responses with testdata/*.golden, so a
+// renamed field or a changed omitempty shows up as a diff. After changing
+// a response on purpose, go test ./cmd/api -run TestResponseShapes -update
+func TestResponseShapes(t *testing.T) {
+	tests := []struct {
+		name        string
+		errorFormat string
+		method      string
+		target      string
+		body        string
+		headers     map[string]string
+	}{
+		{name: "movie", method: http.MethodGet, target: "/v1/movies/1"},
+		{name: "movie_created", method: http.MethodPost, target: "/v1/movies", body: `{"title":"Deadpool","year":2016,"runtime":108,"genres":["action","comedy"],"budget":58000000,"currency":"USD"}`},
+		{name: "movie_list", method: http.MethodGet, target: "/v1/movies?page_size=1&sort=-year"},
+		{name: "movie_list_without_total", method: http.MethodGet, target: "/v1/movies?page_size=1&include_total=false"},
+		{name: "movie_jsonapi", method: http.MethodGet, target: "/v1/movies/1", headers: map[string]string{"Accept": jsonAPIMediaType}},
+		{name: "movie_list_jsonapi", method: http.MethodGet, target: "/v1/movies?page_size=1", headers: map[string]string{"Accept": jsonAPIMediaType}},
+		{name: "error_not_found", method: http.MethodGet, target: "/v1/movies/999"},
+		{name: "error_validation", method: http.MethodPost, target: "/v1/movies", body: `{"title":"","year":1500,"runtime":-1,"genres":[]}`},
+		{name: "problem_not_found", errorFormat: "problem", method: http.MethodGet, target: "/v1/movies/999"},
+		{name: "problem_validation", errorFormat: "problem", method: http.MethodPost, target: "/v1/movies", body: `{"title":"","year":1500,"runtime":-1,"genres":[]}`},
+		{name: "problem_method_not_allowed", errorFormat: "problem", method: http.MethodPut, target: "/v1/movies/1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			if tt.errorFormat != "" {
+				app.config.errorFormat = tt.errorFormat
+			}
+			h := app.routes()
+
+			insertTestMovie(t, app, "Moana", 2016, "animation", "adventure")
+			insertTestMovie(t, app, "Black Panther", 2018, "action")
+
+			var body []byte
+			if tt.body != "" {
+				body = []byte(tt.body)
+			}
+
+			rr := serve(t, h, tt.method, tt.target, body, tt.headers)
+
+			// Indented so a change is a readable diff
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, rr.Body.Bytes(), "", "  "); err != nil {
+				t.Fatalf("response isn't JSON: %v\n%s", err, rr.Body)
+			}
+
+			got := []byte(rr.Result().Status + "\nContent-Type: " + rr.Header().Get("Content-Type") + "\n\n" + indented.String())
+			path := filepath.Join("testdata", tt.name+".golden")
+
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+
+			if string(got) != string(want) {
+				t.Errorf("response doesn't match %s\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
@@ -1,21 +1,185 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
+	"time"
 )
 
+// healthcheckHandler is the liveness check: the process is up and serving.
+// It doesn't fail when the database is down, restarting us wouldn't help
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 
 	data := envelope{
 		"status": "available",
-		"system_info": map[string]string{
-			"env":     app.config.env,
-			"version": version,
-		},
+		"system_info": struct {
+			Env string `json:"env"`
+			buildInfo
+		}{app.config.env, build},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, data, nil)
+	if app.breaker.Open() {
+		data["database"] = "circuit open"
+	}
+
+	// An exhausted pool shows here as waits long before requests time out
+	if app.db != nil {
+		data["database_pool"] = newDBPoolStats(app.db.Stats())
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readinessHandler tells load balancers whether to send us traffic.
+// We're not ready while the database is unreachable or while shutting down
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"database": "ok"}
+	ready := true
+
+	switch {
+	case app.shuttingDown.Load():
+		checks["server"] = "shutting down"
+		ready = false
+	case app.breaker.Open():
+		checks["database"] = "circuit open"
+		ready = false
+	case app.db != nil: // in-memory storage is always there
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+
+		err := app.db.PingContext(ctx)
+		if err != nil {
+			checks["database"] = err.Error()
+			ready = false
+		}
+	}
+
+	data := envelope{"status": "ready", "checks": checks}
+	status := http.StatusOK
+
+	if !ready {
+		data["status"] = "not ready"
+		status = http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, r, status, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// healthzHandler is the liveness probe for Kubernetes. It checks nothing
+// but that requests are served, a dependency being down is no reason
+// to restart the process
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// componentStatus is one check of /readyz. Status is ok, failing or
+// disabled, message says why it's failing
+type componentStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// readyzHandler is the readiness probe for Kubernetes, with a status for
+// every component: the database is reachable, its migrations are all
+// applied and the job workers are running. Any failing one makes it 503
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ok := componentStatus{Status: "ok"}
+	disabled := componentStatus{Status: "disabled"}
+	failing := func(format string, args ...any) componentStatus {
+		return componentStatus{Status: "failing", Message: fmt.Sprintf(format, args...)}
+	}
+
+	checks := map[string]componentStatus{
+		"server":     ok,
+		"database":   ok,
+		"migrations": disabled,
+		"jobs":       disabled,
+	}
+
+	if app.shuttingDown.Load() {
+		checks["server"] = failing("shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	switch {
+	case app.breaker.Open():
+		checks["database"] = failing("circuit open")
+	case app.db != nil: // in-memory storage is always there
+		err := app.db.PingContext(ctx)
+		if err != nil {
+			checks["database"] = failing("%s", err)
+		}
+	}
+
+	if app.migrator != nil && checks["database"].Status == "ok" {
+		version, dirty, err := app.migrator.Version(ctx)
+		switch {
+		case err != nil:
+			checks["migrations"] = failing("%s", err)
+		case dirty:
+			checks["migrations"] = failing("version %d is dirty", version)
+		case version < app.migrator.Latest():
+			checks["migrations"] = failing("at version %d, this build needs %d", version, app.migrator.Latest())
+		default:
+			checks["migrations"] = ok
+		}
+	}
+
+	if app.jobs != nil && app.config.jobs.workers > 0 {
+		running := app.jobs.Running()
+		checks["jobs"] = ok
+		if running < app.config.jobs.workers {
+			checks["jobs"] = failing("%d of %d workers running", running, app.config.jobs.workers)
+		}
+	}
+
+	data := envelope{"status": "ready", "checks": checks}
+	status := http.StatusOK
+
+	for _, check := range checks {
+		if check.Status == "failing" {
+			data["status"] = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	err := app.writeJSON(w, r, status, data, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dbPoolStats is the part of sql.DBStats worth watching, with JSON names.
+// It's in the healthcheck and in /debug/vars as "db_pool"
+type dbPoolStats struct {
+	MaxOpen        int   `json:"max_open"`
+	Open           int   `json:"open"`
+	InUse          int   `json:"in_use"`
+	Idle           int   `json:"idle"`
+	WaitCount      int64 `json:"wait_count"`
+	WaitDurationMS int64 `json:"wait_duration_ms"`
+}
+
+func newDBPoolStats(stats sql.DBStats) dbPoolStats {
+	return dbPoolStats{
+		MaxOpen:        stats.MaxOpenConnections,
+		Open:           stats.OpenConnections,
+		InUse:          stats.InUse,
+		Idle:           stats.Idle,
+		WaitCount:      stats.WaitCount,
+		WaitDurationMS: stats.WaitDuration.Milliseconds(),
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHealthAndRouting(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "healthcheck", method: http.MethodGet, target: "/v1/healthcheck", wantStatus: http.StatusOK, wantBody: `"status":"available"`},
+		{name: "healthz", method: http.MethodGet, target: "/v1/healthz", wantStatus: http.StatusOK},
+		{name: "version", method: http.MethodGet, target: "/v1/version", wantStatus: http.StatusOK},
+		{name: "v2 healthcheck", method: http.MethodGet, target: "/v2/healthcheck", wantStatus: http.StatusOK},
+		{name: "options", method: http.MethodOptions, target: "/v1/movies/1", wantStatus: http.StatusNoContent},
+		{name: "method not allowed", method: http.MethodPost, target: "/v1/healthcheck", wantStatus: http.StatusMethodNotAllowed, wantBody: "POST"},
+		{name: "unknown operation", method: http.MethodGet, target: "/v1/operations/999", wantStatus: http.StatusNotFound},
+		{name: "bad request timeout", method: http.MethodGet, target: "/v1/movies", headers: map[string]string{"X-Request-Timeout": "soon"}, wantStatus: http.StatusBadRequest},
+		{name: "unsupported content encoding", method: http.MethodPost, target: "/v1/movies", body: `{}`, headers: map[string]string{"Content-Encoding": "br"}, wantStatus: http.StatusUnsupportedMediaType, wantBody: "only gzip"},
+		{name: "bad input mode", method: http.MethodPost, target: "/v1/movies", body: `{}`, headers: map[string]string{"X-Input-Mode": "loose"}, wantStatus: http.StatusBadRequest},
+	})
+
+	rr := serve(t, h, http.MethodOptions, "/v1/movies/1", nil, nil)
+	if got := rr.Header().Get("Allow"); got != "DELETE, GET, HEAD, OPTIONS, PATCH" {
+		t.Errorf("got Allow %q", got)
+	}
+}
+
+func TestUnsupportedContentEncoding(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	for _, target := range []string{"/v1/movies", "/v1/collections"} {
+		rr := serve(t, h, http.MethodPost, target, []byte(`{}`), map[string]string{"Content-Encoding": "br"})
+
+		if rr.Code != http.StatusUnsupportedMediaType || rr.Header().Get("Accept-Encoding") != "gzip" {
+			t.Errorf("%s: got status %d, Accept-Encoding %q, want 415 and gzip", target, rr.Code, rr.Header().Get("Accept-Encoding"))
+		}
+	}
+}
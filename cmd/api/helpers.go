@@ -1,24 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/julienschmidt/httprouter"
+	"greenlight.brainwhat/internal/validator"
 )
 
 func (app *application) readIDParams(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
 
-	params := httprouter.ParamsFromContext(r.Context())
+// Reads positive integer URL parameter, e.g. {revision} in /v1/movies/{id}/revert/{revision}
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -26,37 +35,115 @@ func (app *application) readIDParams(r *http.Request) (int64, error) {
 
 type envelope map[string]any
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+// Response buffers are reused, except the odd huge one that would
+// otherwise stay in memory for good
+const maxPooledBufferSize = 64 << 10
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// writeJSON encodes data into a pooled buffer, so a value that fails to
+// encode can still get an error response and the length is known up
+// front. Responses are compact unless the request has ?pretty=1
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	enc := json.NewEncoder(buf)
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		enc.SetIndent("", "\t")
+	}
 
-	js, err := json.MarshalIndent(data, "", "\t")
+	err := enc.Encode(data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
-
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	// Error responses can ask for a different media type, e.g. application/problem+json
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
 	w.WriteHeader(status)
-	w.Write(js)
+	w.Write(buf.Bytes())
 
 	return nil
 }
 
+var errUnsupportedContentEncoding = errors.New("unsupported Content-Encoding")
+
+// requestBody limits the request body to maxBytes and transparently
+// decompresses it when the client sent Content-Encoding: gzip.
+// The limit applies to the decompressed data as well, otherwise a tiny
+// gzip bomb could expand into gigabytes in memory
+func (app *application) requestBody(w http.ResponseWriter, r *http.Request, maxBytes int64) (io.Reader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return r.Body, nil
+
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, errors.New("body is not valid gzip data")
+		}
+
+		return http.MaxBytesReader(w, gz, maxBytes), nil
+
+	default:
+		return nil, fmt.Errorf("%w %q, only gzip is accepted", errUnsupportedContentEncoding, r.Header.Get("Content-Encoding"))
+	}
+}
+
+// strictInput tells if unknown fields fail the request. Partners that want
+// typos caught send X-Input-Mode: strict, clients that send extra fields
+// send lenient, everyone else gets -input-mode
+func (app *application) strictInput(r *http.Request) (bool, error) {
+	mode := r.Header.Get("X-Input-Mode")
+	if mode == "" {
+		mode = app.config.inputMode
+	}
+
+	switch strings.ToLower(mode) {
+	case "strict":
+		return true, nil
+	case "lenient":
+		return false, nil
+	default:
+		return false, errors.New("X-Input-Mode header must be strict or lenient")
+	}
+}
+
 // We are working around every error that json.Decode() can return
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	strict, err := app.strictInput(r)
+	if err != nil {
+		return err
+	}
 
 	// Protect against DOS attacks
 	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	body, err := app.requestBody(w, r, int64(maxBytes))
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(body)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
 
-	err := dec.Decode(dst)
+	err = dec.Decode(dst)
 	if err != nil {
 		// This one is for convenience
 		var syntaxError *json.SyntaxError
@@ -110,3 +197,113 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 	return nil
 }
+
+// Query string helpers. They return the default value if the key is missing
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// Conversion errors are recorded in the validator instead of being returned
+// so that the client gets all the bad parameters in one response
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+
+	return b
+}
+
+// Reads comma separated list of ids, e.g. ?ids=1,5,9
+func (app *application) readIDList(qs url.Values, key string, v *validator.Validator) []int64 {
+	var ids []int64
+
+	for _, s := range app.readCSV(qs, key, nil) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError(key, "must be a comma separated list of positive integers")
+			return nil
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Resume tokens are opaque to clients, but really just base64 JSON.
+// They only point into the tenant's own data, so they aren't signed
+func encodeResumeToken(position any) string {
+	js, _ := json.Marshal(position)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeResumeToken(token string, position any) error {
+	js, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(js, position)
+}
+
+func (app *application) lastModifiedHeaders(lastModified time.Time) http.Header {
+	headers := make(http.Header)
+	headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	return headers
+}
+
+// unmodifiedSince reports whether the If-Unmodified-Since precondition holds.
+// Missing or invalid dates are ignored, as RFC 9110 tells us to
+func (app *application) unmodifiedSince(r *http.Request, lastModified time.Time) bool {
+	header := r.Header.Get("If-Unmodified-Since")
+	if header == "" {
+		return true
+	}
+
+	t, err := http.ParseTime(header)
+	if err != nil {
+		return true
+	}
+
+	// HTTP dates only have second precision
+	return !lastModified.Truncate(time.Second).After(t)
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/storage"
+	"greenlight.brainwhat/internal/validator"
+)
+
+const (
+	maxImportBytes = 100 << 20
+
+	// The server's read timeout is made for JSON bodies, not 100MB uploads
+	importReadTimeout = 10 * time.Minute
+
+	// Valid rows are inserted this many per transaction. A failing batch
+	// fails the import, the batches before it stay imported
+	importBatchSize = 500
+)
+
+// Movie fields a column can be mapped to. Lists like genres go in one
+// cell separated by |, certifications look like US=PG-13|DE=12
+var (
+	importFields         = []string{"title", "year", "runtime", "genres", "certifications", "content_warnings", "budget", "revenue", "currency"}
+	requiredImportFields = []string{"title", "year", "runtime", "genres"}
+)
+
+var importFormats = []string{"csv", "tsv", "ndjson"}
+
+// Field separators of the formats with columns
+var importDelimiters = map[string]rune{
+	"csv": ',',
+	"tsv": '\t',
+}
+
+type importParams struct {
+	Key     string            `json:"key"` // the upload in storage
+	Format  string            `json:"format"`
+	Columns map[string]string `json:"columns,omitempty"` // header -> movie field
+	Resume  int64             `json:"resume,omitempty"`  // rows to skip
+}
+
+// importPosition is what an import's checkpoint holds
+type importPosition struct {
+	Rows int64 `json:"rows"` // rows done, imported or reported
+}
+
+// importRowError is one line of the error report
+type importRowError struct {
+	Column  string
+	Message string
+}
+
+// importSource reads an upload one row at a time. line is where the row
+// starts in the file, for the report. io.EOF means there are no more rows
+type importSource interface {
+	next() (line int, movie *data.Movie, errs []importRowError, err error)
+}
+
+func importReportKey(operationID int64) string {
+	return fmt.Sprintf("operations/%d/errors.csv", operationID)
+}
+
+// importMoviesHandler takes a multipart upload: the file, its format
+// (csv, tsv or ndjson, by default from the file name) and for csv and
+// tsv an optional mapping of the file's headers to movie fields, e.g.
+// {"Movie": "title"}. Without a mapping headers have to be named like
+// the fields. Only the header is checked here, the rows are validated by
+// the import operation. Rows that fail end up in its error report.
+// A failed import can be resumed by uploading the same file again with
+// resume set to the checkpoint in the operation's progress
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(importReadTimeout))
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	// Parts over 32MB go to temporary files instead of memory
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.badRequestReponse(w, r, fmt.Errorf("upload must not be larger than %d bytes", maxImportBytes))
+			return
+		}
+		app.badRequestReponse(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			v.AddError("file", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.badRequestReponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		switch strings.ToLower(path.Ext(header.Filename)) {
+		case ".tsv", ".tab":
+			format = "tsv"
+		case ".ndjson", ".jsonl":
+			format = "ndjson"
+		default:
+			format = "csv"
+		}
+	}
+
+	v.Check(validator.PermittedValue(format, importFormats...), "format", "must be one of "+strings.Join(importFormats, ", "))
+
+	var mapping map[string]string
+	if m := r.FormValue("mapping"); m != "" {
+		err = json.Unmarshal([]byte(m), &mapping)
+		v.Check(err == nil, "mapping", "must be a JSON object of column headers to movie fields")
+		v.Check(format != "ndjson", "mapping", "only works with csv and tsv")
+	}
+
+	var resume importPosition
+	if token := r.FormValue("resume"); token != "" {
+		err = decodeResumeToken(token, &resume)
+		v.Check(err == nil && resume.Rows >= 0, "resume", "must be the checkpoint of an earlier import")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	params := importParams{Format: format, Resume: resume.Rows}
+
+	if delimiter, ok := importDelimiters[format]; ok {
+		reader := csv.NewReader(file)
+		reader.Comma = delimiter
+
+		headers, err := reader.Read()
+		if err != nil {
+			v.AddError("file", "must start with a header row")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		params.Columns = importColumns(v, headers, mapping)
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// The header was only peeked at, the operation reads the whole file
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The operation may run on another instance, so the upload goes to storage
+	random := make([]byte, 16)
+	_, err = rand.Read(random)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	params.Key = "imports/" + hex.EncodeToString(random) + "." + format
+
+	err = app.storage.Put(r.Context(), params.Key, file, header.Size, "text/"+format)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.startOperation(w, r, "movies.import", params)
+}
+
+// importColumns works out which header goes to which movie field
+func importColumns(v *validator.Validator, headers []string, mapping map[string]string) map[string]string {
+	headers[0] = strings.TrimPrefix(headers[0], "\ufeff") // Excel starts its CSVs with a BOM
+
+	for i, h := range headers {
+		v.Check(!slices.Contains(headers[:i], h), "file", fmt.Sprintf("has the column %q twice", h))
+	}
+
+	if mapping == nil {
+		mapping = make(map[string]string)
+		for _, h := range headers {
+			field := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(h)), " ", "_")
+			if slices.Contains(importFields, field) {
+				mapping[h] = field
+			}
+		}
+	}
+
+	columns := make(map[string]string, len(mapping))
+	mapped := make(map[string]bool)
+
+	for h, field := range mapping {
+		switch {
+		case !slices.Contains(headers, h):
+			v.AddError("mapping", fmt.Sprintf("the file has no column %q", h))
+		case !slices.Contains(importFields, field):
+			v.AddError("mapping", fmt.Sprintf("%q isn't one of %s", field, strings.Join(importFields, ", ")))
+		case mapped[field]:
+			v.AddError("mapping", fmt.Sprintf("more than one column is mapped to %s", field))
+		}
+
+		columns[h] = field
+		mapped[field] = true
+	}
+
+	for _, field := range requiredImportFields {
+		v.Check(mapped[field], "mapping", fmt.Sprintf("no column is mapped to %s", field))
+	}
+
+	return columns
+}
+
+// importMoviesOperation goes through the upload row by row, so the file
+// is never in memory as a whole. Invalid rows are skipped and reported.
+// Each batch saves the checkpoint in its own transaction, so a retried
+// job or a resumed import never imports a row twice. The error report
+// of a resumed import only has the rows after the checkpoint
+func (app *application) importMoviesOperation(ctx context.Context, op *data.Operation, progress func(data.OperationProgress)) (any, error) {
+	var params importParams
+
+	err := json.Unmarshal(op.Params, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	// A job that is retried after its worker died goes on from its own checkpoint
+	skip := params.Resume
+	if op.Progress.Checkpoint != "" {
+		var position importPosition
+
+		err = decodeResumeToken(op.Progress.Checkpoint, &position)
+		if err != nil {
+			return nil, err
+		}
+		skip = max(skip, position.Rows)
+	}
+
+	upload, _, err := app.storage.Get(ctx, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer upload.Close()
+
+	// The operation finishes either way, nobody needs the upload after that
+	defer func() {
+		err := app.storage.Delete(context.Background(), params.Key)
+		if err != nil {
+			app.logger.Error("deleting import upload", "key", params.Key, "error", err)
+		}
+	}()
+
+	var source importSource
+
+	if delimiter, ok := importDelimiters[params.Format]; ok {
+		source, err = newCSVSource(upload, delimiter, params.Columns, app.validateMovie)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		source = &ndjsonSource{reader: bufio.NewReader(upload), validate: app.validateMovie}
+	}
+
+	report, err := os.CreateTemp("", "greenlight-import-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(report.Name())
+	defer report.Close()
+
+	reportWriter := csv.NewWriter(report)
+	reportWriter.Write([]string{"row", "column", "message"})
+
+	var result struct {
+		Rows        int64  `json:"rows"`
+		Skipped     int64  `json:"skipped,omitempty"` // because of the checkpoint
+		Imported    int64  `json:"imported"`
+		Failed      int64  `json:"failed"`
+		ErrorReport string `json:"error_report,omitempty"`
+	}
+
+	var rows int64 // including the skipped ones
+	var checkpoint string
+
+	for rows < skip {
+		_, _, _, err := source.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows++
+	}
+	result.Skipped = rows
+
+	var batch []*data.Movie
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		position := data.OperationProgress{Done: rows, Checkpoint: encodeResumeToken(importPosition{Rows: rows})}
+
+		err := app.withTenantTx(ctx, op.TenantID, func(models data.Models) error {
+			for _, movie := range batch {
+				err := models.Movies.Insert(movie)
+				if err != nil {
+					return err
+				}
+			}
+			return models.Operations.Progress(ctx, op.ID, position)
+		})
+		if err != nil {
+			return err
+		}
+
+		result.Imported += int64(len(batch))
+		batch = batch[:0]
+		checkpoint = position.Checkpoint
+		return nil
+	}
+
+	for {
+		line, movie, errs, err := source.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rows++
+		result.Rows++
+
+		if len(errs) > 0 {
+			result.Failed++
+			for _, e := range errs {
+				reportWriter.Write([]string{strconv.Itoa(line), e.Column, e.Message})
+			}
+		} else {
+			batch = append(batch, movie)
+		}
+
+		if len(batch) >= importBatchSize {
+			err = flush()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		progress(data.OperationProgress{Done: rows, Checkpoint: checkpoint})
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, err
+	}
+	progress(data.OperationProgress{Done: rows, Total: rows, Checkpoint: encodeResumeToken(importPosition{Rows: rows})})
+
+	if result.Failed == 0 {
+		return result, nil
+	}
+
+	reportWriter.Flush()
+	if err = reportWriter.Error(); err != nil {
+		return nil, err
+	}
+
+	size, err := report.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	_, err = report.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.storage.Put(ctx, importReportKey(op.ID), report, size, "text/csv")
+	if err != nil {
+		return nil, err
+	}
+
+	result.ErrorReport = app.url("operation-errors", "id", op.ID)
+	return result, nil
+}
+
+type csvSource struct {
+	reader   *csv.Reader
+	headers  []string
+	columns  map[string]string
+	validate movieValidator
+}
+
+// newCSVSource reads the header right away, the rows are counted after it
+func newCSVSource(r io.Reader, delimiter rune, columns map[string]string, validate movieValidator) (*csvSource, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1 // checked per row, so the row can be reported
+	reader.LazyQuotes = true
+
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	headers[0] = strings.TrimPrefix(headers[0], "\ufeff")
+
+	return &csvSource{reader: reader, headers: headers, columns: columns, validate: validate}, nil
+}
+
+func (s *csvSource) next() (int, *data.Movie, []importRowError, error) {
+	record, err := s.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return parseError.StartLine, nil, []importRowError{{Message: parseError.Err.Error()}}, nil
+		}
+		return 0, nil, nil, err
+	}
+
+	line, _ := s.reader.FieldPos(0)
+
+	movie, errs := parseCSVRow(s.headers, record, s.columns, s.validate)
+	return line, movie, errs, nil
+}
+
+// parseCSVRow turns a row into a movie. Errors name the column of the
+// file, not the movie field, that's what the client can find in the file
+func parseCSVRow(headers, record []string, columns map[string]string, validate movieValidator) (*data.Movie, []importRowError) {
+	if len(record) != len(headers) {
+		return nil, []importRowError{{Message: fmt.Sprintf("has %d columns, the header has %d", len(record), len(headers))}}
+	}
+
+	var movie data.Movie
+	var errs []importRowError
+
+	fieldColumns := make(map[string]string, len(columns))
+
+	for i, h := range headers {
+		field, ok := columns[h]
+		if !ok {
+			continue
+		}
+		fieldColumns[field] = h
+
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+
+		fail := func(message string) {
+			errs = append(errs, importRowError{Column: h, Message: message})
+		}
+
+		switch field {
+		case "title":
+			movie.Title = value
+		case "year":
+			year, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				fail("must be a year like 1979")
+			}
+			movie.Year = int32(year)
+		case "runtime":
+			// Same formats the JSON API takes
+			err := movie.Runtime.UnmarshalJSON([]byte(strconv.Quote(value)))
+			if err != nil {
+				fail(data.ErrInvalidRuntimeFormat.Error())
+			}
+		case "genres":
+			movie.Genres = splitImportList(value)
+		case "content_warnings":
+			movie.ContentWarnings = splitImportList(value)
+		case "certifications":
+			movie.Certifications = make(map[string]string)
+			for _, item := range splitImportList(value) {
+				country, rating, ok := strings.Cut(item, "=")
+				if !ok {
+					fail("must look like US=PG-13|DE=12")
+					break
+				}
+				movie.Certifications[strings.TrimSpace(country)] = strings.TrimSpace(rating)
+			}
+		case "budget", "revenue":
+			amount, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				fail("must be a whole number")
+			}
+			if field == "budget" {
+				movie.Budget = amount
+			} else {
+				movie.Revenue = amount
+			}
+		case "currency":
+			movie.Currency = value
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	errs = validateImportedMovie(&movie, validate, func(field string) string { return cmp.Or(fieldColumns[field], field) })
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b importRowError) int {
+			return slices.Index(headers, a.Column) - slices.Index(headers, b.Column)
+		})
+		return nil, errs
+	}
+
+	return &movie, nil
+}
+
+func splitImportList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type ndjsonSource struct {
+	reader   *bufio.Reader
+	line     int
+	validate movieValidator
+}
+
+func (s *ndjsonSource) next() (int, *data.Movie, []importRowError, error) {
+	for {
+		b, err := s.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, nil, nil, err
+		}
+		if len(b) == 0 && err != nil {
+			return 0, nil, nil, io.EOF
+		}
+
+		s.line++
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+
+		movie, errs, isCheckpoint := parseNDJSONRow(b, s.validate)
+		if isCheckpoint {
+			continue
+		}
+
+		return s.line, movie, errs, nil
+	}
+}
+
+// parseNDJSONRow reads a movie like the create endpoint takes it. Other
+// fields are ignored, so the lines of an export with their ids and
+// versions import fine. So do its checkpoint lines, they're skipped
+func parseNDJSONRow(b []byte, validate movieValidator) (*data.Movie, []importRowError, bool) {
+	var input struct {
+		Checkpoint      *string           `json:"checkpoint"`
+		Title           string            `json:"title"`
+		Year            int32             `json:"year"`
+		Runtime         data.Runtime      `json:"runtime"`
+		Genres          []string          `json:"genres"`
+		Certifications  map[string]string `json:"certifications"`
+		ContentWarnings []string          `json:"content_warnings"`
+		Budget          int64             `json:"budget"`
+		Revenue         int64             `json:"revenue"`
+		Currency        string            `json:"currency"`
+	}
+
+	err := json.Unmarshal(b, &input)
+	if err != nil {
+		var typeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &typeError):
+			return nil, []importRowError{{Column: typeError.Field, Message: "has the wrong type"}}, false
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			return nil, []importRowError{{Column: "runtime", Message: err.Error()}}, false
+		default:
+			return nil, []importRowError{{Message: "must be a JSON object"}}, false
+		}
+	}
+
+	if input.Checkpoint != nil {
+		return nil, nil, true
+	}
+
+	movie := &data.Movie{
+		Title:           input.Title,
+		Year:            input.Year,
+		Runtime:         input.Runtime,
+		Genres:          input.Genres,
+		Certifications:  input.Certifications,
+		ContentWarnings: input.ContentWarnings,
+		Budget:          input.Budget,
+		Revenue:         input.Revenue,
+		Currency:        input.Currency,
+	}
+
+	errs := validateImportedMovie(movie, validate, func(field string) string { return field })
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b importRowError) int { return strings.Compare(a.Column, b.Column) })
+		return nil, errs, false
+	}
+
+	return movie, nil, false
+}
+
+// movieValidator is app.validateMovie, the sources get it handed in
+type movieValidator func(v *validator.Validator, movie *data.Movie)
+
+// validateImportedMovie runs validate, column names the column a field came from
+func validateImportedMovie(movie *data.Movie, validate movieValidator, column func(field string) string) []importRowError {
+	v := validator.New()
+	if validate(v, movie); v.Valid() {
+		return nil
+	}
+
+	var errs []importRowError
+	for field, message := range v.Errors {
+		errs = append(errs, importRowError{Column: column(field), Message: message})
+	}
+
+	return errs
+}
+
+// showOperationErrorsHandler downloads the error report of an import
+func (app *application) showOperationErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	// Reports are stored by operation id only, this is the tenant check
+	_, err = app.tenantModels(r).Operations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	report, object, err := app.storage.Get(r.Context(), importReportKey(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer report.Close()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%d-errors.csv"`, id))
+
+	_, err = io.Copy(w, report)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
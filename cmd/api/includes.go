@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Related resources that can be embedded into movies with ?include=
+var movieIncludesSafelist = []string{"collection", "availability"}
+
+// movieOptions controls how movies are rendered
+type movieOptions struct {
+	fields []string
+
+	// Filled only when ?include=collection was requested,
+	// movies that aren't in any collection are missing from the map
+	collections map[int64]*data.MovieCollection
+
+	// Same for ?include=availability
+	availability map[int64][]*data.Availability
+}
+
+// includes tells if there are included resources for JSON:API's "included",
+// availability goes into the attributes instead
+func (opts movieOptions) includes() bool {
+	return opts.collections != nil
+}
+
+// movieView is what a movie looks like in our own envelope:
+// the movie fields, included resources and HAL style _links
+type movieView struct {
+	*data.Movie
+	Collection   *data.MovieCollection `json:"collection,omitempty"`
+	Availability []*data.Availability  `json:"availability,omitempty"`
+	Links        map[string]string     `json:"_links"`
+}
+
+// movieView makes sure _links and included resources survive ?fields= projection
+func (app *application) movieView(movie *data.Movie, opts movieOptions) (any, error) {
+	fields := opts.fields
+	if len(fields) > 0 {
+		fields = append(fields[:len(fields):len(fields)], "_links", "collection", "availability")
+	}
+
+	view := movieView{
+		Movie:        movie,
+		Collection:   opts.collections[movie.ID],
+		Availability: opts.availability[movie.ID],
+		Links:        app.movieLinks(movie),
+	}
+
+	return project(view, fields)
+}
+
+func (app *application) readIncludes(qs url.Values, safelist []string, v *validator.Validator) []string {
+	includes := app.readCSV(qs, "include", nil)
+
+	for _, include := range includes {
+		if !validator.PermittedValue(include, safelist...) {
+			v.AddError("include", "cannot include "+include)
+		}
+	}
+
+	return includes
+}
+
+// loadIncludes fetches every requested related resource for all the movies
+// at once, one query per resource type no matter how many movies there are
+func (app *application) loadIncludes(r *http.Request, opts *movieOptions, includes []string, movies ...*data.Movie) error {
+	ids := make([]int64, 0, len(movies))
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+
+	for _, include := range includes {
+		switch include {
+		case "collection":
+			collections, err := app.tenantModels(r).Collections.GetForMovies(ids)
+			if err != nil {
+				return err
+			}
+			opts.collections = collections
+		case "availability":
+			availability, err := app.tenantModels(r).Availability.GetForMovies(ids)
+			if err != nil {
+				return err
+			}
+			opts.availability = availability
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"greenlight.brainwhat/internal/data"
+)
+
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// Clients opt into JSON:API with the Accept header, everyone else
+// keeps getting our regular envelopes
+func wantsJSONAPI(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if strings.TrimSpace(mediaType) == jsonAPIMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type jsonAPIResource struct {
+	Type          string                     `json:"type"`
+	ID            string                     `json:"id"`
+	Attributes    map[string]json.RawMessage `json:"attributes"`
+	Relationships map[string]any             `json:"relationships,omitempty"`
+	Links         map[string]string          `json:"links,omitempty"`
+}
+
+// movieResource moves everything except id into attributes.
+// ?fields= still works, but only limits the attributes
+func (app *application) movieResource(movie *data.Movie, opts movieOptions) (jsonAPIResource, error) {
+	attributes, err := project(movie, opts.fields)
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+
+	js, err := json.Marshal(attributes)
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+
+	resource := jsonAPIResource{
+		Type:  "movies",
+		ID:    fmt.Sprint(movie.ID),
+		Links: app.movieLinks(movie),
+	}
+
+	err = json.Unmarshal(js, &resource.Attributes)
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+
+	delete(resource.Attributes, "id")
+
+	// Availability has no ids of its own, so it's an attribute
+	if opts.availability != nil {
+		availability := opts.availability[movie.ID]
+		if availability == nil {
+			availability = []*data.Availability{}
+		}
+
+		resource.Attributes["availability"], err = json.Marshal(availability)
+		if err != nil {
+			return jsonAPIResource{}, err
+		}
+	}
+
+	// Included resources are linked through relationships, their
+	// attributes go to the top level "included" array
+	if opts.collections != nil {
+		var linkage any // null when the movie isn't in a collection
+
+		if collection, ok := opts.collections[movie.ID]; ok {
+			linkage = map[string]string{"type": "collections", "id": fmt.Sprint(collection.ID)}
+		}
+
+		resource.Relationships = map[string]any{"collection": map[string]any{"data": linkage}}
+	}
+
+	return resource, nil
+}
+
+// jsonAPIIncluded lists every included resource once, even if several movies share it
+func (app *application) jsonAPIIncluded(opts movieOptions) []jsonAPIResource {
+	included := []jsonAPIResource{}
+	seen := make(map[int64]bool)
+
+	for _, collection := range opts.collections {
+		if seen[collection.ID] {
+			continue
+		}
+		seen[collection.ID] = true
+
+		name, _ := json.Marshal(collection.Name)
+
+		included = append(included, jsonAPIResource{
+			Type:       "collections",
+			ID:         fmt.Sprint(collection.ID),
+			Attributes: map[string]json.RawMessage{"name": name},
+			Links:      map[string]string{"self": app.url("collection", "id", collection.ID)},
+		})
+	}
+
+	sort.Slice(included, func(i, j int) bool { return included[i].ID < included[j].ID })
+
+	return included
+}
+
+func (app *application) writeJSONAPI(w http.ResponseWriter, r *http.Request, status int, doc envelope, headers http.Header) error {
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("Content-Type", jsonAPIMediaType)
+
+	return app.writeJSON(w, r, status, doc, headers)
+}
+
+// writeMovie sends a single movie either in our envelope or as a JSON:API document
+func (app *application) writeMovie(w http.ResponseWriter, r *http.Request, status int, movie *data.Movie, opts movieOptions, headers http.Header) error {
+	if wantsJSONAPI(r) {
+		resource, err := app.movieResource(movie, opts)
+		if err != nil {
+			return err
+		}
+
+		doc := envelope{"data": resource}
+		if opts.includes() {
+			doc["included"] = app.jsonAPIIncluded(opts)
+		}
+
+		return app.writeJSONAPI(w, r, status, doc, headers)
+	}
+
+	view, err := app.movieView(movie, opts)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSON(w, r, status, envelope{"movie": view}, headers)
+}
+
+// writeMovies sends a list of movies. Extra keys like metadata sit next to
+// the movies in our envelope and go under "meta" in JSON:API.
+// links are the links of the list itself, e.g. next/prev page
+func (app *application) writeMovies(w http.ResponseWriter, r *http.Request, status int, movies []*data.Movie, opts movieOptions, extra envelope, links map[string]string) error {
+	if wantsJSONAPI(r) {
+		resources := make([]jsonAPIResource, 0, len(movies))
+
+		for _, movie := range movies {
+			resource, err := app.movieResource(movie, opts)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, resource)
+		}
+
+		doc := envelope{
+			"data":  resources,
+			"links": links,
+		}
+		if len(extra) > 0 {
+			doc["meta"] = extra
+		}
+		if opts.includes() {
+			doc["included"] = app.jsonAPIIncluded(opts)
+		}
+
+		return app.writeJSONAPI(w, r, status, doc, nil)
+	}
+
+	views := make([]any, 0, len(movies))
+
+	for _, movie := range movies {
+		view, err := app.movieView(movie, opts)
+		if err != nil {
+			return err
+		}
+		views = append(views, view)
+	}
+
+	env := envelope{"movies": views, "_links": links}
+	for key, value := range extra {
+		env[key] = value
+	}
+
+	return app.writeJSON(w, r, status, env, nil)
+}
+
+// jsonAPIErrors turns our error messages into a JSON:API errors array.
+// Every invalid field becomes its own error object pointing at the attribute
+func jsonAPIErrors(status int, message any) envelope {
+	newError := func(detail string) envelope {
+		return envelope{
+			"status": fmt.Sprint(status),
+			"title":  http.StatusText(status),
+			"detail": detail,
+		}
+	}
+
+	var errs []envelope
+
+	switch m := message.(type) {
+	case map[string]string:
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		// Map order is random, sorting keeps responses stable
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			e := newError(m[key])
+			e["source"] = map[string]string{"pointer": "/data/attributes/" + key}
+			errs = append(errs, e)
+		}
+	default:
+		errs = append(errs, newError(fmt.Sprint(m)))
+	}
+
+	return envelope{"errors": errs}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.brainwhat/internal/data"
+)
+
+// url builds a path for a named route, params are wildcard/value pairs:
+// app.url("movie", "id", 5) gives /v1/movies/5
+func (app *application) url(name string, params ...any) string {
+	path, ok := app.routeNames[name]
+	if !ok {
+		// Only possible with a typo in our own code
+		panic("unknown route name " + name)
+	}
+
+	for i := 0; i+1 < len(params); i += 2 {
+		path = strings.ReplaceAll(path, fmt.Sprintf("{%v}", params[i]), fmt.Sprint(params[i+1]))
+	}
+
+	return path
+}
+
+func (app *application) movieLinks(movie *data.Movie) map[string]string {
+	return map[string]string{
+		"self":       app.url("movie", "id", movie.ID),
+		"history":    app.url("movie-history", "id", movie.ID),
+		"collection": app.url("movies"),
+	}
+}
+
+// pageLinks keeps every query parameter of the current request and only
+// changes the page, so filters and sorting carry over to next/prev
+func (app *application) pageLinks(r *http.Request, metadata data.Metadata) map[string]string {
+	links := map[string]string{"self": r.URL.RequestURI()}
+
+	// Empty metadata means there are no results to page through
+	if metadata.CurrentPage == 0 {
+		return links
+	}
+
+	page := func(n int) string {
+		qs := r.URL.Query()
+		qs.Set("page", strconv.Itoa(n))
+		return r.URL.Path + "?" + qs.Encode()
+	}
+
+	links["first"] = page(metadata.FirstPage)
+
+	// Without the total the last page isn't known, only whether there's a next one
+	if metadata.HasMore != nil {
+		if metadata.CurrentPage > metadata.FirstPage {
+			links["prev"] = page(metadata.CurrentPage - 1)
+		}
+		if *metadata.HasMore {
+			links["next"] = page(metadata.CurrentPage + 1)
+		}
+		return links
+	}
+
+	links["last"] = page(metadata.LastPage)
+
+	if metadata.CurrentPage > metadata.FirstPage {
+		links["prev"] = page(min(metadata.CurrentPage-1, metadata.LastPage))
+	}
+
+	if metadata.CurrentPage < metadata.LastPage {
+		links["next"] = page(metadata.CurrentPage + 1)
+	}
+
+	return links
+}
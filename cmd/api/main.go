@@ -3,104 +3,651 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"greenlight.brainwhat/internal/availability"
+	"greenlight.brainwhat/internal/breaker"
+	"greenlight.brainwhat/internal/clamav"
 	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/events"
+	"greenlight.brainwhat/internal/experiment"
+	"greenlight.brainwhat/internal/jsonschema"
+	"greenlight.brainwhat/internal/migrate"
+	"greenlight.brainwhat/internal/ratelimit"
+	"greenlight.brainwhat/internal/shed"
+	"greenlight.brainwhat/internal/sqlstats"
+	"greenlight.brainwhat/internal/storage"
+	"greenlight.brainwhat/internal/tracing"
+	"greenlight.brainwhat/internal/worker"
+	"greenlight.brainwhat/migrations"
 )
 
-const version = "1.0.0"
-
 type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  time.Duration
+	port           int
+	env            string
+	errorFormat    string
+	inputMode      string
+	schemas        bool
+	genresStrict   bool
+	methodOverride bool
+	undoWindow     time.Duration
+	trashRetention time.Duration
+	experiments    []experiment.Experiment
+	requestTimeout struct {
+		timeout time.Duration
+		max     time.Duration
+	}
+	shed struct {
+		maxInFlight  int
+		maxQueue     int
+		queueTimeout time.Duration
+	}
+	stats struct {
+		flushInterval time.Duration
+	}
+	search struct {
+		similarityThreshold float64
+	}
+	schedule struct {
+		expireTokens time.Duration
+		purgeTrash   time.Duration
+		purgeJobs    time.Duration
+		purgeOps     time.Duration
+		rotateEvents time.Duration
+		availability time.Duration
+		catalogStats time.Duration
+	}
+	outbox struct {
+		relayInterval time.Duration
+		retention     time.Duration
+	}
+	events struct {
+		publisher  string
+		natsURL    string
+		kafkaURL   string
+		kafkaTopic string
+	}
+	tracing struct {
+		exporter      string
+		otlpURL       string
+		sampleRatio   float64
+		flushInterval time.Duration
+	}
+	jobs struct {
+		workers           int
+		pollInterval      time.Duration
+		visibilityTimeout time.Duration
+	}
+	admin struct {
+		port     int
+		user     string
+		password string
+		dumpDir  string
+	}
+	storage struct {
+		backend     string
+		localDir    string
+		s3Endpoint  string
+		s3Region    string
+		s3Bucket    string
+		s3AccessKey string
+		s3SecretKey string
+		s3PathStyle bool
+	}
+	clamav struct {
+		addr    string
+		timeout time.Duration
+	}
+	availability struct {
+		url     string
+		token   string
+		timeout time.Duration
+		maxAge  time.Duration
+	}
+	apiKeys struct {
+		required bool
+	}
+	quotas struct {
+		enabled bool
+	}
+	usage struct {
+		flushInterval time.Duration
+	}
+	tenants struct {
+		domain string
+	}
+	limiter struct {
+		enabled      bool
+		store        string
+		redisURL     string
+		redisConns   int
+		redisTimeout time.Duration
+	}
+	db struct {
+		driver          string
+		dsn             string
+		host            string
+		port            int
+		user            string
+		password        string
+		name            string
+		sslmode         string
+		sslrootcert     string
+		sslcert         string
+		sslkey          string
+		maxOpenConns    int
+		maxIdleConns    int
+		maxIdleTime     time.Duration
+		slowQuery       time.Duration
+		rls             bool
+		connectAttempts int
+		connectTimeout  time.Duration
+		breaker         struct {
+			threshold int
+			cooldown  time.Duration
+		}
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
+	config          config
+	db              *sql.DB
+	logger          *slog.Logger
+	models          data.Models
+	routeNames      map[string]string
+	routeList       []*versionRoute // every registered route in order, for the routes command
+	views           *data.ViewBuffer
+	breaker         *breaker.Breaker
+	shed            *shed.Limiter      // nil when load isn't shed
+	limiter         ratelimit.Store    // nil when rate limiting is off
+	quotas          *data.UsageCounter // nil when quotas aren't enforced
+	metrics         *data.MetricsBuffer
+	schemas         map[string]*jsonschema.Schema // nil without -validate-schemas
+	storage         storage.Storage
+	genreVocabulary []string             // nil unless -genres-strict
+	clamav          *clamav.Client       // nil when uploads aren't scanned
+	availability    *availability.Client // nil when availability isn't synced
+	jobs            *worker.Pool
+	migrator        *migrate.Migrator // nil with in-memory storage
+	schedule        *worker.Scheduler
+	events          events.Publisher
+	tracer          *tracing.Tracer       // nil when requests aren't traced
+	spans           *tracing.OTLPExporter // nil unless spans go to a collector
+	wg              sync.WaitGroup
+
+	shuttingDown  atomic.Bool
+	shutdownHooks []func(ctx context.Context) error // see OnShutdown
 }
 
 func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	var cfg config
+	// Plain `api -port 4000` keeps working, serve is the default command
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "dev", "Current environment (dev/stage/prod")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time. Has to satisfy time.ParseDuration()")
+	err := cmd.run(args, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
 
-	flag.Parse()
+func serveCommand(args []string, logger *slog.Logger) error {
+	var cfg config
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	err := parseFlags(serveFlags("serve", &cfg), args)
+	if err != nil {
+		return err
+	}
 
-	db, err := openDB(cfg)
+	err = validateConfig(cfg)
 	if err != nil {
-		logger.Error(err.Error())
-		os.Exit(1)
+		return err
 	}
-	defer db.Close()
 
-	logger.Info("database connection pool established")
+	return run(cfg, logger)
+}
+
+// serveFlags defines every setting of the server. Config files use the
+// same names, see parseFlags
+func serveFlags(name string, cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "dev", "Current environment (dev/stage/prod")
+	fs.StringVar(&cfg.errorFormat, "error-format", "envelope", "Error response format (envelope/problem)")
+	fs.StringVar(&cfg.inputMode, "input-mode", "strict", "Whether unknown fields in request bodies are rejected (strict) or ignored (lenient), clients can override it with X-Input-Mode")
+	fs.BoolVar(&cfg.schemas, "validate-schemas", false, "Check request bodies against their JSON Schema before decoding them")
+	fs.BoolVar(&cfg.genresStrict, "genres-strict", false, "Only accept genres from the genre vocabulary, read once at startup")
+	fs.BoolVar(&cfg.methodOverride, "method-override", false, "Let POST requests stand in for PUT, PATCH and DELETE with X-HTTP-Method-Override")
+	fs.DurationVar(&cfg.undoWindow, "undo-window", time.Hour, "How long a deleted movie can be restored")
+	fs.DurationVar(&cfg.requestTimeout.timeout, "request-timeout", 8*time.Second, "How long a request may take before it's answered with 504, 0 disables it")
+	fs.DurationVar(&cfg.requestTimeout.max, "request-timeout-max", 30*time.Second, "Longest deadline clients can ask for with X-Request-Timeout")
+	fs.IntVar(&cfg.shed.maxInFlight, "max-in-flight", 100, "Requests handled at once before more have to queue, 0 disables load shedding")
+	fs.IntVar(&cfg.shed.maxQueue, "max-queue", 100, "Requests waiting for one in flight to finish before more get a 503")
+	fs.DurationVar(&cfg.shed.queueTimeout, "queue-timeout", 500*time.Millisecond, "How long a request may wait in the queue before it gets a 503")
+	fs.DurationVar(&cfg.trashRetention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept before they are purged")
+	fs.DurationVar(&cfg.stats.flushInterval, "stats-flush-interval", 30*time.Second, "How often buffered view counts are written to the database")
+	fs.Float64Var(&cfg.search.similarityThreshold, "search-similarity-threshold", 0.3, "Minimum title similarity (0-1) for the fuzzy search fallback")
+	fs.IntVar(&cfg.jobs.workers, "jobs-workers", 2, "Number of background job workers, 0 disables them")
+	fs.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "How often idle workers check for new jobs")
+	fs.DurationVar(&cfg.jobs.visibilityTimeout, "jobs-visibility-timeout", 5*time.Minute, "How long a job can run before another worker may retry it")
+	fs.DurationVar(&cfg.schedule.expireTokens, "schedule-expire-tokens", 15*time.Minute, "How often expired undo tokens are deleted, 0 disables it")
+	fs.DurationVar(&cfg.schedule.purgeTrash, "schedule-purge-trash", time.Hour, "How often movies past -trash-retention are purged, 0 disables it")
+	fs.DurationVar(&cfg.schedule.purgeJobs, "schedule-purge-jobs", time.Hour, "How often old finished jobs are deleted, 0 disables it")
+	fs.DurationVar(&cfg.schedule.purgeOps, "schedule-purge-operations", time.Hour, "How often operations that finished a week ago are deleted, 0 disables it")
+	fs.DurationVar(&cfg.schedule.rotateEvents, "schedule-rotate-event-partitions", time.Hour, "How often event partitions are created ahead and old ones dropped, 0 disables it")
+	fs.DurationVar(&cfg.schedule.availability, "schedule-sync-availability", 10*time.Minute, "How often stale movie availability is synced, 0 disables it")
+	fs.DurationVar(&cfg.schedule.catalogStats, "schedule-refresh-catalog-stats", 5*time.Minute, "How often the precomputed facets, revenue and trending stats are refreshed, 0 disables it")
+	fs.DurationVar(&cfg.outbox.relayInterval, "outbox-relay-interval", time.Second, "How often new events are picked up from the outbox and published")
+	fs.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "How long published events are kept, whole months are dropped once they're all past it")
+	fs.StringVar(&cfg.events.publisher, "events-publisher", "log", "Where events are published (log/nats/kafka)")
+	fs.StringVar(&cfg.events.natsURL, "events-nats-url", "nats://127.0.0.1:4222", "NATS server URL")
+	fs.StringVar(&cfg.events.kafkaURL, "events-kafka-url", "http://127.0.0.1:8082", "Kafka REST Proxy URL")
+	fs.StringVar(&cfg.events.kafkaTopic, "events-kafka-topic", "greenlight.events", "Kafka topic for all events")
+	fs.StringVar(&cfg.tracing.exporter, "tracing-exporter", "none", "Where request and query spans go (none/log/otlp)")
+	fs.StringVar(&cfg.tracing.otlpURL, "tracing-otlp-url", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint of the collector, with -tracing-exporter=otlp")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "tracing-sample-ratio", 1, "Share of the traces starting here that are exported, callers sending traceparent decide for theirs")
+	fs.DurationVar(&cfg.tracing.flushInterval, "tracing-flush-interval", 5*time.Second, "How often spans are sent to the collector")
+	fs.IntVar(&cfg.admin.port, "admin-port", 0, "Port for the debug endpoints, 0 disables them")
+	fs.StringVar(&cfg.admin.user, "admin-user", "admin", "Basic auth user for the debug endpoints")
+	fs.StringVar(&cfg.admin.password, "admin-password", secretEnv("GREENLIGHT_ADMIN_PASSWORD"), "Basic auth password for the debug endpoints")
+	fs.StringVar(&cfg.admin.dumpDir, "admin-dump-dir", os.TempDir(), "Where POST /debug/dump writes its files")
+	fs.StringVar(&cfg.storage.backend, "storage-backend", "local", "Where files like posters are kept (local/s3)")
+	fs.StringVar(&cfg.storage.localDir, "storage-local-dir", "./storage", "Directory for -storage-backend=local")
+	fs.StringVar(&cfg.storage.s3Endpoint, "storage-s3-endpoint", "https://s3.amazonaws.com", "S3 endpoint, e.g. http://127.0.0.1:9000 for MinIO")
+	fs.StringVar(&cfg.storage.s3Region, "storage-s3-region", "us-east-1", "S3 region")
+	fs.StringVar(&cfg.storage.s3Bucket, "storage-s3-bucket", os.Getenv("GREENLIGHT_S3_BUCKET"), "S3 bucket")
+	fs.StringVar(&cfg.storage.s3AccessKey, "storage-s3-access-key", secretEnv("AWS_ACCESS_KEY_ID"), "S3 access key")
+	fs.StringVar(&cfg.storage.s3SecretKey, "storage-s3-secret-key", secretEnv("AWS_SECRET_ACCESS_KEY"), "S3 secret key")
+	fs.BoolVar(&cfg.storage.s3PathStyle, "storage-s3-path-style", false, "Put the bucket into the URL path instead of the host name (needed for MinIO)")
+	fs.StringVar(&cfg.clamav.addr, "clamav-addr", "", "clamd to scan uploads with, e.g. unix:///var/run/clamav/clamd.ctl or tcp://127.0.0.1:3310")
+	fs.DurationVar(&cfg.clamav.timeout, "clamav-timeout", 30*time.Second, "How long a clamd scan may take")
+	fs.StringVar(&cfg.availability.url, "availability-url", "", "Availability API to sync where movies can be watched from, empty disables the sync")
+	fs.StringVar(&cfg.availability.token, "availability-token", secretEnv("GREENLIGHT_AVAILABILITY_TOKEN"), "Bearer token for the availability API")
+	fs.DurationVar(&cfg.availability.timeout, "availability-timeout", 10*time.Second, "How long one availability API request may take")
+	fs.DurationVar(&cfg.availability.maxAge, "availability-max-age", 24*time.Hour, "How old a movie's availability may get before it's synced again")
+	fs.BoolVar(&cfg.apiKeys.required, "api-keys-required", false, "Reject requests without an X-API-Key header")
+	fs.BoolVar(&cfg.quotas.enabled, "quotas-enabled", false, "Hold requests to the daily and monthly quotas of their API key's plan, needs -api-keys-required")
+	fs.DurationVar(&cfg.usage.flushInterval, "usage-flush-interval", 10*time.Second, "How often API key request counts and metrics are written to the database, replicas can let keys go over their quota by this much")
+	fs.StringVar(&cfg.tenants.domain, "tenant-domain", "", "Base domain whose subdomains name tenants, e.g. api.example.com for acme.api.example.com")
+	fs.Func("experiment", "A/B experiment as name=variant:weight,variant:weight, can be repeated", func(s string) error {
+		e, err := experiment.Parse(s)
+		if err != nil {
+			return err
+		}
+		for _, running := range cfg.experiments {
+			if running.Name == e.Name {
+				return fmt.Errorf("experiment %s is defined twice", e.Name)
+			}
+		}
+		cfg.experiments = append(cfg.experiments, e)
+		return nil
+	})
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Rate limit clients by IP")
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Where rate limit counters are kept (memory/redis), use redis with more than one replica")
+	fs.StringVar(&cfg.limiter.redisURL, "limiter-redis-url", "redis://127.0.0.1:6379", "Redis URL for -limiter-store=redis")
+	fs.IntVar(&cfg.limiter.redisConns, "limiter-redis-conns", 8, "Connections to Redis, requests wait for a free one up to -limiter-redis-timeout")
+	fs.DurationVar(&cfg.limiter.redisTimeout, "limiter-redis-timeout", 100*time.Millisecond, "How long a request waits for Redis before it's let through unlimited")
+	fs.StringVar(&cfg.db.driver, "db-driver", "postgres", "Storage backend (postgres/memory)")
+	fs.BoolVar(&cfg.db.rls, "db-rls", false, "Run tenant requests in a transaction limited to the tenant by row-level security")
+
+	dbFlags(fs, cfg)
+
+	return fs
+}
+
+// validateConfig checks the settings that depend on each other
+func validateConfig(cfg config) error {
+	if cfg.errorFormat != "envelope" && cfg.errorFormat != "problem" {
+		return fmt.Errorf("invalid -error-format value %q, must be envelope or problem", cfg.errorFormat)
+	}
+
+	if cfg.inputMode != "strict" && cfg.inputMode != "lenient" {
+		return fmt.Errorf("invalid -input-mode value %q, must be strict or lenient", cfg.inputMode)
+	}
+
+	if cfg.db.driver != "postgres" && cfg.db.driver != "memory" {
+		return fmt.Errorf("invalid -db-driver value %q, must be postgres or memory", cfg.db.driver)
+	}
+
+	if cfg.db.rls && cfg.db.driver != "postgres" {
+		return errors.New("-db-rls needs -db-driver=postgres")
+	}
+
+	// Debug endpoints without a password would give away too much
+	if cfg.admin.port != 0 && cfg.admin.password == "" {
+		return errors.New("-admin-password (or GREENLIGHT_ADMIN_PASSWORD) is required with -admin-port")
+	}
+
+	if cfg.limiter.enabled && cfg.limiter.store == "redis" && cfg.limiter.redisConns < 1 {
+		return errors.New("-limiter-redis-conns must be at least 1")
+	}
+
+	if cfg.shed.maxInFlight > 0 && (cfg.shed.maxQueue < 0 || cfg.shed.queueTimeout <= 0) {
+		return errors.New("-max-queue must not be negative and -queue-timeout must be positive")
+	}
+
+	if cfg.requestTimeout.timeout > cfg.requestTimeout.max {
+		return errors.New("-request-timeout must not be longer than -request-timeout-max")
+	}
+
+	// Clients could get around their quota by leaving the key out
+	if cfg.quotas.enabled && !cfg.apiKeys.required {
+		return errors.New("-quotas-enabled needs -api-keys-required")
+	}
+
+	if cfg.tracing.exporter != "none" && cfg.tracing.exporter != "log" && cfg.tracing.exporter != "otlp" {
+		return fmt.Errorf("invalid -tracing-exporter value %q, must be none, log or otlp", cfg.tracing.exporter)
+	}
+
+	if cfg.tracing.sampleRatio < 0 || cfg.tracing.sampleRatio > 1 {
+		return errors.New("-tracing-sample-ratio must be between 0 and 1")
+	}
+
+	if cfg.outbox.retention < 0 {
+		return errors.New("-outbox-retention must not be negative")
+	}
+
+	// Purging a movie that can still be restored would break its undo token
+	if cfg.trashRetention < cfg.undoWindow {
+		return errors.New("-trash-retention must not be shorter than -undo-window")
+	}
+
+	return nil
+}
+
+func run(cfg config, logger *slog.Logger) error {
+	publisher, err := openPublisher(cfg, logger)
+	if err != nil {
+		return err
+	}
 
 	app := application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:  cfg,
+		logger:  logger,
+		events:  publisher,
+		breaker: breaker.New(cfg.db.breaker.threshold, cfg.db.breaker.cooldown),
+	}
+
+	app.OnShutdown(func(ctx context.Context) error {
+		return publisher.Close()
+	})
+
+	switch cfg.tracing.exporter {
+	case "log":
+		app.tracer = tracing.New(tracing.LogExporter{Logger: logger}, cfg.tracing.sampleRatio)
+	case "otlp":
+		app.spans = tracing.NewOTLPExporter(cfg.tracing.otlpURL, map[string]string{
+			"service.name":           "greenlight",
+			"service.version":        build.Version,
+			"deployment.environment": cfg.env,
+		})
+		app.tracer = tracing.New(app.spans, cfg.tracing.sampleRatio)
+
+		app.OnShutdown(func(ctx context.Context) error {
+			return app.spans.Flush(ctx)
+		})
+	}
+
+	app.storage, err = openStorage(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.clamav.addr != "" {
+		app.clamav, err = clamav.New(cfg.clamav.addr, cfg.clamav.timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.schemas {
+		app.schemas, err = loadSchemas()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.availability.url != "" {
+		app.availability, err = availability.New(cfg.availability.url, cfg.availability.token, cfg.availability.timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.shed.maxInFlight > 0 {
+		app.shed = shed.New(cfg.shed.maxInFlight, cfg.shed.maxQueue, cfg.shed.queueTimeout)
+
+		expvar.Publish("load_shedding", expvar.Func(func() any {
+			return app.shed.Stats()
+		}))
+	}
+
+	if cfg.limiter.enabled {
+		app.limiter, err = openLimiter(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch cfg.db.driver {
+	case "memory":
+		logger.Warn("using in-memory storage, everything is lost on exit")
+
+		app.models = data.NewMemoryModels(cfg.search.similarityThreshold)
+
+		if cfg.genresStrict {
+			app.genreVocabulary = data.DefaultGenreVocabulary
+		}
+	default:
+		db, err := openDB(cfg, logger, app.breaker)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		logger.Info("database connection pool established")
+
+		expvar.Publish("db_pool", expvar.Func(func() any {
+			return newDBPoolStats(db.Stats())
+		}))
+
+		app.db = db
+		app.models = data.NewModels(db, cfg.search.similarityThreshold)
+
+		if cfg.genresStrict {
+			app.genreVocabulary, err = data.LoadGenreVocabulary(db)
+			if err != nil {
+				return fmt.Errorf("loading the genre vocabulary: %w", err)
+			}
+
+			// An empty vocabulary would reject every movie
+			if len(app.genreVocabulary) == 0 {
+				return errors.New("-genres-strict needs genres in genre_vocabulary, run the migrations")
+			}
+		}
+
+		// Only for /readyz, serve doesn't migrate by itself
+		app.migrator, err = migrate.New(db, migrations.FS)
+		if err != nil {
+			return err
+		}
+
+		// The job queue lives in postgres, there's no memory version of it
+		app.jobs = worker.New(db, logger, worker.Config{
+			Workers:           cfg.jobs.workers,
+			PollInterval:      cfg.jobs.pollInterval,
+			VisibilityTimeout: cfg.jobs.visibilityTimeout,
+		})
+
+		app.jobs.Handle("poster.resize", app.resizePosterJob)
+		app.jobs.Handle("operation.run", app.runOperationJob)
+	}
+
+	app.views = data.NewViewBuffer(app.models.Stats)
+	app.OnShutdown(func(ctx context.Context) error {
+		return app.views.Flush()
+	})
+
+	app.metrics = data.NewMetricsBuffer(app.models.Metrics)
+	app.OnShutdown(func(ctx context.Context) error {
+		return app.metrics.Flush()
+	})
+
+	if cfg.quotas.enabled {
+		app.quotas = data.NewUsageCounter(app.models.Quotas)
+		app.OnShutdown(func(ctx context.Context) error {
+			return app.quotas.Flush()
+		})
 	}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	app.schedule = app.scheduler(app.db)
+
+	return app.serve()
+}
+
+// dbFlags are shared by every command that talks to postgres
+func dbFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.db.dsn, "db-dsn", secretEnv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
+
+	// The same as separate settings, the DSN is built from them
+	fs.StringVar(&cfg.db.host, "db-host", os.Getenv("GREENLIGHT_DB_HOST"), "PostgreSQL host, instead of -db-dsn")
+	fs.IntVar(&cfg.db.port, "db-port", 5432, "PostgreSQL port, with -db-host")
+	fs.StringVar(&cfg.db.user, "db-user", os.Getenv("GREENLIGHT_DB_USER"), "PostgreSQL user, with -db-host")
+	fs.StringVar(&cfg.db.password, "db-password", secretEnv("GREENLIGHT_DB_PASSWORD"), "PostgreSQL password, with -db-host. Needs no escaping")
+	fs.StringVar(&cfg.db.name, "db-name", os.Getenv("GREENLIGHT_DB_NAME"), "PostgreSQL database, with -db-host")
+	fs.StringVar(&cfg.db.sslmode, "db-sslmode", os.Getenv("GREENLIGHT_DB_SSLMODE"), "PostgreSQL sslmode (disable/require/verify-ca/verify-full), with -db-host")
+	fs.StringVar(&cfg.db.sslrootcert, "db-sslrootcert", os.Getenv("GREENLIGHT_DB_SSLROOTCERT"), "CA certificate file the server's certificate is checked against, with -db-host")
+	fs.StringVar(&cfg.db.sslcert, "db-sslcert", os.Getenv("GREENLIGHT_DB_SSLCERT"), "Client certificate file, with -db-host")
+	fs.StringVar(&cfg.db.sslkey, "db-sslkey", os.Getenv("GREENLIGHT_DB_SSLKEY"), "Client certificate key file, with -db-host")
+
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time. Has to satisfy time.ParseDuration()")
+
+	fs.DurationVar(&cfg.db.slowQuery, "db-slow-query-threshold", 200*time.Millisecond, "Queries slower than this are logged with their args, 0 disables it")
+
+	fs.IntVar(&cfg.db.breaker.threshold, "db-breaker-threshold", 5, "Connection failures in a row before database calls fail fast")
+	fs.DurationVar(&cfg.db.breaker.cooldown, "db-breaker-cooldown", 10*time.Second, "How long database calls fail fast before the database is tried again")
+
+	fs.IntVar(&cfg.db.connectAttempts, "db-connect-attempts", 10, "How many times to try reaching PostgreSQL on startup")
+	fs.DurationVar(&cfg.db.connectTimeout, "db-connect-timeout", 5*time.Second, "How long one startup connection attempt may take")
+}
+
+func openPublisher(cfg config, logger *slog.Logger) (events.Publisher, error) {
+	switch cfg.events.publisher {
+	case "log":
+		return events.LogPublisher{Logger: logger}, nil
+	case "nats":
+		return events.NewNATSPublisher(cfg.events.natsURL)
+	case "kafka":
+		return events.NewKafkaPublisher(cfg.events.kafkaURL, cfg.events.kafkaTopic), nil
+	default:
+		return nil, fmt.Errorf("invalid -events-publisher value %q, must be log, nats or kafka", cfg.events.publisher)
 	}
+}
 
-	logger.Info("starting server", "addr", srv.Addr, "env", cfg.env)
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.Local{Root: cfg.storage.localDir}, nil
+	case "s3":
+		s := cfg.storage
+		return storage.NewS3(s.s3Endpoint, s.s3Region, s.s3Bucket, s.s3AccessKey, s.s3SecretKey, s.s3PathStyle)
+	default:
+		return nil, fmt.Errorf("invalid -storage-backend value %q, must be local or s3", cfg.storage.backend)
+	}
+}
 
-	err = srv.ListenAndServe()
-	logger.Error(err.Error())
-	os.Exit(1)
+func openLimiter(cfg config) (ratelimit.Store, error) {
+	switch cfg.limiter.store {
+	case "memory":
+		return ratelimit.NewMemoryStore(), nil
+	case "redis":
+		return ratelimit.NewRedisStore(cfg.limiter.redisURL, cfg.limiter.redisConns, cfg.limiter.redisTimeout)
+	default:
+		return nil, fmt.Errorf("invalid -limiter-store value %q, must be memory or redis", cfg.limiter.store)
+	}
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+func openDB(cfg config, logger *slog.Logger, b *breaker.Breaker) (*sql.DB, error) {
+	dsn, err := databaseDSN(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	err = checkDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every query goes through sqlstats for slow query logs and metrics,
+	// then through the breaker. Calls the breaker rejects count as errors
+	db := sql.OpenDB(sqlstats.Wrap(breaker.Wrap(connector, b), sqlstats.Config{
+		SlowThreshold: cfg.db.slowQuery,
+		Logger:        logger,
+	}))
+
 	db.SetMaxOpenConns(cfg.db.maxOpenConns)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
 	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
 
 	// db conns are established lazily (only when they are first called)
-	// so we create context with 5 second timeout and establish a connection
-	// if it isn't established within 5 seconds, close connection and return err
-	//TODO: learn about contexts
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// so we ping to make sure the database is actually there. In containers
+	// postgres often starts after us, so we keep trying for a while
+	wait := time.Second
 
-	err = db.PingContext(ctx)
-	if err != nil {
-		db.Close()
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.db.connectTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+
+		if err == nil {
+			break
+		}
+
+		err = explainConnectError(err)
+
+		if errors.Is(err, errTLSMismatch) {
+			db.Close()
+			return nil, err
+		}
+
+		if attempt >= cfg.db.connectAttempts {
+			db.Close()
+			return nil, fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
+
+		logger.Warn("database not reachable yet", "attempt", attempt, "retry_in", wait, "error", err)
+
+		time.Sleep(wait)
+		wait = min(wait*2, 30*time.Second)
 	}
 
 	return db, nil
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+
+	"greenlight.brainwhat/internal/validator"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -17,3 +20,70 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Room for ?ids= with 100 seven digit ids, nothing legit needs more
+const (
+	maxQueryLength      = 4096
+	maxQueryValueLength = 1024
+)
+
+// validateQuery rejects query strings no client would send on purpose:
+// very long ones, parameters given twice (only the first would be used)
+// and oversized values. Every offending parameter is reported at once
+func (app *application) validateQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		v.Check(len(r.URL.RawQuery) <= maxQueryLength, "query", fmt.Sprintf("must not be more than %d bytes long", maxQueryLength))
+
+		// r.URL.Query() silently drops pairs it can't decode
+		qs, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			v.AddError("query", "must be a valid query string")
+		}
+
+		for key, values := range qs {
+			v.Check(len(values) == 1, key, "must only be given once")
+
+			for _, value := range values {
+				v.Check(len(value) <= maxQueryValueLength, key, fmt.Sprintf("must not be more than %d bytes long", maxQueryValueLength))
+			}
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// compress gzips (or deflates) responses for clients that ask for it.
+// Small bodies and already compressed content types are sent unchanged
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Caches must not serve a gzipped response to a client that can't read it
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+
+		next.ServeHTTP(cw, r)
+
+		err := cw.finish()
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
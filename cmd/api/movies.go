@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"greenlight.brainwhat/internal/data"
 	"greenlight.brainwhat/internal/validator"
@@ -12,47 +15,69 @@ import (
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title           string            `json:"title"`
+		Year            int32             `json:"year"`
+		Runtime         data.Runtime      `json:"runtime"`
+		Genres          []string          `json:"genres"`
+		Certifications  map[string]string `json:"certifications"`
+		ContentWarnings []string          `json:"content_warnings"`
+		Budget          int64             `json:"budget"`
+		Revenue         int64             `json:"revenue"`
+		Currency        string            `json:"currency"`
 	}
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.badRequestReponse(w, r, err)
+		app.movieInputErrorResponse(w, r, err)
 		return
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:           input.Title,
+		Year:            input.Year,
+		Runtime:         input.Runtime,
+		Genres:          input.Genres,
+		Certifications:  input.Certifications,
+		ContentWarnings: input.ContentWarnings,
+		Budget:          input.Budget,
+		Revenue:         input.Revenue,
+		Currency:        input.Currency,
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if app.validateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.tenantModels(r).Movies.Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", app.url("movie", "id", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeMovie(w, r, http.StatusCreated, movie, movieOptions{}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// movieInputErrorResponse answers a movie body that couldn't be decoded.
+// A runtime in none of the formats we take is a validation error,
+// the client got the JSON right, only the value is off
+func (app *application) movieInputErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, data.ErrInvalidRuntimeFormat) {
+		app.failedValidationResponse(w, r, map[string]string{"runtime": err.Error()})
+		return
+	}
+
+	app.badRequestReponse(w, r, err)
+}
+
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 
 	id, err := app.readIDParams(r)
@@ -61,23 +86,251 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+	qs := r.URL.Query()
+
+	opts := movieOptions{fields: app.readFields(qs, movieFieldsSafelist, v)}
+	includes := app.readIncludes(qs, movieIncludesSafelist, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.tenantModels(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundError(w, r)
+			app.movieNotFoundResponse(w, r, id)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.loadIncludes(r, &opts, includes, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// HEAD is for checking the movie is there, e.g. by link checkers
+	if r.Method != http.MethodHead {
+		app.views.Record(movie.ID)
+	}
+
+	err = app.writeMovie(w, r, http.StatusOK, movie, opts, app.lastModifiedHeaders(movie.UpdatedAt))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateMovie is data.ValidateMovie, with -genres-strict genres also
+// have to be in the vocabulary. The title is normalized first, a title of
+// only spaces is as empty as ""
+func (app *application) validateMovie(v *validator.Validator, movie *data.Movie) {
+	movie.Title = data.NormalizeTitle(movie.Title)
+
+	data.ValidateMovie(v, movie)
+
+	if app.genreVocabulary != nil {
+		data.ValidateGenreVocabulary(v, movie.Genres, app.genreVocabulary)
+	}
+}
+
+// Facets that can be asked for with ?facets=, see MovieModel.Facets
+var movieFacetsSafelist = []string{"genres", "year_decade"}
+
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "revenue", "-id", "-title", "-year", "-runtime", "-revenue"}
+
+// readMovieSearch reads and checks the search parameters every endpoint
+// that narrows down the catalog takes, so they all take the same ones
+func (app *application) readMovieSearch(qs url.Values, v *validator.Validator) data.MovieSearch {
+	search := data.MovieSearch{
+		Title:         app.readString(qs, "title", ""),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		Year:          app.readInt(qs, "year", 0, v),
+		YearMin:       app.readInt(qs, "year_min", 0, v),
+		YearMax:       app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:    app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:    app.readInt(qs, "runtime_max", 0, v),
+		Country:       app.readString(qs, "country", ""),
+		Certification: app.readString(qs, "certification", ""),
+		Currency:      app.readString(qs, "currency", ""),
+	}
+
+	data.ValidateCertificationSearch(v, search)
+	data.ValidateRangeSearch(v, search)
+	data.ValidateCurrency(v, search.Currency)
+
+	return search
+}
+
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Facets []string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	opts := movieOptions{fields: app.readFields(qs, movieFieldsSafelist, v)}
+	includes := app.readIncludes(qs, movieIncludesSafelist, v)
+
+	// ?ids=1,5,9 switches the endpoint into batch mode, other filters don't apply
+	if qs.Has("ids") {
+		app.batchGetMoviesHandler(w, r, app.readIDList(qs, "ids", v), opts, includes, v)
+		return
+	}
+
+	search := app.readMovieSearch(qs, v)
+	input.Facets = app.readCSV(qs, "facets", nil)
+
+	for _, facet := range input.Facets {
+		if !validator.PermittedValue(facet, movieFacetsSafelist...) {
+			v.AddError("facets", "unknown facet "+facet)
+		}
+	}
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = movieSortSafelist
+	input.Filters.SkipTotal = !app.readBool(qs, "include_total", true, v)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.tenantModels(r).Movies.GetAll(search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	extra := envelope{"metadata": metadata}
+
+	if len(input.Facets) > 0 {
+		// Count the same movies that were listed, fuzzy fallback included
+		search.Fuzzy = metadata.Fuzzy
+
+		facets, refreshedAt, err := app.tenantModels(r).Movies.Facets(search, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		extra["facets"] = facets
+		addStatsRefreshedAt(extra, refreshedAt)
+	}
+
+	err = app.loadIncludes(r, &opts, includes, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeMovies(w, r, http.StatusOK, movies, opts, extra, app.pageLinks(r, metadata))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Movies are returned in the same order as the requested ids
+// and the ones that don't exist are listed under "missing"
+func (app *application) batchGetMoviesHandler(w http.ResponseWriter, r *http.Request, ids []int64, opts movieOptions, includes []string, v *validator.Validator) {
+	v.Check(len(ids) > 0, "ids", "must contain at least one id")
+	v.Check(len(ids) <= 100, "ids", "must contain a maximum of 100 ids")
+	v.Check(validator.Unique(ids), "ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	found, err := app.tenantModels(r).Movies.GetByIDs(ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	byID := make(map[int64]*data.Movie, len(found))
+	for _, movie := range found {
+		byID[movie.ID] = movie
+	}
+
+	movies := []*data.Movie{}
+	missing := []int64{}
+
+	for _, id := range ids {
+		if movie, ok := byID[id]; ok {
+			movies = append(movies, movie)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	err = app.loadIncludes(r, &opts, includes, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeMovies(w, r, http.StatusOK, movies, opts, envelope{"missing": missing}, map[string]string{"self": r.URL.RequestURI()})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// movieChanges is the body of a movie PATCH.
+// Pointers and slices have zero-value of nil
+// So when the field is not provided in request
+// it'll remain nil which we check for later
+type movieChanges struct {
+	Title           *string           `json:"title"`
+	Year            *int32            `json:"year"`
+	Runtime         *data.Runtime     `json:"runtime"`
+	Genres          []string          `json:"genres"`
+	Certifications  map[string]string `json:"certifications"`   // replaces all of them, {} clears
+	ContentWarnings []string          `json:"content_warnings"` // same, [] clears
+	Budget          *int64            `json:"budget"`
+	Revenue         *int64            `json:"revenue"`
+	Currency        *string           `json:"currency"`
+}
+
+func (c movieChanges) apply(movie *data.Movie) {
+	if c.Title != nil {
+		movie.Title = *c.Title
+	}
+	if c.Year != nil {
+		movie.Year = *c.Year
+	}
+	if c.Runtime != nil {
+		movie.Runtime = *c.Runtime
+	}
+	if c.Genres != nil {
+		movie.Genres = c.Genres
+	}
+	if c.Certifications != nil {
+		movie.Certifications = c.Certifications
+	}
+	if c.ContentWarnings != nil {
+		movie.ContentWarnings = c.ContentWarnings
+	}
+	if c.Budget != nil {
+		movie.Budget = *c.Budget
+	}
+	if c.Revenue != nil {
+		movie.Revenue = *c.Revenue
+	}
+	if c.Currency != nil {
+		movie.Currency = *c.Currency
+	}
+}
+
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParams(r)
 	if err != nil {
@@ -85,7 +338,222 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.tenantModels(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.movieNotFoundResponse(w, r, id)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.unmodifiedSince(r, movie.UpdatedAt) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// Kept for the revision history
+	old := *movie
+
+	var input movieChanges
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.movieInputErrorResponse(w, r, err)
+		return
+	}
+
+	input.apply(movie)
+
+	v := validator.New()
+	if app.validateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.tenantModels(r).Movies.Update(movie, old, app.editor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeMovie(w, r, http.StatusOK, movie, movieOptions{}, app.lastModifiedHeaders(movie.UpdatedAt))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Most movies a batch update may change at once
+const maxBatchUpdate = 500
+
+// batchResult is the outcome of one item of a batch update
+type batchResult struct {
+	ID     int64 `json:"id"`
+	Status int   `json:"status"`
+	Error  any   `json:"error,omitempty"`
+	Movie  any   `json:"movie,omitempty"`
+}
+
+// batchUpdateMoviesHandler applies PATCHes to many movies at once, e.g.
+// [{"id": 1, "version": 3, "changes": {"genres": ["drama"]}}].
+// It's all or nothing: every item is checked first and all of them are
+// written in one transaction, so one bad item leaves every movie as it was.
+// Each item gets its own status in the results either way
+func (app *application) batchUpdateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input []struct {
+		ID      int64        `json:"id"`
+		Version int32        `json:"version"`
+		Changes movieChanges `json:"changes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.movieInputErrorResponse(w, r, err)
+		return
+	}
+
+	ids := make([]int64, 0, len(input))
+	for _, item := range input {
+		ids = append(ids, item.ID)
+	}
+
+	v := validator.New()
+	v.Check(len(input) > 0, "movies", "must contain at least one movie")
+	v.Check(len(input) <= maxBatchUpdate, "movies", fmt.Sprintf("must contain a maximum of %d movies", maxBatchUpdate))
+	v.Check(validator.Unique(ids), "movies", "must not change the same movie twice")
+
+	for _, item := range input {
+		v.Check(item.ID > 0, "id", "must be a positive integer")
+		v.Check(item.Version > 0, "version", "must be provided, it's the version the changes are based on")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]*batchResult, len(input))
+	movies := make([]*data.Movie, len(input))
+	olds := make([]data.Movie, len(input))
+	failed := 0 // status of the first failed item
+
+	for i, item := range input {
+		results[i] = &batchResult{ID: item.ID}
+
+		movie, err := app.tenantModels(r).Movies.Get(item.ID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			results[i].Status = http.StatusNotFound
+			results[i].Error = "the request resource could not be found"
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		case movie.Version != item.Version:
+			results[i].Status = http.StatusConflict
+			results[i].Error = "unable to update record due to an edit conflict, try again"
+		default:
+			olds[i] = *movie
+			item.Changes.apply(movie)
+			movies[i] = movie
+
+			v := validator.New()
+			if app.validateMovie(v, movie); !v.Valid() {
+				results[i].Status = http.StatusUnprocessableEntity
+				results[i].Error = v.Errors
+			}
+		}
+
+		if failed == 0 {
+			failed = results[i].Status
+		}
+	}
+
+	if failed == 0 {
+		err = app.inTenantTx(r, func(models data.Models) error {
+			for i, movie := range movies {
+				err := models.Movies.Update(movie, olds[i], app.editor(r))
+				if errors.Is(err, data.ErrEditConflict) {
+					// Someone else got in between the check and the update
+					results[i].Status = http.StatusConflict
+					results[i].Error = "unable to update record due to an edit conflict, try again"
+					failed = http.StatusConflict
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && failed == 0 {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if failed != 0 {
+		for _, result := range results {
+			if result.Status == 0 {
+				result.Status = http.StatusFailedDependency
+				result.Error = "not applied because other changes in the batch failed"
+			}
+		}
+
+		err = app.writeJSON(w, r, failed, envelope{"results": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for i, movie := range movies {
+		results[i].Status = http.StatusOK
+		results[i].Movie, err = app.movieView(movie, movieOptions{})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	// Delete doesn't need the movie otherwise, so we only fetch it for conditional requests
+	if r.Header.Get("If-Unmodified-Since") != "" {
+		movie, err := app.tenantModels(r).Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundError(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.unmodifiedSince(r, movie.UpdatedAt) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+	}
+
+	token, err := app.tenantModels(r).Movies.Delete(id, app.config.undoWindow)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -96,65 +564,193 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Pointers and slices have zero-value of nil
-	// So when the field is not provided in request
-	// it'll remain nil which we check for later
+	env := envelope{"message": "movie successfully deleted", "undo": token}
+
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) undoDeleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Token string `json:"token"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestReponse(w, r, err)
 		return
 	}
-	if input.Title != nil {
-		movie.Title = *input.Title
+
+	v := validator.New()
+
+	v.Check(input.Token != "", "token", "must be provided")
+	v.Check(len(input.Token) == 26, "token", "must be 26 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	if input.Year != nil {
-		movie.Year = *input.Year
 
+	movie, err := app.tenantModels(r).Movies.Restore(input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired undo token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+
+	err = app.writeMovie(w, r, http.StatusOK, movie, movieOptions{}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+}
+
+// mergeMovieHandler folds duplicate movie {id} into {target}, see
+// data.MovieModel.Merge. {id} redirects to {target} afterwards
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	target, err := app.readInt64Param(r, "target")
+	if err != nil {
+		app.notFoundError(w, r)
+		return
 	}
 
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+
+	if v.Check(target != id, "target", "cannot merge a movie into itself"); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.tenantModels(r).Movies.Merge(id, target)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	movie, err := app.tenantModels(r).Movies.Get(target)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeMovie(w, r, http.StatusOK, movie, movieOptions{}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+// similarMoviesHandler returns "more like this" movies, best match first
+func (app *application) similarMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParams(r)
 	if err != nil {
 		app.notFoundError(w, r)
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	v := validator.New()
+	qs := r.URL.Query()
+
+	opts := movieOptions{fields: app.readFields(qs, movieFieldsSafelist, v)}
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a maximum of 50")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.tenantModels(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.movieNotFoundResponse(w, r, id)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movies, err := app.tenantModels(r).Movies.GetSimilar(id, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeMovies(w, r, http.StatusOK, movies, opts, nil, map[string]string{"self": r.URL.RequestURI()})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// trendingMoviesHandler returns the most viewed movies of the last day, week or month
+func (app *application) trendingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	opts := movieOptions{fields: app.readFields(qs, movieFieldsSafelist, v)}
+	window := app.readString(qs, "window", "week")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	days, ok := data.TrendingWindows[window]
+
+	v.Check(ok, "window", "must be day, week or month")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 50, "limit", "must be a maximum of 50")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, refreshedAt, err := app.tenantModels(r).Stats.Trending(days, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	extra := envelope{"window": window}
+	addStatsRefreshedAt(extra, refreshedAt)
+
+	err = app.writeMovies(w, r, http.StatusOK, movies, opts, extra, map[string]string{"self": r.URL.RequestURI()})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// randomMovieHandler picks one random movie out of those matching
+// the list filters (title, genres, years, runtimes, country and certification)
+func (app *application) randomMovieHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	opts := movieOptions{fields: app.readFields(qs, movieFieldsSafelist, v)}
+	search := app.readMovieSearch(qs, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.tenantModels(r).Movies.GetRandom(search)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -164,7 +760,77 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+
+	// Every request gets a different movie, caches shouldn't keep it
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeMovie(w, r, http.StatusOK, movie, opts, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addStatsRefreshedAt tells clients how stale precomputed statistics are.
+// A zero refreshedAt means they were computed for this request
+func addStatsRefreshedAt(env envelope, refreshedAt time.Time) {
+	if !refreshedAt.IsZero() {
+		env["stats_refreshed_at"] = refreshedAt
+	}
+}
+
+// revenueByYearHandler sums up box office revenue per year for analytics.
+// It takes the list filters, so e.g. ?genres=horror&currency=USD works
+func (app *application) revenueByYearHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := app.readMovieSearch(qs, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	totals, refreshedAt, err := app.tenantModels(r).Movies.RevenueByYear(search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"revenue": totals}
+	addStatsRefreshedAt(env, refreshedAt)
+
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	q := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.tenantModels(r).Movies.Suggest(q, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMovieHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	moana := insertTestMovie(t, app, "Moana", 2016, "animation", "adventure")
+	insertTestMovie(t, app, "Black Panther", 2018, "action")
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "show", method: http.MethodGet, target: "/v1/movies/1", wantStatus: http.StatusOK, wantBody: `"title":"Moana"`},
+		{name: "show missing", method: http.MethodGet, target: "/v1/movies/999", wantStatus: http.StatusNotFound},
+		{name: "show bad id", method: http.MethodGet, target: "/v1/movies/abc", wantStatus: http.StatusNotFound},
+
+		{name: "list", method: http.MethodGet, target: "/v1/movies", wantStatus: http.StatusOK, wantBody: `"total_records":2`},
+		{name: "list by genre", method: http.MethodGet, target: "/v1/movies?genres=action", wantStatus: http.StatusOK, wantBody: `"title":"Black Panther"`},
+		{name: "list bad page", method: http.MethodGet, target: "/v1/movies?page=0", wantStatus: http.StatusUnprocessableEntity, wantBody: `"page"`},
+		{name: "list bad sort", method: http.MethodGet, target: "/v1/movies?sort=rating", wantStatus: http.StatusUnprocessableEntity, wantBody: `"sort"`},
+		{name: "list repeated parameter", method: http.MethodGet, target: "/v1/movies?page=1&page=2", wantStatus: http.StatusUnprocessableEntity, wantBody: "only be given once"},
+
+		{name: "create", method: http.MethodPost, target: "/v1/movies", body: `{"title":"Deadpool","year":2016,"runtime":"108 mins","genres":["action","comedy"]}`, wantStatus: http.StatusCreated, wantBody: `"title":"Deadpool"`},
+		{name: "create invalid", method: http.MethodPost, target: "/v1/movies", body: `{"title":"","year":1500,"runtime":108,"genres":[]}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"title"`},
+		{name: "create bad runtime", method: http.MethodPost, target: "/v1/movies", body: `{"title":"Deadpool","year":2016,"runtime":"108.5","genres":["action"]}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"runtime"`},
+		{name: "create malformed", method: http.MethodPost, target: "/v1/movies", body: `{"title":"Deadpool",`, wantStatus: http.StatusBadRequest},
+		{name: "create unknown field", method: http.MethodPost, target: "/v1/movies", body: `{"title":"Deadpool","rating":5}`, wantStatus: http.StatusBadRequest, wantBody: "rating"},
+		{name: "create empty", method: http.MethodPost, target: "/v1/movies", wantStatus: http.StatusBadRequest},
+
+		{name: "update", method: http.MethodPatch, target: "/v1/movies/1", body: `{"year":2017}`, wantStatus: http.StatusOK, wantBody: `"year":2017`},
+		{name: "update invalid", method: http.MethodPatch, target: "/v1/movies/1", body: `{"title":""}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"title"`},
+		{name: "update malformed", method: http.MethodPatch, target: "/v1/movies/1", body: `{"year":"2017"}`, wantStatus: http.StatusBadRequest},
+		{name: "update missing", method: http.MethodPatch, target: "/v1/movies/999", body: `{"year":2017}`, wantStatus: http.StatusNotFound},
+		{name: "update precondition", method: http.MethodPatch, target: "/v1/movies/1", body: `{"year":2018}`, headers: map[string]string{"If-Unmodified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}, wantStatus: http.StatusPreconditionFailed},
+
+		{name: "batch update conflict", method: http.MethodPatch, target: "/v1/movies", body: fmt.Sprintf(`[{"id":%d,"version":%d,"changes":{"year":2019}}]`, moana.ID, moana.Version), wantStatus: http.StatusConflict},
+		{name: "batch update empty", method: http.MethodPatch, target: "/v1/movies", body: `[]`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "batch update", method: http.MethodPatch, target: "/v1/movies", body: `[{"id":2,"version":1,"changes":{"year":2019}}]`, wantStatus: http.StatusOK, wantBody: `"year":2019`},
+
+		{name: "merge into itself", method: http.MethodPost, target: "/v1/movies/1/merge-into/1", wantStatus: http.StatusUnprocessableEntity},
+		{name: "merge missing", method: http.MethodPost, target: "/v1/movies/1/merge-into/999", wantStatus: http.StatusNotFound},
+
+		{name: "history", method: http.MethodGet, target: "/v1/movies/1/history", wantStatus: http.StatusOK, wantBody: `"revisions"`},
+		{name: "similar", method: http.MethodGet, target: "/v1/movies/1/similar", wantStatus: http.StatusOK},
+		{name: "suggest", method: http.MethodGet, target: "/v1/movies/suggest?q=moa", wantStatus: http.StatusOK, wantBody: "Moana"},
+		{name: "trending bad window", method: http.MethodGet, target: "/v1/movies/trending?window=year", wantStatus: http.StatusUnprocessableEntity, wantBody: `"window"`},
+		{name: "random", method: http.MethodGet, target: "/v1/movies/random", wantStatus: http.StatusOK},
+		{name: "revenue", method: http.MethodGet, target: "/v1/movies/revenue", wantStatus: http.StatusOK},
+		{name: "upcoming", method: http.MethodGet, target: "/v1/movies/upcoming?country=US", wantStatus: http.StatusOK},
+		{name: "upcoming without country", method: http.MethodGet, target: "/v1/movies/upcoming", wantStatus: http.StatusUnprocessableEntity, wantBody: `"country"`},
+		{name: "export", method: http.MethodGet, target: "/v1/movies/export", wantStatus: http.StatusOK, wantBody: "Moana"},
+		{name: "poster missing", method: http.MethodGet, target: "/v1/movies/1/poster", wantStatus: http.StatusNotFound},
+		{name: "poster upload not an image", method: http.MethodPut, target: "/v1/movies/1/poster", body: "hello", wantStatus: http.StatusUnprocessableEntity, wantBody: `"poster"`},
+		{name: "import malformed", method: http.MethodPost, target: "/v1/movies/import", body: `{"title":`, wantStatus: http.StatusBadRequest},
+
+		{name: "undo malformed token", method: http.MethodPost, target: "/v1/movies/undo", body: `{"token":"short"}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"token"`},
+		{name: "undo unknown token", method: http.MethodPost, target: "/v1/movies/undo", body: `{"token":"AAAAAAAAAAAAAAAAAAAAAAAAAA"}`, wantStatus: http.StatusUnprocessableEntity, wantBody: "invalid or expired"},
+		{name: "delete missing", method: http.MethodDelete, target: "/v1/movies/999", wantStatus: http.StatusNotFound},
+
+		{name: "method not allowed", method: http.MethodPut, target: "/v1/movies/1", wantStatus: http.StatusMethodNotAllowed},
+		{name: "unknown route", method: http.MethodGet, target: "/v1/films", wantStatus: http.StatusNotFound},
+	})
+}
+
+// Deleting hands out an undo token, the movie is gone until it's used
+func TestDeleteAndUndoMovie(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	rr := serve(t, h, http.MethodDelete, "/v1/movies/1", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: got status %d, body %s", rr.Code, rr.Body)
+	}
+
+	var deleted struct {
+		Undo struct {
+			Token string `json:"token"`
+		} `json:"undo"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &deleted); err != nil || deleted.Undo.Token == "" {
+		t.Fatalf("delete: no undo token in %s", rr.Body)
+	}
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "deleted", method: http.MethodGet, target: "/v1/movies/1", wantStatus: http.StatusNotFound},
+		{name: "undo", method: http.MethodPost, target: "/v1/movies/undo", body: `{"token":"` + deleted.Undo.Token + `"}`, wantStatus: http.StatusOK, wantBody: `"title":"Moana"`},
+		{name: "restored", method: http.MethodGet, target: "/v1/movies/1", wantStatus: http.StatusOK},
+		{name: "undo twice", method: http.MethodPost, target: "/v1/movies/undo", body: `{"token":"` + deleted.Undo.Token + `"}`, wantStatus: http.StatusUnprocessableEntity},
+	})
+}
+
+// Merged movies redirect to the movie they went into
+func TestMergeMovie(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "merge", method: http.MethodPost, target: "/v1/movies/2/merge-into/1", wantStatus: http.StatusOK, wantBody: `"id":1`},
+		{name: "show merged", method: http.MethodGet, target: "/v1/movies/2", wantStatus: http.StatusPermanentRedirect},
+		{name: "merge again", method: http.MethodPost, target: "/v1/movies/2/merge-into/1", wantStatus: http.StatusNotFound},
+	})
+}
+
+func TestMovieTitleNormalization(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "ﬁve Easy Pieces", 1970, "drama")
+
+	// "é" as e and a combining accent is two runes, one in NFC
+	decomposed := strings.Repeat("e\u0301", 499)
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "499 CJK characters", method: http.MethodPost, target: "/v1/movies", body: `{"title":"` + strings.Repeat("映", 499) + `","year":2016,"runtime":100,"genres":["drama"]}`, wantStatus: http.StatusCreated},
+		{name: "too long", method: http.MethodPost, target: "/v1/movies", body: `{"title":"` + strings.Repeat("映", 500) + `","year":2016,"runtime":100,"genres":["drama"]}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"title"`},
+		{name: "NFC before the length", method: http.MethodPost, target: "/v1/movies", body: `{"title":"` + decomposed + `","year":2016,"runtime":100,"genres":["drama"]}`, wantStatus: http.StatusCreated, wantBody: strings.Repeat("\u00e9", 499)},
+		{name: "whitespace", method: http.MethodPost, target: "/v1/movies", body: `{"title":"  Le\tMépris \n","year":1963,"runtime":100,"genres":["drama"]}`, wantStatus: http.StatusCreated, wantBody: `"title":"Le Mépris"`},
+		{name: "compatibility characters match", method: http.MethodGet, target: "/v1/movies?title=five", wantStatus: http.StatusOK, wantBody: `"title":"ﬁve Easy Pieces"`},
+		{name: "decomposed search matches", method: http.MethodGet, target: "/v1/movies?title=me%CC%81pris", wantStatus: http.StatusOK, wantBody: `"title":"Le Mépris"`},
+	})
+}
+
+// The list, random and revenue endpoints take the same search parameters
+func TestMovieSearchParameters(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	var tests []handlerTest
+
+	for _, path := range []string{"/v1/movies", "/v1/movies/random", "/v1/movies/revenue"} {
+		tests = append(tests,
+			handlerTest{name: path + " bad currency", method: http.MethodGet, target: path + "?currency=XYZ", wantStatus: http.StatusUnprocessableEntity, wantBody: `"currency"`},
+			handlerTest{name: path + " bad year range", method: http.MethodGet, target: path + "?year_min=2000&year_max=1990", wantStatus: http.StatusUnprocessableEntity, wantBody: `"year_max"`},
+			handlerTest{name: path + " certification without country", method: http.MethodGet, target: path + "?certification=PG", wantStatus: http.StatusUnprocessableEntity, wantBody: `"country"`},
+		)
+	}
+
+	tests = append(tests,
+		handlerTest{name: "random by currency", method: http.MethodGet, target: "/v1/movies/random?currency=USD", wantStatus: http.StatusNotFound},
+		handlerTest{name: "random", method: http.MethodGet, target: "/v1/movies/random?genres=animation", wantStatus: http.StatusOK, wantBody: `"title":"Moana"`},
+	)
+
+	runHandlerTests(t, h, tests)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/worker"
+)
+
+// How long finished operations can still be polled
+const operationsRetention = 7 * 24 * time.Hour
+
+// Progress is written at most this often, a sync of thousands of
+// movies shouldn't be thousands of UPDATEs
+const operationProgressInterval = time.Second
+
+// operationFunc does the work of one kind of operation. It can call
+// progress as often as it likes. What it returns becomes the result
+type operationFunc func(ctx context.Context, op *data.Operation, progress func(data.OperationProgress)) (any, error)
+
+// operationKinds lists what the operations runner knows how to run
+func (app *application) operationKinds() map[string]operationFunc {
+	return map[string]operationFunc{
+		"availability.sync": app.syncAvailabilityOperation,
+		"backup.create":     app.createBackupOperation,
+		"backup.restore":    app.restoreBackupOperation,
+		"movies.import":     app.importMoviesOperation,
+	}
+}
+
+// startOperation records an operation of kind and queues it. The client
+// gets 202 with the operation and polls its Location for the result
+func (app *application) startOperation(w http.ResponseWriter, r *http.Request, kind string, params any) {
+	op, err := app.queueOperation(app.tenantModels(r).Operations, kind, params)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.url("operation", "id", op.ID))
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"operation": op}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) queueOperation(operations data.OperationStore, kind string, params any) (*data.Operation, error) {
+	js, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &data.Operation{Kind: kind, Params: js}
+
+	err = operations.Insert(op)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.enqueueOperation(op.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func (app *application) showOperationHandler(w http.ResponseWriter, r *http.Request) {
+	app.showOperation(w, r, app.tenantModels(r).Operations)
+}
+
+func (app *application) showOperation(w http.ResponseWriter, r *http.Request, operations data.OperationStore) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	op, err := operations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Pollers shouldn't come back every millisecond
+	headers := make(http.Header)
+	if !op.Finished() {
+		headers.Set("Retry-After", "1")
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"operation": op}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Like poster resizes, operations only go through the job queue with
+// postgres. In memory they just run in the background of this process
+func (app *application) enqueueOperation(id int64) error {
+	if app.jobs == nil {
+		app.background(func() {
+			err := app.runOperation(context.Background(), id)
+			if err != nil {
+				app.logger.Error("running operation failed", "id", id, "error", err)
+			}
+		})
+		return nil
+	}
+
+	return app.jobs.Enqueue("operation.run", map[string]int64{"operation_id": id})
+}
+
+func (app *application) runOperationJob(ctx context.Context, job *worker.Job) error {
+	var payload struct {
+		OperationID int64 `json:"operation_id"`
+	}
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	return app.runOperation(ctx, payload.OperationID)
+}
+
+// runOperation runs the operation and records how it went. Errors of the
+// operation itself fail it for good instead of retrying the job, half of
+// an import done twice is worse than the client starting it again. Only
+// errors recording the outcome are returned, the job retries those
+func (app *application) runOperation(ctx context.Context, id int64) error {
+	// With -db-rls the operation is inserted in the request's transaction,
+	// a fast worker may not see it yet. The job is retried then
+	op, err := app.models.Operations.Start(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if op.Finished() {
+		return nil
+	}
+
+	fn, ok := app.operationKinds()[op.Kind]
+	if !ok {
+		return app.models.Operations.Finish(ctx, id, nil, fmt.Errorf("unknown operation kind %q", op.Kind))
+	}
+
+	var last data.OperationProgress
+	var written time.Time
+	var pending bool
+
+	write := func() {
+		written, pending = time.Now(), false
+
+		err := app.models.Operations.Progress(ctx, id, last)
+		if err != nil {
+			app.logger.Error("updating operation progress", "id", id, "error", err)
+		}
+	}
+
+	progress := func(p data.OperationProgress) {
+		last, pending = p, true
+		if time.Since(written) >= operationProgressInterval {
+			write()
+		}
+	}
+
+	var result json.RawMessage
+
+	value, opErr := fn(ctx, op, progress)
+	if pending {
+		write()
+	}
+	if opErr == nil && value != nil {
+		result, opErr = json.Marshal(value)
+	}
+
+	if opErr != nil {
+		app.logger.Error("operation failed", "id", id, "kind", op.Kind, "error", opErr)
+	}
+
+	return app.models.Operations.Finish(ctx, id, result, opErr)
+}
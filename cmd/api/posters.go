@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.brainwhat/internal/clamav"
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/imaging"
+	"greenlight.brainwhat/internal/storage"
+	"greenlight.brainwhat/internal/validator"
+	"greenlight.brainwhat/internal/worker"
+)
+
+const (
+	maxPosterBytes  = 10 << 20
+	maxPosterWidth  = 6000
+	maxPosterHeight = 6000
+)
+
+// Widths of the generated variants. "original" is the upload as it was
+var posterSizes = map[string]int{
+	"thumb":  150,
+	"medium": 500,
+}
+
+// Uploads keep their format, so the original can have either extension
+var posterFormats = map[string]string{
+	"jpeg": "jpg",
+	"png":  "png",
+}
+
+func posterKey(movieID int64, size, ext string) string {
+	return fmt.Sprintf("posters/%d/%s.%s", movieID, size, ext)
+}
+
+// validatePoster looks at the bytes only, the Content-Type the client sent
+// doesn't matter. Dimensions come from the header, so decompression
+// bombs are caught before anything gets decoded
+func validatePoster(v *validator.Validator, body []byte) string {
+	sniffed := http.DetectContentType(body)
+	if sniffed != "image/jpeg" && sniffed != "image/png" {
+		v.AddError("poster", "must be a JPEG or PNG image")
+		return ""
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil || "image/"+format != sniffed {
+		v.AddError("poster", "must be a valid JPEG or PNG image")
+		return ""
+	}
+
+	v.Check(config.Width <= maxPosterWidth, "poster", fmt.Sprintf("must not be wider than %d pixels", maxPosterWidth))
+	v.Check(config.Height <= maxPosterHeight, "poster", fmt.Sprintf("must not be higher than %d pixels", maxPosterHeight))
+
+	return format
+}
+
+// uploadPosterHandler takes the raw image as the request body. Metadata
+// is stripped before anything is stored. Variants are made by a
+// background job, until it's done GET serves the original
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	_, err = app.tenantModels(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPosterBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.badRequestReponse(w, r, fmt.Errorf("poster must not be larger than %d bytes", maxPosterBytes))
+			return
+		}
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	format := validatePoster(v, body)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	body, err = imaging.StripMetadata(body, format)
+	if err != nil {
+		v.AddError("poster", "must be a valid JPEG or PNG image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Without a working scanner nothing gets stored
+	if app.clamav != nil {
+		err = app.clamav.Scan(r.Context(), body)
+		if err != nil {
+			var infected *clamav.InfectedError
+			switch {
+			case errors.As(err, &infected):
+				app.logger.Warn("rejected infected upload", "movie_id", id, "signature", infected.Signature)
+				v.AddError("poster", "was rejected by the virus scanner")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	// Old variants would be served for the new poster until the job replaces them
+	for _, key := range app.posterKeys(id) {
+		err = app.storage.Delete(r.Context(), key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.storage.Put(r.Context(), posterKey(id, "original", posterFormats[format]), bytes.NewReader(body), int64(len(body)), "image/"+format)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.enqueuePosterResize(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"message": "poster uploaded, resized versions will be available shortly"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// posterKeys lists every key a movie's poster can be stored under
+func (app *application) posterKeys(movieID int64) []string {
+	var keys []string
+
+	for _, ext := range posterFormats {
+		keys = append(keys, posterKey(movieID, "original", ext))
+	}
+	for size := range posterSizes {
+		keys = append(keys, posterKey(movieID, size, "jpg"))
+	}
+
+	return keys
+}
+
+// The job queue needs postgres, with in-memory storage the resize
+// just runs in the background of this process
+func (app *application) enqueuePosterResize(movieID int64) error {
+	if app.jobs == nil {
+		app.background(func() {
+			err := app.resizePoster(context.Background(), movieID)
+			if err != nil {
+				app.logger.Error("resizing poster failed", "movie_id", movieID, "error", err)
+			}
+		})
+		return nil
+	}
+
+	return app.jobs.Enqueue("poster.resize", map[string]int64{"movie_id": movieID})
+}
+
+func (app *application) resizePosterJob(ctx context.Context, job *worker.Job) error {
+	var payload struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	return app.resizePoster(ctx, payload.MovieID)
+}
+
+func (app *application) resizePoster(ctx context.Context, movieID int64) error {
+	original, _, _, err := app.openPoster(ctx, movieID, "original")
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	img, _, err := image.Decode(original)
+	if err != nil {
+		return err
+	}
+
+	for size, width := range posterSizes {
+		var buf bytes.Buffer
+
+		err = jpeg.Encode(&buf, imaging.Thumbnail(img, width), &jpeg.Options{Quality: 85})
+		if err != nil {
+			return err
+		}
+
+		err = app.storage.Put(ctx, posterKey(movieID, size, "jpg"), &buf, int64(buf.Len()), "image/jpeg")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openPoster finds the stored poster of the given size. Missing variants
+// fall back to the original, fallback tells the caller that happened
+func (app *application) openPoster(ctx context.Context, movieID int64, size string) (io.ReadCloser, *storage.Object, string, error) {
+	keys := []string{}
+	if size != "original" {
+		keys = append(keys, posterKey(movieID, size, "jpg"))
+	}
+	for _, ext := range posterFormats {
+		keys = append(keys, posterKey(movieID, "original", ext))
+	}
+
+	for _, key := range keys {
+		body, object, err := app.storage.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return body, object, key, nil
+	}
+
+	return nil, nil, "", storage.ErrNotFound
+}
+
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	size := app.readString(r.URL.Query(), "size", "original")
+
+	v := validator.New()
+	_, known := posterSizes[size]
+	v.Check(known || size == "original", "size", "must be one of thumb, medium, original")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	body, object, key, err := app.openPoster(r.Context(), id, size)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	// Variants that aren't ready yet mustn't be cached as the original
+	if size != "original" && key != posterKey(id, size, "jpg") {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+
+	// With S3 the client downloads straight from the bucket
+	url, err := app.storage.PresignGet(r.Context(), key, 15*time.Minute)
+	if err == nil {
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+	if !errors.Is(err, storage.ErrPresignNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !object.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err == nil && !object.ModTime.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+
+	_, err = io.Copy(w, body)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// enforceQuota counts requests against the quotas of their API key's plan.
+// Quotas need -api-keys-required, so every request that gets here has a
+// key. Like X-RateLimit-Reset, X-Quota-Reset is in seconds from now
+func (app *application) enforceQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := app.contextGetAPIKey(r)
+
+		result, err := app.quotas.Allow(key, time.Now())
+		if err != nil {
+			// Same as the rate limiter, billing trouble shouldn't take the API down
+			app.logError(r, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reset := math.Ceil(result.Reset.Seconds())
+
+		w.Header().Set("X-Quota-Plan", key.Plan)
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(result.Limit, 10))
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(result.Remaining, 10))
+		w.Header().Set("X-Quota-Reset", strconv.Itoa(int(reset)))
+
+		if !result.Allowed {
+			app.quotaExceededResponse(w, r, result, reset)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.brainwhat/internal/ratelimit"
+)
+
+// Every policy has its own counter per client, routes sharing
+// a policy share the counter too
+var rateLimitPolicies = map[string]ratelimit.Policy{
+	"read":    {Limit: 120, Window: time.Minute},
+	"write":   {Limit: 30, Window: time.Minute},
+	"suggest": {Limit: 300, Window: time.Minute}, // typeahead sends one per keystroke
+}
+
+// Routes use "read" for GET and "write" for everything else unless
+// they're listed here. Paths leave out the version prefix, and ""
+// turns limiting off for the route
+var rateLimitRoutes = map[string]string{
+	"GET /healthcheck":    "", // probes from load balancers and orchestrators
+	"GET /readiness":      "",
+	"GET /healthz":        "",
+	"GET /readyz":         "",
+	"GET /movies/suggest": "suggest",
+}
+
+// rateLimitPolicy returns the name of the policy for a route,
+// false when the route isn't limited
+func (app *application) rateLimitPolicy(method, path string) (string, bool) {
+	if app.limiter == nil {
+		return "", false
+	}
+
+	name, listed := rateLimitRoutes[method+" "+path]
+	if !listed {
+		name = "write"
+		if method == http.MethodGet {
+			name = "read"
+		}
+	}
+
+	return name, name != ""
+}
+
+// rateLimitKey picks whose counter a request goes to. Clients with an
+// API key are counted by key instead of IP, and a key with its own
+// rate limit gets one counter for all routes. The same IP gets separate
+// counters for every tenant it calls
+func (app *application) rateLimitKey(r *http.Request, policyName string, policy ratelimit.Policy) (string, ratelimit.Policy) {
+	key := app.contextGetAPIKey(r)
+
+	switch {
+	case key != nil && key.RateLimit > 0:
+		return "apikey:" + strconv.FormatInt(key.ID, 10), ratelimit.Policy{Limit: key.RateLimit, Window: time.Minute}
+	case key != nil:
+		return policyName + ":apikey:" + strconv.FormatInt(key.ID, 10), policy
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if tenant := app.contextGetTenant(r); tenant != nil {
+		ip = "tenant:" + strconv.FormatInt(tenant.ID, 10) + ":" + ip
+	}
+
+	return policyName + ":" + ip, policy
+}
+
+// rateLimit limits clients by IP or API key. Every response says where the client
+// stands: X-RateLimit-Reset is in seconds from now, not a timestamp
+func (app *application) rateLimit(policyName string, next http.Handler) http.Handler {
+	policy, ok := rateLimitPolicies[policyName]
+	if !ok {
+		panic("unknown rate limit policy " + policyName)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitKey, limitPolicy := app.rateLimitKey(r, policyName, policy)
+
+		result, err := app.limiter.Allow(r.Context(), limitKey, limitPolicy)
+		if err != nil {
+			// A broken limiter shouldn't take the API down with it
+			app.logError(r, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reset := math.Ceil(result.Reset.Seconds())
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(reset)))
+
+		if !result.Allowed {
+			app.rateLimitExceededResponse(w, r, reset)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
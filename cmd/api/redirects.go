@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.brainwhat/internal/data"
+)
+
+// movieNotFoundResponse is notFoundError for routes under /movies/{id}.
+// When the movie moved, say it was merged into another one, GET and PATCH
+// get a 308 to the same route of the new id instead, and so does HEAD.
+// 308 keeps the method and the body, so a PATCH goes to the new movie as
+// it was sent
+func (app *application) movieNotFoundResponse(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPatch {
+		app.notFoundError(w, r)
+		return
+	}
+
+	newID, err := app.tenantModels(r).Movies.Redirect(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	location, ok := movedLocation(r, "id", newID)
+	if !ok {
+		app.notFoundError(w, r)
+		return
+	}
+
+	http.Redirect(w, r, location, http.StatusPermanentRedirect)
+}
+
+// movedLocation is the request's URI with the {name} wildcard of the route
+// it matched set to id. Everything else, version prefix and query
+// included, stays as it was
+func movedLocation(r *http.Request, name string, id int64) (string, bool) {
+	// Patterns are "METHOD /path"
+	_, pattern, _ := strings.Cut(r.Pattern, " ")
+
+	wildcards := strings.Split(pattern, "/")
+	segments := strings.Split(r.URL.Path, "/")
+
+	if len(wildcards) != len(segments) {
+		return "", false
+	}
+
+	found := false
+	for i, wildcard := range wildcards {
+		if wildcard == "{"+name+"}" {
+			segments[i] = strconv.FormatInt(id, 10)
+			found = true
+		}
+	}
+
+	if !found {
+		return "", false
+	}
+
+	u := *r.URL
+	u.Path = strings.Join(segments, "/")
+	u.RawPath = ""
+
+	return u.RequestURI(), true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+func (app *application) listMovieReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	// 404 for movies that don't exist instead of an empty list
+	_, err = app.tenantModels(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.movieNotFoundResponse(w, r, id)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	releases, err := app.tenantModels(r).Releases.GetAllForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"releases": releases}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setMovieReleaseHandler is a PUT, sending it again with another date
+// moves the release
+func (app *application) setMovieReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	var input struct {
+		Date data.Date `json:"date"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	release := &data.Release{
+		MovieID: id,
+		Country: r.PathValue("country"),
+		Type:    r.PathValue("type"),
+		Date:    input.Date,
+	}
+
+	v := validator.New()
+	if data.ValidateRelease(v, release); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.tenantModels(r).Releases.Set(release)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"release": release}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMovieReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	err = app.tenantModels(r).Releases.Delete(id, r.PathValue("country"), r.PathValue("type"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "release successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// upcomingView is a release with its movie rendered like everywhere else
+type upcomingView struct {
+	*data.Release
+	Movie any `json:"movie"`
+}
+
+// upcomingMoviesHandler lists what comes out in a country in the next
+// days, e.g. ?country=DE&window=30d&type=theatrical
+func (app *application) upcomingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	opts := movieOptions{fields: app.readFields(qs, movieFieldsSafelist, v)}
+	country := app.readString(qs, "country", "")
+	releaseType := app.readString(qs, "type", "")
+	window := app.readString(qs, "window", "30d")
+	limit := app.readInt(qs, "limit", 20, v)
+
+	days, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+
+	v.Check(country != "", "country", "must be provided")
+	v.Check(country == "" || validator.MatchesRX(country, data.CountryRX), "country", "must be an ISO 3166 country code, e.g. DE")
+	v.Check(releaseType == "" || validator.PermittedValue(releaseType, data.ReleaseTypes...), "type", "must be one of "+strings.Join(data.ReleaseTypes, ", "))
+	v.Check(err == nil && strings.HasSuffix(window, "d"), "window", "must be a number of days, e.g. 30d")
+	v.Check(err != nil || days > 0 && days <= 365, "window", "must be between 1d and 365d")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	releases, err := app.tenantModels(r).Releases.Upcoming(country, releaseType, days, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	views := make([]upcomingView, 0, len(releases))
+
+	for _, release := range releases {
+		movie, err := app.movieView(release.Movie, opts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		views = append(views, upcomingView{Release: &release.Release, Movie: movie})
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"releases": views, "window": window}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReleaseHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	insertTestMovie(t, app, "Moana", 2016, "animation")
+
+	runHandlerTests(t, h, []handlerTest{
+		{name: "set", method: http.MethodPut, target: "/v1/movies/1/releases/US/theatrical", body: `{"date":"2016-11-23"}`, wantStatus: http.StatusOK, wantBody: "2016-11-23"},
+		{name: "set bad date", method: http.MethodPut, target: "/v1/movies/1/releases/US/theatrical", body: `{"date":"23/11/2016"}`, wantStatus: http.StatusBadRequest},
+		{name: "set bad type", method: http.MethodPut, target: "/v1/movies/1/releases/US/drive-in", body: `{"date":"2016-11-23"}`, wantStatus: http.StatusUnprocessableEntity, wantBody: `"type"`},
+		{name: "set missing movie", method: http.MethodPut, target: "/v1/movies/999/releases/US/theatrical", body: `{"date":"2016-11-23"}`, wantStatus: http.StatusNotFound},
+
+		{name: "list", method: http.MethodGet, target: "/v1/movies/1/releases", wantStatus: http.StatusOK, wantBody: "theatrical"},
+		{name: "list missing movie", method: http.MethodGet, target: "/v1/movies/999/releases", wantStatus: http.StatusNotFound},
+
+		{name: "delete", method: http.MethodDelete, target: "/v1/movies/1/releases/US/theatrical", wantStatus: http.StatusOK},
+		{name: "delete again", method: http.MethodDelete, target: "/v1/movies/1/releases/US/theatrical", wantStatus: http.StatusNotFound},
+	})
+}
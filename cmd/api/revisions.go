@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// There are no user accounts yet, so the API key or else the client
+// address is the best we can record as the author of a change
+func (app *application) editor(r *http.Request) string {
+	if key := app.contextGetAPIKey(r); key != nil {
+		return "api-key:" + key.Name
+	}
+	return r.RemoteAddr
+}
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.SortSafelist = []string{"id", "-id"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Makes sure we return 404 for movies that don't exist instead of an empty list
+	_, err = app.tenantModels(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.movieNotFoundResponse(w, r, id)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revisions, metadata, err := app.tenantModels(r).Revisions.GetAllForMovie(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revert puts the movie back to the state it was in before the given revision.
+// It's a regular update, so it gets its own revision and can be reverted too
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	revisionID, err := app.readInt64Param(r, "revision")
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	movie, err := app.tenantModels(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision, err := app.tenantModels(r).Revisions.Get(id, revisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundError(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var snapshot data.Movie
+
+	err = json.Unmarshal(revision.Old, &snapshot)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	old := *movie
+
+	movie.Title = snapshot.Title
+	movie.Year = snapshot.Year
+	movie.Runtime = snapshot.Runtime
+	movie.Genres = snapshot.Genres
+	movie.Certifications = snapshot.Certifications
+	movie.ContentWarnings = snapshot.ContentWarnings
+	movie.Budget = snapshot.Budget
+	movie.Revenue = snapshot.Revenue
+	movie.Currency = snapshot.Currency
+
+	// Validation rules could've changed since the revision was made
+	v := validator.New()
+	if app.validateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.tenantModels(r).Movies.Update(movie, old, app.editor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeMovie(w, r, http.StatusOK, movie, movieOptions{}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,22 +2,228 @@ package main
 
 import (
 	"net/http"
-
-	"github.com/julienschmidt/httprouter"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
 )
 
+// We use ServeMux instead of httprouter because httprouter can't have
+// static segments next to wildcards, e.g. /movies/undo and /movies/{id}
 func (app *application) routes() http.Handler {
 
-	router := httprouter.New()
+	mux := http.NewServeMux()
+
+	app.mountVersions(mux,
+		apiVersion{prefix: "/v1", routes: app.routesV1},
+		apiVersion{prefix: "/v2", routes: app.routesV2},
+	)
+
+	var h http.Handler = app.jsonErrors(mux)
+
+	// Before the mux, it routes by the method
+	if app.config.methodOverride {
+		h = app.overrideMethod(h)
+	}
+
+	return app.recoverPanic(app.compress(app.validateQuery(h)))
+}
+
+// routeMiddleware is the chain routes() wraps around every route,
+// outermost first. It only feeds the routes listing, keep it in sync
+func (app *application) routeMiddleware() []string {
+	middleware := []string{"recoverPanic", "compress", "validateQuery"}
+	if app.config.methodOverride {
+		middleware = append(middleware, "overrideMethod")
+	}
+
+	return append(middleware, "jsonErrors")
+}
+
+type routeInfo struct {
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Name       string   `json:"name,omitempty"`
+	Handler    string   `json:"handler"`
+	Middleware []string `json:"middleware"`
+}
+
+// routeInfo describes every registered route. It's built from what
+// the versionRouters recorded, so it can't drift from what's served
+func (app *application) routeInfo() []routeInfo {
+	info := make([]routeInfo, 0, len(app.routeList))
+
+	for _, route := range app.routeList {
+		info = append(info, routeInfo{
+			Method:     route.method,
+			Path:       route.path,
+			Name:       route.name,
+			Handler:    route.handler,
+			Middleware: slices.Concat(app.routeMiddleware(), route.middleware),
+		})
+	}
+
+	return info
+}
+
+// handlerName turns main.(*application).listMoviesHandler-fm into listMoviesHandler
+func handlerName(handler http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Methods X-HTTP-Method-Override can turn a POST into
+var overridableMethods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// overrideMethod is for clients behind proxies that only let GET and POST
+// through. A POST with X-HTTP-Method-Override: PATCH is routed and handled
+// as if it was a PATCH. Only POST can be overridden, a GET that changes
+// something would be cached and prefetched like any other GET
+func (app *application) overrideMethod(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		override := r.Header.Get("X-HTTP-Method-Override")
+		if override == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := strings.ToUpper(override)
+		if !slices.Contains(overridableMethods, method) {
+			app.errorResponse(w, r, http.StatusBadRequest, "X-HTTP-Method-Override must be PUT, PATCH or DELETE")
+			return
+		}
+
+		r = r.WithContext(r.Context())
+		r.Method = method
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeMux answers unknown paths and methods with plain text.
+// When no pattern matches we send our JSON errors instead.
+// OPTIONS isn't registered anywhere, so it ends up here too and gets
+// the methods of the path without running any route middleware. That's
+// what CORS preflights need, they don't carry credentials
+func (app *application) jsonErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Run the mux's own error handler just to find out whether it's 404 or 405
+		rec := &statusRecorder{header: make(http.Header)}
+		handler.ServeHTTP(rec, r)
+
+		if rec.status == http.StatusMethodNotAllowed {
+			allow := allowedMethods(rec.header.Get("Allow"))
+
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			app.methodNotAllowedError(w, r, allow)
+			return
+		}
+
+		app.notFoundError(w, r)
+	})
+}
+
+// allowedMethods adds OPTIONS to the Allow header ServeMux came up with.
+// The mux builds it from the registered patterns, GET ones allow HEAD too
+func allowedMethods(header string) []string {
+	var allow []string
+	for _, method := range strings.Split(header, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			allow = append(allow, method)
+		}
+	}
+
+	if !slices.Contains(allow, http.MethodOptions) {
+		allow = append(allow, http.MethodOptions)
+	}
+	slices.Sort(allow)
+
+	return allow
+}
+
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (app *application) routesV1(v *versionRouter) {
+	v.HandlerFunc(http.MethodGet, "/healthcheck", app.healthcheckHandler)
+	v.HandlerFunc(http.MethodGet, "/readiness", app.readinessHandler)
+	v.HandlerFunc(http.MethodGet, "/healthz", app.healthzHandler)
+	v.HandlerFunc(http.MethodGet, "/readyz", app.readyzHandler)
+	v.HandlerFunc(http.MethodGet, "/version", app.versionHandler)
+	v.HandlerFunc(http.MethodGet, "/movies", app.listMoviesHandler).Name("movies")
+	v.HandlerFunc(http.MethodPost, "/movies", app.createMovieHandler)
+	v.HandlerFunc(http.MethodPatch, "/movies", app.batchUpdateMoviesHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/trending", app.trendingMoviesHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/suggest", app.suggestMoviesHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/random", app.randomMovieHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/revenue", app.revenueByYearHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/upcoming", app.upcomingMoviesHandler)
+	v.HandlerFunc(http.MethodPost, "/movies/availability/sync", app.syncAvailabilityHandler)
+	v.HandlerFunc(http.MethodPost, "/movies/import", app.importMoviesHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/export", app.exportMoviesHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/{id}", app.showMovieHandler).Name("movie")
+	v.HandlerFunc(http.MethodPatch, "/movies/{id}", app.updateMovieHandler)
+	v.HandlerFunc(http.MethodDelete, "/movies/{id}", app.deleteMovieHandler)
+	v.HandlerFunc(http.MethodPost, "/movies/undo", app.undoDeleteMovieHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/{id}/history", app.listMovieRevisionsHandler).Name("movie-history")
+	v.HandlerFunc(http.MethodGet, "/movies/{id}/similar", app.similarMoviesHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/{id}/poster", app.showPosterHandler)
+	v.HandlerFunc(http.MethodPut, "/movies/{id}/poster", app.uploadPosterHandler)
+	v.HandlerFunc(http.MethodPost, "/movies/{id}/revert/{revision}", app.revertMovieHandler)
+	v.HandlerFunc(http.MethodPost, "/movies/{id}/merge-into/{target}", app.mergeMovieHandler)
+	v.HandlerFunc(http.MethodGet, "/movies/{id}/releases", app.listMovieReleasesHandler)
+	v.HandlerFunc(http.MethodPut, "/movies/{id}/releases/{country}/{type}", app.setMovieReleaseHandler)
+	v.HandlerFunc(http.MethodDelete, "/movies/{id}/releases/{country}/{type}", app.deleteMovieReleaseHandler)
+
+	v.HandlerFunc(http.MethodGet, "/genres", app.listGenresHandler)
 
-	router.NotFound = http.HandlerFunc(app.notFoundError)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedError)
+	v.HandlerFunc(http.MethodGet, "/operations/{id}", app.showOperationHandler).Name("operation")
+	v.HandlerFunc(http.MethodGet, "/operations/{id}/errors", app.showOperationErrorsHandler).Name("operation-errors")
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	v.HandlerFunc(http.MethodPost, "/collections", app.createCollectionHandler)
+	v.HandlerFunc(http.MethodGet, "/collections/{id}", app.showCollectionHandler).Name("collection")
+	v.HandlerFunc(http.MethodPut, "/collections/{id}/movies/{movie_id}", app.attachCollectionMovieHandler)
+	v.HandlerFunc(http.MethodDelete, "/collections/{id}/movies/{movie_id}", app.detachCollectionMovieHandler)
+
+	v.HandlerFunc(http.MethodGet, "/api-keys/{id}/usage", app.showAPIKeyUsageHandler)
+
+	v.HandlerFunc(http.MethodPatch, "/admin/genres/{id}", app.renameGenreHandler)
+	v.HandlerFunc(http.MethodPost, "/admin/genres/{id}/merge", app.mergeGenreHandler)
+}
 
-	return app.recoverPanic(router)
+// v2 is a scaffold for now. Breaking changes to the movie representation
+// go here, v1 handlers can be reused for everything that stays the same
+func (app *application) routesV2(v *versionRouter) {
+	v.HandlerFunc(http.MethodGet, "/healthcheck", app.healthcheckHandler)
+	v.HandlerFunc(http.MethodGet, "/readiness", app.readinessHandler)
+	v.HandlerFunc(http.MethodGet, "/healthz", app.healthzHandler)
+	v.HandlerFunc(http.MethodGet, "/readyz", app.readyzHandler)
+	v.HandlerFunc(http.MethodGet, "/version", app.versionHandler)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greenlight.brainwhat/internal/worker"
+)
+
+// Finished jobs are only kept around for debugging
+const jobsRetention = 7 * 24 * time.Hour
+
+// scheduler sets up the periodic cleanup tasks. Intervals come from
+// the -schedule-* flags, 0 turns a task off
+func (app *application) scheduler(db *sql.DB) *worker.Scheduler {
+	s := worker.NewScheduler(db, app.logger)
+
+	s.Every("expire-undo-tokens", app.config.schedule.expireTokens, func(ctx context.Context) error {
+		n, err := app.models.Movies.DeleteExpiredUndoTokens(ctx)
+		if n > 0 {
+			app.logger.Info("deleted expired undo tokens", "count", n)
+		}
+		return err
+	})
+
+	s.Every("purge-trash", app.config.schedule.purgeTrash, func(ctx context.Context) error {
+		n, err := app.models.Movies.PurgeDeleted(ctx, app.config.trashRetention)
+		if n > 0 {
+			app.logger.Info("purged deleted movies", "count", n)
+		}
+		return err
+	})
+
+	if app.jobs != nil {
+		s.Every("purge-jobs", app.config.schedule.purgeJobs, func(ctx context.Context) error {
+			n, err := app.jobs.PurgeFinished(ctx, jobsRetention)
+			if n > 0 {
+				app.logger.Info("purged finished jobs", "count", n)
+			}
+			return err
+		})
+	}
+
+	s.Every("purge-operations", app.config.schedule.purgeOps, func(ctx context.Context) error {
+		ids, err := app.models.Operations.PurgeFinished(ctx, operationsRetention)
+		if err != nil {
+			return err
+		}
+
+		// Import error reports
+		for _, id := range ids {
+			err = app.storage.Delete(ctx, importReportKey(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(ids) > 0 {
+			app.logger.Info("purged finished operations", "count", len(ids))
+		}
+		return nil
+	})
+
+	s.Every("rotate-event-partitions", app.config.schedule.rotateEvents, func(ctx context.Context) error {
+		dropped, err := app.models.Outbox.RotatePartitions(ctx, app.config.outbox.retention)
+		if len(dropped) > 0 {
+			app.logger.Info("dropped event partitions", "partitions", dropped)
+		}
+		return err
+	})
+
+	s.Every("refresh-catalog-stats", app.config.schedule.catalogStats, func(ctx context.Context) error {
+		return app.models.Stats.RefreshCatalog(ctx)
+	})
+
+	if app.availability != nil {
+		s.Every("sync-availability", app.config.schedule.availability, app.syncAvailability)
+	}
+
+	return s
+}
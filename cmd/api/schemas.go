@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"greenlight.brainwhat/internal/jsonschema"
+)
+
+// The *_response.json schemas describe what the handlers answer with,
+// only TestContracts checks them
+//
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// Request bodies checked with -validate-schemas, paths leave out the
+// version prefix. Routes that aren't listed are only checked by their handler
+var requestSchemas = map[string]string{
+	"POST /movies":       "movie_create.json",
+	"PATCH /movies/{id}": "movie_update.json",
+	"PUT /movies/{id}/releases/{country}/{type}": "movie_release.json",
+	"POST /collections":                          "collection_create.json",
+}
+
+// loadSchemas parses every schema up front, a broken one fails startup
+// instead of the first request that needs it
+func loadSchemas() (map[string]*jsonschema.Schema, error) {
+	schemas := make(map[string]*jsonschema.Schema, len(requestSchemas))
+
+	for route, file := range requestSchemas {
+		b, err := schemaFS.ReadFile("schemas/" + file)
+		if err != nil {
+			return nil, err
+		}
+
+		schemas[route], err = jsonschema.Parse(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return schemas, nil
+}
+
+// validateSchema checks the body before the handler decodes it, and tells
+// the client exactly where it went wrong, e.g. "/genres/3": "expected string".
+// Bodies that aren't JSON at all are left to readJSON and its messages.
+// Unknown fields are too, they depend on X-Input-Mode
+func (app *application) validateSchema(schema *jsonschema.Schema, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Same limit and gzip handling readJSON applies later
+		body, err := app.requestBody(w, r, 1_048_576)
+		if err != nil {
+			app.badRequestReponse(w, r, err)
+			return
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must be under %d bytes", maxBytesError.Limit)
+			}
+			app.badRequestReponse(w, r, err)
+			return
+		}
+
+		// The handler gets the body already decompressed
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		r.Header.Del("Content-Encoding")
+
+		errs, err := schema.Validate(b)
+		if err == nil && errs != nil {
+			app.failedValidationResponse(w, r, errs)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"greenlight.brainwhat/internal/breaker"
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// A curated list of movies for development and staging databases
+//
+//go:embed seed_movies.json
+var seedMoviesJSON []byte
+
+func seedCommand(args []string, logger *slog.Logger) error {
+	var cfg config
+
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	tenantSlug := fs.String("tenant", data.DefaultTenantSlug, "Slug of the tenant that gets the movies")
+	dbFlags(fs, &cfg)
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(cfg, logger, breaker.New(cfg.db.breaker.threshold, cfg.db.breaker.cooldown))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	models := data.NewModels(db, 0.3)
+
+	tenant, err := models.Tenants.GetBySlug(*tenantSlug)
+	if err != nil {
+		return fmt.Errorf("tenant %q: %w", *tenantSlug, err)
+	}
+
+	inserted, skipped, err := seedMovies(models.ForTenant(tenant.ID))
+	if err != nil {
+		return err
+	}
+
+	logger.Info("seeded movies", "tenant", tenant.Slug, "inserted", inserted, "skipped", skipped)
+	return nil
+}
+
+// seedMovies goes through the regular models, so genres are created along
+// the way. Running it again is safe, movies that are already there
+// (same title and year) are skipped
+func seedMovies(models data.Models) (inserted, skipped int, err error) {
+	var movies []*data.Movie
+
+	err = json.Unmarshal(seedMoviesJSON, &movies)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, movie := range movies {
+		// Seed data goes through the same checks as the API
+		v := validator.New()
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			return inserted, skipped, fmt.Errorf("invalid seed movie %q: %v", movie.Title, v.Errors)
+		}
+
+		exists, err := movieExists(models, movie)
+		if err != nil {
+			return inserted, skipped, err
+		}
+
+		if exists {
+			skipped++
+			continue
+		}
+
+		err = models.Movies.Insert(movie)
+		if err != nil {
+			return inserted, skipped, err
+		}
+		inserted++
+	}
+
+	return inserted, skipped, nil
+}
+
+// movieExists looks the movie up through the regular title search.
+// That finds movies with extra words in the title too, so titles are compared exactly
+func movieExists(models data.Models, movie *data.Movie) (bool, error) {
+	search := data.MovieSearch{Title: movie.Title, Year: int(movie.Year)}
+	filters := data.Filters{Page: 1, PageSize: 100, Sort: "id", SortSafelist: []string{"id"}}
+
+	found, _, err := models.Movies.GetAll(search, filters)
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range found {
+		if f.Title == movie.Title {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
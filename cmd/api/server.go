@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/events"
+)
+
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	// Closed on shutdown, background goroutines watch it to know when to stop
+	done := make(chan struct{})
+
+	app.background(func() { app.flushViews(done) })
+	app.background(func() { app.flushUsage(done) })
+	if app.spans != nil {
+		app.background(func() { app.exportSpans(done) })
+	}
+	if app.jobs != nil {
+		app.background(func() { app.jobs.Run(done) })
+	}
+	app.background(func() { app.schedule.Run(done) })
+	app.background(func() { app.relayEvents(done) })
+
+	var adminSrv *http.Server
+
+	if app.config.admin.port != 0 {
+		adminSrv = app.adminServer()
+
+		go func() {
+			app.logger.Info("starting admin server", "addr", adminSrv.Addr)
+
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("admin server", "error", err)
+			}
+		}()
+	}
+
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// Readiness fails from now on, so load balancers stop sending requests
+		app.shuttingDown.Store(true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// Shutdown waits for in-flight requests, so nothing records views
+		// or enqueues jobs after this. Workers finish the jobs they hold
+		err := srv.Shutdown(ctx)
+
+		// Debug endpoints stay up until the API is done, useful when shutdown hangs
+		if adminSrv != nil {
+			adminSrv.Shutdown(ctx)
+		}
+
+		close(done)
+		app.wg.Wait()
+
+		err = errors.Join(err, app.runShutdownHooks(ctx))
+
+		shutdownError <- err
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+
+	// ListenAndServe returns ErrServerClosed right after Shutdown is called,
+	// the real result comes from the goroutine above
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+
+	return nil
+}
+
+// OnShutdown registers fn to run on graceful shutdown, once the servers
+// stopped and the background goroutines are done. Hooks run in reverse
+// order like defers, whatever was set up last is shut down first. They
+// are registered while starting up, before serve
+func (app *application) OnShutdown(fn func(ctx context.Context) error) {
+	app.shutdownHooks = append(app.shutdownHooks, fn)
+}
+
+// runShutdownHooks runs every hook even when some fail, ctx is what's
+// left of the shutdown timeout
+func (app *application) runShutdownHooks(ctx context.Context) error {
+	var errs []error
+
+	for i := len(app.shutdownHooks) - 1; i >= 0; i-- {
+		err := app.shutdownHooks[i](ctx)
+		if err != nil {
+			app.logger.Error("shutdown hook failed", "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// background runs fn in a goroutine that shutdown waits for
+// and that can't take the whole server down with a panic
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// flushViews writes buffered view counts periodically, the last flush is a shutdown hook
+func (app *application) flushViews(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.stats.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := app.views.Flush()
+			if err != nil {
+				app.logger.Error("flushing view counts", "error", err)
+			}
+		case <-done:
+			return // the last flush is a shutdown hook
+		}
+	}
+}
+
+// flushUsage writes API key request counts and metrics periodically, the
+// last flushes are shutdown hooks
+func (app *application) flushUsage(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.usage.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if app.quotas != nil {
+				err := app.quotas.Flush()
+				if err != nil {
+					app.logger.Error("flushing api key usage", "error", err)
+				}
+			}
+
+			err := app.metrics.Flush()
+			if err != nil {
+				app.logger.Error("flushing api key metrics", "error", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// exportSpans sends spans to the collector periodically, the last flush
+// is a shutdown hook
+func (app *application) exportSpans(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.tracing.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), app.config.tracing.flushInterval)
+			err := app.spans.Flush(ctx)
+			cancel()
+
+			if err != nil {
+				app.logger.Error("exporting spans", "error", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// How many outbox events are published in one go
+const relayBatchSize = 100
+
+// relayEvents publishes outbox events until done is closed. A full batch
+// means there may be more waiting, so we don't wait for the next tick
+func (app *application) relayEvents(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.outbox.relayInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.models.Outbox.Relay(relayBatchSize, app.publishEvent)
+		if err != nil {
+			app.logger.Error("relaying events", "error", err)
+		}
+
+		if n == relayBatchSize {
+			select {
+			case <-done:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// publishEvent hands an outbox event to the configured publisher
+func (app *application) publishEvent(event *data.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return app.events.Publish(ctx, &events.Event{
+		ID:   event.ID,
+		Type: event.Type,
+		Time: event.CreatedAt,
+		Data: event.Payload,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"greenlight.brainwhat/internal/shed"
+)
+
+// Routes that are never shed. Paths leave out the version prefix.
+// An orchestrator that can't reach the probes of a busy instance would
+// restart it, making things worse
+var loadSheddingExempt = map[string]bool{
+	"GET /healthcheck": true,
+	"GET /readiness":   true,
+	"GET /healthz":     true,
+	"GET /readyz":      true,
+}
+
+func (app *application) loadSheddingApplies(method, path string) bool {
+	return app.shed != nil && !loadSheddingExempt[method+" "+path]
+}
+
+// shedLoad lets -max-in-flight requests run at once, queues a few more
+// briefly (-max-queue, -queue-timeout) and turns the rest away with a 503
+func (app *application) shedLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := app.shed.Acquire(r.Context())
+		if err != nil {
+			if errors.Is(err, shed.ErrOverloaded) {
+				app.overloadedResponse(w, r)
+			}
+			// Otherwise the client is gone, there's nobody to answer
+			return
+		}
+		defer app.shed.Release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) overloadedResponse(w http.ResponseWriter, r *http.Request) {
+	retryAfter := math.Ceil(app.shed.RetryAfter().Seconds())
+	w.Header().Set("Retry-After", fmt.Sprint(retryAfter))
+
+	message := "the server is handling too many requests, try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/ratelimit"
+	"greenlight.brainwhat/internal/validator"
+)
+
+const (
+	tenantContextKey   = contextKey("tenant")
+	tenantTxContextKey = contextKey("tenantTx")
+)
+
+func (app *application) contextSetTenant(r *http.Request, tenant *data.Tenant) *http.Request {
+	ctx := context.WithValue(r.Context(), tenantContextKey, tenant)
+	return r.WithContext(ctx)
+}
+
+// contextGetTenant returns nil on routes that don't resolve a tenant
+func (app *application) contextGetTenant(r *http.Request) *data.Tenant {
+	tenant, _ := r.Context().Value(tenantContextKey).(*data.Tenant)
+	return tenant
+}
+
+// tenantModels returns the models of the request's tenant. Only handlers
+// behind resolveTenant may call it. With -db-rls they run in the
+// request's transaction
+func (app *application) tenantModels(r *http.Request) data.Models {
+	tenant := app.contextGetTenant(r)
+	if tenant == nil {
+		panic("missing tenant value in request context")
+	}
+
+	models := app.models.ForTenant(tenant.ID)
+	if tx, ok := r.Context().Value(tenantTxContextKey).(*sql.Tx); ok {
+		models = app.models.ForTenantTx(tenant.ID, tx)
+	}
+
+	if deadline, ok := r.Context().Deadline(); ok {
+		models = models.WithDeadline(deadline)
+	}
+
+	if span := requestSpan(r); span != nil {
+		models = models.WithSpan(span)
+	}
+
+	return models
+}
+
+// Every route belongs to a tenant unless it's listed here. Paths leave
+// out the version prefix. Probes have to keep working without the database
+var tenantlessRoutes = map[string]bool{
+	"GET /healthcheck": true,
+	"GET /readiness":   true,
+	"GET /healthz":     true,
+	"GET /readyz":      true,
+}
+
+func (app *application) tenantScoped(method, path string) bool {
+	return !tenantlessRoutes[method+" "+path]
+}
+
+// tenantSlug picks the tenant out of the request. With -tenant-domain set
+// acme.example.com is tenant acme, otherwise the X-Tenant header is used.
+// Requests that name no tenant get the default one
+func (app *application) tenantSlug(r *http.Request) string {
+	if domain := app.config.tenants.domain; domain != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+		if ok && sub != "" && !strings.Contains(sub, ".") {
+			return sub
+		}
+	}
+
+	if slug := r.Header.Get("X-Tenant"); slug != "" {
+		return slug
+	}
+
+	return data.DefaultTenantSlug
+}
+
+// resolveTenant puts the tenant into the request context and enforces
+// its rate limit. The tenant limit counts every request of every client,
+// the per client limits still apply on top of it
+func (app *application) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := app.models.Tenants.GetBySlug(app.tenantSlug(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.tenantNotFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if tenant.RateLimit > 0 && app.limiter != nil {
+			policy := ratelimit.Policy{Limit: tenant.RateLimit, Window: time.Minute}
+
+			result, err := app.limiter.Allow(r.Context(), "tenant:"+strconv.FormatInt(tenant.ID, 10), policy)
+			switch {
+			case err != nil:
+				// Same as rateLimit, a broken limiter lets requests through
+				app.logError(r, err)
+			case !result.Allowed:
+				app.rateLimitExceededResponse(w, r, math.Ceil(result.Reset.Seconds()))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, app.contextSetTenant(r, tenant))
+	})
+}
+
+// Routes that stream their response don't get a tenantTx. It would commit
+// with the first line, the queries for the rest would have no transaction
+var streamingRoutes = map[string]bool{
+	"GET /movies/export": true,
+}
+
+// tenantTx runs the handler in a transaction that postgres row-level
+// security limits to the request's tenant (-db-rls). It sits right around
+// the handler, so requests that get rejected earlier don't hold a connection
+func (app *application) tenantTx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tx, err := data.BeginTenantTx(r.Context(), app.db, app.contextGetTenant(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// No-op once committed, otherwise panics and errors roll back
+		defer tx.Rollback()
+
+		tw := &txResponseWriter{ResponseWriter: w, app: app, r: r, tx: tx}
+
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), tenantTxContextKey, tx)))
+
+		if !tw.done {
+			tw.finish(http.StatusOK)
+		}
+	})
+}
+
+// inTenantTx runs fn with models that share one transaction, which is
+// rolled back when fn fails. With -db-rls that's the request's transaction,
+// the error response that follows rolls it back. The memory models have
+// no transactions, fn just runs
+func (app *application) inTenantTx(r *http.Request, fn func(models data.Models) error) error {
+	if _, ok := r.Context().Value(tenantTxContextKey).(*sql.Tx); ok {
+		return fn(app.tenantModels(r))
+	}
+
+	return app.withTenantTx(r.Context(), app.contextGetTenant(r).ID, fn)
+}
+
+// withTenantTx is inTenantTx for code that doesn't run in a request, like jobs
+func (app *application) withTenantTx(ctx context.Context, tenantID int64, fn func(models data.Models) error) error {
+	if app.db == nil {
+		return fn(app.models.ForTenant(tenantID))
+	}
+
+	tx, err := data.BeginTenantTx(ctx, app.db, tenantID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(app.models.ForTenantTx(tenantID, tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// txResponseWriter ends the transaction right before the status goes out.
+// Error responses roll back, and when the commit fails the client gets
+// a 500 instead of whatever the handler was about to send
+type txResponseWriter struct {
+	http.ResponseWriter
+	app    *application
+	r      *http.Request
+	tx     *sql.Tx
+	done   bool
+	failed bool
+}
+
+func (tw *txResponseWriter) finish(status int) {
+	tw.done = true
+
+	if status >= http.StatusBadRequest {
+		tw.tx.Rollback()
+		return
+	}
+
+	err := tw.tx.Commit()
+	if err != nil {
+		tw.failed = true
+
+		// Set for the handler's body, not ours
+		tw.Header().Del("Content-Length")
+		tw.app.serverErrorResponse(tw.ResponseWriter, tw.r, err)
+	}
+}
+
+func (tw *txResponseWriter) WriteHeader(status int) {
+	if !tw.done {
+		tw.finish(status)
+	}
+
+	if !tw.failed {
+		tw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (tw *txResponseWriter) Write(b []byte) (int, error) {
+	if !tw.done {
+		tw.WriteHeader(http.StatusOK)
+	}
+
+	// The handler's body is dropped after a failed commit
+	if tw.failed {
+		return len(b), nil
+	}
+
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *txResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// The tenant handlers are served on the admin port like the API key ones
+
+func (app *application) createTenantHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug      string `json:"slug"`
+		Name      string `json:"name"`
+		RateLimit int    `json:"rate_limit"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestReponse(w, r, err)
+		return
+	}
+
+	tenant := &data.Tenant{
+		Slug:      input.Slug,
+		Name:      input.Name,
+		RateLimit: input.RateLimit,
+	}
+
+	v := validator.New()
+	if data.ValidateTenant(v, tenant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tenants.Insert(tenant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateTenant):
+			v.AddError("slug", "a tenant with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"tenant": tenant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	tenants, err := app.models.Tenants.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"tenants": tenants}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
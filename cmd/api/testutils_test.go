@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"greenlight.brainwhat/internal/breaker"
+	"greenlight.brainwhat/internal/data"
+	"greenlight.brainwhat/internal/events"
+	"greenlight.brainwhat/internal/storage"
+)
+
+// newTestApplication is what run() builds for -db-driver=memory with every
+// other flag at its default, minus the background work. Rate limiting and
+// load shedding are off so tests can send as many requests as they like
+func newTestApplication(t testing.TB) *application {
+	t.Helper()
+
+	var cfg config
+
+	err := serveFlags("test", &cfg).Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.db.driver = "memory"
+	cfg.limiter.enabled = false
+	cfg.shed.maxInFlight = 0
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	app := &application{
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewMemoryModels(cfg.search.similarityThreshold),
+		breaker: breaker.New(cfg.db.breaker.threshold, cfg.db.breaker.cooldown),
+		storage: storage.Local{Root: t.TempDir()},
+		events:  events.LogPublisher{Logger: logger},
+	}
+
+	app.views = data.NewViewBuffer(app.models.Stats)
+	app.metrics = data.NewMetricsBuffer(app.models.Metrics)
+
+	return app
+}
+
+// serve sends one request through h and returns the recorded response,
+// body can be nil
+func serve(t testing.TB, h http.Handler, method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var r *http.Request
+	if body == nil {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, bytes.NewReader(body))
+	}
+
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	return rr
+}
+
+// handlerTest is one row of the handler tables: a request and what has
+// to come back. body is sent as is, wantBody only has to be in the response
+type handlerTest struct {
+	name       string
+	method     string
+	target     string
+	body       string
+	headers    map[string]string
+	wantStatus int
+	wantBody   string
+}
+
+func runHandlerTests(t *testing.T, h http.Handler, tests []handlerTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			if tt.body != "" {
+				body = []byte(tt.body)
+			}
+
+			rr := serve(t, h, tt.method, tt.target, body, tt.headers)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d, body %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("got body %s, want it to contain %s", rr.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// insertTestMovie adds a movie to the default tenant
+func insertTestMovie(t testing.TB, app *application, title string, year int32, genres ...string) *data.Movie {
+	t.Helper()
+
+	movie := &data.Movie{Title: title, Year: year, Runtime: 100, Genres: genres}
+
+	err := app.models.ForTenant(data.DefaultTenantID).Movies.Insert(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// traceRequest starts the server span of a request to route, e.g.
+// "GET /v1/movies/{id}". The span reaches the queries through
+// tenantModels, and the response's traceresponse header tells the client
+// which trace to look for
+func (app *application) traceRequest(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.tracer.StartRequest(r, route)
+		defer span.Finish()
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", r.URL.Path)
+
+		w.Header().Set("traceresponse", span.Traceparent())
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", status)
+
+		// Client errors are the client's, they don't make the span fail
+		if status >= 500 {
+			span.SetError(errorStatus(status))
+		}
+	})
+}
+
+type errorStatus int
+
+func (s errorStatus) Error() string {
+	return http.StatusText(int(s))
+}
+
+// requestSpan is the span of r, nil when it isn't traced
+func requestSpan(r *http.Request) *tracing.Span {
+	return tracing.FromContext(r.Context())
+}
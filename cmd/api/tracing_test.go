@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+type spanRecorder struct {
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(span *tracing.Span) {
+	r.spans = append(r.spans, span)
+}
+
+func TestTraceRequest(t *testing.T) {
+	app := newTestApplication(t)
+	exporter := &spanRecorder{}
+	app.tracer = tracing.New(exporter, 1)
+
+	movie := insertTestMovie(t, app, "Moana", 2016)
+	h := app.routes()
+
+	rr := serve(t, h, http.MethodGet, fmt.Sprintf("/v1/movies/%d", movie.ID), nil, map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d", rr.Code)
+	}
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(exporter.spans))
+	}
+	span := exporter.spans[0]
+
+	if span.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("span isn't in the caller's trace: %s under %s", span.TraceID, span.Parent)
+	}
+	if span.Name != "GET /v1/movies/{id}" || span.Attributes["http.response.status_code"] != http.StatusOK {
+		t.Errorf("got span %s with %v", span.Name, span.Attributes)
+	}
+	if got := rr.Header().Get("traceresponse"); got != span.Traceparent() {
+		t.Errorf("got traceresponse %q, want %q", got, span.Traceparent())
+	}
+
+	// A new trace for requests without one, and a client error doesn't fail the span
+	rr = serve(t, h, http.MethodGet, "/v1/movies/999999", nil, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("got status %d", rr.Code)
+	}
+
+	span = exporter.spans[len(exporter.spans)-1]
+	if !span.Parent.IsZero() || span.TraceID.String() == "4bf92f3577b34da6a3ce929d0e0e4736" || span.Error != "" {
+		t.Errorf("got span %+v", span)
+	}
+	if !strings.HasPrefix(rr.Header().Get("traceresponse"), "00-"+span.TraceID.String()) {
+		t.Errorf("got traceresponse %q", rr.Header().Get("traceresponse"))
+	}
+}
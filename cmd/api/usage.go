@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+// recordUsage rolls requests made with an API key up into the metrics
+// the usage endpoint reports, route is the registered pattern
+func (app *application) recordUsage(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		// Nothing to count without a key, the status has to be read after
+		// next because authenticateAPIKey is outside of us
+		key := app.contextGetAPIKey(r)
+		if key == nil {
+			return
+		}
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		app.metrics.Record(key.ID, route, status, time.Now())
+	})
+}
+
+// showAPIKeyUsageHandler lets a client see the usage of the key it sends,
+// other keys' usage is a 404 like keys that don't exist
+func (app *application) showAPIKeyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	key := app.contextGetAPIKey(r)
+	if key == nil {
+		app.apiKeyRequiredResponse(w, r)
+		return
+	}
+
+	id, err := app.readIDParams(r)
+	if err != nil || id != key.ID {
+		app.notFoundError(w, r)
+		return
+	}
+
+	app.writeAPIKeyUsage(w, r, id)
+}
+
+// adminAPIKeyUsageHandler is the same for any key, on the admin port
+func (app *application) adminAPIKeyUsageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParams(r)
+	if err != nil {
+		app.notFoundError(w, r)
+		return
+	}
+
+	app.writeAPIKeyUsage(w, r, id)
+}
+
+func (app *application) writeAPIKeyUsage(w http.ResponseWriter, r *http.Request, id int64) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	days := app.readInt(qs, "days", 30, v)
+	top := app.readInt(qs, "top", 10, v)
+
+	v.Check(days > 0, "days", "must be greater than zero")
+	v.Check(days <= 90, "days", "must be a maximum of 90")
+	v.Check(top > 0, "top", "must be greater than zero")
+	v.Check(top <= 50, "top", "must be a maximum of 50")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	usage, err := app.models.Metrics.Usage(id, days, top)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"usage": usage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// apiVersion is a set of handlers mounted under a common prefix.
+// When a version gets deprecated we fill in the dates and every response
+// from it carries Deprecation/Sunset headers, so clients have time to move
+type apiVersion struct {
+	prefix     string
+	deprecated time.Time // zero value means the version isn't deprecated
+	sunset     time.Time // date after which the version may stop working
+	successor  string    // prefix of the version clients should migrate to
+	routes     func(v *versionRouter)
+}
+
+type versionRouter struct {
+	app     *application
+	mux     *http.ServeMux
+	version apiVersion
+	names   map[string]string
+	list    *[]*versionRoute
+}
+
+// path uses ServeMux wildcards, e.g. /movies/{id}
+func (v *versionRouter) HandlerFunc(method, path string, handler http.HandlerFunc) *versionRoute {
+	route := &versionRoute{router: v, method: method, path: v.version.prefix + path, handler: handlerName(handler)}
+
+	var h http.Handler = v.deprecate(handler)
+	if !v.version.deprecated.IsZero() {
+		route.middleware = []string{"deprecate"}
+	}
+
+	if v.app.config.db.rls && v.app.tenantScoped(method, path) && !streamingRoutes[method+" "+path] {
+		h = v.app.tenantTx(h)
+		route.middleware = append([]string{"tenantTx"}, route.middleware...)
+	}
+
+	if schema, ok := v.app.schemas[method+" "+path]; ok {
+		h = v.app.validateSchema(schema, h)
+		route.middleware = append([]string{"validateSchema"}, route.middleware...)
+	}
+
+	if len(v.app.config.experiments) > 0 && v.app.tenantScoped(method, path) {
+		h = v.app.assignExperiments(h)
+		route.middleware = append([]string{"assignExperiments"}, route.middleware...)
+	}
+
+	// Inside the rate limiter, requests it turns away don't use up the quota
+	if v.app.quotas != nil && v.app.apiKeyUsed(method, path) {
+		h = v.app.enforceQuota(h)
+		route.middleware = append([]string{"enforceQuota"}, route.middleware...)
+	}
+
+	if policy, ok := v.app.rateLimitPolicy(method, path); ok {
+		h = v.app.rateLimit(policy, h)
+		route.middleware = append([]string{"rateLimit(" + policy + ")"}, route.middleware...)
+	}
+
+	// Outside the rate limiter and quota, what they turn away counts as errors
+	if v.app.apiKeyUsed(method, path) {
+		h = v.app.recordUsage(route.method+" "+route.path, h)
+		route.middleware = append([]string{"recordUsage"}, route.middleware...)
+	}
+
+	// Outside the rate limiter, so clients with a key are limited by key
+	if scope, ok := v.app.apiKeyScope(method, path); ok {
+		h = v.app.authenticateAPIKey(scope, h)
+		route.middleware = append([]string{"authenticateAPIKey(" + scope + ")"}, route.middleware...)
+	}
+
+	// Outermost, API keys and limits are per tenant
+	if v.app.tenantScoped(method, path) {
+		h = v.app.resolveTenant(h)
+		route.middleware = append([]string{"resolveTenant"}, route.middleware...)
+	}
+
+	// Around everything, waiting for the tenant or the rate limiter counts too
+	if v.app.requestTimeoutApplies(method, path) {
+		h = v.app.requestTimeout(h)
+		route.middleware = append([]string{"requestTimeout"}, route.middleware...)
+	}
+
+	// Queued requests don't hold a connection yet, so this goes first
+	if v.app.loadSheddingApplies(method, path) {
+		h = v.app.shedLoad(h)
+		route.middleware = append([]string{"shedLoad"}, route.middleware...)
+	}
+
+	// Outside everything else, time spent queueing shows up in the trace
+	if v.app.tracer != nil {
+		h = v.app.traceRequest(route.method+" "+route.path, h)
+		route.middleware = append([]string{"traceRequest"}, route.middleware...)
+	}
+
+	v.mux.Handle(method+" "+v.version.prefix+path, h)
+	*v.list = append(*v.list, route)
+
+	return route
+}
+
+type versionRoute struct {
+	router     *versionRouter
+	method     string
+	path       string
+	name       string
+	handler    string
+	middleware []string // only the ones added for this route, outermost first
+}
+
+// Name makes the route available to app.url, so links in responses are
+// generated from the registered paths instead of being hardcoded.
+// Names are shared between versions and have to be unique
+func (rt *versionRoute) Name(name string) {
+	if _, exists := rt.router.names[name]; exists {
+		panic("duplicate route name " + name)
+	}
+	rt.router.names[name] = rt.path
+	rt.name = name
+}
+
+func (v *versionRouter) deprecate(next http.Handler) http.Handler {
+	if v.version.deprecated.IsZero() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Deprecation header uses the structured field date format (RFC 9745)
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", v.version.deprecated.Unix()))
+
+		if !v.version.sunset.IsZero() {
+			w.Header().Set("Sunset", v.version.sunset.UTC().Format(http.TimeFormat))
+		}
+
+		if v.version.successor != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, v.version.successor))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) mountVersions(mux *http.ServeMux, versions ...apiVersion) {
+	app.routeNames = make(map[string]string)
+	app.routeList = nil
+
+	for _, version := range versions {
+		version.routes(&versionRouter{app: app, mux: mux, version: version, names: app.routeNames, list: &app.routeList})
+	}
+}
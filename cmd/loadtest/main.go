@@ -0,0 +1,357 @@
+// Command loadtest sends requests to a running API for a while and
+// reports throughput and latency percentiles:
+//
+//	go run ./cmd/loadtest -url http://localhost:4000/v1/movies -c 50 -d 30s
+//
+// -out writes the report as JSON. Keep one from before a change and pass
+// it as -baseline afterwards, the run fails when p50, p99 or RPS got
+// worse by more than -tolerance. Only compare runs made on the same
+// machine against the same data, -seed adds the same movies to an empty
+// catalog before every run
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return fmt.Errorf("header %q must be Name: value", s)
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+type config struct {
+	url         string
+	method      string
+	body        string
+	headers     headerFlags
+	concurrency int
+	duration    time.Duration
+	rate        int
+	timeout     time.Duration
+	seed        int
+	out         string
+	baseline    string
+	tolerance   float64
+}
+
+// report is what a run found, and what -out writes and -baseline reads
+type report struct {
+	URL       string         `json:"url"`
+	Requests  int            `json:"requests"`
+	Errors    int            `json:"errors"` // failed requests and 5xx responses
+	Statuses  map[string]int `json:"statuses"`
+	Duration  float64        `json:"duration_s"`
+	RPS       float64        `json:"rps"`
+	P50       float64        `json:"p50_ms"`
+	P90       float64        `json:"p90_ms"`
+	P99       float64        `json:"p99_ms"`
+	Max       float64        `json:"max_ms"`
+	StartedAt time.Time      `json:"started_at"`
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.url, "url", "http://localhost:4000/v1/movies", "URL to send requests to")
+	flag.StringVar(&cfg.method, "method", http.MethodGet, "HTTP method")
+	flag.StringVar(&cfg.body, "body", "", "Request body, @file reads it from a file")
+	flag.Var(&cfg.headers, "H", "Request header as Name: value, can be repeated")
+	flag.IntVar(&cfg.concurrency, "c", 10, "Requests in flight at once")
+	flag.DurationVar(&cfg.duration, "d", 10*time.Second, "How long to send requests for")
+	flag.IntVar(&cfg.rate, "rate", 0, "Requests per second across all workers, 0 sends as fast as the server answers")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "How long one request may take")
+	flag.IntVar(&cfg.seed, "seed", 0, "Movies to create through POST /v1/movies before the run")
+	flag.StringVar(&cfg.out, "out", "", "Write the report as JSON to this file")
+	flag.StringVar(&cfg.baseline, "baseline", "", "Report of an earlier run to compare against")
+	flag.Float64Var(&cfg.tolerance, "tolerance", 0.1, "How much worse than -baseline a run may get, 0.1 is 10%")
+	flag.Parse()
+
+	err := run(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config) error {
+	if cfg.concurrency < 1 {
+		return fmt.Errorf("-c must be at least 1")
+	}
+
+	body := []byte(cfg.body)
+	if path, ok := strings.CutPrefix(cfg.body, "@"); ok {
+		var err error
+
+		body, err = os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	header := make(http.Header)
+	for _, h := range cfg.headers {
+		name, value, _ := strings.Cut(h, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	// One connection per worker, the default of 2 idle connections per
+	// host would have most workers dialing all the time
+	client := &http.Client{
+		Timeout: cfg.timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: cfg.concurrency,
+		},
+	}
+
+	// A request that can't be built fails here and not once per worker
+	_, err := http.NewRequest(cfg.method, cfg.url, nil)
+	if err != nil {
+		return err
+	}
+
+	if cfg.seed > 0 {
+		err = seed(client, cfg.url, header, cfg.seed)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+
+	// With -rate workers take a tick each before they send
+	var ticks <-chan time.Time
+	if cfg.rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(cfg.rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		statuses  = make(map[string]int)
+		errors    int
+		wg        sync.WaitGroup
+	)
+
+	rep := report{URL: cfg.url, StartedAt: time.Now().UTC()}
+	start := time.Now()
+
+	for range cfg.concurrency {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				status, latency, err := send(client, cfg.method, cfg.url, header, body)
+
+				mu.Lock()
+				latencies = append(latencies, latency)
+				switch {
+				case err != nil:
+					errors++
+					statuses["error"]++
+				default:
+					statuses[fmt.Sprint(status)]++
+					if status >= 500 {
+						errors++
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	slices.Sort(latencies)
+
+	rep.Requests = len(latencies)
+	rep.Errors = errors
+	rep.Statuses = statuses
+	rep.Duration = elapsed.Seconds()
+	rep.RPS = float64(len(latencies)) / elapsed.Seconds()
+	rep.P50 = percentile(latencies, 0.50)
+	rep.P90 = percentile(latencies, 0.90)
+	rep.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		rep.Max = milliseconds(latencies[len(latencies)-1])
+	}
+
+	printReport(rep)
+
+	if cfg.out != "" {
+		js, err := json.MarshalIndent(rep, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(cfg.out, append(js, '\n'), 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.baseline != "" {
+		return compare(rep, cfg.baseline, cfg.tolerance)
+	}
+
+	return nil
+}
+
+func send(client *http.Client, method, url string, header http.Header, body []byte) (int, time.Duration, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header = header.Clone()
+
+	start := time.Now()
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+
+	// Read to the end, or the connection can't be reused
+	_, err = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	return res.StatusCode, time.Since(start), err
+}
+
+// seed creates n movies on the server rawURL points at, with the same
+// headers as the run so an API key in -H works for it too
+func seed(client *http.Client, rawURL string, header http.Header, n int) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	u.Path, u.RawQuery = "/v1/movies", ""
+
+	header = header.Clone()
+	header.Set("Content-Type", "application/json")
+
+	genres := []string{"action", "comedy", "drama", "animation", "thriller"}
+
+	for i := range n {
+		body := fmt.Sprintf(`{"title":"Load Test Movie %d","year":%d,"runtime":"%d mins","genres":[%q,%q]}`,
+			i, 1950+i%70, 80+i%60, genres[i%len(genres)], genres[(i+1)%len(genres)])
+
+		status, _, err := send(client, http.MethodPost, u.String(), header, []byte(body))
+		if err != nil {
+			return err
+		}
+		if status != http.StatusCreated {
+			return fmt.Errorf("seeding movie %d: got status %d", i, status)
+		}
+	}
+
+	return nil
+}
+
+// percentile expects sorted latencies
+func percentile(latencies []time.Duration, p float64) float64 {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	i := int(float64(len(latencies)-1) * p)
+	return milliseconds(latencies[i])
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func printReport(rep report) {
+	fmt.Printf("%d requests in %.1fs, %.1f req/s, %d errors\n", rep.Requests, rep.Duration, rep.RPS, rep.Errors)
+	fmt.Printf("latency p50 %.2fms  p90 %.2fms  p99 %.2fms  max %.2fms\n", rep.P50, rep.P90, rep.P99, rep.Max)
+
+	codes := make([]string, 0, len(rep.Statuses))
+	for code := range rep.Statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	for _, code := range codes {
+		fmt.Printf("  %s: %d\n", code, rep.Statuses[code])
+	}
+}
+
+// compare fails when rep is worse than the baseline by more than tolerance
+func compare(rep report, path string, tolerance float64) error {
+	js, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var base report
+
+	err = json.Unmarshal(js, &base)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var regressions []string
+
+	check := func(name string, got, was float64, higherIsWorse bool) {
+		if was == 0 {
+			return
+		}
+
+		change := (got - was) / was
+		if !higherIsWorse {
+			change = -change
+		}
+
+		fmt.Printf("%s: %.2f, baseline %.2f (%+.1f%%)\n", name, got, was, (got-was)/was*100)
+
+		if change > tolerance {
+			regressions = append(regressions, name)
+		}
+	}
+
+	check("rps", rep.RPS, base.RPS, false)
+	check("p50_ms", rep.P50, base.P50, true)
+	check("p99_ms", rep.P99, base.P99, true)
+
+	if len(regressions) > 0 {
+		return fmt.Errorf("worse than %s by more than %.0f%%: %s", path, tolerance*100, strings.Join(regressions, ", "))
+	}
+
+	return nil
+}
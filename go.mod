@@ -2,6 +2,7 @@ module greenlight.brainwhat
 
 go 1.23.6
 
-require github.com/julienschmidt/httprouter v1.3.0
-
-require github.com/lib/pq v1.10.9
+require (
+	github.com/lib/pq v1.10.9
+	golang.org/x/text v0.25.0
+)
@@ -0,0 +1,105 @@
+// Package availability asks an external availability API where a movie
+// can be watched. We don't keep ids of the API's catalog, movies are
+// looked up by title and year:
+//
+//	GET <url>/availability?title=Alien&year=1979
+//	Authorization: Bearer <token>
+//
+//	200 {"offers": [
+//	  {"provider": "netflix", "country": "US", "type": "stream", "url": "https://..."},
+//	  {"provider": "apple-tv", "country": "DE", "type": "rent", "url": "https://..."}
+//	]}
+//
+// 404 means the API doesn't know the movie, it's treated as no offers.
+// Types are stream, rent and buy, offers with other types are dropped
+package availability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OfferTypes are the ways a provider can offer a movie
+var OfferTypes = []string{"stream", "rent", "buy"}
+
+type Offer struct {
+	Provider string `json:"provider"`
+	Country  string `json:"country"`
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+}
+
+type Client struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func New(rawURL, token string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid availability API url %q, must look like https://host/path", rawURL)
+	}
+
+	return &Client{
+		url:    strings.TrimSuffix(rawURL, "/"),
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Lookup returns every offer of the movie in every country
+func (c *Client) Lookup(ctx context.Context, title string, year int32) ([]Offer, error) {
+	qs := url.Values{"title": {title}, "year": {strconv.Itoa(int(year))}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/availability?"+qs.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return []Offer{}, nil
+	default:
+		return nil, fmt.Errorf("availability: looking up %q: %s", title, res.Status)
+	}
+
+	var response struct {
+		Offers []Offer `json:"offers"`
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("availability: looking up %q: %w", title, err)
+	}
+
+	offers := slices.DeleteFunc(response.Offers, func(o Offer) bool {
+		return o.Provider == "" || o.Country == "" || !slices.Contains(OfferTypes, o.Type)
+	})
+
+	return offers, nil
+}
@@ -0,0 +1,126 @@
+// Package breaker stops calling the database once it looks down.
+// After Threshold connection failures in a row the circuit opens and every
+// call fails right away with ErrOpen. After Cooldown one call is let through
+// as a probe: if it works the circuit closes, otherwise it stays open
+package breaker
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	probing  bool
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow returns ErrOpen if the call shouldn't be made. Every allowed call
+// has to be followed by Record
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return ErrOpen
+	}
+
+	b.probing = true
+	return nil
+}
+
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !isConnectionError(err) {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// RecordContext is Record for a call made with ctx. A call that ran out
+// of its own time, a plain context.WithTimeout, failed because the database
+// was too slow and counts. A call whose caller gave up doesn't, that says
+// nothing about the database: ctx was cancelled, e.g. the client went away,
+// or its deadline came with a cause, context.WithDeadlineCause, which is
+// how callers mark a deadline that's someone else's, e.g. a client's short
+// X-Request-Timeout
+func (b *Breaker) RecordContext(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		if context.Cause(ctx) == context.DeadlineExceeded {
+			// Whatever the driver made of it, lib/pq says "canceling statement"
+			b.Record(context.DeadlineExceeded)
+			return
+		}
+
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+		return
+	}
+
+	b.Record(err)
+}
+
+// Open reports whether the circuit is open. It stays open
+// until a probe succeeds, even after the cooldown
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !b.openedAt.IsZero()
+}
+
+// RetryAfter is how long until the next probe is allowed
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0
+	}
+
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
+
+// Only failures to reach the database count. A unique violation or a
+// missing row means postgres is doing fine. A deadline counts only when
+// it's the call's own, see RecordContext
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
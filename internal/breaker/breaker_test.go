@@ -0,0 +1,100 @@
+package breaker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// expired returns a context that ran out of time on its own, like a
+// query's timeout
+func expired(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	t.Cleanup(cancel)
+
+	<-ctx.Done()
+	return ctx
+}
+
+func TestRecordContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	requestDeadline, cancel := context.WithTimeoutCause(context.Background(), -time.Second, errors.New("request timeout"))
+	defer cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		wantOpen bool
+	}{
+		{name: "database timeout", ctx: expired(t), err: context.DeadlineExceeded, wantOpen: true},
+		{name: "database timeout the driver reports its own way", ctx: expired(t), err: errors.New("pq: canceling statement due to user request"), wantOpen: true},
+		{name: "connection refused", ctx: context.Background(), err: driver.ErrBadConn, wantOpen: true},
+		{name: "request deadline", ctx: requestDeadline, err: context.DeadlineExceeded},
+		{name: "client gone", ctx: canceled, err: context.Canceled},
+		{name: "client gone during a failing query", ctx: canceled, err: errors.New("pq: canceling statement due to user request")},
+		{name: "other errors", ctx: context.Background(), err: errors.New("duplicate key")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(2, time.Minute)
+
+			for range 2 {
+				if err := b.Allow(); err != nil {
+					t.Fatal(err)
+				}
+				b.RecordContext(tt.ctx, tt.err)
+			}
+
+			if b.Open() != tt.wantOpen {
+				t.Errorf("got open %t, want %t", b.Open(), tt.wantOpen)
+			}
+		})
+	}
+}
+
+// hungConn is a database that never answers, queries wait for their
+// context like lib/pq does
+type hungConn struct{}
+
+func (c hungConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c hungConn) Driver() driver.Driver                        { return nil }
+func (c hungConn) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (c hungConn) Close() error                                 { return nil }
+func (c hungConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c hungConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, errors.New("pq: canceling statement due to user request")
+}
+
+func TestHungDatabase(t *testing.T) {
+	b := New(3, time.Minute)
+	db := sql.OpenDB(Wrap(hungConn{}, b))
+	defer db.Close()
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := db.QueryContext(ctx, "SELECT 1")
+		cancel()
+
+		if err == nil {
+			t.Fatal("want an error")
+		}
+	}
+
+	if !b.Open() {
+		t.Fatal("queries timing out didn't open the circuit")
+	}
+
+	_, err := db.QueryContext(context.Background(), "SELECT 1")
+	if !errors.Is(err, ErrOpen) {
+		t.Errorf("got %v, want ErrOpen", err)
+	}
+}
@@ -0,0 +1,126 @@
+package breaker
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// Wrap puts b in front of every connection and query made through c
+func Wrap(c driver.Connector, b *Breaker) driver.Connector {
+	return &connector{Connector: c, breaker: b}
+}
+
+type connector struct {
+	driver.Connector
+	breaker *Breaker
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	cn, err := c.Connector.Connect(ctx)
+	c.breaker.RecordContext(ctx, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: cn, breaker: c.breaker}, nil
+}
+
+type conn struct {
+	driver.Conn
+	breaker *Breaker
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := queryer.QueryContext(ctx, query, args)
+	c.breaker.RecordContext(ctx, err)
+
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := execer.ExecContext(ctx, query, args)
+	c.breaker.RecordContext(ctx, err)
+
+	return result, err
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	var tx driver.Tx
+
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	c.breaker.RecordContext(ctx, err)
+
+	return tx, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	pinger, ok := c.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+
+	err := c.breaker.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = pinger.Ping(ctx)
+	c.breaker.RecordContext(ctx, err)
+
+	return err
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
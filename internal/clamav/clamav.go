@@ -0,0 +1,103 @@
+// Package clamav scans data with a running clamd over its INSTREAM command
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// InfectedError is returned when clamd found something
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return "clamav: infected with " + e.Signature
+}
+
+type Client struct {
+	Network string // unix or tcp
+	Address string // e.g. /var/run/clamav/clamd.ctl or 127.0.0.1:3310
+	Timeout time.Duration
+}
+
+// New takes unix:///path/to/clamd.ctl or tcp://host:3310
+func New(rawAddr string, timeout time.Duration) (*Client, error) {
+	network, address, ok := strings.Cut(rawAddr, "://")
+	if !ok || (network != "unix" && network != "tcp") || address == "" {
+		return nil, fmt.Errorf("invalid clamd address %q, must look like unix:///path or tcp://host:3310", rawAddr)
+	}
+
+	return &Client{Network: network, Address: address, Timeout: timeout}, nil
+}
+
+// chunkSize stays well below clamd's default StreamMaxLength chunk limits
+const chunkSize = 64 << 10
+
+// Scan returns nil for clean data and *InfectedError when clamd found
+// something. Any other error means the data wasn't scanned
+func (c *Client) Scan(ctx context.Context, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// The z prefix means commands and replies end with a NUL byte
+	_, err = io.WriteString(conn, "zINSTREAM\x00")
+	if err != nil {
+		return err
+	}
+
+	size := make([]byte, 4)
+
+	for len(data) > 0 {
+		chunk := data[:min(chunkSize, len(data))]
+		data = data[len(chunk):]
+
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+
+		_, err = conn.Write(append(size, chunk...))
+		if err != nil {
+			return err
+		}
+	}
+
+	// A zero length chunk ends the stream
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return err
+	}
+
+	// The reply ends with NUL, no need to wait for clamd to hang up
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return err
+	}
+
+	// stream: OK, stream: <signature> FOUND or <message> ERROR
+	result := strings.TrimPrefix(strings.TrimRight(reply, "\x00\n"), "stream: ")
+
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &InfectedError{Signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamav: %s", result)
+	}
+}
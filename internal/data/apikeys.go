@@ -0,0 +1,234 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Scopes an API key can have. GET routes need read, other methods write
+// and the /admin routes admin
+var APIKeyScopes = []string{"read", "write", "admin"}
+
+// APIKey lets a backend integration call the API without a user.
+// A key only works for its own tenant. Plaintext is only set right after Insert
+type APIKey struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	TenantID  int64     `json:"tenant_id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	RateLimit int       `json:"rate_limit"` // requests per minute, 0 uses the route limits
+	Plan      string    `json:"plan"`       // one of APIKeyPlans
+	Plaintext string    `json:"key,omitempty"`
+	Hash      []byte    `json:"-"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "cannot be empty")
+	v.Check(len(key.Name) <= 500, "name", "must not be more than 500 characters")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range key.Scopes {
+		v.Check(validator.PermittedValue(scope, APIKeyScopes...), "scopes", "must only contain read, write or admin")
+	}
+
+	v.Check(key.RateLimit >= 0, "rate_limit", "must not be negative")
+	v.Check(key.RateLimit <= 100_000, "rate_limit", "must be a maximum of 100000")
+
+	ValidateAPIKeyPlan(v, key.Plan)
+}
+
+func ValidateAPIKeyPlan(v *validator.Validator, plan string) {
+	_, ok := APIKeyPlans[plan]
+	v.Check(ok, "plan", "must be free or pro")
+}
+
+// generateAPIKey fills in Plaintext and Hash. The prefix makes leaked
+// keys easy to spot for secret scanners
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = "glk_" + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Hash = hashAPIKey(key.Plaintext)
+
+	return nil
+}
+
+func hashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO api_keys (name, hash, scopes, rate_limit, plan, tenant_id)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, key.Name, key.Hash, pq.Array(key.Scopes), key.RateLimit, key.Plan, key.TenantID).Scan(
+		&key.ID,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "violates foreign key constraint"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetByPlaintext looks a key up by what the client sent. Hashes are unique
+// across tenants, it's up to the caller to check TenantID
+func (m APIKeyModel) GetByPlaintext(plaintext string) (*APIKey, error) {
+	query := `SELECT id, created_at, tenant_id, name, scopes, rate_limit, plan
+	FROM api_keys
+	WHERE hash = $1`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, hashAPIKey(plaintext)).Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.TenantID,
+			&key.Name,
+			pq.Array(&key.Scopes),
+			&key.RateLimit,
+			&key.Plan,
+		)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAll() ([]*APIKey, error) {
+	query := `SELECT id, created_at, tenant_id, name, scopes, rate_limit, plan
+	FROM api_keys
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.TenantID,
+			&key.Name,
+			pq.Array(&key.Scopes),
+			&key.RateLimit,
+			&key.Plan,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// UpdatePlan moves a key to another plan. The quotas of the new plan
+// apply from the key's next request, usage so far still counts
+func (m APIKeyModel) UpdatePlan(id int64, plan string) error {
+	query := `UPDATE api_keys SET plan = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, plan, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete revokes the key right away
+func (m APIKeyModel) Delete(id int64) error {
+	query := `DELETE FROM api_keys WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
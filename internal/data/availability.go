@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// Availability is one way to watch a movie, e.g. streaming on netflix in US
+type Availability struct {
+	Provider string `json:"provider"`
+	Country  string `json:"country"`
+	Type     string `json:"type"` // stream, rent or buy
+	URL      string `json:"url,omitempty"`
+}
+
+type AvailabilityModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+// GetForMovies loads the availability of many movies in one query, like
+// CollectionModel.GetForMovies. Movies nobody offers are missing from the map
+func (m AvailabilityModel) GetForMovies(movieIDs []int64) (map[int64][]*Availability, error) {
+	query := `SELECT a.movie_id, a.provider, a.country, a.type, a.url
+	FROM movie_availability a
+	JOIN movies ON movies.id = a.movie_id
+	WHERE a.movie_id = ANY($1) AND movies.tenant_id = $2
+	ORDER BY a.movie_id, a.country, a.provider, a.type`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, pq.Array(movieIDs), m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	availability := make(map[int64][]*Availability)
+
+	for rows.Next() {
+		var movieID int64
+		var a Availability
+
+		err := rows.Scan(&movieID, &a.Provider, &a.Country, &a.Type, &a.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		availability[movieID] = append(availability[movieID], &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return availability, nil
+}
+
+// SyncTarget is what the availability API needs to find a movie
+type SyncTarget struct {
+	MovieID int64
+	Title   string
+	Year    int32
+}
+
+// Stale returns the movies whose availability wasn't synced for longer
+// than maxAge, never synced ones first. It's for the sync job and looks
+// at every tenant
+func (m AvailabilityModel) Stale(ctx context.Context, maxAge time.Duration, limit int) ([]*SyncTarget, error) {
+	query := `SELECT id, title, year
+	FROM movies
+	WHERE deleted_at IS NULL
+	AND (availability_synced_at IS NULL OR availability_synced_at < NOW() - $1 * interval '1 second')
+	ORDER BY availability_synced_at NULLS FIRST, id
+	LIMIT $2`
+
+	rows, err := queryWithRetry(ctx, m.DB, query, maxAge.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []*SyncTarget{}
+
+	for rows.Next() {
+		var target SyncTarget
+
+		err := rows.Scan(&target.MovieID, &target.Title, &target.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, &target)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// Replace swaps the movie's availability for what the API returned and
+// marks it synced. Like Stale it ignores the tenant, movie ids are unique
+func (m AvailabilityModel) Replace(ctx context.Context, movieID int64, availability []*Availability) error {
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_availability WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	var providers, countries, types, urls []string
+
+	// The API may list an offer twice, one INSERT can't conflict with itself
+	seen := make(map[Availability]bool)
+
+	for _, a := range availability {
+		key := Availability{Provider: a.Provider, Country: a.Country, Type: a.Type}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		providers = append(providers, a.Provider)
+		countries = append(countries, a.Country)
+		types = append(types, a.Type)
+		urls = append(urls, a.URL)
+	}
+
+	stmt := `INSERT INTO movie_availability (movie_id, provider, country, type, url)
+	SELECT $1, a.provider, a.country, a.type, a.url
+	FROM unnest($2::text[], $3::text[], $4::text[], $5::text[]) AS a(provider, country, type, url)`
+
+	if len(availability) > 0 {
+		_, err = tx.ExecContext(ctx, stmt, movieID, pq.Array(providers), pq.Array(countries), pq.Array(types), pq.Array(urls))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET availability_synced_at = NOW() WHERE id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,282 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const backupFormat = "greenlight-backup/1"
+
+// Tables in a backup, in an order their foreign keys can be restored in.
+// jobs, outbox_events and operations are left out, they are about work
+// in flight and not about the catalog
+// tenants has to stay first, see Restore
+var backupTables = []string{
+	"tenants",
+	"movies",
+	"movie_redirects",
+	"movie_revisions",
+	"movie_undo_tokens",
+	"genres",
+	"movies_genres",
+	"collections",
+	"collections_movies",
+	"movie_stats",
+	"movie_releases",
+	"movie_availability",
+	"api_keys",
+	"api_key_usage",
+	"api_key_metrics",
+	"genre_vocabulary",
+}
+
+// Tables with a bigserial id, their sequences are moved past the restored ids
+var backupSequenceTables = []string{"tenants", "movies", "movie_revisions", "genres", "collections", "api_keys"}
+
+// Rows inserted per statement while restoring
+const restoreBatchSize = 500
+
+// ErrBackupMismatch means a backup can't be restored into this database
+var ErrBackupMismatch = errors.New("backup doesn't match the database")
+
+// BackupHeader is the first line of a backup. Every other line is one
+// row: ["table", {"column": value, ...}]
+type BackupHeader struct {
+	Format        string    `json:"format"`
+	SchemaVersion int64     `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Rows          int64     `json:"rows"`
+}
+
+// Backup writes every row of the backup tables to w. It reads in one
+// repeatable read transaction, so the tables agree with each other even
+// while the API keeps writing. progress gets the rows written so far
+func Backup(ctx context.Context, db *sql.DB, w io.Writer, progress func(done, total int64)) (*BackupHeader, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	header := &BackupHeader{Format: backupFormat, CreatedAt: time.Now().UTC()}
+
+	header.SchemaVersion, err = schemaVersion(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range backupTables {
+		var count int64
+
+		err = tx.QueryRowContext(ctx, `SELECT count(*) FROM `+table).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+
+		header.Rows += count
+	}
+
+	enc := json.NewEncoder(w)
+
+	err = enc.Encode(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var done int64
+
+	for _, table := range backupTables {
+		rows, err := tx.QueryContext(ctx, `SELECT row_to_json(t) FROM `+table+` t`)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var row json.RawMessage
+
+			err = rows.Scan(&row)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			err = enc.Encode([]any{table, row})
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			done++
+			progress(done, header.Rows)
+		}
+
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return header, nil
+}
+
+// Restore replaces the backup tables with what Backup wrote to r. It all
+// happens in one transaction, a restore that fails halfway leaves the
+// database as it was. The backup has to be from the same schema version
+func Restore(ctx context.Context, db *sql.DB, r io.Reader, progress func(done, total int64)) (*BackupHeader, error) {
+	dec := json.NewDecoder(r)
+
+	var header BackupHeader
+
+	err := dec.Decode(&header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrBackupMismatch, err)
+	}
+	if header.Format != backupFormat {
+		return nil, fmt.Errorf("%w: unknown format %q", ErrBackupMismatch, header.Format)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	version, err := schemaVersion(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if version != header.SchemaVersion {
+		return nil, fmt.Errorf("%w: backup is from schema version %d, the database is at %d", ErrBackupMismatch, header.SchemaVersion, version)
+	}
+
+	tables := make(map[string]bool, len(backupTables))
+	for _, table := range backupTables {
+		tables[table] = true
+	}
+
+	// operations references tenants too. A TRUNCATE of tenants would lock
+	// it, and with it the progress updates of this very restore. DELETE
+	// only locks the rows, the tenants' operations go with them
+	_, err = tx.ExecContext(ctx, `TRUNCATE `+strings.Join(backupTables[1:], ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tenants`)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generated columns like movies.normalized_title are in the backup
+	// but can't be inserted, postgres computes them again
+	columns := make(map[string]string, len(backupTables))
+	for _, table := range backupTables {
+		var list string
+
+		err = tx.QueryRowContext(ctx, `SELECT string_agg(quote_ident(column_name), ', ' ORDER BY ordinal_position)
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND is_generated = 'NEVER'`, table).Scan(&list)
+		if err != nil {
+			return nil, err
+		}
+
+		columns[table] = list
+	}
+
+	var batch []json.RawMessage
+	var batchTable string
+	var done int64
+
+	// json_populate_recordset turns the JSON back into rows of the table,
+	// with every column converted to its type
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		js, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM json_populate_recordset(NULL::%[1]s, $1)`, batchTable, columns[batchTable])
+
+		_, err = tx.ExecContext(ctx, query, string(js))
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", batchTable, err)
+		}
+
+		done += int64(len(batch))
+		progress(done, header.Rows)
+
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		var line []json.RawMessage
+
+		err = dec.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: row %d: %v", ErrBackupMismatch, done+int64(len(batch))+1, err)
+		}
+
+		var table string
+		if len(line) != 2 || json.Unmarshal(line[0], &table) != nil || !tables[table] {
+			return nil, fmt.Errorf("%w: row %d isn't a row of a backup table", ErrBackupMismatch, done+int64(len(batch))+1)
+		}
+
+		if table != batchTable || len(batch) == restoreBatchSize {
+			err = flush()
+			if err != nil {
+				return nil, err
+			}
+			batchTable = table
+		}
+
+		batch = append(batch, line[1])
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, err
+	}
+
+	// The restored ids came with the rows, the sequences don't know about them
+	for _, table := range backupSequenceTables {
+		query := fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s`, table)
+
+		_, err = tx.ExecContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+// schemaVersion is the last migration applied, see package migrate
+func schemaVersion(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var version int64
+
+	err := tx.QueryRowContext(ctx, `SELECT version FROM schema_migrations LIMIT 1`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return version, err
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The catalog_* materialized views of migration 000026, in the order they
+// get refreshed. catalog_stats_refreshed goes last so its timestamp only
+// moves once the others are done
+var catalogViews = []string{"catalog_facets", "catalog_revenue_by_year", "catalog_trending"}
+
+// Trending days the catalog_trending columns hold, other windows are
+// counted from movie_stats directly
+var catalogTrendingColumns = map[int]string{
+	1:  "day_views",
+	7:  "week_views",
+	30: "month_views",
+}
+
+// RefreshCatalog recomputes the catalog_* views for every tenant. Readers
+// keep seeing the old rows until each view is done
+func (m StatsModel) RefreshCatalog(ctx context.Context) error {
+	for _, view := range catalogViews {
+		_, err := m.DB.ExecContext(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY "+view)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := m.DB.ExecContext(ctx, "REFRESH MATERIALIZED VIEW catalog_stats_refreshed")
+	return err
+}
+
+// unfiltered is true when search doesn't narrow the catalog down at all,
+// the catalog_* views only hold answers for that
+func (s MovieSearch) unfiltered() bool {
+	return s.Title == "" && len(s.Genres) == 0 && s.Year == 0 &&
+		s.YearMin == 0 && s.YearMax == 0 && s.RuntimeMin == 0 && s.RuntimeMax == 0 &&
+		s.Country == "" && s.Certification == "" && s.Currency == ""
+}
+
+func catalogRefreshedAt(ctx context.Context, db dbtx) (time.Time, error) {
+	var refreshedAt time.Time
+
+	err := db.QueryRowContext(ctx, `SELECT refreshed_at FROM catalog_stats_refreshed`).Scan(&refreshedAt)
+	return refreshedAt, err
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Ratings of the certification systems we know, by ISO 3166 country code.
+// Ratings are written the way the rating boards write them
+var CertificationSystems = map[string][]string{
+	"US": {"G", "PG", "PG-13", "R", "NC-17"},                // MPA
+	"GB": {"U", "PG", "12A", "12", "15", "18", "R18"},       // BBFC
+	"DE": {"0", "6", "12", "16", "18"},                      // FSK
+	"FR": {"TP", "12", "16", "18"},                          // CNC, TP is tous publics
+	"AU": {"G", "PG", "M", "MA15+", "R18+", "X18+"},         // ACB
+	"JP": {"G", "PG12", "R15+", "R18+"},                     // Eirin
+	"CA": {"G", "PG", "14A", "18A", "R", "A"},               // Canadian Home Video Rating System
+	"NL": {"AL", "6", "9", "12", "14", "16", "18"},          // Kijkwijzer
+	"BR": {"L", "10", "12", "14", "16", "18"},               // ClassInd
+	"KR": {"ALL", "12", "15", "18", "Restricted Screening"}, // KMRB
+}
+
+// Content warnings a movie can carry
+var ContentWarnings = []string{
+	"violence",
+	"gore",
+	"language",
+	"sexual-content",
+	"nudity",
+	"drug-use",
+	"alcohol",
+	"smoking",
+	"self-harm",
+	"suicide",
+	"frightening-scenes",
+	"discrimination",
+	"animal-harm",
+	"flashing-lights",
+}
+
+func validateCertifications(v *validator.Validator, movie *Movie) {
+	// Sorted, so the same movie always gets the same error
+	for _, country := range slices.Sorted(maps.Keys(movie.Certifications)) {
+		ratings, ok := CertificationSystems[country]
+		if !ok {
+			v.AddError("certifications", "unknown certification system "+country)
+			continue
+		}
+
+		if !validator.PermittedValue(movie.Certifications[country], ratings...) {
+			v.AddError("certifications", fmt.Sprintf("%s certification must be one of %s", country, strings.Join(ratings, ", ")))
+		}
+	}
+
+	v.Check(validator.Unique(movie.ContentWarnings), "content_warnings", "must be unique")
+	for _, warning := range movie.ContentWarnings {
+		v.Check(validator.PermittedValue(warning, ContentWarnings...), "content_warnings", "unknown content warning "+warning)
+	}
+}
+
+// ValidateCertificationSearch checks the ?certification= and ?country= filters.
+// A certification means nothing without its country
+func ValidateCertificationSearch(v *validator.Validator, search MovieSearch) {
+	if search.Country == "" {
+		v.Check(search.Certification == "", "country", "must be provided with certification")
+		return
+	}
+
+	ratings, ok := CertificationSystems[search.Country]
+	if !ok {
+		v.AddError("country", "unknown certification system "+search.Country)
+		return
+	}
+
+	if search.Certification != "" && !validator.PermittedValue(search.Certification, ratings...) {
+		v.AddError("certification", fmt.Sprintf("must be one of %s", strings.Join(ratings, ", ")))
+	}
+}
+
+// certificationsColumn moves the certifications map in and out of jsonb.
+// Movies without certifications get {} rather than null
+type certificationsColumn map[string]string
+
+func (c certificationsColumn) Value() (driver.Value, error) {
+	if c == nil {
+		return "{}", nil
+	}
+
+	js, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// jsonb args have to be passed as strings, pq would send []byte as bytea
+	return string(js), nil
+}
+
+type certificationsScanner struct {
+	dest *map[string]string
+}
+
+func (s certificationsScanner) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("certifications: unexpected %T", src)
+	}
+
+	*s.dest = nil
+
+	return json.Unmarshal(b, s.dest)
+}
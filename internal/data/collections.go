@@ -0,0 +1,216 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.brainwhat/internal/tracing"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Collection groups movies in a fixed order, e.g. a trilogy or a franchise
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Movies      []*Movie  `json:"movies"`
+	Version     int32     `json:"version"`
+}
+
+// MovieCollection is what gets embedded into a movie with ?include=collection
+type MovieCollection struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+}
+
+type CollectionModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `INSERT INTO collections (name, description, tenant_id)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	collection.Movies = []*Movie{}
+
+	return m.DB.QueryRowContext(ctx, query, collection.Name, collection.Description, m.TenantID).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Version,
+	)
+}
+
+// Get returns the collection with its movies in order
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	query := `SELECT id, created_at, name, description, version
+	FROM collections
+	WHERE id = $1 AND tenant_id = $2`
+
+	var collection Collection
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, id, m.TenantID).Scan(
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.Name,
+			&collection.Description,
+			&collection.Version,
+		)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `SELECT ` + strings.Join(movieColumns, ", ") + `
+	FROM movies
+	JOIN collections_movies cm ON cm.movie_id = movies.id
+	WHERE cm.collection_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY cm.position, movies.id`
+
+	rows, err := queryWithRetry(ctx, m.DB, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collection.Movies = []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie)
+		if err != nil {
+			return nil, err
+		}
+
+		collection.Movies = append(collection.Movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+// GetForMovies loads collections of many movies in one query, so including
+// them in a list response doesn't cost a query per movie.
+// Movies that aren't in a collection are missing from the map
+func (m CollectionModel) GetForMovies(movieIDs []int64) (map[int64]*MovieCollection, error) {
+	query := `SELECT cm.movie_id, c.id, c.name, cm.position
+	FROM collections_movies cm
+	JOIN collections c ON c.id = cm.collection_id
+	WHERE cm.movie_id = ANY($1) AND c.tenant_id = $2`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, pq.Array(movieIDs), m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := make(map[int64]*MovieCollection)
+
+	for rows.Next() {
+		var movieID int64
+		var collection MovieCollection
+
+		err := rows.Scan(&movieID, &collection.ID, &collection.Name, &collection.Position)
+		if err != nil {
+			return nil, err
+		}
+
+		collections[movieID] = &collection
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// AttachMovie puts the movie into the collection at position. Zero position
+// appends it to the end. A movie that's in another collection is moved over.
+// Both have to belong to the tenant, nothing is inserted otherwise
+func (m CollectionModel) AttachMovie(collectionID, movieID int64, position int) error {
+	query := `INSERT INTO collections_movies (collection_id, movie_id, position)
+	SELECT c.id, mv.id, CASE WHEN $3 > 0 THEN $3
+		ELSE (SELECT COALESCE(max(position), 0) + 1 FROM collections_movies WHERE collection_id = $1) END
+	FROM collections c, movies mv
+	WHERE c.id = $1 AND c.tenant_id = $4 AND mv.id = $2 AND mv.tenant_id = $4
+	ON CONFLICT (movie_id) DO UPDATE
+	SET collection_id = EXCLUDED.collection_id, position = EXCLUDED.position`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, collectionID, movieID, position, m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m CollectionModel) DetachMovie(collectionID, movieID int64) error {
+	query := `DELETE FROM collections_movies
+	WHERE collection_id = $1 AND movie_id = $2
+	AND collection_id IN (SELECT id FROM collections WHERE tenant_id = $3)`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, collectionID, movieID, m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "cannot be empty")
+	v.Check(len(collection.Name) <= 500, "name", "must not be more than 500 characters")
+	v.Check(len(collection.Description) <= 5000, "description", "must not be more than 5000 characters")
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ExplainShapes are the movie queries ExplainMovies knows, built by the
+// same code the endpoints use:
+//
+//	list                 GET /movies
+//	list_without_total   GET /movies?include_total=false
+//	facets               GET /movies?facets=genres,year_decade
+//	revenue              GET /movies/revenue
+//	random               GET /movies/random
+var ExplainShapes = []string{"list", "list_without_total", "facets", "revenue", "random"}
+
+// ExplainMovies returns the plan postgres picks for one of ExplainShapes,
+// as EXPLAIN (FORMAT JSON) gives it. There's no ANALYZE, the query is only
+// planned and never run. The plan is made in a tenant transaction that's
+// rolled back, so row-level security shows up the way it does for requests.
+// Unknown shapes panic, they have to be checked against ExplainShapes
+func ExplainMovies(ctx context.Context, db *sql.DB, m MovieModel, shape string, search MovieSearch, filters Filters) (json.RawMessage, error) {
+	var query string
+	var args []any
+
+	switch shape {
+	case "list":
+		query, args = m.listQuery(search, filters)
+	case "list_without_total":
+		filters.SkipTotal = true
+		query, args = m.listQuery(search, filters)
+	case "facets":
+		query, args = m.facetsQuery(search, []string{"genres", "year_decade"})
+	case "revenue":
+		query, args, _ = m.revenueQuery(search)
+	case "random":
+		query, args = m.randomQuery(search)
+	default:
+		panic(fmt.Sprintf("unknown explain shape %q", shape))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := BeginTenantTx(ctx, db, m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var plan []byte
+
+	err = tx.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&plan)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(plan), nil
+}
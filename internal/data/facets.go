@@ -0,0 +1,108 @@
+package data
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FacetValue is how many of the searched movies share one value,
+// e.g. 12 movies in "drama" or 40 movies from the "1990" decade
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Grouped queries behind every facet. They read from the "filtered" CTE,
+// which holds the ids and years of all movies matching the search
+var movieFacetQueries = map[string]string{
+	"genres": `SELECT 'genres', g.name, count(*) FROM filtered f
+	JOIN movies_genres mg ON mg.movie_id = f.id
+	JOIN genres g ON g.id = mg.genre_id
+	GROUP BY 2`,
+	"year_decade": `SELECT 'year_decade', ((f.year / 10) * 10)::text, count(*) FROM filtered f
+	GROUP BY 2`,
+}
+
+// Facets counts the movies matching search per value of every requested
+// facet. Counts ignore pagination, they describe the whole result set.
+// Names must come from a safelist, unknown ones panic.
+// Without any search the counts come from catalog_facets, refreshedAt says
+// how old they are. It's zero when they were counted just now
+func (m MovieModel) Facets(search MovieSearch, names []string) (map[string][]FacetValue, time.Time, error) {
+	facets := make(map[string][]FacetValue, len(names))
+
+	var unique []string
+	for _, name := range names {
+		if _, ok := movieFacetQueries[name]; !ok {
+			panic("unknown movie facet: " + name)
+		}
+		if _, seen := facets[name]; seen {
+			continue
+		}
+
+		facets[name] = []FacetValue{}
+		unique = append(unique, name)
+	}
+
+	if len(unique) == 0 {
+		return facets, time.Time{}, nil
+	}
+
+	query, args := m.facetsQuery(search, unique)
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var value FacetValue
+
+		err := rows.Scan(&name, &value.Value, &value.Count)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		facets[name] = append(facets[name], value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if !search.unfiltered() {
+		return facets, time.Time{}, nil
+	}
+
+	refreshedAt, err := catalogRefreshedAt(ctx, m.DB)
+	return facets, refreshedAt, err
+}
+
+// facetsQuery expects names to be known and unique
+func (m MovieModel) facetsQuery(search MovieSearch, names []string) (string, []any) {
+	if search.unfiltered() {
+		return `SELECT facet, value, count FROM catalog_facets
+		WHERE tenant_id = $1 AND facet = ANY($2)
+		ORDER BY 1, 3 DESC, 2`, []any{m.TenantID, pq.Array(names)}
+	}
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, movieFacetQueries[name])
+	}
+
+	filtered, args := m.applySearch(newQuery("movies", "id", "year"), search).build()
+
+	query := "WITH filtered AS (" + filtered + ")\n" +
+		strings.Join(parts, "\nUNION ALL\n") +
+		"\nORDER BY 1, 3 DESC, 2"
+
+	return query, args
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+
+	// SkipTotal leaves out the count of all matching rows, it's the most
+	// expensive part of a big list. Metadata says HasMore instead
+	SkipTotal bool
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	keys := strings.Split(f.Sort, ",")
+	columns := make(map[string]bool, len(keys))
+
+	v.Check(len(keys) <= maxSortKeys, "sort", fmt.Sprintf("must not have more than %d keys", maxSortKeys))
+
+	for _, key := range keys {
+		v.Check(validator.PermittedValue(key, f.SortSafelist...), "sort", "must be one or more of "+strings.Join(f.SortSafelist, ", ")+", separated by commas")
+
+		column := strings.TrimPrefix(key, "-")
+		v.Check(!columns[column], "sort", "must not sort by "+column+" twice")
+		columns[column] = true
+	}
+}
+
+// ?sort=-year,title,-runtime is about as far as sorting makes sense
+const maxSortKeys = 4
+
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+func (k sortKey) direction() string {
+	if k.desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// sortKeys splits Sort into its keys, -year,title is year descending and
+// then title. id is added last when it isn't there yet, so rows that tie
+// on everything else come in the same order on every page.
+// Keys are put straight into the SQL, so we check them against the safelist
+// one more time. ValidateFilters should've caught it already, so panic is fine
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	hasID := false
+
+	for _, key := range strings.Split(f.Sort, ",") {
+		if !slices.Contains(f.SortSafelist, key) {
+			panic("unsafe sort parameter: " + f.Sort)
+		}
+
+		column := strings.TrimPrefix(key, "-")
+		keys = append(keys, sortKey{column: column, desc: strings.HasPrefix(key, "-")})
+		hasID = hasID || column == "id"
+	}
+
+	if !hasID {
+		keys = append(keys, sortKey{column: "id"})
+	}
+
+	return keys
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+
+	// HasMore replaces the total and the last page with SkipTotal
+	HasMore *bool `json:"has_more,omitempty"`
+
+	// Fuzzy is set when the exact title search found nothing and
+	// the results come from the similarity fallback
+	Fuzzy bool `json:"fuzzy,omitempty"`
+}
+
+// calculatePartialMetadata is calculateMetadata for SkipTotal. The list
+// fetches one row more than fits on the page, hasMore is whether it came
+func calculatePartialMetadata(page, pageSize int, hasMore bool) Metadata {
+	return Metadata{
+		CurrentPage: page,
+		PageSize:    pageSize,
+		FirstPage:   1,
+		HasMore:     &hasMore,
+	}
+}
+
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	// Empty Metadata so that we don't show last_page: 0
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+// ISO 4217 codes budgets and revenues can be given in
+var Currencies = []string{"USD", "EUR", "GBP", "JPY", "CNY", "INR", "KRW", "CAD", "AUD", "BRL", "CHF", "SEK", "NOK", "DKK", "MXN"}
+
+func validateFinances(v *validator.Validator, movie *Movie) {
+	v.Check(movie.Budget >= 0, "budget", "must not be negative")
+	v.Check(movie.Revenue >= 0, "revenue", "must not be negative")
+
+	if movie.Budget == 0 && movie.Revenue == 0 {
+		v.Check(movie.Currency == "", "currency", "must be empty without budget or revenue")
+		return
+	}
+
+	v.Check(movie.Currency != "", "currency", "must be provided with budget or revenue")
+	v.Check(movie.Currency == "" || validator.PermittedValue(movie.Currency, Currencies...), "currency", "must be one of "+strings.Join(Currencies, ", "))
+}
+
+// ValidateCurrency checks the ?currency= filter
+func ValidateCurrency(v *validator.Validator, currency string) {
+	v.Check(currency == "" || validator.PermittedValue(currency, Currencies...), "currency", "must be one of "+strings.Join(Currencies, ", "))
+}
+
+// YearRevenue is the revenue of all movies of one year in one currency.
+// Currencies are never converted, a year can show up once per currency
+type YearRevenue struct {
+	Year     int32  `json:"year"`
+	Currency string `json:"currency"`
+	Revenue  int64  `json:"revenue"`
+	Movies   int    `json:"movies"`
+}
+
+// RevenueByYear sums the revenue of the movies matching search per year
+// and currency, oldest year first. Movies without revenue are left out.
+// A search for at most a currency is read from catalog_revenue_by_year,
+// refreshedAt is zero when the sums are fresh
+func (m MovieModel) RevenueByYear(search MovieSearch) ([]*YearRevenue, time.Time, error) {
+	query, args, precomputed := m.revenueQuery(search)
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	totals := []*YearRevenue{}
+
+	for rows.Next() {
+		var total YearRevenue
+
+		err := rows.Scan(&total.Year, &total.Currency, &total.Revenue, &total.Movies)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		totals = append(totals, &total)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if !precomputed {
+		return totals, time.Time{}, nil
+	}
+
+	refreshedAt, err := catalogRefreshedAt(ctx, m.DB)
+	return totals, refreshedAt, err
+}
+
+// revenueQuery reads catalog_revenue_by_year when the search is for at
+// most a currency, precomputed says whether it does
+func (m MovieModel) revenueQuery(search MovieSearch) (query string, args []any, precomputed bool) {
+	anyCurrency := search
+	anyCurrency.Currency = ""
+
+	if anyCurrency.unfiltered() {
+		return `SELECT year, currency, revenue, movies FROM catalog_revenue_by_year
+		WHERE tenant_id = $1 AND ($2 = '' OR currency = $2)
+		ORDER BY year, currency`, []any{m.TenantID, search.Currency}, true
+	}
+
+	filtered, args := m.applySearch(newQuery("movies", "year", "currency", "revenue"), search).build()
+
+	query = "WITH filtered AS (" + filtered + `)
+	SELECT year, currency, sum(revenue), count(*) FROM filtered
+	WHERE revenue > 0
+	GROUP BY year, currency
+	ORDER BY year, currency`
+
+	return query, args, false
+}
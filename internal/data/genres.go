@@ -0,0 +1,239 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.brainwhat/internal/tracing"
+	"greenlight.brainwhat/internal/validator"
+)
+
+var ErrDuplicateGenre = errors.New("duplicate genre")
+
+type Genre struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Movies int    `json:"movies"` // number of movies in the genre, deleted ones don't count
+}
+
+type GenreModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+// setMovieGenres replaces the genres of a movie. Unknown genre names are
+// created on the fly in the movie's tenant, positions keep the order the
+// client sent them in. It's always called as part of a bigger transaction
+func setMovieGenres(ctx context.Context, tx dbtx, tenantID, movieID int64, genres []string) error {
+	stmt := `INSERT INTO genres (name, tenant_id) SELECT unnest($1::text[]), $2
+	ON CONFLICT (tenant_id, name) DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, stmt, pq.Array(genres), tenantID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies_genres WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO movies_genres (movie_id, genre_id, position)
+	SELECT $1, g.id, t.position
+	FROM unnest($2::text[]) WITH ORDINALITY AS t(name, position)
+	JOIN genres g ON g.name = t.name AND g.tenant_id = $3`
+
+	_, err = tx.ExecContext(ctx, stmt, movieID, pq.Array(genres), tenantID)
+	return err
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	query := `SELECT g.id, g.name, count(m.id)
+	FROM genres g
+	LEFT JOIN movies_genres mg ON mg.genre_id = g.id
+	LEFT JOIN movies m ON m.id = mg.movie_id AND m.deleted_at IS NULL
+	WHERE g.id = $1 AND g.tenant_id = $2
+	GROUP BY g.id`
+
+	var genre Genre
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, id, m.TenantID).Scan(&genre.ID, &genre.Name, &genre.Movies)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `SELECT g.id, g.name, count(m.id)
+	FROM genres g
+	LEFT JOIN movies_genres mg ON mg.genre_id = g.id
+	LEFT JOIN movies m ON m.id = mg.movie_id AND m.deleted_at IS NULL
+	WHERE g.tenant_id = $1
+	GROUP BY g.id
+	ORDER BY g.name`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.ID, &genre.Name, &genre.Movies)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// bumpMovies changes version of every movie in the genre, the genres
+// are part of the movie representation so clients holding an old copy
+// should get an edit conflict. Every bumped movie gets an updated event too
+func bumpMovies(ctx context.Context, tx dbtx, genreID int64) error {
+	stmt := `WITH bumped AS (
+		UPDATE movies SET version = version + 1, updated_at = NOW()
+		WHERE id IN (SELECT movie_id FROM movies_genres WHERE genre_id = $1)
+		RETURNING id, version, tenant_id
+	)
+	INSERT INTO outbox_events (type, payload)
+	SELECT $2, jsonb_build_object('id', id, 'version', version, 'tenant_id', tenant_id) FROM bumped`
+
+	_, err := tx.ExecContext(ctx, stmt, genreID, EventMovieUpdated)
+	return err
+}
+
+func (m GenreModel) Rename(genre *Genre) error {
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE genres SET name = $1 WHERE id = $2 AND tenant_id = $3`, genre.Name, genre.ID, m.TenantID)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "genres_tenant_id_name_key"`):
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = bumpMovies(ctx, tx, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Merge moves every movie from genre id into target and deletes id.
+// Movies that already had both genres just lose the merged one
+func (m GenreModel) Merge(id, target int64) error {
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Both genres have to be ours, otherwise movies would end up
+	// with another tenant's genre
+	var found int
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM genres WHERE id IN ($1, $2) AND tenant_id = $3`, id, target, m.TenantID).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if found != 2 {
+		return ErrRecordNotFound
+	}
+
+	err = bumpMovies(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `DELETE FROM movies_genres s
+	WHERE s.genre_id = $1
+	AND EXISTS (SELECT 1 FROM movies_genres t WHERE t.movie_id = s.movie_id AND t.genre_id = $2)`
+
+	_, err = tx.ExecContext(ctx, stmt, id, target)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE movies_genres SET genre_id = $2 WHERE genre_id = $1`, id, target)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "cannot be empty")
+	v.Check(len(genre.Name) <= 50, "name", "must not be more than 50 characters")
+}
@@ -0,0 +1,320 @@
+package data
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// memoryStore keeps every "table" in maps behind one lock, so the memory
+// models behave like the postgres ones would inside a transaction.
+// Everything is lost when the process exits
+type memoryStore struct {
+	mu sync.RWMutex
+
+	movies     map[int64]*memoryMovie
+	undoTokens map[string]memoryUndoToken // key is the token hash
+	revisions  []*Revision
+	genres     map[int64]memoryGenre
+	stats      map[memoryStatKey]int64
+	outbox     []*memoryEvent
+	apiKeys    map[int64]*APIKey
+	usage      map[memoryUsageKey]int64
+	metrics    map[RouteMetric]RouteCounts
+	tenants    map[int64]*Tenant
+
+	collections      map[int64]*memoryCollection
+	collectionMovies map[int64]memoryMembership
+	releases         map[memoryReleaseKey]Date
+	redirects        map[int64]memoryRedirect // key is the old id
+	availability     map[int64][]*Availability
+	operations       map[int64]*Operation
+
+	// Like bigserial: ids only go up, even after deletes
+	lastMovieID, lastGenreID, lastRevisionID, lastCollectionID, lastEventID, lastAPIKeyID, lastTenantID, lastOperationID int64
+
+	similarityThreshold float64
+}
+
+type memoryMovie struct {
+	Movie     // Genres is always nil here, they come from genreIDs
+	tenantID  int64
+	genreIDs  []int64
+	deletedAt time.Time
+
+	availabilitySyncedAt time.Time
+}
+
+type memoryGenre struct {
+	tenantID int64
+	name     string
+}
+
+type memoryCollection struct {
+	Collection // Movies is always empty here
+	tenantID   int64
+}
+
+type memoryUndoToken struct {
+	movieID int64
+	expiry  time.Time
+}
+
+type memoryUsageKey struct {
+	apiKeyID int64
+	day      time.Time
+}
+
+type memoryStatKey struct {
+	movieID int64
+	day     string // 2006-01-02
+}
+
+type memoryRedirect struct {
+	tenantID int64
+	newID    int64
+}
+
+type memoryReleaseKey struct {
+	movieID     int64
+	country     string
+	releaseType string
+}
+
+type memoryMembership struct {
+	collectionID int64
+	position     int
+}
+
+type memoryEvent struct {
+	Event
+	publishedAt time.Time
+}
+
+// NewMemoryModels returns models that keep everything in memory.
+// Handy for demos and for running the API without PostgreSQL.
+// similarityThreshold plays the role of the pg_trgm one
+func NewMemoryModels(similarityThreshold float64) Models {
+	s := &memoryStore{
+		movies:              make(map[int64]*memoryMovie),
+		undoTokens:          make(map[string]memoryUndoToken),
+		genres:              make(map[int64]memoryGenre),
+		stats:               make(map[memoryStatKey]int64),
+		collections:         make(map[int64]*memoryCollection),
+		collectionMovies:    make(map[int64]memoryMembership),
+		releases:            make(map[memoryReleaseKey]Date),
+		redirects:           make(map[int64]memoryRedirect),
+		availability:        make(map[int64][]*Availability),
+		operations:          make(map[int64]*Operation),
+		apiKeys:             make(map[int64]*APIKey),
+		usage:               make(map[memoryUsageKey]int64),
+		metrics:             make(map[RouteMetric]RouteCounts),
+		tenants:             make(map[int64]*Tenant),
+		similarityThreshold: similarityThreshold,
+	}
+
+	// Same as the one the 000014 migration creates
+	s.lastTenantID = DefaultTenantID
+	s.tenants[DefaultTenantID] = &Tenant{ID: DefaultTenantID, CreatedAt: memoryNow(), Slug: DefaultTenantSlug, Name: "Default"}
+
+	return s.models(0)
+}
+
+func (s *memoryStore) models(tenantID int64) Models {
+	return Models{
+		Movies:       memoryMovies{s, tenantID},
+		Revisions:    memoryRevisions{s, tenantID},
+		Genres:       memoryGenres{s, tenantID},
+		Collections:  memoryCollections{s, tenantID},
+		Releases:     memoryReleases{s, tenantID},
+		Availability: memoryAvailability{s, tenantID},
+		Operations:   memoryOperations{s, tenantID},
+		Stats:        memoryStats{s, tenantID},
+		Outbox:       memoryOutbox{s},
+		APIKeys:      memoryAPIKeys{s},
+		Quotas:       memoryQuotas{s},
+		Metrics:      memoryMetrics{s},
+		Tenants:      memoryTenants{s},
+
+		tenantID: tenantID,
+		forTenant: func(tenantID int64, _ *sql.Tx, _ time.Time, _ *tracing.Span) Models {
+			return s.models(tenantID)
+		},
+	}
+}
+
+// memoryNow is truncated like timestamp(0) columns are, otherwise
+// If-Unmodified-Since checks would behave differently than with postgres
+func memoryNow() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// movie returns a copy with genres filled in, so callers can't change the store
+func (s *memoryStore) movie(m *memoryMovie) *Movie {
+	movie := m.Movie
+	movie.Genres = make([]string, 0, len(m.genreIDs))
+
+	for _, id := range m.genreIDs {
+		movie.Genres = append(movie.Genres, s.genres[id].name)
+	}
+
+	movie.Certifications = maps.Clone(m.Certifications)
+	movie.ContentWarnings = slices.Clone(m.ContentWarnings)
+
+	return &movie
+}
+
+// genreIDs works like setMovieGenres, unknown genres are created
+func (s *memoryStore) genreIDs(tenantID int64, names []string) []int64 {
+	ids := make([]int64, 0, len(names))
+
+	for _, name := range names {
+		id, ok := s.genreID(tenantID, name)
+		if !ok {
+			s.lastGenreID++
+			id = s.lastGenreID
+			s.genres[id] = memoryGenre{tenantID: tenantID, name: name}
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (s *memoryStore) genreID(tenantID int64, name string) (int64, bool) {
+	for id, genre := range s.genres {
+		if genre.tenantID == tenantID && genre.name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (s *memoryStore) insertEvent(eventType string, payload any) {
+	js, _ := json.Marshal(payload)
+
+	s.lastEventID++
+	s.outbox = append(s.outbox, &memoryEvent{Event: Event{
+		ID:        s.lastEventID,
+		CreatedAt: memoryNow(),
+		Type:      eventType,
+		Payload:   js,
+	}})
+}
+
+// paginate cuts one page out of items, like LIMIT/OFFSET does
+func paginate[T any](items []T, filters Filters) []T {
+	start := min(filters.offset(), len(items))
+	end := min(start+filters.limit(), len(items))
+
+	return items[start:end]
+}
+
+// sortMovies orders movies by the sort keys, id always ends them
+func sortMovies(movies []*Movie, filters Filters) {
+	keys := filters.sortKeys()
+
+	slices.SortStableFunc(movies, func(a, b *Movie) int {
+		for _, key := range keys {
+			var c int
+
+			switch key.column {
+			case "title":
+				c = strings.Compare(a.Title, b.Title)
+			case "year":
+				c = cmp.Compare(a.Year, b.Year)
+			case "runtime":
+				c = cmp.Compare(a.Runtime, b.Runtime)
+			case "revenue":
+				c = cmp.Compare(a.Revenue, b.Revenue)
+			default:
+				c = cmp.Compare(a.ID, b.ID)
+			}
+
+			if key.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+}
+
+// words splits text the way the 'simple' text search config does:
+// lowercase, anything that isn't a letter or a digit separates words
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesAllWords is plainto_tsquery: every word of q has to be in title
+func matchesAllWords(title, q string) bool {
+	titleWords := words(title)
+
+	for _, word := range words(q) {
+		if !slices.Contains(titleWords, word) {
+			return false
+		}
+	}
+
+	return len(words(q)) > 0
+}
+
+// trigrams works like pg_trgm: every word is padded with two spaces
+// in front and one at the end, then cut into three letter pieces
+func trigrams(text string) map[string]bool {
+	set := make(map[string]bool)
+
+	for _, word := range words(text) {
+		padded := []rune("  " + word + " ")
+
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+// similarity is pg_trgm's similarity(): shared trigrams over all trigrams
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+
+	total := len(ta) + len(tb) - shared
+	if total == 0 {
+		return 0
+	}
+
+	return float64(shared) / float64(total)
+}
+
+var (
+	_ MovieStore      = memoryMovies{}
+	_ RevisionStore   = memoryRevisions{}
+	_ GenreStore      = memoryGenres{}
+	_ CollectionStore = memoryCollections{}
+	_ StatsStore      = memoryStats{}
+	_ OutboxStore     = memoryOutbox{}
+	_ APIKeyStore     = memoryAPIKeys{}
+	_ QuotaStore      = memoryQuotas{}
+	_ MetricsStore    = memoryMetrics{}
+	_ TenantStore     = memoryTenants{}
+)
@@ -0,0 +1,983 @@
+package data
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+type memoryRevisions struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+// ours tells if the revisions of movieID belong to the tenant
+func (m memoryRevisions) ours(movieID int64) bool {
+	stored, ok := m.s.movies[movieID]
+	return ok && stored.tenantID == m.tenantID
+}
+
+func (m memoryRevisions) Get(movieID, id int64) (*Revision, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	if !m.ours(movieID) {
+		return nil, ErrRecordNotFound
+	}
+
+	for _, revision := range m.s.revisions {
+		if revision.ID == id && revision.MovieID == movieID {
+			r := *revision
+			return &r, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryRevisions) GetAllForMovie(movieID int64, filters Filters) ([]*Revision, Metadata, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	revisions := []*Revision{}
+
+	for _, revision := range m.s.revisions {
+		if revision.MovieID == movieID && m.ours(movieID) {
+			r := *revision
+			revisions = append(revisions, &r)
+		}
+	}
+
+	// Revisions can only be sorted by id, and they are stored in id order
+	if filters.sortKeys()[0].desc {
+		slices.Reverse(revisions)
+	}
+
+	return paginate(revisions, filters), calculateMetadata(len(revisions), filters.Page, filters.PageSize), nil
+}
+
+type memoryGenres struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+func (m memoryGenres) ours(id int64) bool {
+	genre, ok := m.s.genres[id]
+	return ok && genre.tenantID == m.tenantID
+}
+
+// genre counts movies like GenreModel does, deleted ones don't count
+func (m memoryGenres) genre(id int64) *Genre {
+	genre := &Genre{ID: id, Name: m.s.genres[id].name}
+
+	for _, stored := range m.s.movies {
+		if stored.deletedAt.IsZero() && slices.Contains(stored.genreIDs, id) {
+			genre.Movies++
+		}
+	}
+
+	return genre
+}
+
+func (m memoryGenres) Get(id int64) (*Genre, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	if !m.ours(id) {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.genre(id), nil
+}
+
+func (m memoryGenres) GetAll() ([]*Genre, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	genres := []*Genre{}
+	for id := range m.s.genres {
+		if m.ours(id) {
+			genres = append(genres, m.genre(id))
+		}
+	}
+
+	slices.SortFunc(genres, func(a, b *Genre) int { return strings.Compare(a.Name, b.Name) })
+
+	return genres, nil
+}
+
+// bumpMovies is the memory version of the function of the same name
+func (m memoryGenres) bumpMovies(genreID int64) {
+	for _, stored := range m.s.movies {
+		if slices.Contains(stored.genreIDs, genreID) {
+			stored.Version++
+			stored.UpdatedAt = memoryNow()
+			m.s.insertEvent(EventMovieUpdated, movieEvent{ID: stored.ID, Version: stored.Version, TenantID: stored.tenantID})
+		}
+	}
+}
+
+func (m memoryGenres) Rename(genre *Genre) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if !m.ours(genre.ID) {
+		return ErrRecordNotFound
+	}
+
+	if id, ok := m.s.genreID(m.tenantID, genre.Name); ok && id != genre.ID {
+		return ErrDuplicateGenre
+	}
+
+	m.s.genres[genre.ID] = memoryGenre{tenantID: m.tenantID, name: genre.Name}
+	m.bumpMovies(genre.ID)
+
+	return nil
+}
+
+func (m memoryGenres) Merge(id, target int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if !m.ours(id) || !m.ours(target) {
+		return ErrRecordNotFound
+	}
+
+	m.bumpMovies(id)
+
+	for _, stored := range m.s.movies {
+		i := slices.Index(stored.genreIDs, id)
+		if i < 0 {
+			continue
+		}
+
+		if slices.Contains(stored.genreIDs, target) {
+			stored.genreIDs = slices.Delete(stored.genreIDs, i, i+1)
+		} else {
+			stored.genreIDs[i] = target
+		}
+	}
+
+	delete(m.s.genres, id)
+
+	return nil
+}
+
+type memoryCollections struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+// get returns the stored collection if it belongs to the tenant
+func (m memoryCollections) get(id int64) (*memoryCollection, bool) {
+	stored, ok := m.s.collections[id]
+	if !ok || stored.tenantID != m.tenantID {
+		return nil, false
+	}
+
+	return stored, true
+}
+
+func (m memoryCollections) Insert(collection *Collection) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.s.lastCollectionID++
+
+	collection.ID = m.s.lastCollectionID
+	collection.CreatedAt = memoryNow()
+	collection.Version = 1
+	collection.Movies = []*Movie{}
+
+	m.s.collections[collection.ID] = &memoryCollection{Collection: *collection, tenantID: m.tenantID}
+
+	return nil
+}
+
+func (m memoryCollections) Get(id int64) (*Collection, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	stored, ok := m.get(id)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	collection := stored.Collection
+	collection.Movies = []*Movie{}
+
+	positions := make(map[int64]int)
+
+	for movieID, membership := range m.s.collectionMovies {
+		movie, ok := m.s.movies[movieID]
+		if membership.collectionID != id || !ok || !movie.deletedAt.IsZero() {
+			continue
+		}
+
+		positions[movieID] = membership.position
+		collection.Movies = append(collection.Movies, m.s.movie(movie))
+	}
+
+	slices.SortFunc(collection.Movies, func(a, b *Movie) int {
+		return cmp.Or(cmp.Compare(positions[a.ID], positions[b.ID]), cmp.Compare(a.ID, b.ID))
+	})
+
+	return &collection, nil
+}
+
+func (m memoryCollections) GetForMovies(movieIDs []int64) (map[int64]*MovieCollection, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	collections := make(map[int64]*MovieCollection)
+
+	for _, movieID := range movieIDs {
+		membership, ok := m.s.collectionMovies[movieID]
+		if !ok {
+			continue
+		}
+
+		collection, ok := m.get(membership.collectionID)
+		if !ok {
+			continue
+		}
+
+		collections[movieID] = &MovieCollection{
+			ID:       membership.collectionID,
+			Name:     collection.Name,
+			Position: membership.position,
+		}
+	}
+
+	return collections, nil
+}
+
+func (m memoryCollections) AttachMovie(collectionID, movieID int64, position int) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	_, collectionExists := m.get(collectionID)
+	movie, movieExists := m.s.movies[movieID]
+
+	if !collectionExists || !movieExists || movie.tenantID != m.tenantID {
+		return ErrRecordNotFound
+	}
+
+	if position <= 0 {
+		for _, membership := range m.s.collectionMovies {
+			if membership.collectionID == collectionID {
+				position = max(position, membership.position)
+			}
+		}
+		position++
+	}
+
+	m.s.collectionMovies[movieID] = memoryMembership{collectionID: collectionID, position: position}
+
+	return nil
+}
+
+func (m memoryCollections) DetachMovie(collectionID, movieID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	membership, ok := m.s.collectionMovies[movieID]
+	if !ok || membership.collectionID != collectionID {
+		return ErrRecordNotFound
+	}
+
+	if _, ok := m.get(collectionID); !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.collectionMovies, movieID)
+
+	return nil
+}
+
+type memoryReleases struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+func (m memoryReleases) ours(movieID int64) bool {
+	stored, ok := m.s.movies[movieID]
+	return ok && stored.tenantID == m.tenantID
+}
+
+func (m memoryReleases) Set(release *Release) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.movies[release.MovieID]
+	if !ok || stored.tenantID != m.tenantID || !stored.deletedAt.IsZero() {
+		return ErrRecordNotFound
+	}
+
+	m.s.releases[memoryReleaseKey{release.MovieID, release.Country, release.Type}] = release.Date
+
+	return nil
+}
+
+func (m memoryReleases) GetAllForMovie(movieID int64) ([]*Release, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	releases := []*Release{}
+
+	if !m.ours(movieID) {
+		return releases, nil
+	}
+
+	for key, date := range m.s.releases {
+		if key.movieID == movieID {
+			releases = append(releases, &Release{MovieID: movieID, Country: key.country, Type: key.releaseType, Date: date})
+		}
+	}
+
+	slices.SortFunc(releases, func(a, b *Release) int {
+		return cmp.Or(
+			time.Time(a.Date).Compare(time.Time(b.Date)),
+			strings.Compare(a.Country, b.Country),
+			strings.Compare(a.Type, b.Type),
+		)
+	})
+
+	return releases, nil
+}
+
+func (m memoryReleases) Delete(movieID int64, country, releaseType string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	key := memoryReleaseKey{movieID, country, releaseType}
+
+	if _, ok := m.s.releases[key]; !ok || !m.ours(movieID) {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.releases, key)
+
+	return nil
+}
+
+// Upcoming uses the local date where postgres would use CURRENT_DATE
+func (m memoryReleases) Upcoming(country, releaseType string, days, limit int) ([]*UpcomingRelease, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	end := today.AddDate(0, 0, days)
+
+	releases := []*UpcomingRelease{}
+
+	for key, date := range m.s.releases {
+		if key.country != country || releaseType != "" && key.releaseType != releaseType {
+			continue
+		}
+
+		if t := time.Time(date); t.Before(today) || !t.Before(end) {
+			continue
+		}
+
+		stored, ok := m.s.movies[key.movieID]
+		if !ok || stored.tenantID != m.tenantID || !stored.deletedAt.IsZero() {
+			continue
+		}
+
+		releases = append(releases, &UpcomingRelease{
+			Release: Release{MovieID: key.movieID, Country: key.country, Type: key.releaseType, Date: date},
+			Movie:   m.s.movie(stored),
+		})
+	}
+
+	slices.SortFunc(releases, func(a, b *UpcomingRelease) int {
+		return cmp.Or(
+			time.Time(a.Date).Compare(time.Time(b.Date)),
+			cmp.Compare(a.MovieID, b.MovieID),
+			strings.Compare(a.Type, b.Type),
+		)
+	})
+
+	return releases[:min(limit, len(releases))], nil
+}
+
+type memoryAvailability struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+func (m memoryAvailability) GetForMovies(movieIDs []int64) (map[int64][]*Availability, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	availability := make(map[int64][]*Availability)
+
+	for _, movieID := range movieIDs {
+		stored, ok := m.s.movies[movieID]
+		if !ok || stored.tenantID != m.tenantID || len(m.s.availability[movieID]) == 0 {
+			continue
+		}
+
+		for _, a := range m.s.availability[movieID] {
+			a := *a
+			availability[movieID] = append(availability[movieID], &a)
+		}
+	}
+
+	return availability, nil
+}
+
+func (m memoryAvailability) Stale(ctx context.Context, maxAge time.Duration, limit int) ([]*SyncTarget, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	cutoff := time.Now().Add(-maxAge)
+
+	var stale []*memoryMovie
+
+	for _, stored := range m.s.movies {
+		if stored.deletedAt.IsZero() && stored.availabilitySyncedAt.Before(cutoff) {
+			stale = append(stale, stored)
+		}
+	}
+
+	// Zero times sort first, like NULLS FIRST
+	slices.SortFunc(stale, func(a, b *memoryMovie) int {
+		return cmp.Or(a.availabilitySyncedAt.Compare(b.availabilitySyncedAt), cmp.Compare(a.ID, b.ID))
+	})
+
+	targets := []*SyncTarget{}
+	for _, stored := range stale[:min(limit, len(stale))] {
+		targets = append(targets, &SyncTarget{MovieID: stored.ID, Title: stored.Title, Year: stored.Year})
+	}
+
+	return targets, nil
+}
+
+// Replace sorts like the ORDER BY of AvailabilityModel.GetForMovies
+func (m memoryAvailability) Replace(ctx context.Context, movieID int64, availability []*Availability) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.movies[movieID]
+	if !ok {
+		return nil
+	}
+
+	seen := make(map[Availability]bool)
+	replaced := []*Availability{}
+
+	for _, a := range availability {
+		key := Availability{Provider: a.Provider, Country: a.Country, Type: a.Type}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		a := *a
+		replaced = append(replaced, &a)
+	}
+
+	slices.SortFunc(replaced, func(a, b *Availability) int {
+		return cmp.Or(strings.Compare(a.Country, b.Country), strings.Compare(a.Provider, b.Provider), strings.Compare(a.Type, b.Type))
+	})
+
+	m.s.availability[movieID] = replaced
+	stored.availabilitySyncedAt = memoryNow()
+
+	return nil
+}
+
+type memoryOperations struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+// operation returns a copy, so callers can't change the store
+func (s *memoryStore) operation(op *Operation) *Operation {
+	copied := *op
+	if op.FinishedAt != nil {
+		finishedAt := *op.FinishedAt
+		copied.FinishedAt = &finishedAt
+	}
+	return &copied
+}
+
+func (m memoryOperations) Insert(op *Operation) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.s.lastOperationID++
+
+	params := op.Params
+	if params == nil {
+		params = json.RawMessage(`{}`)
+	}
+
+	stored := &Operation{
+		ID:        m.s.lastOperationID,
+		Kind:      op.Kind,
+		Status:    OperationPending,
+		CreatedAt: memoryNow(),
+		UpdatedAt: memoryNow(),
+		Params:    params,
+		TenantID:  m.tenantID,
+	}
+	m.s.operations[stored.ID] = stored
+
+	*op = *m.s.operation(stored)
+	return nil
+}
+
+func (m memoryOperations) Get(id int64) (*Operation, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	stored, ok := m.s.operations[id]
+	if !ok || stored.TenantID != m.tenantID {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.s.operation(stored), nil
+}
+
+func (m memoryOperations) Start(ctx context.Context, id int64) (*Operation, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.operations[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	if !stored.Finished() {
+		stored.Status = OperationRunning
+		stored.UpdatedAt = memoryNow()
+	}
+
+	return m.s.operation(stored), nil
+}
+
+func (m memoryOperations) Progress(ctx context.Context, id int64, progress OperationProgress) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if stored, ok := m.s.operations[id]; ok {
+		stored.Progress = progress
+		stored.UpdatedAt = memoryNow()
+	}
+
+	return nil
+}
+
+func (m memoryOperations) Finish(ctx context.Context, id int64, result json.RawMessage, opErr error) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.s.operations[id]
+	if !ok {
+		return nil
+	}
+
+	stored.Status, stored.Result, stored.Error = OperationSucceeded, result, ""
+	if opErr != nil {
+		stored.Status, stored.Error = OperationFailed, opErr.Error()
+	}
+
+	now := memoryNow()
+	stored.UpdatedAt, stored.FinishedAt = now, &now
+
+	return nil
+}
+
+func (m memoryOperations) PurgeFinished(ctx context.Context, olderThan time.Duration) ([]int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+
+	var ids []int64
+	for id, stored := range m.s.operations {
+		if stored.FinishedAt != nil && stored.FinishedAt.Before(cutoff) {
+			delete(m.s.operations, id)
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+type memoryStats struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+func (m memoryStats) AddViews(counts map[int64]int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	today := time.Now().Format(time.DateOnly)
+
+	for movieID, n := range counts {
+		// Same as the join in StatsModel.AddViews, purged movies are skipped
+		if _, ok := m.s.movies[movieID]; ok {
+			m.s.stats[memoryStatKey{movieID: movieID, day: today}] += n
+		}
+	}
+
+	return nil
+}
+
+func (m memoryStats) Trending(days, limit int) ([]*Movie, time.Time, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	since := time.Now().AddDate(0, 0, -days).Format(time.DateOnly)
+	totals := make(map[int64]int64)
+
+	for key, views := range m.s.stats {
+		if key.day > since {
+			totals[key.movieID] += views
+		}
+	}
+
+	movies := []*Movie{}
+
+	for movieID := range totals {
+		stored, ok := m.s.movies[movieID]
+		if ok && stored.tenantID == m.tenantID && stored.deletedAt.IsZero() {
+			movies = append(movies, m.s.movie(stored))
+		}
+	}
+
+	slices.SortFunc(movies, func(a, b *Movie) int {
+		return cmp.Or(cmp.Compare(totals[b.ID], totals[a.ID]), cmp.Compare(a.ID, b.ID))
+	})
+
+	return movies[:min(limit, len(movies))], time.Time{}, nil
+}
+
+func (m memoryStats) RefreshCatalog(ctx context.Context) error {
+	return nil
+}
+
+type memoryOutbox struct {
+	s *memoryStore
+}
+
+// Relay doesn't hold the lock while publishing, publish may be slow
+func (m memoryOutbox) Relay(limit int, publish func(*Event) error) (int, error) {
+	m.s.mu.RLock()
+
+	var pending []*memoryEvent
+
+	for _, event := range m.s.outbox {
+		if len(pending) == limit {
+			break
+		}
+		if event.publishedAt.IsZero() {
+			pending = append(pending, event)
+		}
+	}
+
+	m.s.mu.RUnlock()
+
+	published := 0
+
+	for _, event := range pending {
+		e := event.Event
+
+		err := publish(&e)
+		if err != nil {
+			return published, err
+		}
+
+		m.s.mu.Lock()
+		event.publishedAt = memoryNow()
+		m.s.mu.Unlock()
+
+		published++
+	}
+
+	return published, nil
+}
+
+// There are no partitions in memory, published events past retention
+// are deleted one by one instead. Nothing is ever reported as dropped
+func (m memoryOutbox) RotatePartitions(ctx context.Context, retention time.Duration) ([]string, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	m.s.outbox = slices.DeleteFunc(m.s.outbox, func(e *memoryEvent) bool {
+		return !e.publishedAt.IsZero() && e.publishedAt.Before(cutoff)
+	})
+
+	return nil, nil
+}
+
+type memoryAPIKeys struct {
+	s *memoryStore
+}
+
+func (m memoryAPIKeys) Insert(key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	// Like the foreign key does
+	if _, ok := m.s.tenants[key.TenantID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	m.s.lastAPIKeyID++
+
+	key.ID = m.s.lastAPIKeyID
+	key.CreatedAt = memoryNow()
+
+	stored := *key
+	stored.Plaintext = ""
+	stored.Scopes = slices.Clone(key.Scopes)
+	m.s.apiKeys[key.ID] = &stored
+
+	return nil
+}
+
+func (m memoryAPIKeys) GetByPlaintext(plaintext string) (*APIKey, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	hash := hashAPIKey(plaintext)
+
+	for _, stored := range m.s.apiKeys {
+		if bytes.Equal(stored.Hash, hash) {
+			key := *stored
+			key.Scopes = slices.Clone(stored.Scopes)
+			return &key, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryAPIKeys) GetAll() ([]*APIKey, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	keys := []*APIKey{}
+
+	for _, stored := range m.s.apiKeys {
+		key := *stored
+		key.Scopes = slices.Clone(stored.Scopes)
+		keys = append(keys, &key)
+	}
+
+	slices.SortFunc(keys, func(a, b *APIKey) int { return cmp.Compare(a.ID, b.ID) })
+
+	return keys, nil
+}
+
+func (m memoryAPIKeys) Delete(id int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.apiKeys[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.apiKeys, id)
+
+	// Like the foreign key does
+	for k := range m.s.usage {
+		if k.apiKeyID == id {
+			delete(m.s.usage, k)
+		}
+	}
+	for metric := range m.s.metrics {
+		if metric.APIKeyID == id {
+			delete(m.s.metrics, metric)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryAPIKeys) UpdatePlan(id int64, plan string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	key, ok := m.s.apiKeys[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	key.Plan = plan
+
+	return nil
+}
+
+type memoryQuotas struct {
+	s *memoryStore
+}
+
+func (m memoryQuotas) Usage(keyID int64, day time.Time) (int64, int64, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var daily, monthly int64
+
+	for k, n := range m.s.usage {
+		if k.apiKeyID != keyID || k.day.After(day) || k.day.Before(quotaMonth(day)) {
+			continue
+		}
+
+		monthly += n
+		if k.day.Equal(day) {
+			daily += n
+		}
+	}
+
+	return daily, monthly, nil
+}
+
+func (m memoryQuotas) AddUsage(day time.Time, counts map[int64]int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for id, n := range counts {
+		// Like the join does
+		if _, ok := m.s.apiKeys[id]; ok {
+			m.s.usage[memoryUsageKey{id, day}] += n
+		}
+	}
+
+	return nil
+}
+
+type memoryMetrics struct {
+	s *memoryStore
+}
+
+func (m memoryMetrics) AddMetrics(counts map[RouteMetric]RouteCounts) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for metric, c := range counts {
+		// Like the join does
+		if _, ok := m.s.apiKeys[metric.APIKeyID]; !ok {
+			continue
+		}
+
+		total := m.s.metrics[metric]
+		total.Requests += c.Requests
+		total.Errors += c.Errors
+		m.s.metrics[metric] = total
+	}
+
+	return nil
+}
+
+func (m memoryMetrics) Usage(keyID int64, days, top int) (*APIKeyUsage, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	from, to := usageWindow(days)
+	daily := make(map[time.Time]RouteCounts)
+	routes := make(map[string]*RouteUsage)
+
+	for metric, c := range m.s.metrics {
+		if metric.APIKeyID != keyID || metric.Day.Before(from) || metric.Day.After(to) {
+			continue
+		}
+
+		day := daily[metric.Day]
+		day.Requests += c.Requests
+		day.Errors += c.Errors
+		daily[metric.Day] = day
+
+		route, ok := routes[metric.Route]
+		if !ok {
+			route = &RouteUsage{Route: metric.Route}
+			routes[metric.Route] = route
+		}
+		route.Requests += c.Requests
+		route.Errors += c.Errors
+	}
+
+	topRoutes := slices.Collect(maps.Values(routes))
+	slices.SortFunc(topRoutes, func(a, b *RouteUsage) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Route, b.Route))
+	})
+	if topRoutes == nil {
+		topRoutes = []*RouteUsage{}
+	}
+
+	return newAPIKeyUsage(keyID, from, to, daily, topRoutes[:min(top, len(topRoutes))]), nil
+}
+
+type memoryTenants struct {
+	s *memoryStore
+}
+
+func (m memoryTenants) Insert(tenant *Tenant) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	for _, stored := range m.s.tenants {
+		if stored.Slug == tenant.Slug {
+			return ErrDuplicateTenant
+		}
+	}
+
+	m.s.lastTenantID++
+
+	tenant.ID = m.s.lastTenantID
+	tenant.CreatedAt = memoryNow()
+
+	stored := *tenant
+	m.s.tenants[tenant.ID] = &stored
+
+	return nil
+}
+
+func (m memoryTenants) GetBySlug(slug string) (*Tenant, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	for _, stored := range m.s.tenants {
+		if stored.Slug == slug {
+			tenant := *stored
+			return &tenant, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryTenants) GetAll() ([]*Tenant, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	tenants := []*Tenant{}
+
+	for _, stored := range m.s.tenants {
+		tenant := *stored
+		tenants = append(tenants, &tenant)
+	}
+
+	slices.SortFunc(tenants, func(a, b *Tenant) int { return cmp.Compare(a.ID, b.ID) })
+
+	return tenants, nil
+}
@@ -0,0 +1,630 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type memoryMovies struct {
+	s        *memoryStore
+	tenantID int64
+}
+
+// get returns the stored movie if the tenant can see it
+func (m memoryMovies) get(id int64) (*memoryMovie, bool) {
+	stored, ok := m.s.movies[id]
+	if !ok || stored.tenantID != m.tenantID || !stored.deletedAt.IsZero() {
+		return nil, false
+	}
+
+	return stored, true
+}
+
+func (m memoryMovies) Insert(movie *Movie) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.s.lastMovieID++
+
+	// Like the normalize(title, NFC) of MovieModel.Insert
+	movie.Title = norm.NFC.String(movie.Title)
+	movie.ID = m.s.lastMovieID
+	movie.CreatedAt = memoryNow()
+	movie.UpdatedAt = movie.CreatedAt
+	movie.Version = 1
+
+	stored := &memoryMovie{Movie: *movie, tenantID: m.tenantID, genreIDs: m.s.genreIDs(m.tenantID, movie.Genres)}
+	stored.Genres = nil
+	stored.Certifications = maps.Clone(movie.Certifications)
+	stored.ContentWarnings = slices.Clone(movie.ContentWarnings)
+	m.s.movies[movie.ID] = stored
+
+	m.s.insertEvent(EventMovieCreated, movieEvent{ID: movie.ID, Version: movie.Version, TenantID: m.tenantID})
+
+	return nil
+}
+
+func (m memoryMovies) Get(id int64) (*Movie, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	stored, ok := m.get(id)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.s.movie(stored), nil
+}
+
+// search returns copies of the movies matching search in id order.
+// It's the applySearch of the memory models
+func (m memoryMovies) search(search MovieSearch) []*Movie {
+	movies := []*Movie{}
+
+	for _, stored := range m.s.movies {
+		if stored.tenantID != m.tenantID || !stored.deletedAt.IsZero() {
+			continue
+		}
+
+		switch {
+		case search.Title != "" && search.Fuzzy:
+			if similarity(matchTitle(stored.Title), matchTitle(search.Title)) <= m.s.similarityThreshold {
+				continue
+			}
+		case search.Title != "":
+			if !matchesAllWords(matchTitle(stored.Title), matchTitle(search.Title)) {
+				continue
+			}
+		}
+
+		if search.Year != 0 && int(stored.Year) != search.Year {
+			continue
+		}
+
+		if search.YearMin != 0 && int(stored.Year) < search.YearMin || search.YearMax != 0 && int(stored.Year) > search.YearMax {
+			continue
+		}
+
+		if search.RuntimeMin != 0 && int(stored.Runtime) < search.RuntimeMin || search.RuntimeMax != 0 && int(stored.Runtime) > search.RuntimeMax {
+			continue
+		}
+
+		if search.Currency != "" && stored.Currency != search.Currency {
+			continue
+		}
+
+		if rating, ok := stored.Certifications[search.Country]; search.Country != "" &&
+			(!ok || search.Certification != "" && rating != search.Certification) {
+			continue
+		}
+
+		movie := m.s.movie(stored)
+
+		if !containsAll(movie.Genres, search.Genres) {
+			continue
+		}
+
+		movies = append(movies, movie)
+	}
+
+	slices.SortFunc(movies, func(a, b *Movie) int { return cmp.Compare(a.ID, b.ID) })
+
+	return movies
+}
+
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(have, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetAll falls back to the fuzzy search just like MovieModel.GetAll
+func (m memoryMovies) GetAll(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	movies := m.search(search)
+
+	// These are all the matches, not just the page, so a page past the
+	// last one doesn't make the search fuzzy
+	if len(movies) == 0 && search.Title != "" && !search.Fuzzy {
+		search.Fuzzy = true
+		movies = m.search(search)
+	}
+
+	sortMovies(movies, filters)
+
+	if search.Fuzzy {
+		slices.SortStableFunc(movies, func(a, b *Movie) int {
+			return cmp.Compare(similarity(matchTitle(b.Title), matchTitle(search.Title)), similarity(matchTitle(a.Title), matchTitle(search.Title)))
+		})
+	}
+
+	metadata := calculateMetadata(len(movies), filters.Page, filters.PageSize)
+	if filters.SkipTotal {
+		metadata = calculatePartialMetadata(filters.Page, filters.PageSize, len(movies) > filters.offset()+filters.limit())
+	}
+	metadata.Fuzzy = search.Fuzzy && len(movies) > 0
+
+	return paginate(movies, filters), metadata, nil
+}
+
+func (m memoryMovies) GetAllAfter(afterID int64, limit int) ([]*Movie, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var ids []int64
+	for id := range m.s.movies {
+		if _, ok := m.get(id); ok && id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	movies := []*Movie{}
+	for _, id := range ids[:min(limit, len(ids))] {
+		movies = append(movies, m.s.movie(m.s.movies[id]))
+	}
+
+	return movies, nil
+}
+
+func (m memoryMovies) GetByIDs(ids []int64) ([]*Movie, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	movies := []*Movie{}
+
+	for _, id := range ids {
+		stored, ok := m.get(id)
+		if ok {
+			movies = append(movies, m.s.movie(stored))
+		}
+	}
+
+	return movies, nil
+}
+
+func (m memoryMovies) Update(movie *Movie, old Movie, editor string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.get(movie.ID)
+	if !ok || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+
+	movie.Title = norm.NFC.String(movie.Title)
+	movie.Version++
+	movie.UpdatedAt = memoryNow()
+
+	newJSON, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	stored.Title = movie.Title
+	stored.Year = movie.Year
+	stored.Runtime = movie.Runtime
+	stored.Version = movie.Version
+	stored.UpdatedAt = movie.UpdatedAt
+	stored.genreIDs = m.s.genreIDs(m.tenantID, movie.Genres)
+	stored.Certifications = maps.Clone(movie.Certifications)
+	stored.ContentWarnings = slices.Clone(movie.ContentWarnings)
+	stored.Budget = movie.Budget
+	stored.Revenue = movie.Revenue
+	stored.Currency = movie.Currency
+
+	m.s.lastRevisionID++
+	m.s.revisions = append(m.s.revisions, &Revision{
+		ID:        m.s.lastRevisionID,
+		MovieID:   movie.ID,
+		Version:   movie.Version,
+		Old:       oldJSON,
+		New:       newJSON,
+		Editor:    editor,
+		CreatedAt: movie.UpdatedAt,
+	})
+
+	m.s.insertEvent(EventMovieUpdated, movieEvent{ID: movie.ID, Version: movie.Version, TenantID: m.tenantID})
+
+	return nil
+}
+
+func (m memoryMovies) Delete(id int64, undoWindow time.Duration) (*UndoToken, error) {
+	token, err := generateUndoToken(id, undoWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.get(id)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	stored.deletedAt = memoryNow()
+	m.s.undoTokens[string(token.Hash)] = memoryUndoToken{movieID: id, expiry: token.Expiry}
+
+	m.s.insertEvent(EventMovieDeleted, movieEvent{ID: id, TenantID: m.tenantID})
+
+	return token, nil
+}
+
+func (m memoryMovies) Restore(tokenPlaintext string) (*Movie, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	token, ok := m.s.undoTokens[string(hash[:])]
+	if !ok || !token.expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	// A token of another tenant's movie stays where it is
+	stored, ok := m.s.movies[token.movieID]
+	if !ok || stored.tenantID != m.tenantID {
+		return nil, ErrRecordNotFound
+	}
+
+	delete(m.s.undoTokens, string(hash[:]))
+
+	if stored.deletedAt.IsZero() {
+		return nil, ErrRecordNotFound
+	}
+
+	stored.deletedAt = time.Time{}
+
+	m.s.insertEvent(EventMovieRestored, movieEvent{ID: stored.ID, Version: stored.Version, TenantID: m.tenantID})
+
+	return m.s.movie(stored), nil
+}
+
+// GetSimilar ranks the same way as MovieModel.GetSimilar
+func (m memoryMovies) Merge(id, target int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	stored, ok := m.get(id)
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if _, ok := m.get(target); !ok {
+		return ErrRecordNotFound
+	}
+
+	stored.deletedAt = memoryNow()
+	stored.Version++
+
+	for oldID, redirect := range m.s.redirects {
+		if redirect.newID == id {
+			m.s.redirects[oldID] = memoryRedirect{tenantID: redirect.tenantID, newID: target}
+		}
+	}
+	m.s.redirects[id] = memoryRedirect{tenantID: m.tenantID, newID: target}
+
+	if membership, ok := m.s.collectionMovies[id]; ok {
+		if _, taken := m.s.collectionMovies[target]; !taken {
+			m.s.collectionMovies[target] = membership
+		}
+		delete(m.s.collectionMovies, id)
+	}
+
+	for key, views := range m.s.stats {
+		if key.movieID == id {
+			m.s.stats[memoryStatKey{movieID: target, day: key.day}] += views
+			delete(m.s.stats, key)
+		}
+	}
+
+	for key, date := range m.s.releases {
+		if key.movieID == id {
+			moved := memoryReleaseKey{movieID: target, country: key.country, releaseType: key.releaseType}
+			if _, taken := m.s.releases[moved]; !taken {
+				m.s.releases[moved] = date
+			}
+			delete(m.s.releases, key)
+		}
+	}
+
+	delete(m.s.availability, id)
+
+	m.s.insertEvent(EventMovieMerged, mergeEvent{ID: id, Into: target, TenantID: m.tenantID})
+
+	return nil
+}
+
+func (m memoryMovies) Redirect(id int64) (int64, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	redirect, ok := m.s.redirects[id]
+	if !ok || redirect.tenantID != m.tenantID {
+		return 0, ErrRecordNotFound
+	}
+
+	return redirect.newID, nil
+}
+
+func (m memoryMovies) GetSimilar(id int64, limit int) ([]*Movie, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	target, ok := m.s.movies[id]
+	if !ok || target.tenantID != m.tenantID {
+		return []*Movie{}, nil
+	}
+
+	type scored struct {
+		movie *Movie
+		score float64
+	}
+
+	var candidates []scored
+
+	for _, stored := range m.s.movies {
+		if stored.ID == id || stored.tenantID != m.tenantID || !stored.deletedAt.IsZero() {
+			continue
+		}
+
+		shared := 0
+		for _, genreID := range stored.genreIDs {
+			if slices.Contains(target.genreIDs, genreID) {
+				shared++
+			}
+		}
+
+		if shared == 0 {
+			continue
+		}
+
+		yearGap := float64(stored.Year - target.Year)
+		if yearGap < 0 {
+			yearGap = -yearGap
+		}
+
+		candidates = append(candidates, scored{
+			movie: m.s.movie(stored),
+			score: float64(shared)*2 + 1.0/(1+yearGap/10.0),
+		})
+	}
+
+	slices.SortFunc(candidates, func(a, b scored) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.movie.ID, b.movie.ID))
+	})
+
+	movies := []*Movie{}
+	for _, c := range candidates[:min(limit, len(candidates))] {
+		movies = append(movies, c.movie)
+	}
+
+	return movies, nil
+}
+
+func (m memoryMovies) GetRandom(search MovieSearch) (*Movie, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	movies := m.search(search)
+	if len(movies) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return movies[rand.IntN(len(movies))], nil
+}
+
+// There are no catalog views in memory, counts are always fresh
+func (m memoryMovies) Facets(search MovieSearch, names []string) (map[string][]FacetValue, time.Time, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	movies := m.search(search)
+	facets := make(map[string][]FacetValue, len(names))
+
+	for _, name := range names {
+		if _, ok := movieFacetQueries[name]; !ok {
+			panic("unknown movie facet: " + name)
+		}
+
+		counts := make(map[string]int)
+
+		for _, movie := range movies {
+			switch name {
+			case "genres":
+				for _, genre := range movie.Genres {
+					counts[genre]++
+				}
+			case "year_decade":
+				counts[strconv.Itoa(int(movie.Year/10*10))]++
+			}
+		}
+
+		values := []FacetValue{}
+		for value, count := range counts {
+			values = append(values, FacetValue{Value: value, Count: count})
+		}
+
+		slices.SortFunc(values, func(a, b FacetValue) int {
+			return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Value, b.Value))
+		})
+
+		facets[name] = values
+	}
+
+	return facets, time.Time{}, nil
+}
+
+func (m memoryMovies) RevenueByYear(search MovieSearch) ([]*YearRevenue, time.Time, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	type key struct {
+		year     int32
+		currency string
+	}
+
+	byKey := make(map[key]*YearRevenue)
+	totals := []*YearRevenue{}
+
+	for _, movie := range m.search(search) {
+		if movie.Revenue == 0 {
+			continue
+		}
+
+		k := key{movie.Year, movie.Currency}
+
+		total, ok := byKey[k]
+		if !ok {
+			total = &YearRevenue{Year: movie.Year, Currency: movie.Currency}
+			byKey[k] = total
+			totals = append(totals, total)
+		}
+
+		total.Revenue += movie.Revenue
+		total.Movies++
+	}
+
+	slices.SortFunc(totals, func(a, b *YearRevenue) int {
+		return cmp.Or(cmp.Compare(a.Year, b.Year), strings.Compare(a.Currency, b.Currency))
+	})
+
+	return totals, time.Time{}, nil
+}
+
+func (m memoryMovies) Suggest(q string, limit int) ([]*Suggestion, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	type scored struct {
+		suggestion *Suggestion
+		prefix     bool
+		similarity float64
+	}
+
+	var candidates []scored
+
+	for _, stored := range m.s.movies {
+		if stored.tenantID != m.tenantID || !stored.deletedAt.IsZero() {
+			continue
+		}
+
+		c := scored{
+			suggestion: &Suggestion{ID: stored.ID, Title: stored.Title, Year: stored.Year},
+			prefix:     strings.HasPrefix(strings.ToLower(stored.Title), strings.ToLower(q)),
+			similarity: similarity(stored.Title, q),
+		}
+
+		// 0.3 is the default threshold of pg_trgm's % operator
+		if c.prefix || c.similarity > 0.3 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	slices.SortFunc(candidates, func(a, b scored) int {
+		if a.prefix != b.prefix {
+			if a.prefix {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(b.similarity, a.similarity), strings.Compare(a.suggestion.Title, b.suggestion.Title))
+	})
+
+	suggestions := []*Suggestion{}
+	for _, c := range candidates[:min(limit, len(candidates))] {
+		suggestions = append(suggestions, c.suggestion)
+	}
+
+	return suggestions, nil
+}
+
+func (m memoryMovies) DeleteExpiredUndoTokens(ctx context.Context) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var n int64
+
+	for hash, token := range m.s.undoTokens {
+		if token.expiry.Before(time.Now()) {
+			delete(m.s.undoTokens, hash)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// PurgeDeleted also drops everything that references the movie,
+// like ON DELETE CASCADE does
+func (m memoryMovies) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+	purged := make(map[int64]bool)
+
+	for id, stored := range m.s.movies {
+		if !stored.deletedAt.IsZero() && stored.deletedAt.Before(cutoff) {
+			purged[id] = true
+		}
+	}
+
+	for id := range purged {
+		delete(m.s.movies, id)
+		delete(m.s.collectionMovies, id)
+		delete(m.s.availability, id)
+	}
+
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	for hash, token := range m.s.undoTokens {
+		if purged[token.movieID] {
+			delete(m.s.undoTokens, hash)
+		}
+	}
+
+	for key := range m.s.stats {
+		if purged[key.movieID] {
+			delete(m.s.stats, key)
+		}
+	}
+
+	for key := range m.s.releases {
+		if purged[key.movieID] {
+			delete(m.s.releases, key)
+		}
+	}
+
+	for oldID, redirect := range m.s.redirects {
+		if purged[redirect.newID] {
+			delete(m.s.redirects, oldID)
+		}
+	}
+
+	m.s.revisions = slices.DeleteFunc(m.s.revisions, func(r *Revision) bool { return purged[r.MovieID] })
+
+	return int64(len(purged)), nil
+}
@@ -0,0 +1,82 @@
+package data
+
+import "time"
+
+type mergeEvent struct {
+	ID       int64 `json:"id"`
+	Into     int64 `json:"into"`
+	TenantID int64 `json:"tenant_id"`
+}
+
+// Merge folds duplicate movie id into target. Collection membership,
+// views and releases move over unless target already has its own, the
+// rest of target stays as it is. id goes to the trash without an undo
+// token and redirects to target from then on
+func (m MovieModel) Merge(id, target int64) error {
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE movies SET deleted_at = NOW(), version = version + 1
+	WHERE id = $1 AND tenant_id = $3 AND deleted_at IS NULL
+	AND EXISTS (SELECT 1 FROM movies WHERE id = $2 AND tenant_id = $3 AND deleted_at IS NULL)`
+
+	result, err := tx.ExecContext(ctx, query, id, target, m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = addRedirect(ctx, tx, id, target, m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	stmts := []string{
+		// A movie belongs to one collection at most
+		`UPDATE collections_movies SET movie_id = $2
+		WHERE movie_id = $1 AND NOT EXISTS (SELECT 1 FROM collections_movies WHERE movie_id = $2)`,
+		`DELETE FROM collections_movies WHERE movie_id = $1`,
+
+		`INSERT INTO movie_stats (movie_id, day, views)
+		SELECT $2, day, views FROM movie_stats WHERE movie_id = $1
+		ON CONFLICT (movie_id, day) DO UPDATE SET views = movie_stats.views + EXCLUDED.views`,
+		`DELETE FROM movie_stats WHERE movie_id = $1`,
+
+		`UPDATE movie_releases r SET movie_id = $2
+		WHERE movie_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM movie_releases t WHERE t.movie_id = $2 AND t.country = r.country AND t.type = r.type
+		)`,
+		`DELETE FROM movie_releases WHERE movie_id = $1`,
+
+		// Availability comes from syncing target
+		`DELETE FROM movie_availability WHERE movie_id = $1`,
+	}
+
+	for _, stmt := range stmts {
+		_, err = tx.ExecContext(ctx, stmt, id, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertEvent(ctx, tx, EventMovieMerged, mergeEvent{ID: id, Into: target, TenantID: m.TenantID})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
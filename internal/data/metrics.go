@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RouteMetric is one key's requests to one route on one day. Route is the
+// registered pattern, e.g. "GET /v1/movies/{id}", and every response with
+// a status of 400 or more counts as an error, the client's mistakes too
+type RouteMetric struct {
+	APIKeyID int64
+	Day      time.Time
+	Route    string
+}
+
+type RouteCounts struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+// errorRate is Errors over Requests, 0 without requests
+func (c RouteCounts) errorRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.Errors) / float64(c.Requests)
+}
+
+type DailyUsage struct {
+	Day Date `json:"day"`
+	RouteCounts
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type RouteUsage struct {
+	Route string `json:"route"`
+	RouteCounts
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// APIKeyUsage is what a key did between From and To, both days included.
+// Daily has every day of the window, the ones without requests too
+type APIKeyUsage struct {
+	APIKeyID int64 `json:"api_key_id"`
+	From     Date  `json:"from"`
+	To       Date  `json:"to"`
+	RouteCounts
+	ErrorRate    float64       `json:"error_rate"`
+	Daily        []*DailyUsage `json:"daily"`
+	TopEndpoints []*RouteUsage `json:"top_endpoints"`
+}
+
+// usageWindow is the last days UTC days, today included
+func usageWindow(days int) (time.Time, time.Time) {
+	to := quotaDay(time.Now())
+	return to.AddDate(0, 0, 1-days), to
+}
+
+// newAPIKeyUsage fills in the totals, the error rates and the days
+// that had no rows
+func newAPIKeyUsage(keyID int64, from, to time.Time, days map[time.Time]RouteCounts, top []*RouteUsage) *APIKeyUsage {
+	usage := &APIKeyUsage{
+		APIKeyID:     keyID,
+		From:         Date(from),
+		To:           Date(to),
+		Daily:        []*DailyUsage{},
+		TopEndpoints: top,
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		counts := days[day]
+
+		usage.Requests += counts.Requests
+		usage.Errors += counts.Errors
+		usage.Daily = append(usage.Daily, &DailyUsage{Day: Date(day), RouteCounts: counts, ErrorRate: counts.errorRate()})
+	}
+
+	usage.ErrorRate = usage.RouteCounts.errorRate()
+
+	for _, route := range top {
+		route.ErrorRate = route.RouteCounts.errorRate()
+	}
+
+	return usage
+}
+
+type MetricsModel struct {
+	DB *sql.DB
+}
+
+// AddMetrics adds counts to the rolled up rows in one statement. Keys
+// revoked in the meantime are skipped by the join
+func (m MetricsModel) AddMetrics(counts map[RouteMetric]RouteCounts) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(counts))
+	days := make([]time.Time, 0, len(counts))
+	routes := make([]string, 0, len(counts))
+	requests := make([]int64, 0, len(counts))
+	errs := make([]int64, 0, len(counts))
+
+	for metric, c := range counts {
+		ids = append(ids, metric.APIKeyID)
+		days = append(days, metric.Day)
+		routes = append(routes, metric.Route)
+		requests = append(requests, c.Requests)
+		errs = append(errs, c.Errors)
+	}
+
+	stmt := `INSERT INTO api_key_metrics (api_key_id, day, route, requests, errors)
+	SELECT m.api_key_id, m.day, m.route, m.requests, m.errors
+	FROM unnest($1::bigint[], $2::date[], $3::text[], $4::bigint[], $5::bigint[]) AS m(api_key_id, day, route, requests, errors)
+	JOIN api_keys ON api_keys.id = m.api_key_id
+	ON CONFLICT (api_key_id, day, route) DO UPDATE
+	SET requests = api_key_metrics.requests + EXCLUDED.requests, errors = api_key_metrics.errors + EXCLUDED.errors`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, pq.Array(ids), pq.Array(days), pq.Array(routes), pq.Array(requests), pq.Array(errs))
+	return err
+}
+
+// Usage sums up the key's metrics of the last days, with the top
+// routes by requests
+func (m MetricsModel) Usage(keyID int64, days, top int) (*APIKeyUsage, error) {
+	from, to := usageWindow(days)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, `SELECT day, sum(requests), sum(errors)
+	FROM api_key_metrics
+	WHERE api_key_id = $1 AND day BETWEEN $2 AND $3
+	GROUP BY day`, keyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	daily := make(map[time.Time]RouteCounts)
+
+	for rows.Next() {
+		var day time.Time
+		var counts RouteCounts
+
+		err := rows.Scan(&day, &counts.Requests, &counts.Errors)
+		if err != nil {
+			return nil, err
+		}
+
+		daily[quotaDay(day)] = counts
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = queryWithRetry(ctx, m.DB, `SELECT route, sum(requests), sum(errors)
+	FROM api_key_metrics
+	WHERE api_key_id = $1 AND day BETWEEN $2 AND $3
+	GROUP BY route
+	ORDER BY sum(requests) DESC, route
+	LIMIT $4`, keyID, from, to, top)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []*RouteUsage{}
+
+	for rows.Next() {
+		var route RouteUsage
+
+		err := rows.Scan(&route.Route, &route.Requests, &route.Errors)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, &route)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return newAPIKeyUsage(keyID, from, to, daily, routes), nil
+}
+
+// MetricsBuffer rolls requests up in memory until Flush, like ViewBuffer
+type MetricsBuffer struct {
+	mu      sync.Mutex
+	counts  map[RouteMetric]RouteCounts
+	metrics MetricsStore
+}
+
+func NewMetricsBuffer(metrics MetricsStore) *MetricsBuffer {
+	return &MetricsBuffer{counts: make(map[RouteMetric]RouteCounts), metrics: metrics}
+}
+
+func (b *MetricsBuffer) Record(keyID int64, route string, status int, now time.Time) {
+	metric := RouteMetric{APIKeyID: keyID, Day: quotaDay(now), Route: route}
+
+	b.mu.Lock()
+	counts := b.counts[metric]
+	counts.Requests++
+	if status >= 400 {
+		counts.Errors++
+	}
+	b.counts[metric] = counts
+	b.mu.Unlock()
+}
+
+func (b *MetricsBuffer) Flush() error {
+	b.mu.Lock()
+	counts := b.counts
+	b.counts = make(map[RouteMetric]RouteCounts)
+	b.mu.Unlock()
+
+	err := b.metrics.AddMetrics(counts)
+	if err != nil {
+		// Put the counts back so they aren't lost, next flush will retry
+		b.mu.Lock()
+		for metric, c := range b.counts {
+			total := counts[metric]
+			total.Requests += c.Requests
+			total.Errors += c.Errors
+			counts[metric] = total
+		}
+		b.counts = counts
+		b.mu.Unlock()
+	}
+
+	return err
+}
@@ -1,8 +1,13 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"time"
+
+	"greenlight.brainwhat/internal/tracing"
 )
 
 var (
@@ -10,12 +15,226 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// Handlers only talk to the storage through these interfaces.
+// The XModel types implement them on top of PostgreSQL.
+// Stores holding a TenantID only ever see that tenant's rows, zero
+// matches nothing. Background jobs that clean up after every tenant
+// (PurgeDeleted and friends) ignore it on purpose
+
+type MovieStore interface {
+	Insert(movie *Movie) error
+	Get(id int64) (*Movie, error)
+	GetAll(search MovieSearch, filters Filters) ([]*Movie, Metadata, error)
+	GetByIDs(ids []int64) ([]*Movie, error)
+	GetAllAfter(afterID int64, limit int) ([]*Movie, error)
+	Update(movie *Movie, old Movie, editor string) error
+	Delete(id int64, undoWindow time.Duration) (*UndoToken, error)
+	Restore(tokenPlaintext string) (*Movie, error)
+	Merge(id, target int64) error
+	Redirect(id int64) (int64, error)
+	GetSimilar(id int64, limit int) ([]*Movie, error)
+	GetRandom(search MovieSearch) (*Movie, error)
+	Facets(search MovieSearch, names []string) (map[string][]FacetValue, time.Time, error)
+	RevenueByYear(search MovieSearch) ([]*YearRevenue, time.Time, error)
+	Suggest(q string, limit int) ([]*Suggestion, error)
+	DeleteExpiredUndoTokens(ctx context.Context) (int64, error)
+	PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+type RevisionStore interface {
+	Get(movieID, id int64) (*Revision, error)
+	GetAllForMovie(movieID int64, filters Filters) ([]*Revision, Metadata, error)
+}
+
+type GenreStore interface {
+	Get(id int64) (*Genre, error)
+	GetAll() ([]*Genre, error)
+	Rename(genre *Genre) error
+	Merge(id, target int64) error
+}
+
+type CollectionStore interface {
+	Insert(collection *Collection) error
+	Get(id int64) (*Collection, error)
+	GetForMovies(movieIDs []int64) (map[int64]*MovieCollection, error)
+	AttachMovie(collectionID, movieID int64, position int) error
+	DetachMovie(collectionID, movieID int64) error
+}
+
+type ReleaseStore interface {
+	Set(release *Release) error
+	GetAllForMovie(movieID int64) ([]*Release, error)
+	Delete(movieID int64, country, releaseType string) error
+	Upcoming(country, releaseType string, days, limit int) ([]*UpcomingRelease, error)
+}
+
+type AvailabilityStore interface {
+	GetForMovies(movieIDs []int64) (map[int64][]*Availability, error)
+	Stale(ctx context.Context, maxAge time.Duration, limit int) ([]*SyncTarget, error)
+	Replace(ctx context.Context, movieID int64, availability []*Availability) error
+}
+
+type OperationStore interface {
+	Insert(op *Operation) error
+	Get(id int64) (*Operation, error)
+	Start(ctx context.Context, id int64) (*Operation, error)
+	Progress(ctx context.Context, id int64, progress OperationProgress) error
+	Finish(ctx context.Context, id int64, result json.RawMessage, opErr error) error
+	PurgeFinished(ctx context.Context, olderThan time.Duration) ([]int64, error)
+}
+
+type StatsStore interface {
+	AddViews(counts map[int64]int64) error
+	Trending(days, limit int) ([]*Movie, time.Time, error)
+	RefreshCatalog(ctx context.Context) error
+}
+
+type OutboxStore interface {
+	Relay(limit int, publish func(*Event) error) (int, error)
+	RotatePartitions(ctx context.Context, retention time.Duration) ([]string, error)
+}
+
+type APIKeyStore interface {
+	Insert(key *APIKey) error
+	GetByPlaintext(plaintext string) (*APIKey, error)
+	GetAll() ([]*APIKey, error)
+	UpdatePlan(id int64, plan string) error
+	Delete(id int64) error
+}
+
+type QuotaStore interface {
+	Usage(keyID int64, day time.Time) (daily, monthly int64, err error)
+	AddUsage(day time.Time, counts map[int64]int64) error
+}
+
+type MetricsStore interface {
+	AddMetrics(counts map[RouteMetric]RouteCounts) error
+	Usage(keyID int64, days, top int) (*APIKeyUsage, error)
+}
+
+type TenantStore interface {
+	Insert(tenant *Tenant) error
+	GetBySlug(slug string) (*Tenant, error)
+	GetAll() ([]*Tenant, error)
+}
+
+var (
+	_ MovieStore        = MovieModel{}
+	_ RevisionStore     = RevisionModel{}
+	_ GenreStore        = GenreModel{}
+	_ CollectionStore   = CollectionModel{}
+	_ ReleaseStore      = ReleaseModel{}
+	_ AvailabilityStore = AvailabilityModel{}
+	_ OperationStore    = OperationModel{}
+	_ StatsStore        = StatsModel{}
+	_ OutboxStore       = OutboxModel{}
+	_ APIKeyStore       = APIKeyModel{}
+	_ QuotaStore        = QuotaModel{}
+	_ MetricsStore      = MetricsModel{}
+	_ TenantStore       = TenantModel{}
+)
+
 type Models struct {
-	Movies MovieModel
+	Movies       MovieStore
+	Revisions    RevisionStore
+	Genres       GenreStore
+	Collections  CollectionStore
+	Releases     ReleaseStore
+	Availability AvailabilityStore
+	Operations   OperationStore
+	Stats        StatsStore
+	Outbox       OutboxStore
+	APIKeys      APIKeyStore
+	Quotas       QuotaStore
+	Metrics      MetricsStore
+	Tenants      TenantStore
+
+	tenantID  int64
+	tx        *sql.Tx
+	deadline  time.Time
+	span      *tracing.Span
+	forTenant func(tenantID int64, tx *sql.Tx, deadline time.Time, span *tracing.Span) Models
 }
 
-func NewModels(db *sql.DB) Models {
+// ForTenant returns the same models scoped to one tenant. Models that
+// haven't been scoped belong to no tenant, so request handlers always
+// have to go through here
+func (m Models) ForTenant(tenantID int64) Models {
+	return m.forTenant(tenantID, nil, time.Time{}, nil)
+}
+
+// ForTenantTx is ForTenant with every statement running in tx, see
+// BeginTenantTx. The memory models don't have transactions and ignore it
+func (m Models) ForTenantTx(tenantID int64, tx *sql.Tx) Models {
+	return m.forTenant(tenantID, tx, time.Time{}, nil)
+}
+
+// WithDeadline returns the same models with their queries cut short at
+// deadline, usually the request's. Queries still have their own timeout
+// too, whichever comes first wins. Only the deadline is taken over and
+// not the request's context, work a request hands off to a goroutine
+// isn't cancelled when the client goes away
+func (m Models) WithDeadline(deadline time.Time) Models {
+	return m.forTenant(m.tenantID, m.tx, deadline, m.span)
+}
+
+// WithSpan returns the same models with their queries traced under span,
+// usually the request's. Like with WithDeadline only the span is taken
+// over, not the request's context
+func (m Models) WithSpan(span *tracing.Span) Models {
+	return m.forTenant(m.tenantID, m.tx, m.deadline, span)
+}
+
+// errRequestDeadline is the cause of a query context that ran out of the
+// request's time rather than its own, the breaker doesn't count those
+var errRequestDeadline = errors.New("request deadline exceeded")
+
+// queryContext is the context of one query: timeout from now, or deadline
+// if that's sooner. The span is for sqlstats to hang the query's span on
+func queryContext(deadline time.Time, span *tracing.Span, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := tracing.ContextWithSpan(context.Background(), span)
+
+	if !deadline.IsZero() && time.Until(deadline) < timeout {
+		return context.WithDeadlineCause(ctx, deadline, errRequestDeadline)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// NewModels returns the PostgreSQL backed models.
+// similarityThreshold is the minimum pg_trgm similarity of the fuzzy title search
+func NewModels(db *sql.DB, similarityThreshold float64) Models {
+	return newModels(db, nil, similarityThreshold, 0, time.Time{}, nil)
+}
+
+// The tenant models use tx when there is one, the rest always use the pool
+func newModels(db *sql.DB, tx *sql.Tx, similarityThreshold float64, tenantID int64, deadline time.Time, span *tracing.Span) Models {
+	var scoped dbtx = db
+	if tx != nil {
+		scoped = tx
+	}
+
 	return Models{
-		Movies: MovieModel{DB: db},
+		Movies:       MovieModel{DB: scoped, SimilarityThreshold: similarityThreshold, TenantID: tenantID, Deadline: deadline, Span: span},
+		Revisions:    RevisionModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Genres:       GenreModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Collections:  CollectionModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Releases:     ReleaseModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Availability: AvailabilityModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Operations:   OperationModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Stats:        StatsModel{DB: scoped, TenantID: tenantID, Deadline: deadline, Span: span},
+		Outbox:       OutboxModel{DB: db},
+		APIKeys:      APIKeyModel{DB: db},
+		Quotas:       QuotaModel{DB: db},
+		Metrics:      MetricsModel{DB: db},
+		Tenants:      TenantModel{DB: db},
+
+		tenantID: tenantID,
+		tx:       tx,
+		deadline: deadline,
+		span:     span,
+		forTenant: func(tenantID int64, tx *sql.Tx, deadline time.Time, span *tracing.Span) Models {
+			return newModels(db, tx, similarityThreshold, tenantID, deadline, span)
+		},
 	}
 }
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// benchmarkModels runs fn against the memory models and, when
+// GREENLIGHT_BENCH_DSN points at a migrated database, against PostgreSQL.
+// The movies the benchmarks insert end up in its trash, use a throwaway one
+func benchmarkModels(b *testing.B, fn func(b *testing.B, m Models)) {
+	b.Run("memory", func(b *testing.B) {
+		fn(b, NewMemoryModels(0.3).ForTenant(DefaultTenantID))
+	})
+
+	b.Run("postgres", func(b *testing.B) {
+		dsn := os.Getenv("GREENLIGHT_BENCH_DSN")
+		if dsn == "" {
+			b.Skip("GREENLIGHT_BENCH_DSN isn't set")
+		}
+
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { db.Close() })
+
+		fn(b, NewModels(db, 0.3).ForTenant(DefaultTenantID))
+	})
+}
+
+// insertBenchMovies adds n movies and removes them when the benchmark ends
+func insertBenchMovies(b *testing.B, m Models, n int) []*Movie {
+	b.Helper()
+
+	genres := []string{"action", "comedy", "drama", "animation", "thriller"}
+	movies := make([]*Movie, 0, n)
+
+	for i := range n {
+		movie := &Movie{
+			Title:   fmt.Sprintf("Bench Movie %d", i),
+			Year:    int32(1950 + i%70),
+			Runtime: Runtime(80 + i%60),
+			Genres:  []string{genres[i%len(genres)], genres[(i+1)%len(genres)]},
+		}
+
+		err := m.Movies.Insert(movie)
+		if err != nil {
+			b.Fatal(err)
+		}
+		movies = append(movies, movie)
+	}
+
+	b.Cleanup(func() {
+		for _, movie := range movies {
+			m.Movies.Delete(movie.ID, 0)
+		}
+	})
+
+	return movies
+}
+
+func BenchmarkMoviesGet(b *testing.B) {
+	benchmarkModels(b, func(b *testing.B, m Models) {
+		movies := insertBenchMovies(b, m, 200)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := range b.N {
+			_, err := m.Movies.Get(movies[i%len(movies)].ID)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMoviesGetAll(b *testing.B) {
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	searches := map[string]MovieSearch{
+		"all":    {},
+		"title":  {Title: "bench movie 1"},
+		"genres": {Genres: []string{"drama"}},
+	}
+
+	benchmarkModels(b, func(b *testing.B, m Models) {
+		insertBenchMovies(b, m, 200)
+
+		for name, search := range searches {
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+
+				for range b.N {
+					_, _, err := m.Movies.GetAll(search, filters)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	})
+}
+
+func BenchmarkMoviesInsert(b *testing.B) {
+	benchmarkModels(b, func(b *testing.B, m Models) {
+		var ids []int64
+		b.Cleanup(func() {
+			for _, id := range ids {
+				m.Movies.Delete(id, 0)
+			}
+		})
+
+		b.ReportAllocs()
+
+		for i := range b.N {
+			movie := &Movie{Title: fmt.Sprintf("Inserted Movie %d", i), Year: 2000, Runtime: 100, Genres: []string{"drama"}}
+
+			err := m.Movies.Insert(movie)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ids = append(ids, movie.ID)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"io"
+	"testing"
+	"time"
+
+	"greenlight.brainwhat/internal/sqlstats"
+)
+
+// The breaker counts a query that ran out of its own time, not one that ran
+// out of the request's, and tells them apart by the cause
+func TestQueryContextCause(t *testing.T) {
+	tests := []struct {
+		name      string
+		deadline  time.Time
+		wantCause error
+	}{
+		{name: "no request deadline", wantCause: context.DeadlineExceeded},
+		{name: "request deadline later", deadline: time.Now().Add(time.Hour), wantCause: context.DeadlineExceeded},
+		{name: "request deadline sooner", deadline: time.Now().Add(time.Millisecond), wantCause: errRequestDeadline},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := queryContext(tt.deadline, nil, 5*time.Millisecond)
+			defer cancel()
+
+			<-ctx.Done()
+
+			if ctx.Err() != context.DeadlineExceeded || context.Cause(ctx) != tt.wantCause {
+				t.Errorf("got %v caused by %v, want caused by %v", ctx.Err(), context.Cause(ctx), tt.wantCause)
+			}
+		})
+	}
+}
+
+// emptyDB answers every query with no rows
+type emptyDB struct{}
+
+func (c emptyDB) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c emptyDB) Driver() driver.Driver                        { return nil }
+func (c emptyDB) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (c emptyDB) Close() error                                 { return nil }
+func (c emptyDB) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c emptyDB) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return []string{"n"} }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+// The query metrics are per model method, the retry helpers the queries
+// go through mustn't show up in their names
+func TestQueryMetricNames(t *testing.T) {
+	db := sql.OpenDB(sqlstats.Wrap(emptyDB{}, sqlstats.Config{}))
+	defer db.Close()
+
+	m := MovieModel{DB: db, TenantID: DefaultTenantID}
+
+	_, err := m.Get(1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got %v, want ErrRecordNotFound", err)
+	}
+
+	_, _, err = m.getAll(MovieSearch{}, Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := expvar.Get("sql_queries").(*expvar.Map)
+
+	for _, name := range []string{"data.MovieModel.Get", "data.MovieModel.getAll"} {
+		if queries.Get(name) == nil {
+			t.Errorf("no metric for %s in %s", name, queries)
+		}
+	}
+
+	for _, name := range []string{"data.withRetry", "data.queryWithRetry", "data.MovieModel.Get.func1"} {
+		if queries.Get(name) != nil {
+			t.Errorf("got a metric for %s", name)
+		}
+	}
+}
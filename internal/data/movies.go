@@ -1,42 +1,132 @@
 package data
 
 import (
-	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
+	"golang.org/x/text/unicode/norm"
+	"greenlight.brainwhat/internal/tracing"
 	"greenlight.brainwhat/internal/validator"
 )
 
 type Movie struct {
 	ID        int64     `json:"id"` // This just changes output names
 	CreatedAt time.Time `json:"-"`  // "-" doen't show field in json response
+	UpdatedAt time.Time `json:"-"`  // Sent as Last-Modified header instead
 	Title     string    `json:"title"`
 	Year      int32     `json:"year,omitempty"` // omitempty doesn't show field if it's not defined/zero/""/false/etc
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+
+	// Country code to rating, e.g. "US": "PG-13". See CertificationSystems
+	Certifications  map[string]string `json:"certifications,omitempty"`
+	ContentWarnings []string          `json:"content_warnings,omitempty"`
+
+	// In cents of Currency, 0 when unknown
+	Budget   int64  `json:"budget,omitempty"`
+	Revenue  int64  `json:"revenue,omitempty"`
+	Currency string `json:"currency,omitempty"`
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB dbtx
+
+	// Minimum pg_trgm similarity for the fuzzy title search fallback
+	SimilarityThreshold float64
+
+	TenantID int64
+
+	// Queries end by then at the latest, see Models.WithDeadline
+	Deadline time.Time
+
+	// Queries are traced under it, see Models.WithSpan
+	Span *tracing.Span
+}
+
+// Genres live in their own table, this puts them back into an array
+// in the order they were given. It expects the movies table not to be aliased
+const genresColumn = `ARRAY(SELECT g.name FROM movies_genres mg JOIN genres g ON g.id = mg.genre_id
+	WHERE mg.movie_id = movies.id ORDER BY mg.position) AS genres`
+
+// Every query that returns whole movies selects these columns,
+// scanMovie expects them in exactly this order
+var movieColumns = []string{"id", "created_at", "updated_at", "title", "year", "runtime", genresColumn, "version", "certifications", "content_warnings", "budget", "revenue", "currency"}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Extra destinations go before the movie columns, e.g. count(*) OVER()
+func scanMovie(row scanner, movie *Movie, extra ...any) error {
+	dest := append(extra,
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		certificationsScanner{&movie.Certifications},
+		pq.Array(&movie.ContentWarnings),
+		&movie.Budget,
+		&movie.Revenue,
+		&movie.Currency,
+	)
+
+	return row.Scan(dest...)
 }
 
 func (m MovieModel) Insert(movie *Movie) error {
-	stmt := `INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at, version`
+	stmt := `INSERT INTO movies (title, year, runtime, tenant_id, certifications, content_warnings, budget, revenue, currency)
+	VALUES (normalize($1, NFC), $2, $3, $4, $5, COALESCE($6, '{}'), $7, $8, $9)
+	RETURNING id, title, created_at, updated_at, version`
 
-	// pq.Array returns pq.StringArray type that implements the driver.Valuer and sql.Scanner interfaces
-	// That are neccessary to translate []string to postgres text[] array
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		m.TenantID,
+		certificationsColumn(movie.Certifications),
+		pq.Array(movie.ContentWarnings),
+		movie.Budget,
+		movie.Revenue,
+		movie.Currency,
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.Title, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieGenres(ctx, tx, m.TenantID, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	err = insertEvent(ctx, tx, EventMovieCreated, movieEvent{ID: movie.ID, Version: movie.Version, TenantID: m.TenantID})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
@@ -45,24 +135,20 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, created_at, title, year, runtime, genres, version
-	FROM movies
-	WHERE id = $1`
+	query, args := newQuery("movies", movieColumns...).
+		where("id = ?", id).
+		where("tenant_id = ?", m.TenantID).
+		where("deleted_at IS NULL").
+		build()
 
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version)
-
+	err := withRetry(ctx, func() error {
+		return scanMovie(m.DB.QueryRowContext(ctx, query, args...), &movie)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -76,25 +162,314 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// MovieSearch holds the optional filters of the movie list.
+// Empty fields are simply not added to the query
+type MovieSearch struct {
+	Title  string
+	Genres []string
+	Year   int
+
+	// Inclusive ranges, zero leaves that end open
+	YearMin, YearMax       int
+	RuntimeMin, RuntimeMax int
+
+	// Country alone matches movies rated in that country,
+	// with Certification only the ones with that rating
+	Country       string
+	Certification string
+
+	// Only movies with amounts in this currency
+	Currency string
+
+	// Match title by trigram similarity instead of full-text search
+	Fuzzy bool
+}
+
+// applySearch adds the search conditions to q. It's shared by the list
+// and the facets, so the facet counts always match the listed movies
+func (m MovieModel) applySearch(q *query, search MovieSearch) *query {
+	q.where("tenant_id = ?", m.TenantID)
+	q.where("deleted_at IS NULL")
+
+	switch {
+	case search.Title != "" && search.Fuzzy:
+		q.where("similarity(normalized_title, ?) > ?", matchTitle(search.Title), m.SimilarityThreshold)
+	case search.Title != "":
+		q.where("to_tsvector('simple', normalized_title) @@ plainto_tsquery('simple', ?)", matchTitle(search.Title))
+	}
+
+	if search.Year != 0 {
+		q.where("year = ?", search.Year)
+	}
+
+	// Plain comparisons, so movies_tenant_year_runtime_idx can serve them
+	if search.YearMin != 0 {
+		q.where("year >= ?", search.YearMin)
+	}
+	if search.YearMax != 0 {
+		q.where("year <= ?", search.YearMax)
+	}
+	if search.RuntimeMin != 0 {
+		q.where("runtime >= ?", search.RuntimeMin)
+	}
+	if search.RuntimeMax != 0 {
+		q.where("runtime <= ?", search.RuntimeMax)
+	}
+
+	// Movie has to have every one of the genres
+	if len(search.Genres) > 0 {
+		genres := slices.Compact(slices.Sorted(slices.Values(search.Genres)))
+		q.where(`id IN (SELECT mg.movie_id FROM movies_genres mg JOIN genres g ON g.id = mg.genre_id
+		WHERE g.name = ANY(?) GROUP BY mg.movie_id HAVING count(*) = ?)`, pq.Array(genres), len(genres))
+	}
+
+	// @> can use the GIN index. ->> can't, but filtering by country alone is rare
+	switch {
+	case search.Certification != "":
+		js, _ := json.Marshal(map[string]string{search.Country: search.Certification})
+		q.where("certifications @> ?::jsonb", string(js))
+	case search.Country != "":
+		q.where("certifications ->> ? IS NOT NULL", search.Country)
+	}
+
+	if search.Currency != "" {
+		q.where("currency = ?", search.Currency)
+	}
+
+	return q
+}
+
+// GetAll lists movies matching the search. When the full-text title search
+// finds nothing we try again with trigram similarity, so typos like
+// "Shawshenk" still find the movie. metadata.Fuzzy tells which one was used
+func (m MovieModel) GetAll(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	movies, metadata, err := m.getAll(search, filters)
+	if err != nil || len(movies) > 0 || search.Title == "" || search.Fuzzy {
+		return movies, metadata, err
+	}
+
+	// A page past the last one is empty too, and count(*) OVER() has no
+	// rows to count there. Only fall back when nothing matched at all
+	if filters.Page > 1 {
+		found, err := m.anyMatch(search)
+		if err != nil || found {
+			return movies, metadata, err
+		}
+	}
+
+	search.Fuzzy = true
+
+	movies, metadata, err = m.getAll(search, filters)
+	metadata.Fuzzy = len(movies) > 0
+
+	return movies, metadata, err
+}
+
+func (m MovieModel) anyMatch(search MovieSearch) (bool, error) {
+	stmt, args := m.applySearch(newQuery("movies", "1"), search).build()
+	query := "SELECT EXISTS (" + stmt + ")"
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	var found bool
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, args...).Scan(&found)
+	})
+
+	return found, err
+}
+
+func (m MovieModel) getAll(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	query, args := m.listQuery(search, filters)
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	var extra []any
+	if !filters.SkipTotal {
+		extra = append(extra, &totalRecords)
+	}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie, extra...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	// rows.Next() stops on errors too, so we have to check for them separately
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if filters.SkipTotal {
+		hasMore := len(movies) > filters.limit()
+		return movies[:min(len(movies), filters.limit())], calculatePartialMetadata(filters.Page, filters.PageSize, hasMore), nil
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+func (m MovieModel) listQuery(search MovieSearch, filters Filters) (string, []any) {
+	columns := append([]string{"count(*) OVER()"}, movieColumns...)
+	limit := filters.limit()
+
+	// Without the total one more row tells whether there's another page
+	if filters.SkipTotal {
+		columns = movieColumns
+		limit++
+	}
+
+	q := m.applySearch(newQuery("movies", columns...), search)
+
+	if search.Fuzzy {
+		q.orderExpr("similarity(normalized_title, ?) DESC", matchTitle(search.Title))
+	}
+
+	q.sort(filters.sortKeys())
+	q.paginate(limit, filters.offset())
+
+	return q.build()
+}
+
+// GetAllAfter pages through every movie by id, for exports. Unlike
+// offsets the position stays right while movies are added or deleted
+func (m MovieModel) GetAllAfter(afterID int64, limit int) ([]*Movie, error) {
+	q := newQuery("movies", movieColumns...).
+		where("id > ?", afterID).
+		where("tenant_id = ?", m.TenantID).
+		where("deleted_at IS NULL").
+		order("id", "ASC").
+		paginate(limit, 0)
+
+	query, args := q.build()
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// GetByIDs returns the movies it could find, in no particular order.
+// It's up to the caller to figure out which ids are missing
+func (m MovieModel) GetByIDs(ids []int64) ([]*Movie, error) {
+	q := newQuery("movies", movieColumns...).
+		where("id = ANY(?)", pq.Array(ids)).
+		where("tenant_id = ?", m.TenantID).
+		where("deleted_at IS NULL")
+
+	query, args := q.build()
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// Update also stores a revision with the movie as it was before (old)
+// and after the change. Both happen in one transaction, so history
+// can't get out of sync with the movies table
+func (m MovieModel) Update(movie *Movie, old Movie, editor string) error {
 	query := `UPDATE movies
-	SET title=$1, year=$2, runtime=$3, genres=$4, version = version + 1
-	WHERE id=$5 AND version = $6
-	RETURNING version`
+	SET title=normalize($1, NFC), year=$2, runtime=$3, certifications=$7, content_warnings=COALESCE($8, '{}'),
+		budget=$9, revenue=$10, currency=$11,
+		version = version + 1, updated_at = NOW()
+	WHERE id=$4 AND version = $5 AND tenant_id = $6 AND deleted_at IS NULL
+	RETURNING title, version, updated_at`
 
 	args := []any{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
+		m.TenantID,
+		certificationsColumn(movie.Certifications),
+		pq.Array(movie.ContentWarnings),
+		movie.Budget,
+		movie.Revenue,
+		movie.Currency,
+	}
+
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op after Commit
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Title, &movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -104,39 +479,63 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
-}
-
-func (m MovieModel) Delete(id int64) error {
-	if id < 0 {
-		return ErrRecordNotFound
+	err = setMovieGenres(ctx, tx, m.TenantID, movie.ID, movie.Genres)
+	if err != nil {
+		return err
 	}
 
-	query := `DELETE FROM movies WHERE id = $1`
+	newJSON, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// jsonb args have to be passed as strings, pq would send []byte as bytea
+	stmt := `INSERT INTO movie_revisions (movie_id, version, old_data, new_data, editor)
+	VALUES ($1, $2, $3, $4, $5)`
 
-	defer cancel()
-	result, err := m.DB.ExecContext(ctx, query, id)
+	_, err = tx.ExecContext(ctx, stmt, movie.ID, movie.Version, string(oldJSON), string(newJSON), editor)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	err = insertEvent(ctx, tx, EventMovieUpdated, movieEvent{ID: movie.ID, Version: movie.Version, TenantID: m.TenantID})
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	return tx.Commit()
+}
+
+// ValidateRangeSearch checks year_min, year_max, runtime_min and runtime_max
+func ValidateRangeSearch(v *validator.Validator, search MovieSearch) {
+	for key, year := range map[string]int{"year_min": search.YearMin, "year_max": search.YearMax} {
+		v.Check(year == 0 || year > 1888 && year <= time.Now().Year(), key, "must be between 1888 and today")
 	}
 
-	return nil
+	v.Check(search.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(search.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+
+	v.Check(search.YearMin == 0 || search.YearMax == 0 || search.YearMin <= search.YearMax, "year_max", "must not be before year_min")
+	v.Check(search.RuntimeMin == 0 || search.RuntimeMax == 0 || search.RuntimeMin <= search.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+}
+
+// NormalizeTitle puts a title in Unicode NFC, trims it and collapses runs
+// of whitespace, tabs and newlines included, into single spaces. It runs
+// before validation, so the length is that of the title that's stored
+func NormalizeTitle(title string) string {
+	return norm.NFC.String(strings.Join(strings.Fields(title), " "))
+}
+
+// matchTitle is what title searches compare, the same as the
+// normalized_title column: NFKC, so the "ﬁ" ligature is "fi", in lower case
+func matchTitle(title string) string {
+	return strings.ToLower(norm.NFKC.String(title))
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "cannot be empty")
-	v.Check(len(movie.Title) < 500, "title", "must be under 500 characters")
+	v.Check(utf8.ValidString(movie.Title), "title", "must be valid UTF-8")
+	v.Check(utf8.RuneCountInString(movie.Title) < 500, "title", "must be under 500 characters")
 
 	v.Check(movie.Year != 0, "year", "cannpt be empty")
 	v.Check(movie.Year > 1888 && movie.Year <= int32(time.Now().Year()), "year", "must be between 1888 and today")
@@ -147,4 +546,7 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.CheckForEmptyStrings(movie.Genres), "genres", "cannot be empty")
 	v.Check(len(movie.Genres) > 0 && len(movie.Genres) <= 5, "genres", "must have between 1 and 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must be unique")
+
+	validateCertifications(v, movie)
+	validateFinances(v, movie)
 }
@@ -0,0 +1,43 @@
+package data
+
+import "testing"
+
+func TestMoviesGetAllFuzzyFallback(t *testing.T) {
+	m := NewMemoryModels(0.3).ForTenant(DefaultTenantID)
+
+	for _, title := range []string{"The Shawshank Redemption", "Shawshank Stories", "Casablanca"} {
+		err := m.Movies.Insert(&Movie{Title: title, Year: 1994, Runtime: 120, Genres: []string{"drama"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		title     string
+		page      int
+		skipTotal bool
+		wantCount int
+		wantFuzzy bool
+	}{
+		{name: "exact matches", title: "shawshank", page: 1, wantCount: 1},
+		{name: "typo", title: "shawshenk", page: 1, wantCount: 1, wantFuzzy: true},
+		{name: "exact matches past the last page", title: "shawshank", page: 3, wantCount: 0},
+		{name: "exact matches past the last page without total", title: "shawshank", page: 3, skipTotal: true, wantCount: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{Page: tt.page, PageSize: 1, Sort: "id", SortSafelist: []string{"id"}, SkipTotal: tt.skipTotal}
+
+			movies, metadata, err := m.Movies.GetAll(MovieSearch{Title: tt.title}, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(movies) != tt.wantCount || metadata.Fuzzy != tt.wantFuzzy {
+				t.Errorf("got %d movies, fuzzy %t, want %d, fuzzy %t", len(movies), metadata.Fuzzy, tt.wantCount, tt.wantFuzzy)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// Operation is long running work like an import, clients poll it
+// instead of waiting for the response
+type Operation struct {
+	ID         int64             `json:"id"`
+	Kind       string            `json:"kind"`
+	Status     string            `json:"status"`
+	Progress   OperationProgress `json:"progress"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+
+	// What the operation works on, only its runner reads it
+	Params   json.RawMessage `json:"-"`
+	TenantID int64           `json:"-"`
+}
+
+// OperationProgress counts whatever the operation works through,
+// rows of an import or movies of a sync. Total is 0 until it's known.
+// Checkpoint is where a failed operation can be resumed from, for the
+// kinds that support it
+type OperationProgress struct {
+	Done       int64  `json:"done"`
+	Total      int64  `json:"total"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+func (op *Operation) Finished() bool {
+	return op.Status == OperationSucceeded || op.Status == OperationFailed
+}
+
+// Operations of the unscoped models, tenant 0, belong to no tenant.
+// Those are the admin server's
+type OperationModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+const operationColumns = `id, tenant_id, kind, status, params, done, total, checkpoint, result, error, created_at, updated_at, finished_at`
+
+func scanOperation(row scanner) (*Operation, error) {
+	var op Operation
+	var tenantID sql.NullInt64
+	var result []byte
+	var finishedAt sql.NullTime
+
+	err := row.Scan(
+		&op.ID,
+		&tenantID,
+		&op.Kind,
+		&op.Status,
+		&op.Params,
+		&op.Progress.Done,
+		&op.Progress.Total,
+		&op.Progress.Checkpoint,
+		&result,
+		&op.Error,
+		&op.CreatedAt,
+		&op.UpdatedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	op.TenantID = tenantID.Int64
+	if result != nil {
+		op.Result = result
+	}
+	if finishedAt.Valid {
+		op.FinishedAt = &finishedAt.Time
+	}
+
+	return &op, nil
+}
+
+func (m OperationModel) Insert(op *Operation) error {
+	params := op.Params
+	if params == nil {
+		params = json.RawMessage(`{}`)
+	}
+
+	query := `INSERT INTO operations (tenant_id, kind, params)
+	VALUES (NULLIF($1::bigint, 0), $2, $3)
+	RETURNING ` + operationColumns
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	// jsonb has to be passed as a string, pq would send []byte as bytea
+	stored, err := scanOperation(m.DB.QueryRowContext(ctx, query, m.TenantID, op.Kind, string(params)))
+	if err != nil {
+		return err
+	}
+
+	*op = *stored
+	return nil
+}
+
+func (m OperationModel) Get(id int64) (*Operation, error) {
+	query := `SELECT ` + operationColumns + ` FROM operations WHERE id = $1 AND tenant_id IS NOT DISTINCT FROM NULLIF($2::bigint, 0)`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	var op *Operation
+
+	err := withRetry(ctx, func() (err error) {
+		op, err = scanOperation(m.DB.QueryRowContext(ctx, query, id, m.TenantID))
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return op, nil
+}
+
+// Start marks the operation running and returns it for its runner.
+// A running one can be started again, its job may have been retried
+// after a worker died. Finished ones come back unchanged.
+// Start, Progress and Finish are for the job and ignore the tenant
+func (m OperationModel) Start(ctx context.Context, id int64) (*Operation, error) {
+	query := `UPDATE operations
+	SET status = CASE WHEN finished_at IS NULL THEN 'running' ELSE status END, updated_at = NOW()
+	WHERE id = $1
+	RETURNING ` + operationColumns
+
+	op, err := scanOperation(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return op, nil
+}
+
+func (m OperationModel) Progress(ctx context.Context, id int64, progress OperationProgress) error {
+	query := `UPDATE operations SET done = $2, total = $3, checkpoint = $4, updated_at = NOW() WHERE id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id, progress.Done, progress.Total, progress.Checkpoint)
+	return err
+}
+
+// Finish records the result, or opErr when the operation failed
+func (m OperationModel) Finish(ctx context.Context, id int64, result json.RawMessage, opErr error) error {
+	status, message := OperationSucceeded, ""
+	if opErr != nil {
+		status, message = OperationFailed, opErr.Error()
+	}
+
+	var resultArg any
+	if result != nil {
+		resultArg = string(result)
+	}
+
+	query := `UPDATE operations
+	SET status = $2, result = $3, error = $4, updated_at = NOW(), finished_at = NOW()
+	WHERE id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id, status, resultArg, message)
+	return err
+}
+
+// PurgeFinished deletes operations that finished more than olderThan ago.
+// It returns their ids, files they left in storage can go too
+func (m OperationModel) PurgeFinished(ctx context.Context, olderThan time.Duration) ([]int64, error) {
+	query := `DELETE FROM operations WHERE finished_at < NOW() - make_interval(secs => $1) RETURNING id`
+
+	rows, err := m.DB.QueryContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Event types written to the outbox
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventMovieRestored = "movie.restored"
+	EventMovieMerged   = "movie.merged"
+)
+
+// Event is a change that happened to our data. Payloads are kept small
+// (ids and versions), consumers that need more fetch it from the API
+type Event struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+type movieEvent struct {
+	ID       int64 `json:"id"`
+	Version  int32 `json:"version,omitempty"`
+	TenantID int64 `json:"tenant_id"`
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// insertEvent has to be called with the transaction of the change itself,
+// so an event exists if and only if the change was committed
+func insertEvent(ctx context.Context, tx dbtx, eventType string, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO outbox_events (type, payload) VALUES ($1, $2)`, eventType, string(js))
+	return err
+}
+
+// Relay hands up to limit unpublished events to publish, oldest first, and
+// marks the ones that went through as published. It stops at the first
+// error so events keep their order. An event can be published twice if we
+// crash right after publish, consumers should dedupe by event id.
+// SKIP LOCKED keeps two instances from relaying the same events at once
+func (m OutboxModel) Relay(limit int, publish func(*Event) error) (int, error) {
+	query := `SELECT id, created_at, type, payload FROM outbox_events
+	WHERE published_at IS NULL
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Type, &event.Payload)
+		if err != nil {
+			return 0, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var published []int64
+	var publishErr error
+
+	for _, event := range events {
+		publishErr = publish(event)
+		if publishErr != nil {
+			break
+		}
+		published = append(published, event.ID)
+	}
+
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE outbox_events SET published_at = NOW() WHERE id = ANY($1)`, pq.Array(published))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(published), publishErr
+}
+
+// Months outbox_events has partitions for ahead of the current one
+const eventPartitionsAhead = 2
+
+// RotatePartitions makes sure outbox_events has partitions for the next
+// months and drops the monthly partitions that ended more than retention
+// ago. A month with unpublished events is kept until they went out.
+// It returns the names of the dropped partitions
+func (m OutboxModel) RotatePartitions(ctx context.Context, retention time.Duration) ([]string, error) {
+	_, err := m.DB.ExecContext(ctx, `SELECT create_monthly_partition('outbox_events', month::date)
+	FROM generate_series(date_trunc('month', NOW() AT TIME ZONE 'UTC'),
+		date_trunc('month', NOW() AT TIME ZONE 'UTC') + make_interval(months => $1), interval '1 month') AS month`, eventPartitionsAhead)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i
+	JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = 'outbox_events'::regclass
+	ORDER BY c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []string
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		partitions = append(partitions, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+
+	var dropped []string
+
+	for _, name := range partitions {
+		// Names are what create_monthly_partition made them, outbox_events_pYYYYMM.
+		// The default partition and anything else doesn't parse and is left alone
+		suffix, ok := strings.CutPrefix(name, "outbox_events_p")
+		if !ok {
+			continue
+		}
+
+		month, err := time.Parse("200601", suffix)
+		if err != nil || !month.AddDate(0, 1, 0).Before(cutoff) {
+			continue
+		}
+
+		ok, err = m.dropPartition(ctx, name)
+		if err != nil {
+			return dropped, err
+		}
+		if ok {
+			dropped = append(dropped, name)
+		}
+	}
+
+	return dropped, nil
+}
+
+// dropPartition detaches and drops one partition of outbox_events, unless
+// it still holds unpublished events. The lock keeps the relay from
+// picking any of them up in between
+func (m OutboxModel) dropPartition(ctx context.Context, name string) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	table := pq.QuoteIdentifier(name)
+
+	_, err = tx.ExecContext(ctx, `LOCK TABLE `+table+` IN SHARE MODE`)
+	if err != nil {
+		return false, err
+	}
+
+	var pending bool
+
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+table+` WHERE published_at IS NULL)`).Scan(&pending)
+	if err != nil || pending {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `ALTER TABLE outbox_events DETACH PARTITION `+table)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DROP TABLE `+table)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
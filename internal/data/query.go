@@ -0,0 +1,112 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// query is a tiny SELECT builder so that optional filters can be appended
+// one by one without hand-numbering postgres placeholders.
+// Conditions are written with ? and get rewritten to $1, $2... in the order
+// they were added, so the args slice always lines up with the SQL.
+type query struct {
+	columns []string
+	from    string
+	conds   []string
+	orderBy []string
+	limit   int
+	offset  int
+	args    []any
+}
+
+func newQuery(from string, columns ...string) *query {
+	return &query{from: from, columns: columns}
+}
+
+// where adds a condition that is ANDed with the rest.
+// Every ? in cond consumes one value from args
+func (q *query) where(cond string, args ...any) *query {
+	q.conds = append(q.conds, q.bind(cond, args))
+	return q
+}
+
+// bind replaces every ? in s with the next positional placeholder
+// and records the matching arg
+func (q *query) bind(s string, args []any) string {
+	if strings.Count(s, "?") != len(args) {
+		// This can only happen because of a typo in our own code
+		panic(fmt.Sprintf("query: %d placeholders but %d args in %q", strings.Count(s, "?"), len(args), s))
+	}
+
+	var b strings.Builder
+	i := 0
+	for _, r := range s {
+		if r == '?' {
+			q.args = append(q.args, args[i])
+			fmt.Fprintf(&b, "$%d", len(q.args))
+			i++
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// order adds a column to ORDER BY. Column names can't be passed as args,
+// so they must come from a safelist (see Filters.sortColumn) and never
+// straight from the request
+func (q *query) order(column, direction string) *query {
+	q.orderBy = append(q.orderBy, fmt.Sprintf("%s %s", column, direction))
+	return q
+}
+
+// sort adds the sort keys of a Filters to ORDER BY
+func (q *query) sort(keys []sortKey) *query {
+	for _, key := range keys {
+		q.order(key.column, key.direction())
+	}
+	return q
+}
+
+// orderExpr adds an expression to ORDER BY, e.g. relevance that
+// depends on the search term. Same ? rules as in where
+func (q *query) orderExpr(expr string, args ...any) *query {
+	q.orderBy = append(q.orderBy, q.bind(expr, args))
+	return q
+}
+
+func (q *query) paginate(limit, offset int) *query {
+	q.limit = limit
+	q.offset = offset
+	return q
+}
+
+func (q *query) build() (string, []any) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "SELECT %s\nFROM %s", strings.Join(q.columns, ", "), q.from)
+
+	if len(q.conds) > 0 {
+		fmt.Fprintf(&b, "\nWHERE %s", strings.Join(q.conds, " AND "))
+	}
+
+	if len(q.orderBy) > 0 {
+		fmt.Fprintf(&b, "\nORDER BY %s", strings.Join(q.orderBy, ", "))
+	}
+
+	args := append([]any{}, q.args...)
+
+	// LIMIT and OFFSET are passed as args too, but they always go last
+	if q.limit > 0 {
+		args = append(args, q.limit)
+		fmt.Fprintf(&b, "\nLIMIT $%d", len(args))
+	}
+
+	if q.offset > 0 {
+		args = append(args, q.offset)
+		fmt.Fprintf(&b, " OFFSET $%d", len(args))
+	}
+
+	return b.String(), args
+}
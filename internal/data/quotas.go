@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Quota is how many requests a key may make per UTC day and month,
+// on top of the per-minute rate limits
+type Quota struct {
+	Daily   int64
+	Monthly int64
+}
+
+// APIKeyPlans are the plans a key can be on, new keys start on free
+var APIKeyPlans = map[string]Quota{
+	"free": {Daily: 1_000, Monthly: 10_000},
+	"pro":  {Daily: 100_000, Monthly: 2_000_000},
+}
+
+const DefaultAPIKeyPlan = "free"
+
+// quotaDay is the UTC day t falls on, usage is counted per day
+func quotaDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func quotaMonth(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+type QuotaModel struct {
+	DB *sql.DB
+}
+
+// Usage returns the requests a key made on day and in day's month so far
+func (m QuotaModel) Usage(keyID int64, day time.Time) (int64, int64, error) {
+	query := `SELECT
+		COALESCE(sum(requests) FILTER (WHERE day = $2), 0),
+		COALESCE(sum(requests), 0)
+	FROM api_key_usage
+	WHERE api_key_id = $1 AND day >= $3 AND day <= $2`
+
+	var daily, monthly int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, keyID, day, quotaMonth(day)).Scan(&daily, &monthly)
+	})
+
+	return daily, monthly, err
+}
+
+// AddUsage adds request counts to the keys' rows of day in one statement.
+// Keys revoked in the meantime are skipped by the join
+func (m QuotaModel) AddUsage(day time.Time, counts map[int64]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(counts))
+	requests := make([]int64, 0, len(counts))
+
+	for id, n := range counts {
+		ids = append(ids, id)
+		requests = append(requests, n)
+	}
+
+	stmt := `INSERT INTO api_key_usage (api_key_id, day, requests)
+	SELECT u.api_key_id, $1, u.requests
+	FROM unnest($2::bigint[], $3::bigint[]) AS u(api_key_id, requests)
+	JOIN api_keys ON api_keys.id = u.api_key_id
+	ON CONFLICT (api_key_id, day) DO UPDATE SET requests = api_key_usage.requests + EXCLUDED.requests`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, day, pq.Array(ids), pq.Array(requests))
+	return err
+}
+
+// QuotaResult says where a key stands after Allow. Limit, Remaining and
+// Reset are for whichever of the day and month runs out first
+type QuotaResult struct {
+	Allowed   bool
+	Monthly   bool // the monthly quota is the one that's used up
+	Limit     int64
+	Remaining int64
+	Reset     time.Duration
+}
+
+// UsageCounter counts requests per key in memory, a request doesn't cost
+// a write. The usage from the database is cached until the next Flush,
+// so with several replicas a key can go over its quota by what the others
+// counted since then
+type UsageCounter struct {
+	mu      sync.Mutex
+	cached  map[int64]*cachedUsage
+	pending map[time.Time]map[int64]int64 // counts not written yet, by day
+	quotas  QuotaStore
+}
+
+type cachedUsage struct {
+	day            time.Time
+	daily, monthly int64
+}
+
+func NewUsageCounter(quotas QuotaStore) *UsageCounter {
+	return &UsageCounter{
+		cached:  make(map[int64]*cachedUsage),
+		pending: make(map[time.Time]map[int64]int64),
+		quotas:  quotas,
+	}
+}
+
+// Allow counts a request of key if its plan's quotas have room for it.
+// Rejected requests aren't counted
+func (c *UsageCounter) Allow(key *APIKey, now time.Time) (QuotaResult, error) {
+	quota, ok := APIKeyPlans[key.Plan]
+	if !ok {
+		quota = APIKeyPlans[DefaultAPIKeyPlan]
+	}
+
+	day := quotaDay(now)
+
+	c.mu.Lock()
+	usage, ok := c.cached[key.ID]
+	c.mu.Unlock()
+
+	if !ok || !usage.day.Equal(day) {
+		daily, monthly, err := c.quotas.Usage(key.ID, day)
+		if err != nil {
+			return QuotaResult{}, err
+		}
+
+		c.mu.Lock()
+		// Another request could have loaded it while we were waiting
+		if usage, ok = c.cached[key.ID]; !ok || !usage.day.Equal(day) {
+			usage = &cachedUsage{day: day, daily: daily, monthly: monthly}
+
+			// Counts that haven't made it to the database yet
+			for d, counts := range c.pending {
+				if d.Equal(day) {
+					usage.daily += counts[key.ID]
+				}
+				if !d.After(day) && !d.Before(quotaMonth(day)) {
+					usage.monthly += counts[key.ID]
+				}
+			}
+
+			c.cached[key.ID] = usage
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := QuotaResult{
+		Limit:     quota.Daily,
+		Remaining: quota.Daily - usage.daily,
+		Reset:     day.AddDate(0, 0, 1).Sub(now),
+	}
+
+	if monthlyRemaining := quota.Monthly - usage.monthly; monthlyRemaining <= result.Remaining {
+		result.Monthly = true
+		result.Limit = quota.Monthly
+		result.Remaining = monthlyRemaining
+		result.Reset = quotaMonth(day).AddDate(0, 1, 0).Sub(now)
+	}
+
+	if result.Remaining <= 0 {
+		result.Remaining = 0
+		return result, nil
+	}
+
+	usage.daily++
+	usage.monthly++
+
+	if c.pending[day] == nil {
+		c.pending[day] = make(map[int64]int64)
+	}
+	c.pending[day][key.ID]++
+
+	result.Allowed = true
+	result.Remaining--
+
+	return result, nil
+}
+
+// Flush writes the pending counts and drops the cached usage, the next
+// request of every key reads what all replicas have written
+func (c *UsageCounter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[time.Time]map[int64]int64)
+	c.mu.Unlock()
+
+	for day, counts := range pending {
+		err := c.quotas.AddUsage(day, counts)
+		if err != nil {
+			// Put the counts back so they aren't lost, next flush will retry
+			c.mu.Lock()
+			for d, counts := range pending {
+				if c.pending[d] == nil {
+					c.pending[d] = make(map[int64]int64)
+				}
+				for id, n := range counts {
+					c.pending[d][id] += n
+				}
+			}
+			c.mu.Unlock()
+
+			return err
+		}
+
+		delete(pending, day)
+	}
+
+	c.mu.Lock()
+	c.cached = make(map[int64]*cachedUsage)
+	c.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GetRandom returns one random movie matching search.
+//
+// ORDER BY random() would sort the whole table, so instead we pick a random
+// id between the smallest and the largest matching one and take the first
+// matching movie from there. Both ends are covered by the min/max, so we
+// always find something if anything matches. Movies right after a gap in
+// ids come up a bit more often, which is fine for a "surprise me" button
+func (m MovieModel) GetRandom(search MovieSearch) (*Movie, error) {
+	query, args := m.randomQuery(search)
+
+	var movie Movie
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return scanMovie(m.DB.QueryRowContext(ctx, query, args...), &movie)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+func (m MovieModel) randomQuery(search MovieSearch) (string, []any) {
+	bounds, _ := m.applySearch(newQuery("movies", "min(id)", "max(id)"), search).build()
+
+	// Same search conditions in the same order bind to the same $N and args,
+	// so both parts of the statement can share them. LIMIT is added last
+	stmt, args := m.applySearch(newQuery("movies", movieColumns...), search).
+		where("id >= (SELECT id FROM pick)").
+		order("id", "ASC").
+		paginate(1, 0).
+		build()
+
+	// random() is volatile, so the CTE is evaluated exactly once
+	query := `WITH pick AS (
+		SELECT lo + floor(random() * (hi - lo + 1))::bigint AS id
+		FROM (` + bounds + `) AS b (lo, hi)
+	)
+	` + stmt
+
+	return query, args
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// addRedirect sends oldID to newID, and the ids that went to oldID along
+// with it. Nothing ever takes more than one hop
+func addRedirect(ctx context.Context, tx dbtx, oldID, newID, tenantID int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE movie_redirects SET new_id = $2 WHERE new_id = $1`, oldID, newID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO movie_redirects (old_id, new_id, tenant_id) VALUES ($1, $2, $3)
+	ON CONFLICT (old_id) DO UPDATE SET new_id = EXCLUDED.new_id, created_at = NOW()`
+
+	_, err = tx.ExecContext(ctx, stmt, oldID, newID, tenantID)
+	return err
+}
+
+// Redirect returns the id the movie with id lives at now,
+// ErrRecordNotFound when it didn't move
+func (m MovieModel) Redirect(id int64) (int64, error) {
+	query := `SELECT new_id FROM movie_redirects WHERE old_id = $1 AND tenant_id = $2`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	var newID int64
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, id, m.TenantID).Scan(&newID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return newID, nil
+}
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/tracing"
+	"greenlight.brainwhat/internal/validator"
+)
+
+// Kinds of release a movie can have in every country
+var ReleaseTypes = []string{"theatrical", "streaming", "physical"}
+
+// ISO 3166 alpha-2, e.g. DE
+var CountryRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Date is a day without a time, sent as 2006-01-02
+type Date time.Time
+
+var ErrInvalidDateFormat = errors.New("invalid date format, use YYYY-MM-DD")
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Time(d).Format(time.DateOnly))), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquoted, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	t, err := time.Parse(time.DateOnly, unquoted)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	*d = Date(t)
+
+	return nil
+}
+
+// Release is the day a movie comes out in one country
+type Release struct {
+	MovieID int64  `json:"movie_id"`
+	Country string `json:"country"`
+	Type    string `json:"type"`
+	Date    Date   `json:"date"`
+}
+
+// UpcomingRelease is a release together with its movie
+type UpcomingRelease struct {
+	Release
+	Movie *Movie `json:"movie"`
+}
+
+type ReleaseModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+func ValidateRelease(v *validator.Validator, release *Release) {
+	v.Check(validator.MatchesRX(release.Country, CountryRX), "country", "must be an ISO 3166 country code, e.g. DE")
+	v.Check(validator.PermittedValue(release.Type, ReleaseTypes...), "type", "must be one of "+strings.Join(ReleaseTypes, ", "))
+	v.Check(!time.Time(release.Date).IsZero(), "date", "must be provided")
+}
+
+// Set adds the release or moves its date if the movie already has one
+// of that type in the country. The movie has to belong to the tenant
+func (m ReleaseModel) Set(release *Release) error {
+	query := `INSERT INTO movie_releases (movie_id, country, type, date)
+	SELECT id, $2, $3, $4 FROM movies
+	WHERE id = $1 AND tenant_id = $5 AND deleted_at IS NULL
+	ON CONFLICT (movie_id, country, type) DO UPDATE SET date = EXCLUDED.date`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, release.MovieID, release.Country, release.Type, time.Time(release.Date), m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForMovie returns the releases ordered by date
+func (m ReleaseModel) GetAllForMovie(movieID int64) ([]*Release, error) {
+	query := `SELECT r.movie_id, r.country, r.type, r.date
+	FROM movie_releases r
+	JOIN movies ON movies.id = r.movie_id
+	WHERE r.movie_id = $1 AND movies.tenant_id = $2
+	ORDER BY r.date, r.country, r.type`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, movieID, m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []*Release{}
+
+	for rows.Next() {
+		var release Release
+
+		err := rows.Scan(&release.MovieID, &release.Country, &release.Type, (*time.Time)(&release.Date))
+		if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
+
+func (m ReleaseModel) Delete(movieID int64, country, releaseType string) error {
+	query := `DELETE FROM movie_releases
+	WHERE movie_id = $1 AND country = $2 AND type = $3
+	AND movie_id IN (SELECT id FROM movies WHERE tenant_id = $4)`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, country, releaseType, m.TenantID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Upcoming lists releases in the country from today until days from now,
+// soonest first. An empty releaseType means every type
+func (m ReleaseModel) Upcoming(country, releaseType string, days, limit int) ([]*UpcomingRelease, error) {
+	// movie_releases has no column named like one of movieColumns,
+	// so they don't need the movies. prefix
+	query := `SELECT r.movie_id, r.country, r.type, r.date, ` + strings.Join(movieColumns, ", ") + `
+	FROM movie_releases r
+	JOIN movies ON movies.id = r.movie_id
+	WHERE r.country = $1 AND ($2 = '' OR r.type = $2)
+	AND r.date >= CURRENT_DATE AND r.date < CURRENT_DATE + $3::integer
+	AND movies.tenant_id = $4 AND movies.deleted_at IS NULL
+	ORDER BY r.date, movies.id, r.type
+	LIMIT $5`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, country, releaseType, days, m.TenantID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []*UpcomingRelease{}
+
+	for rows.Next() {
+		release := UpcomingRelease{Movie: &Movie{}}
+
+		err := scanMovie(rows, release.Movie, &release.MovieID, &release.Country, &release.Type, (*time.Time)(&release.Date))
+		if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// How many times a read is tried in total before we give up
+const retryAttempts = 3
+
+// Number of retried queries, shows up in /debug/vars
+var retries = expvar.NewInt("db_retries")
+
+// Errors that go away on their own: the connection broke, the server
+// is restarting or failing over, or postgres asked us to try again
+func isTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Class() == "08": // connection_exception
+			return true
+		case pqErr.Code == "40001", pqErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return true
+		case pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		return false
+	}
+
+	var opErr *net.OpError
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &opErr)
+}
+
+// withRetry runs fn again when it fails with a transient error, waiting
+// a bit longer each time. fn must be safe to repeat, so only wrap reads.
+// Waiting stops early when ctx is done
+func withRetry(ctx context.Context, fn func() error) error {
+	var err error
+
+	for attempt := range retryAttempts {
+		if attempt > 0 {
+			retries.Add(1)
+
+			// Full jitter so that instances don't all retry at the same moment
+			wait := time.Duration(rand.Int64N(int64(50*time.Millisecond) << attempt))
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return err
+			}
+		}
+
+		err = fn()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// queryWithRetry is QueryContext with withRetry. Only starting the query is
+// retried, by the time rows are read the connection has just proven to work
+func queryWithRetry(ctx context.Context, db dbtx, query string, args ...any) (*sql.Rows, error) {
+	var rows *sql.Rows
+
+	err := withRetry(ctx, func() error {
+		var err error
+		rows, err = db.QueryContext(ctx, query, args...)
+		return err
+	})
+
+	return rows, err
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// Revision is a snapshot of a movie before and after an accepted update.
+// Version is the movie version the update produced
+type Revision struct {
+	ID        int64           `json:"id"`
+	MovieID   int64           `json:"movie_id"`
+	Version   int32           `json:"version"`
+	Old       json.RawMessage `json:"old"`
+	New       json.RawMessage `json:"new"`
+	Editor    string          `json:"editor"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type RevisionModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+// Revisions are written by MovieModel.Update in the same transaction
+// as the change itself, so there is no Insert here
+func (m RevisionModel) Get(movieID, id int64) (*Revision, error) {
+	query := `SELECT id, movie_id, version, old_data, new_data, editor, created_at
+	FROM movie_revisions
+	WHERE id = $1 AND movie_id = $2
+	AND movie_id IN (SELECT id FROM movies WHERE tenant_id = $3)`
+
+	var revision Revision
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, id, movieID, m.TenantID).Scan(
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Old,
+			&revision.New,
+			&revision.Editor,
+			&revision.CreatedAt,
+		)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+func (m RevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*Revision, Metadata, error) {
+	q := newQuery("movie_revisions", "count(*) OVER()", "id", "movie_id", "version", "old_data", "new_data", "editor", "created_at").
+		where("movie_id = ?", movieID).
+		where("movie_id IN (SELECT id FROM movies WHERE tenant_id = ?)", m.TenantID).
+		sort(filters.sortKeys()).
+		paginate(filters.limit(), filters.offset())
+
+	query, args := q.build()
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*Revision{}
+
+	for rows.Next() {
+		var revision Revision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Old,
+			&revision.New,
+			&revision.Editor,
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -3,13 +3,16 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Runtime int32
 
-var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
+// Handlers turn it into a validation error on "runtime"
+var ErrInvalidRuntimeFormat = errors.New(`must be minutes like 107, "107 mins" or "1h47m"`)
 
 func (r Runtime) MarshalJSON() ([]byte, error) {
 	jsonValue := fmt.Sprintf("%d mins", r)
@@ -20,24 +23,41 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+// UnmarshalJSON takes the minutes as a number (107), the way we send them
+// ("107 mins") or as a Go duration ("1h47m"). Anything that doesn't come
+// out as a whole number of minutes, like "1h47m30s" or 107.5, is rejected.
+// So is "1:47", it could be hours or minutes
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	s, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		// Not a string, so it has to be a plain number
+		s = string(jsonValue)
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	s = strings.TrimSpace(s)
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+	var minutes int64
+
+	if n, ok := strings.CutSuffix(s, " mins"); ok {
+		minutes, err = strconv.ParseInt(n, 10, 32)
+	} else if n, ok := strings.CutSuffix(s, " min"); ok {
+		minutes, err = strconv.ParseInt(n, 10, 32)
+	} else if minutes, err = strconv.ParseInt(s, 10, 32); err != nil {
+		var d time.Duration
+
+		d, err = time.ParseDuration(s)
+		if err == nil && d%time.Minute != 0 {
+			return ErrInvalidRuntimeFormat
+		}
+
+		minutes = int64(d / time.Minute)
 	}
 
-	i, err := strconv.ParseInt(parts[0], 10, 32)
-	if err != nil {
+	if err != nil || minutes > math.MaxInt32 {
 		return ErrInvalidRuntimeFormat
 	}
 
-	*r = Runtime(i)
+	*r = Runtime(minutes)
 
 	return nil
 }
@@ -0,0 +1,33 @@
+package data
+
+import "testing"
+
+// Whatever UnmarshalJSON accepts has to survive a trip through MarshalJSON
+func FuzzRuntimeUnmarshalJSON(f *testing.F) {
+	f.Add([]byte(`107`))
+	f.Add([]byte(`"107 mins"`))
+	f.Add([]byte(`"1 min"`))
+	f.Add([]byte(`"1h47m"`))
+	f.Add([]byte(`"1h47m30s"`))
+	f.Add([]byte(`107.5`))
+	f.Add([]byte(`"1:47"`))
+	f.Add([]byte(`"9999999999 mins"`))
+	f.Add([]byte(`"-5m"`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var r Runtime
+		if err := r.UnmarshalJSON(input); err != nil {
+			return
+		}
+
+		js, err := r.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var again Runtime
+		if err := again.UnmarshalJSON(js); err != nil || again != r {
+			t.Fatalf("%s came back from %s as %d (%v), want %d", input, js, again, err, r)
+		}
+	})
+}
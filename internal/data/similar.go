@@ -0,0 +1,57 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// GetSimilar ranks other movies by how many genres they share with
+// movie id, with release year proximity as a smaller second factor.
+// Only movies sharing at least one genre are considered
+func (m MovieModel) GetSimilar(id int64, limit int) ([]*Movie, error) {
+	query := `WITH target AS (
+		SELECT year AS target_year FROM movies WHERE id = $1 AND tenant_id = $3
+	),
+	shared AS (
+		SELECT b.movie_id, count(*) AS shared_genres
+		FROM movies_genres a
+		JOIN movies_genres b ON b.genre_id = a.genre_id
+		WHERE a.movie_id = $1 AND b.movie_id <> $1
+		GROUP BY b.movie_id
+	)
+	SELECT ` + strings.Join(movieColumns, ", ") + `
+	FROM movies
+	JOIN shared ON shared.movie_id = movies.id
+	CROSS JOIN target
+	WHERE movies.tenant_id = $3 AND movies.deleted_at IS NULL
+	ORDER BY shared.shared_genres * 2 + 1.0 / (1 + abs(movies.year - target.target_year) / 10.0) DESC, movies.id
+	LIMIT $2`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, id, limit, m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
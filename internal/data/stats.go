@@ -0,0 +1,157 @@
+package data
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// Trending windows and how many days they go back
+var TrendingWindows = map[string]int{
+	"day":   1,
+	"week":  7,
+	"month": 30,
+}
+
+type StatsModel struct {
+	DB       dbtx
+	TenantID int64
+	Deadline time.Time
+	Span     *tracing.Span
+}
+
+// AddViews adds view counts to today's row of every movie in one statement.
+// Movie ids are unique across tenants, so it doesn't need the tenant
+func (m StatsModel) AddViews(counts map[int64]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(counts))
+	views := make([]int64, 0, len(counts))
+
+	for id, n := range counts {
+		ids = append(ids, id)
+		views = append(views, n)
+	}
+
+	// Movies could've been purged since they were viewed,
+	// the join makes sure we don't trip over the foreign key
+	stmt := `INSERT INTO movie_stats (movie_id, day, views)
+	SELECT v.movie_id, CURRENT_DATE, v.views
+	FROM unnest($1::bigint[], $2::bigint[]) AS v(movie_id, views)
+	JOIN movies ON movies.id = v.movie_id
+	ON CONFLICT (movie_id, day) DO UPDATE SET views = movie_stats.views + EXCLUDED.views`
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, pq.Array(ids), pq.Array(views))
+	return err
+}
+
+// Trending returns the most viewed movies of the last days. The windows
+// of TrendingWindows are read from catalog_trending, refreshedAt says as of
+// when. Any other number of days is counted now and refreshedAt is zero
+func (m StatsModel) Trending(days, limit int) ([]*Movie, time.Time, error) {
+	query := `WITH views AS (
+		SELECT movie_id, sum(views) AS total
+		FROM movie_stats
+		WHERE day > CURRENT_DATE - $1::integer
+		GROUP BY movie_id
+	)
+	SELECT ` + strings.Join(movieColumns, ", ") + `
+	FROM movies
+	JOIN views ON views.movie_id = movies.id
+	WHERE movies.tenant_id = $3 AND movies.deleted_at IS NULL
+	ORDER BY views.total DESC, movies.id
+	LIMIT $2`
+
+	args := []any{days, limit, m.TenantID}
+
+	column, precomputed := catalogTrendingColumns[days]
+	if precomputed {
+		query = `SELECT ` + strings.Join(movieColumns, ", ") + `
+		FROM movies
+		JOIN catalog_trending t ON t.movie_id = movies.id
+		WHERE movies.tenant_id = $2 AND movies.deleted_at IS NULL AND t.` + column + ` > 0
+		ORDER BY t.` + column + ` DESC, movies.id
+		LIMIT $1`
+		args = []any{limit, m.TenantID}
+	}
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, args...)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := scanMovie(rows, &movie)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if !precomputed {
+		return movies, time.Time{}, nil
+	}
+
+	refreshedAt, err := catalogRefreshedAt(ctx, m.DB)
+	return movies, refreshedAt, err
+}
+
+// ViewBuffer counts views in memory so showing a movie doesn't cost
+// an extra write. Counts are written out whenever Flush is called
+type ViewBuffer struct {
+	mu     sync.Mutex
+	counts map[int64]int64
+	stats  StatsStore
+}
+
+func NewViewBuffer(stats StatsStore) *ViewBuffer {
+	return &ViewBuffer{counts: make(map[int64]int64), stats: stats}
+}
+
+func (b *ViewBuffer) Record(movieID int64) {
+	b.mu.Lock()
+	b.counts[movieID]++
+	b.mu.Unlock()
+}
+
+func (b *ViewBuffer) Flush() error {
+	b.mu.Lock()
+	counts := b.counts
+	b.counts = make(map[int64]int64)
+	b.mu.Unlock()
+
+	err := b.stats.AddViews(counts)
+	if err != nil {
+		// Put the counts back so they aren't lost, next flush will retry
+		b.mu.Lock()
+		for id, n := range b.counts {
+			counts[id] += n
+		}
+		b.counts = counts
+		b.mu.Unlock()
+	}
+
+	return err
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"strings"
+	"time"
+)
+
+// Suggestion is the bare minimum a type-ahead box needs to show a movie
+type Suggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+// likeEscaper makes user input safe to use as a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest matches titles by prefix first and falls back to trigram
+// similarity, so "godfa" and "godfahter" both find The Godfather.
+// Both conditions are served by the movies_title_trgm_idx index
+func (m MovieModel) Suggest(q string, limit int) ([]*Suggestion, error) {
+	query := `SELECT id, title, year
+	FROM movies
+	WHERE tenant_id = $4 AND deleted_at IS NULL AND (title ILIKE $1 OR title % $2)
+	ORDER BY title ILIKE $1 DESC, similarity(title, $2) DESC, title
+	LIMIT $3`
+
+	prefix := likeEscaper.Replace(q) + "%"
+
+	// Type-ahead results that come late are useless anyway
+	ctx, cancel := queryContext(m.Deadline, m.Span, time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query, prefix, q, limit, m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		var s Suggestion
+
+		err := rows.Scan(&s.ID, &s.Title, &s.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
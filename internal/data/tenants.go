@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+var ErrDuplicateTenant = errors.New("duplicate tenant")
+
+// DefaultTenantID is the tenant the 000014 migration moved the
+// existing catalog to. Requests that don't name a tenant end up here
+const (
+	DefaultTenantID   = 1
+	DefaultTenantSlug = "default"
+)
+
+// Tenant is one catalog. Movies, genres, collections and API keys belong
+// to exactly one tenant and the models never mix them up
+type Tenant struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	RateLimit int       `json:"rate_limit"` // requests per minute for the whole tenant, 0 is unlimited
+}
+
+// Slugs double as subdomains, so they have to be valid DNS labels
+var slugRX = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+func ValidateTenant(v *validator.Validator, tenant *Tenant) {
+	v.Check(tenant.Slug != "", "slug", "cannot be empty")
+	v.Check(len(tenant.Slug) <= 63, "slug", "must not be more than 63 characters")
+	v.Check(validator.MatchesRX(tenant.Slug, slugRX), "slug", "must only contain lowercase letters, digits and dashes")
+
+	v.Check(tenant.Name != "", "name", "cannot be empty")
+	v.Check(len(tenant.Name) <= 500, "name", "must not be more than 500 characters")
+
+	v.Check(tenant.RateLimit >= 0, "rate_limit", "must not be negative")
+	v.Check(tenant.RateLimit <= 1_000_000, "rate_limit", "must be a maximum of 1000000")
+}
+
+type TenantModel struct {
+	DB *sql.DB
+}
+
+func (m TenantModel) Insert(tenant *Tenant) error {
+	query := `INSERT INTO tenants (slug, name, rate_limit)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tenant.Slug, tenant.Name, tenant.RateLimit).Scan(&tenant.ID, &tenant.CreatedAt)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), `duplicate key value violates unique constraint "tenants_slug_key"`):
+			return ErrDuplicateTenant
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetBySlug runs on every request, so keep it to the one indexed lookup
+func (m TenantModel) GetBySlug(slug string) (*Tenant, error) {
+	query := `SELECT id, created_at, slug, name, rate_limit
+	FROM tenants
+	WHERE slug = $1`
+
+	var tenant Tenant
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := withRetry(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, slug).Scan(
+			&tenant.ID,
+			&tenant.CreatedAt,
+			&tenant.Slug,
+			&tenant.Name,
+			&tenant.RateLimit,
+		)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tenant, nil
+}
+
+func (m TenantModel) GetAll() ([]*Tenant, error) {
+	query := `SELECT id, created_at, slug, name, rate_limit
+	FROM tenants
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := queryWithRetry(ctx, m.DB, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []*Tenant{}
+
+	for rows.Next() {
+		var tenant Tenant
+
+		err := rows.Scan(
+			&tenant.ID,
+			&tenant.CreatedAt,
+			&tenant.Slug,
+			&tenant.Name,
+			&tenant.RateLimit,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tenants = append(tenants, &tenant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Deleted movies only get deleted_at set. Until the undo token expires
+// the client can bring the movie back with MovieModel.Restore
+type UndoToken struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	MovieID   int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+func generateUndoToken(movieID int64, ttl time.Duration) (*UndoToken, error) {
+	token := &UndoToken{
+		MovieID: movieID,
+		Expiry:  time.Now().Add(ttl),
+	}
+
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 16 random bytes give us a 26 character token
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	// We only store the hash so leaked db rows can't be used to restore movies
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func (m MovieModel) Delete(id int64, undoWindow time.Duration) (*UndoToken, error) {
+	if id < 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	token, err := generateUndoToken(id, undoWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE movies SET deleted_at = NOW() WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, id, m.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `INSERT INTO movie_undo_tokens (hash, movie_id, expiry) VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, stmt, token.Hash, token.MovieID, token.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertEvent(ctx, tx, EventMovieDeleted, movieEvent{ID: id, TenantID: m.TenantID})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
+}
+
+// Restore undoes a delete. Tokens are single use, and an unknown or
+// expired token results in ErrRecordNotFound
+func (m MovieModel) Restore(tokenPlaintext string) (*Movie, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := queryContext(m.Deadline, m.Span, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var movieID int64
+
+	// A token of another tenant's movie stays where it is
+	query := `DELETE FROM movie_undo_tokens t
+	USING movies
+	WHERE t.hash = $1 AND t.expiry > NOW() AND movies.id = t.movie_id AND movies.tenant_id = $2
+	RETURNING t.movie_id`
+
+	err = tx.QueryRowContext(ctx, query, hash[:], m.TenantID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `UPDATE movies SET deleted_at = NULL
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	RETURNING ` + strings.Join(movieColumns, ", ")
+
+	var movie Movie
+
+	err = scanMovie(tx.QueryRowContext(ctx, query, movieID), &movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = insertEvent(ctx, tx, EventMovieRestored, movieEvent{ID: movie.ID, Version: movie.Version, TenantID: m.TenantID})
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, tx.Commit()
+}
+
+// DeleteExpiredUndoTokens removes tokens that can't be used anymore.
+// Restore already ignores them, this just keeps the table small.
+// It cleans up after every tenant
+func (m MovieModel) DeleteExpiredUndoTokens(ctx context.Context) (int64, error) {
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_undo_tokens WHERE expiry < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// PurgeDeleted removes movies that have been in the trash for longer than
+// retention for good. Revisions, stats and the rest go with them (ON DELETE CASCADE).
+// Like DeleteExpiredUndoTokens it works across tenants
+func (m MovieModel) PurgeDeleted(ctx context.Context, retention time.Duration) (int64, error) {
+	query := `DELETE FROM movies WHERE deleted_at < NOW() - make_interval(secs => $1)`
+
+	result, err := m.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// dbtx is what the tenant models run their statements on: the connection
+// pool, or the transaction of one request when row-level security is on.
+// Both *sql.DB and *sql.Tx implement it
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// tx is a transaction a model method commits or rolls back on its own
+type tx interface {
+	dbtx
+	Commit() error
+	Rollback() error
+}
+
+// beginTx starts a transaction on db. Inside a request transaction it's a
+// savepoint instead, so a model call that fails doesn't take the rest of
+// the request down with it
+func beginTx(ctx context.Context, db dbtx) (tx, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		return db.BeginTx(ctx, nil)
+	case *sql.Tx:
+		_, err := db.ExecContext(ctx, "SAVEPOINT model")
+		if err != nil {
+			return nil, err
+		}
+		return &savepoint{Tx: db, ctx: ctx}, nil
+	default:
+		panic(fmt.Sprintf("beginTx: unexpected %T", db))
+	}
+}
+
+// savepoint behaves like a *sql.Tx for the model methods, Rollback after
+// Commit is a no-op just like there
+type savepoint struct {
+	*sql.Tx
+	ctx  context.Context
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	return s.finish("RELEASE SAVEPOINT model")
+}
+
+func (s *savepoint) Rollback() error {
+	return s.finish("ROLLBACK TO SAVEPOINT model")
+}
+
+func (s *savepoint) finish(stmt string) error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+
+	_, err := s.Tx.ExecContext(s.ctx, stmt)
+	return err
+}
+
+// BeginTenantTx starts a transaction that the row-level security policies
+// limit to one tenant. set_config with is_local is SET LOCAL that takes
+// a parameter, the setting is gone once the transaction ends
+func BeginTenantTx(ctx context.Context, db *sql.DB, tenantID int64) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT set_config('greenlight.tenant_id', $1, true)`, strconv.FormatInt(tenantID, 10))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/validator"
+)
+
+// DefaultGenreVocabulary is what migration 000023 seeds genre_vocabulary
+// with. The memory models have no table and use it as it is
+var DefaultGenreVocabulary = []string{
+	"action", "adventure", "animation", "biography", "comedy",
+	"crime", "documentary", "drama", "family", "fantasy",
+	"film-noir", "history", "horror", "music", "musical",
+	"mystery", "romance", "sci-fi", "short", "sport",
+	"thriller", "war", "western",
+}
+
+// LoadGenreVocabulary reads the allowed genres, sorted by name
+func LoadGenreVocabulary(db *sql.DB) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `SELECT name FROM genre_vocabulary ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vocabulary []string
+
+	for rows.Next() {
+		var name string
+
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		vocabulary = append(vocabulary, name)
+	}
+
+	return vocabulary, rows.Err()
+}
+
+// ValidateGenreVocabulary rejects genres that aren't in vocabulary. Typos
+// like "scifi" or "Drama" get the genres they were probably meant to be
+func ValidateGenreVocabulary(v *validator.Validator, genres, vocabulary []string) {
+	for _, genre := range genres {
+		if slices.Contains(vocabulary, genre) {
+			continue
+		}
+
+		message := fmt.Sprintf("unknown genre %q", genre)
+		if near := nearGenres(genre, vocabulary); len(near) > 0 {
+			message += fmt.Sprintf(", did you mean %s?", strings.Join(near, " or "))
+		}
+
+		v.AddError("genres", message)
+	}
+}
+
+// nearGenres returns up to 3 genres of vocabulary a few edits away from
+// genre, closest first. Case doesn't count as an edit
+func nearGenres(genre string, vocabulary []string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	genre = strings.ToLower(genre)
+
+	// Short names can't take as many edits and still mean the same
+	maxDistance := max(1, len([]rune(genre))/3)
+
+	var candidates []candidate
+	for _, name := range vocabulary {
+		if d := editDistance(genre, name); d <= maxDistance {
+			candidates = append(candidates, candidate{name, d})
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return a.distance - b.distance
+	})
+
+	var near []string
+	for _, c := range candidates[:min(len(candidates), 3)] {
+		near = append(near, fmt.Sprintf("%q", c.name))
+	}
+
+	return near
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
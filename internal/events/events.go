@@ -0,0 +1,59 @@
+// Package events publishes our domain events to downstream consumers.
+//
+// Every event is sent as one JSON message:
+//
+//	{
+//	  "id": 1234,                       // unique and increasing, use it to dedupe
+//	  "type": "movie.updated",          // see below
+//	  "time": "2024-05-01T12:00:00Z",   // when the change was committed
+//	  "data": {"id": 42, "version": 7}  // depends on the type
+//	}
+//
+// Types and their data:
+//
+//	movie.created   {"id": movie id, "version": movie version}
+//	movie.updated   {"id": movie id, "version": movie version}
+//	movie.deleted   {"id": movie id}
+//	movie.restored  {"id": movie id, "version": movie version}
+//
+// Delivery is at least once, so the same event can arrive twice.
+// Events about one movie arrive in order, between movies there is no
+// ordering guarantee. Consumers that need the full movie fetch it from
+// GET /v1/movies/{id}. New fields may be added to data at any time.
+//
+// NATS subjects are "greenlight.<type>", e.g. greenlight.movie.updated.
+// Kafka records all go to one topic, keyed by the movie id. We produce
+// through a Kafka REST Proxy, record values are the JSON above.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Event struct {
+	ID   int64           `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Key groups events about the same thing, brokers use it to keep them ordered.
+// Every event we have so far has the id of its movie in data
+func (e *Event) Key() string {
+	var data struct {
+		ID int64 `json:"id"`
+	}
+
+	// Data is always written by us, a broken one is just not keyed
+	_ = json.Unmarshal(e.Data, &data)
+
+	return fmt.Sprint(data.ID)
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+	Close() error
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	kafkaContentType = "application/vnd.kafka.json.v2+json"
+	kafkaAccept      = "application/vnd.kafka.v2+json"
+)
+
+// KafkaPublisher produces through a Kafka REST Proxy (Confluent's REST API v2),
+// so we don't need a Kafka client library and its protocol handling
+type KafkaPublisher struct {
+	url    string
+	topic  string
+	client *http.Client
+}
+
+// NewKafkaPublisher doesn't connect yet, the first Publish does
+func NewKafkaPublisher(restURL, topic string) *KafkaPublisher {
+	return &KafkaPublisher{
+		url:    strings.TrimSuffix(restURL, "/"),
+		topic:  topic,
+		client: &http.Client{},
+	}
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value *Event `json:"value"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		ErrorCode int    `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Publish sends one record keyed by the movie id. Records with the same key
+// go to the same partition, that's what keeps events about one movie in order
+func (p *KafkaPublisher) Publish(ctx context.Context, event *Event) error {
+	js, err := json.Marshal(map[string]any{
+		"records": []kafkaRecord{{Key: event.Key(), Value: event}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+"/topics/"+p.topic, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", kafkaAccept)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka: producing to %s: %s: %s", p.topic, res.Status, bytes.TrimSpace(body))
+	}
+
+	// The proxy answers 200 even when a single record failed
+	var produced kafkaProduceResponse
+
+	err = json.Unmarshal(body, &produced)
+	if err != nil {
+		return err
+	}
+
+	for _, offset := range produced.Offsets {
+		if offset.ErrorCode != 0 {
+			return fmt.Errorf("kafka: producing to %s: %d %s", p.topic, offset.ErrorCode, offset.Error)
+		}
+	}
+
+	return nil
+}
+
+func (p *KafkaPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,20 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogPublisher only logs events. It's the default when no broker is configured
+type LogPublisher struct {
+	Logger *slog.Logger
+}
+
+func (p LogPublisher) Publish(ctx context.Context, event *Event) error {
+	p.Logger.Info("event", "id", event.ID, "type", event.Type, "data", string(event.Data))
+	return nil
+}
+
+func (p LogPublisher) Close() error {
+	return nil
+}
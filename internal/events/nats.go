@@ -0,0 +1,182 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSPublisher speaks just enough of the NATS text protocol to publish:
+// CONNECT once, then PUB followed by PING. The server answers PONG only
+// after it processed everything before it, so a PONG means the event is in.
+// A broken connection is dropped and dialed again on the next Publish
+type NATSPublisher struct {
+	url *url.URL
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewNATSPublisher(rawURL string) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS url %q, must look like nats://host:4222", rawURL)
+	}
+
+	p := &NATSPublisher{url: u}
+
+	// Fail at startup if the server isn't there at all
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *NATSPublisher) connect(ctx context.Context) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", p.url.Host)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	// Server always starts with INFO {...}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	options := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "greenlight",
+		"lang":     "go",
+		"protocol": 0,
+	}
+	if p.url.User != nil {
+		options["user"] = p.url.User.Username()
+		options["pass"], _ = p.url.User.Password()
+	}
+
+	js, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn = conn
+	p.reader = reader
+
+	// PING right after CONNECT surfaces auth errors as -ERR
+	err = p.roundTrip("CONNECT " + string(js) + "\r\nPING\r\n")
+	if err != nil {
+		p.drop()
+		return err
+	}
+
+	return nil
+}
+
+// roundTrip writes msg, which has to end with PING, and waits for the PONG
+func (p *NATSPublisher) roundTrip(msg string) error {
+	_, err := p.conn.Write([]byte(msg))
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			_, err = p.conn.Write([]byte("PONG\r\n"))
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// Anything else (+OK, INFO updates) doesn't matter to us
+	}
+}
+
+func (p *NATSPublisher) drop() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = nil
+	p.reader = nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, event *Event) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		err = p.connect(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	p.conn.SetDeadline(deadline)
+
+	msg := fmt.Sprintf("PUB greenlight.%s %d\r\n%s\r\nPING\r\n", event.Type, len(js), js)
+
+	err = p.roundTrip(msg)
+	if err != nil {
+		p.drop()
+		return err
+	}
+
+	return nil
+}
+
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drop()
+	return nil
+}
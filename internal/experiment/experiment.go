@@ -0,0 +1,138 @@
+// Package experiment splits clients into the variants of A/B experiments.
+// Assignment is a hash of the experiment and the client, so a client
+// stays in its variant across requests and instances without any state.
+// Requests per variant are published with expvar under "experiments"
+package experiment
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Experiment struct {
+	Name     string
+	Variants []Variant
+}
+
+// Variant gets Weight out of the sum of all weights of the clients
+type Variant struct {
+	Name   string
+	Weight int
+}
+
+var nameRX = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Parse reads an experiment in the flag format,
+// e.g. similar-ranking=control:90,year-boost:10
+func Parse(s string) (Experiment, error) {
+	name, variants, ok := strings.Cut(s, "=")
+	if !ok || !nameRX.MatchString(name) {
+		return Experiment{}, fmt.Errorf("experiment %q: want name=variant:weight,variant:weight", s)
+	}
+
+	e := Experiment{Name: name}
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(variants, ",") {
+		variant, weight, ok := strings.Cut(part, ":")
+		if !ok || !nameRX.MatchString(variant) {
+			return Experiment{}, fmt.Errorf("experiment %s: invalid variant %q", name, part)
+		}
+
+		w, err := strconv.Atoi(weight)
+		if err != nil || w <= 0 {
+			return Experiment{}, fmt.Errorf("experiment %s: weight of %s must be a positive integer", name, variant)
+		}
+
+		if seen[variant] {
+			return Experiment{}, fmt.Errorf("experiment %s: duplicate variant %s", name, variant)
+		}
+		seen[variant] = true
+
+		e.Variants = append(e.Variants, Variant{Name: variant, Weight: w})
+	}
+
+	if len(e.Variants) < 2 {
+		return Experiment{}, errors.New("experiment " + name + ": needs at least two variants")
+	}
+
+	return e, nil
+}
+
+// Assign picks the variant of subject, e.g. an API key id. The experiment
+// name goes into the hash too, otherwise the same clients would end up in
+// the first variant of every experiment
+func (e Experiment) Assign(subject string) string {
+	h := fnv.New64a()
+	h.Write([]byte(e.Name + ":" + subject))
+
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+
+	n := int(h.Sum64() % uint64(total))
+
+	for _, v := range e.Variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+
+	// Can't happen, n is always below total
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+var stats = expvar.NewMap("experiments")
+
+// variantStats implements expvar.Var
+type variantStats struct {
+	mu       sync.Mutex
+	requests int64
+	errors   int64
+	sum      time.Duration
+}
+
+func (s *variantStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	js, _ := json.Marshal(map[string]any{
+		"requests": s.requests,
+		"errors":   s.errors,
+		"sum_ms":   float64(s.sum) / float64(time.Millisecond),
+	})
+
+	return string(js)
+}
+
+// Record counts one request served in a variant. Keys look like
+// similar-ranking/control in /debug/vars
+func Record(experiment, variant string, d time.Duration, failed bool) {
+	key := experiment + "/" + variant
+
+	s, ok := stats.Get(key).(*variantStats)
+	if !ok {
+		// Same race as in sqlstats, a few early requests might get lost
+		s = &variantStats{}
+		stats.Set(key, s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	s.sum += d
+	if failed {
+		s.errors++
+	}
+}
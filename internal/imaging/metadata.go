@@ -0,0 +1,96 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMalformed = errors.New("imaging: malformed image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// StripMetadata removes EXIF, XMP, IPTC and text metadata (camera, GPS
+// position, author...) without re-encoding, so the pixels stay exactly
+// the same. format is what image.DecodeConfig reported
+func StripMetadata(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "jpeg":
+		return stripJPEG(data)
+	case "png":
+		return stripPNG(data)
+	default:
+		return nil, ErrMalformed
+	}
+}
+
+// JPEG is a list of FF xx segments with a two byte length, up to
+// the start of scan. After that comes image data, which is copied as is
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrMalformed
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, ErrMalformed
+		}
+
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+
+		if length < 2 || i+2+length > len(data) {
+			return nil, ErrMalformed
+		}
+
+		// Start of scan, the rest is image data
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+
+		// APP1 holds EXIF and XMP, APP13 IPTC, COM free text.
+		// APP0 (JFIF), APP2 (ICC color profile) and APP14 (Adobe) are kept
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out.Write(data[i : i+2+length])
+		}
+
+		i += 2 + length
+	}
+}
+
+// PNG is a signature and chunks of length, type, data and CRC
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrMalformed
+	}
+
+	dropped := map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+
+		if end > len(data) {
+			return nil, ErrMalformed
+		}
+
+		if !dropped[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+
+		i = end
+	}
+
+	return out.Bytes(), nil
+}
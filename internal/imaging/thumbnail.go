@@ -0,0 +1,54 @@
+// Package imaging has the little bit of image processing the API needs,
+// built on the standard library's image packages
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// Thumbnail scales src down to width, keeping the aspect ratio. Every
+// output pixel is the average of the source pixels it covers, which
+// looks fine for downscaling. Images narrower than width keep their size.
+// The result is opaque, transparent parts end up white since the
+// variants are stored as JPEG
+func Thumbnail(src image.Image, width int) *image.RGBA {
+	b := src.Bounds()
+
+	width = min(width, b.Dx())
+	height := max(1, b.Dy()*width/b.Dx())
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		sy0 := b.Min.Y + y*b.Dy()/height
+		sy1 := max(b.Min.Y+(y+1)*b.Dy()/height, sy0+1)
+
+		for x := range width {
+			sx0 := b.Min.X + x*b.Dx()/width
+			sx1 := max(b.Min.X+(x+1)*b.Dx()/width, sx0+1)
+
+			var r, g, bl, a, n uint64
+
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			// Colors are premultiplied, adding the missing alpha puts them on white
+			white := 0xffff - a/n
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r/n + white),
+				G: uint16(g/n + white),
+				B: uint16(bl/n + white),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,275 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema (draft 2020-12) our request schemas use: type, properties,
+// required, additionalProperties, items, enum, minimum, maximum,
+// minLength, maxLength, pattern, minItems, maxItems and uniqueItems.
+// Keywords outside of that are ignored, so don't rely on them
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Schema struct {
+	Type                 types              `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	UniqueItems          bool               `json:"uniqueItems"`
+
+	pattern *regexp.Regexp
+}
+
+// types is "type", which can be one type or a list of them
+type types []string
+
+func (t *types) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*t = types{one}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// additional is additionalProperties, either false or a schema
+// every property not listed in properties has to match
+type additional struct {
+	forbidden bool
+	schema    *Schema
+}
+
+func (a *additional) UnmarshalJSON(b []byte) error {
+	var allowed bool
+	if json.Unmarshal(b, &allowed) == nil {
+		a.forbidden = !allowed
+		return nil
+	}
+
+	return json.Unmarshal(b, &a.schema)
+}
+
+// Parse reads a schema and compiles its patterns
+func Parse(b []byte) (*Schema, error) {
+	var s Schema
+
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		rx, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = rx
+	}
+
+	children := []*Schema{s.Items}
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	if s.AdditionalProperties != nil {
+		children = append(children, s.AdditionalProperties.schema)
+	}
+
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+
+		err := child.compile()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the JSON in doc. Errors are keyed by the JSON pointer
+// of the offending value, e.g. "/genres/3": "expected string". The whole
+// document is "/". Nil means doc is valid, invalid JSON is an error
+func (s *Schema) Validate(doc []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+
+	var value any
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(map[string]string)
+	s.validate(value, "", errs)
+
+	if len(errs) == 0 {
+		return nil, nil
+	}
+
+	return errs, nil
+}
+
+func (s *Schema) validate(value any, pointer string, errs map[string]string) {
+	fail := func(format string, args ...any) {
+		key := pointer
+		if key == "" {
+			key = "/"
+		}
+		// First problem wins, that's usually the most useful one
+		if _, ok := errs[key]; !ok {
+			errs[key] = fmt.Sprintf(format, args...)
+		}
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return is(value, t) }) {
+		fail("expected %s", strings.Join(s.Type, " or "))
+		return
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return equal(value, e) }) {
+		fail("must be one of %s", enumList(s.Enum))
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("must not be more than %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.Pattern)
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must not have more than %d items", *s.MaxItems)
+		}
+		if s.UniqueItems {
+			for i := range v {
+				for j := range i {
+					if equal(v[i], v[j]) {
+						fail("items must be unique")
+					}
+				}
+			}
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, pointer+"/"+strconv.Itoa(i), errs)
+			}
+		}
+
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs[pointer+"/"+escape(name)] = "is required"
+			}
+		}
+
+		for name, property := range v {
+			child := pointer + "/" + escape(name)
+
+			switch {
+			case s.Properties[name] != nil:
+				s.Properties[name].validate(property, child, errs)
+			case s.AdditionalProperties == nil:
+			case s.AdditionalProperties.forbidden:
+				errs[child] = "is not allowed"
+			case s.AdditionalProperties.schema != nil:
+				s.AdditionalProperties.schema.validate(property, child, errs)
+			}
+		}
+	}
+}
+
+// is reports if value is of the JSON Schema type t
+func is(value any, t string) bool {
+	switch v := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case json.Number:
+		if t == "number" {
+			return true
+		}
+		_, err := v.Int64()
+		return t == "integer" && err == nil
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+
+	return false
+}
+
+// equal compares decoded JSON values. Numbers from the schema are float64,
+// the ones from the document json.Number
+func equal(a, b any) bool {
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+
+	return bytes.Equal(aj, bj)
+}
+
+func enumList(enum []any) string {
+	values := make([]string, 0, len(enum))
+	for _, e := range enum {
+		js, _ := json.Marshal(e)
+		values = append(values, string(js))
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// escape encodes a property name for a JSON pointer (RFC 6901)
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,235 @@
+// Package migrate applies the SQL migrations to PostgreSQL.
+// It keeps its state in the same schema_migrations table golang-migrate
+// uses, so databases migrated with either tool can be handled by the other
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Only one migrator may run at a time, this is the advisory lock it holds
+const lockKey = 7213570812
+
+var ErrDirty = errors.New("migrate: database is dirty, a previous migration failed halfway and has to be fixed by hand")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration // sorted by version
+}
+
+// New reads NNNNNN_name.up.sql and NNNNNN_name.down.sql pairs from fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, file := range files {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migrate: unexpected file name %s", file)
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: unexpected file name %s", file)
+		}
+
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrator := &Migrator{DB: db}
+	for _, m := range byVersion {
+		migrator.Migrations = append(migrator.Migrations, *m)
+	}
+
+	slices.SortFunc(migrator.Migrations, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+
+	return migrator, nil
+}
+
+// Latest returns the version of the newest migration, the one Up ends at
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Version returns the last applied migration, 0 when there is none
+func (m *Migrator) Version(ctx context.Context) (version int64, dirty bool, err error) {
+	err = m.ensureTable(ctx, m.DB)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return currentVersion(ctx, m.DB)
+}
+
+// Up applies every migration newer than the current version and
+// returns how many it applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+
+	err := m.locked(ctx, func(conn *sql.Conn, current int64) error {
+		for _, migration := range m.Migrations {
+			if migration.Version <= current {
+				continue
+			}
+
+			err := apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: %s up: %w", migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last n applied migrations and returns how many it rolled back
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	rolledBack := 0
+
+	err := m.locked(ctx, func(conn *sql.Conn, current int64) error {
+		for i := len(m.Migrations) - 1; i >= 0 && rolledBack < n; i-- {
+			migration := m.Migrations[i]
+			if migration.Version > current {
+				continue
+			}
+
+			// The version left behind is the one before this migration
+			var previous int64
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+
+			err := apply(ctx, conn, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migrate: %s down: %w", migration.Name, err)
+			}
+			rolledBack++
+		}
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// locked runs fn on one connection while holding the advisory lock
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, current int64) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	err = m.ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return ErrDirty
+	}
+
+	return fn(conn, current)
+}
+
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, db execQuerier) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`)
+	return err
+}
+
+func currentVersion(ctx context.Context, db execQuerier) (version int64, dirty bool, err error) {
+	err = db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// apply runs one migration and records the new version in the same
+// transaction, so a failed migration leaves nothing behind.
+// golang-migrate stores no row at all for version 0
+func apply(ctx context.Context, conn *sql.Conn, stmt string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
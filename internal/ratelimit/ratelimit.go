@@ -0,0 +1,117 @@
+// Package ratelimit counts requests per key with a sliding window.
+// The window is approximated with two fixed windows: everything from the
+// current one plus the part of the previous one that still overlaps,
+// e.g. 30s into a 1m window the previous minute counts half
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type Policy struct {
+	Limit  int // requests allowed per Window
+	Window time.Duration
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration // until the current fixed window ends
+}
+
+// Store decides whether one more request for key fits the policy.
+// Keys must include the policy, the same key can't be used with two of them
+type Store interface {
+	Allow(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// evaluate is the sliding window math shared by every Store.
+// start is where the current fixed window began, current doesn't
+// include the request being decided on yet
+func evaluate(now, start time.Time, previous, current int, p Policy) Result {
+	overlap := 1 - float64(now.Sub(start))/float64(p.Window)
+	used := int(math.Floor(float64(previous)*overlap)) + current
+
+	r := Result{
+		Allowed: used < p.Limit,
+		Limit:   p.Limit,
+		Reset:   start.Add(p.Window).Sub(now),
+	}
+
+	if r.Allowed {
+		used++
+	}
+	r.Remaining = max(p.Limit-used, 0)
+
+	return r
+}
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	start    time.Time
+	length   time.Duration
+	previous int
+	current  int
+}
+
+// NewMemoryStore keeps the counters in this process. With several replicas
+// every one of them allows the full limit
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{windows: make(map[string]*window), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, p Policy) (Result, error) {
+	now := time.Now()
+	start := now.Truncate(p.Window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	w, ok := s.windows[key]
+	if !ok {
+		w = &window{start: start, length: p.Window}
+		s.windows[key] = w
+	}
+
+	switch start.Sub(w.start) {
+	case 0:
+	case p.Window:
+		w.previous, w.current = w.current, 0
+	default: // idle for longer than a window
+		w.previous, w.current = 0, 0
+	}
+	w.start = start
+
+	r := evaluate(now, start, w.previous, w.current, p)
+	if r.Allowed {
+		w.current++
+	}
+
+	return r, nil
+}
+
+// sweep drops counters that can't affect any decision anymore,
+// otherwise every client that ever showed up would stay in memory
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= 2*w.length {
+			delete(s.windows, key)
+		}
+	}
+}
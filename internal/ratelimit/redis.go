@@ -0,0 +1,279 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slidingWindowScript does the check and the increment in one step, so
+// replicas can't both take the last request of a window. The math is
+// the same as in evaluate, overlap comes in already computed.
+// Counters expire once they're too old to be the previous window
+const slidingWindowScript = `
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local used = math.floor(previous * tonumber(ARGV[2])) + current
+if used < tonumber(ARGV[1]) then
+	redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {previous, current}
+`
+
+// RedisStore keeps the counters in Redis, so every replica enforces the
+// same limit. It speaks just enough RESP to run the script, over a small
+// pool of connections so one slow reply doesn't hold up every request.
+// Connections are dialed when first needed, a broken one is dropped and
+// dialed again on the next call that gets its slot.
+// Windows are based on each replica's clock, they're expected to be in sync
+type RedisStore struct {
+	url     *url.URL
+	timeout time.Duration
+
+	// One slot per connection, nil until it's dialed. A call holds its
+	// slot for the whole round trip
+	conns chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisStore takes redis://[user:password@]host:6379[/db] and opens
+// up to size connections. timeout caps every call including the wait for
+// a connection, requests wait on it when Redis is down
+func NewRedisStore(rawURL string, size int, timeout time.Duration) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis url %q, must look like redis://host:6379", rawURL)
+	}
+
+	s := &RedisStore{url: u, timeout: timeout, conns: make(chan *redisConn, size)}
+
+	// Fail at startup if the server isn't there at all
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.conns <- c
+	for range size - 1 {
+		s.conns <- nil
+	}
+
+	return s, nil
+}
+
+func (s *RedisStore) connect(ctx context.Context) (*redisConn, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", s.url.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if s.url.User != nil {
+		password, _ := s.url.User.Password()
+
+		args := []string{"AUTH", password}
+		if user := s.url.User.Username(); user != "" {
+			args = []string{"AUTH", user, password}
+		}
+
+		_, err = c.do(args...)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if db := strings.TrimPrefix(s.url.Path, "/"); db != "" {
+		_, err = c.do("SELECT", db)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Without AUTH or SELECT nothing has been sent yet
+	_, err = c.do("PING")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, p Policy) (Result, error) {
+	now := time.Now()
+	start := now.Truncate(p.Window)
+	overlap := 1 - float64(now.Sub(start))/float64(p.Window)
+
+	windowMs := p.Window.Milliseconds()
+	currentKey := fmt.Sprintf("ratelimit:%s:%d", key, start.UnixMilli())
+	previousKey := fmt.Sprintf("ratelimit:%s:%d", key, start.UnixMilli()-windowMs)
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var c *redisConn
+
+	select {
+	case c = <-s.conns:
+	case <-ctx.Done():
+		return Result{}, fmt.Errorf("redis: waiting for a connection: %w", ctx.Err())
+	}
+
+	// Whatever happens the slot goes back, with nil if the connection broke
+	defer func() { s.conns <- c }()
+
+	if c == nil {
+		var err error
+		c, err = s.connect(ctx)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	reply, err := c.do("EVAL", slidingWindowScript, "2", currentKey, previousKey,
+		strconv.Itoa(p.Limit),
+		strconv.FormatFloat(overlap, 'g', -1, 64),
+		strconv.FormatInt(2*windowMs, 10),
+	)
+	if err != nil {
+		// Redis errors leave the connection usable, anything else may not
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			c.conn.Close()
+			c = nil
+		}
+		return Result{}, err
+	}
+
+	counts, ok := reply.([]any)
+	if !ok || len(counts) != 2 {
+		return Result{}, fmt.Errorf("redis: unexpected script reply %v", reply)
+	}
+
+	previous, _ := counts[0].(int64)
+	current, _ := counts[1].(int64)
+
+	return evaluate(now, start, int(previous), int(current), p), nil
+}
+
+// Close closes the connections, waiting for the calls that are using one
+func (s *RedisStore) Close() error {
+	for range cap(s.conns) {
+		if c := <-s.conns; c != nil {
+			c.conn.Close()
+		}
+	}
+	return nil
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends one command and reads its reply. Replies come back as
+// string, int64, []any, nil or a redisError
+func (c *redisConn) do(args ...string) (any, error) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := io.WriteString(c.conn, b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+
+	if redisErr, ok := reply.(redisError); ok {
+		return nil, redisErr
+	}
+
+	return reply, nil
+}
+
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch prefix, rest := line[0], line[1:]; prefix {
+	case '+':
+		return rest, nil
+	case '-':
+		return redisError(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err // $-1 is a nil reply
+		}
+
+		buf := make([]byte, n+2) // value and \r\n
+		_, err = io.ReadFull(c.reader, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		items := make([]any, n)
+		for i := range items {
+			items[i], err = c.readReply()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
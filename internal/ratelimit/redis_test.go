@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers PING and runs the script as if the counters were
+// empty. Scripts on a key with "slow" in it take a while
+func fakeRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+
+					switch args[0] {
+					case "PING":
+						conn.Write([]byte("+PONG\r\n"))
+					case "EVAL":
+						if strings.Contains(args[3], "slow") {
+							time.Sleep(300 * time.Millisecond)
+						}
+						conn.Write([]byte("*2\r\n:0\r\n:0\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	return "redis://" + l.Addr().String()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2) // value and \r\n
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
+
+func TestRedisStoreSlowReply(t *testing.T) {
+	url := fakeRedis(t)
+	policy := Policy{Limit: 10, Window: time.Minute}
+
+	s, err := NewRedisStore(url, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	slow := make(chan error)
+	go func() {
+		_, err := s.Allow(context.Background(), "slow", policy)
+		slow <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The slow call has one connection, the other one is free
+	start := time.Now()
+	result, err := s.Allow(context.Background(), "fast", policy)
+	if err != nil || !result.Allowed {
+		t.Fatalf("got %+v, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("waited %s behind the slow call", elapsed)
+	}
+
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisStoreWaitForConnection(t *testing.T) {
+	url := fakeRedis(t)
+	policy := Policy{Limit: 10, Window: time.Minute}
+
+	s, err := NewRedisStore(url, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	slow := make(chan error)
+	go func() {
+		_, err := s.Allow(context.Background(), "slow", policy)
+		slow <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The only connection is busy, the wait ends with the request's context
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = s.Allow(ctx, "fast", policy)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want a deadline error", err)
+	}
+
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+
+	// And once it's free again it's used
+	_, err = s.Allow(context.Background(), "fast", policy)
+	if err != nil {
+		t.Error(err)
+	}
+}
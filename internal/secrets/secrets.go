@@ -0,0 +1,200 @@
+// Package secrets resolves references to secrets kept outside the config,
+// so nothing secret has to be written into flags or .env files:
+//
+//	file:/run/secrets/db_dsn               the file's contents (Docker and Kubernetes secrets)
+//	vault:secret/data/greenlight#db_dsn    a key of a HashiCorp Vault KV secret
+//	aws-sm:greenlight/prod#db_dsn          a key of an AWS Secrets Manager secret
+//
+// Without #key the whole secret is used, for Vault that needs a secret
+// with a single key. Vault is reached with VAULT_ADDR and VAULT_TOKEN, AWS
+// with the usual AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN (AWS_ENDPOINT_URL_SECRETS_MANAGER overrides the endpoint)
+package secrets
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// IsRef reports whether s is a reference Resolve would look up
+func IsRef(s string) bool {
+	for _, prefix := range []string{"file:", "vault:", "aws-sm:"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the secret ref points to. Anything that isn't a
+// reference is returned as it is
+func Resolve(ctx context.Context, ref string) (string, error) {
+	scheme, rest, _ := strings.Cut(ref, ":")
+
+	var value string
+	var err error
+
+	switch scheme {
+	case "file":
+		value, err = readFile(rest)
+	case "vault":
+		value, err = readVault(ctx, rest)
+	case "aws-sm":
+		value, err = readSecretsManager(ctx, rest)
+	default:
+		return ref, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("secrets: %s: %w", ref, err)
+	}
+
+	return value, nil
+}
+
+// Editors and echo leave a newline at the end of the file, it's never
+// part of the secret
+func readFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func readVault(ctx context.Context, ref string) (string, error) {
+	path, key, _ := strings.Cut(ref, "#")
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR isn't set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+
+	err = doJSON(req, &body)
+	if err != nil {
+		return "", err
+	}
+
+	// KV version 2 nests the secret in data.data, version 1 doesn't
+	values := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		var v2 map[string]json.RawMessage
+		if json.Unmarshal(nested, &v2) == nil {
+			values = v2
+		}
+	}
+
+	return pick(values, key)
+}
+
+func readSecretsManager(ctx context.Context, ref string) (string, error) {
+	id, key, _ := strings.Cut(ref, "#")
+
+	region := cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	if region == "" {
+		return "", fmt.Errorf("AWS_REGION isn't set")
+	}
+
+	endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"), "https://secretsmanager."+region+".amazonaws.com")
+
+	payload, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", strings.NewReader(string(payload)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	sign(req, payload, credentials{
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}, time.Now().UTC())
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+
+	err = doJSON(req, &body)
+	if err != nil {
+		return "", err
+	}
+
+	if key == "" {
+		return body.SecretString, nil
+	}
+
+	// Secrets with several values are stored as a JSON object
+	var values map[string]json.RawMessage
+
+	err = json.Unmarshal([]byte(body.SecretString), &values)
+	if err != nil {
+		return "", fmt.Errorf("secret isn't a JSON object, it has no key %q", key)
+	}
+
+	return pick(values, key)
+}
+
+// pick returns values[key], or the only value there is without a key
+func pick(values map[string]json.RawMessage, key string) (string, error) {
+	if key == "" {
+		if len(values) != 1 {
+			return "", fmt.Errorf("secret has %d keys, say which one with #key", len(values))
+		}
+		for k := range values {
+			key = k
+		}
+	}
+
+	raw, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+
+	// Numbers and the like are used as they are written
+	return string(raw), nil
+}
+
+func doJSON(req *http.Request, dst any) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(res.Body).Decode(dst)
+}
@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type credentials struct {
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// sign adds a Signature Version 4 Authorization header for Secrets
+// Manager. Unlike storage's S3 requests the payload is small and signed
+func sign(req *http.Request, payload []byte, creds credentials, now time.Time) {
+	stamp := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + creds.region + "/secretsmanager/aws4_request"
+
+	req.Header.Set("X-Amz-Date", stamp)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if creds.sessionToken != "" {
+		signedHeaders = []string{"content-type", "host", "x-amz-date", "x-amz-security-token", "x-amz-target"}
+	}
+
+	var headers strings.Builder
+	for _, h := range signedHeaders {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	payloadHash := sha256.Sum256(payload)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, creds.region)
+	key = hmacSHA256(key, "secretsmanager")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, strings.Join(signedHeaders, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,89 @@
+// Package shed caps how much work runs at once. Up to MaxInFlight calls
+// run, the next MaxQueue wait up to QueueTimeout for one of them to
+// finish, everything past that fails right away with ErrOverloaded.
+// Failing fast under a spike is cheaper than letting every call pile up
+// on the database pool and time out there
+package shed
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var ErrOverloaded = errors.New("too many calls in flight")
+
+type Limiter struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+
+	queued atomic.Int64
+	shed   atomic.Int64
+}
+
+func New(maxInFlight, maxQueue int, queueTimeout time.Duration) *Limiter {
+	return &Limiter{
+		slots:        make(chan struct{}, maxInFlight),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire waits for a slot. It returns ErrOverloaded when the queue is
+// full or the wait took too long, and ctx's error when the caller gave
+// up first. Every nil return has to be followed by Release
+func (l *Limiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.shed.Add(1)
+		return ErrOverloaded
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		l.shed.Add(1)
+		return ErrOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) Release() {
+	<-l.slots
+}
+
+// RetryAfter is a guess at when a shed call could get through: about when
+// the queue it didn't fit in will have moved on
+func (l *Limiter) RetryAfter() time.Duration {
+	return max(l.queueTimeout, time.Second)
+}
+
+type Stats struct {
+	MaxInFlight int   `json:"max_in_flight"`
+	InFlight    int   `json:"in_flight"`
+	Queued      int64 `json:"queued"`
+	Shed        int64 `json:"shed"` // since the start
+}
+
+func (l *Limiter) Stats() Stats {
+	return Stats{
+		MaxInFlight: cap(l.slots),
+		InFlight:    len(l.slots),
+		Queued:      l.queued.Load(),
+		Shed:        l.shed.Load(),
+	}
+}
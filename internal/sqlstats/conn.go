@@ -0,0 +1,80 @@
+package sqlstats
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// conn times queries and passes everything else on. The driver has to
+// support the context interfaces, lib/pq does
+type conn struct {
+	driver.Conn
+	config Config
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := tracing.Start(ctx, "query", tracing.KindClient)
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	c.observe(span, start, query, args, err)
+
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := tracing.Start(ctx, "query", tracing.KindClient)
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	c.observe(span, start, query, args, err)
+
+	return result, err
+}
+
+// Prepared statements aren't timed, we don't use them
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package sqlstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the histogram buckets, anything slower goes to "+Inf"
+var buckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// histogram implements expvar.Var. Bucket counts are cumulative like in
+// Prometheus, so "le_100ms" is the number of queries that took up to 100ms
+type histogram struct {
+	mu     sync.Mutex
+	count  int64
+	errors int64
+	sum    time.Duration
+	counts [len(buckets) + 1]int64
+}
+
+func (h *histogram) observe(d time.Duration, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += d
+	if failed {
+		h.errors++
+	}
+
+	i := 0
+	for i < len(buckets) && d > buckets[i] {
+		i++
+	}
+	h.counts[i]++
+}
+
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative := make(map[string]int64, len(buckets)+1)
+	var total int64
+
+	for i, bound := range buckets {
+		total += h.counts[i]
+		cumulative[fmt.Sprintf("le_%s", bound)] = total
+	}
+	cumulative["le_+Inf"] = h.count
+
+	js, _ := json.Marshal(map[string]any{
+		"count":   h.count,
+		"errors":  h.errors,
+		"sum_ms":  float64(h.sum) / float64(time.Millisecond),
+		"buckets": cumulative,
+	})
+
+	return string(js)
+}
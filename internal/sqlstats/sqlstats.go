@@ -0,0 +1,137 @@
+// Package sqlstats wraps a database/sql driver to time every query.
+// Slow queries are logged, and every query feeds a latency histogram
+// published with expvar under "sql_queries" (see /debug/vars).
+//
+// Queries are named after the function that ran them, e.g.
+// data.MovieModel.Get, so nobody has to name hundreds of queries by hand.
+// Queries run with a span in their context get a span of their own under
+// it, named the same way, see package tracing
+package sqlstats
+
+import (
+	"context"
+	"database/sql/driver"
+	"expvar"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+var queries = expvar.NewMap("sql_queries")
+
+type Config struct {
+	// Queries slower than this are logged with their args, 0 disables logging
+	SlowThreshold time.Duration
+	Logger        *slog.Logger
+}
+
+// Wrap returns a connector that times everything that goes through c
+func Wrap(c driver.Connector, config Config) driver.Connector {
+	return &connector{Connector: c, config: config}
+}
+
+type connector struct {
+	driver.Connector
+	config Config
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: cn, config: c.config}, nil
+}
+
+// observe records one query. Queries are timed until the driver returns,
+// for SELECTs that's when the first rows arrive, not when they are all read
+func (c *conn) observe(span *tracing.Span, start time.Time, query string, args []driver.NamedValue, err error) {
+	duration := time.Since(start)
+	name := caller()
+
+	span.SetName(name)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement", query)
+	if err != driver.ErrSkip {
+		span.SetError(err)
+	}
+	span.Finish()
+
+	h, ok := queries.Get(name).(*histogram)
+	if !ok {
+		// Two goroutines may both get here for a new name, Set keeps the last
+		// one and the first few observations might be lost. Good enough
+		h = &histogram{}
+		queries.Set(name, h)
+	}
+	h.observe(duration, err != nil && err != driver.ErrSkip)
+
+	if c.config.SlowThreshold > 0 && duration >= c.config.SlowThreshold {
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = arg.Value
+		}
+
+		logArgs := []any{"name", name, "duration", duration, "query", query, "args", values}
+		if span != nil {
+			logArgs = append(logArgs, "trace_id", span.TraceID.String())
+		}
+
+		c.config.Logger.Warn("slow query", logArgs...)
+	}
+}
+
+// Functions that run queries for someone else, the query is named after
+// whoever called them
+var helpers = map[string]bool{
+	"data.withRetry":      true,
+	"data.queryWithRetry": true,
+}
+
+// caller finds the first function outside database/sql, this package and
+// the helpers, e.g. "greenlight.brainwhat/internal/data.MovieModel.Get",
+// and shortens it to "data.MovieModel.Get". Queries run by a closure are
+// named after the function it's in
+func caller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	for {
+		frame, more := frames.Next()
+
+		fn := frame.Function
+		if !strings.HasPrefix(fn, "database/sql") && !strings.Contains(fn, "/sqlstats.") {
+			name := trimClosures(fn[strings.LastIndex(fn, "/")+1:])
+			if !helpers[name] {
+				return name
+			}
+		}
+
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// trimClosures turns data.MovieModel.Get.func1 and nested closures like
+// data.MovieModel.Get.func1.2 into data.MovieModel.Get
+func trimClosures(name string) string {
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return name
+		}
+
+		last := strings.TrimPrefix(name[i+1:], "func")
+		if last == "" || strings.Trim(last, "0123456789") != "" {
+			return name
+		}
+
+		name = name[:i]
+	}
+}
@@ -0,0 +1,104 @@
+package sqlstats
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"greenlight.brainwhat/internal/tracing"
+)
+
+// fakeConn answers every query with no rows, or fails the ones in failing
+type fakeConn struct {
+	failing map[string]bool
+}
+
+func (c fakeConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c fakeConn) Driver() driver.Driver                        { return nil }
+func (c fakeConn) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                                 { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if c.failing[query] {
+		return nil, errors.New("boom")
+	}
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"n"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+type recorder struct {
+	spans []*tracing.Span
+}
+
+func (r *recorder) Export(span *tracing.Span) {
+	r.spans = append(r.spans, span)
+}
+
+func TestQuerySpans(t *testing.T) {
+	db := sql.OpenDB(Wrap(fakeConn{failing: map[string]bool{"SELECT 2": true}}, Config{}))
+	defer db.Close()
+
+	exporter := &recorder{}
+	ctx, request := tracing.New(exporter, 1).StartRequest(httptest.NewRequest("GET", "/", nil), "GET /")
+
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if _, err := db.QueryContext(ctx, "SELECT 2"); err == nil {
+		t.Fatal("want an error")
+	}
+
+	// Without a span there's no trace to add to
+	rows, err = db.QueryContext(context.Background(), "SELECT 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(exporter.spans))
+	}
+
+	for i, span := range exporter.spans {
+		if span.TraceID != request.TraceID || span.Parent != request.ID || span.Kind != tracing.KindClient {
+			t.Errorf("span %d isn't under the request: %+v", i, span)
+		}
+	}
+
+	if got := exporter.spans[0].Attributes["db.statement"]; got != "SELECT 1" || exporter.spans[0].Error != "" {
+		t.Errorf("got statement %v, error %q", got, exporter.spans[0].Error)
+	}
+	if exporter.spans[1].Error != "boom" {
+		t.Errorf("got error %q, want boom", exporter.spans[1].Error)
+	}
+}
+
+func TestTrimClosures(t *testing.T) {
+	tests := map[string]string{
+		"data.MovieModel.Get":         "data.MovieModel.Get",
+		"data.MovieModel.Get.func1":   "data.MovieModel.Get",
+		"data.MovieModel.Get.func1.2": "data.MovieModel.Get",
+		"data.queryWithRetry.func1":   "data.queryWithRetry",
+		"data.function":               "data.function",
+		"main.main":                   "main.main",
+	}
+
+	for name, want := range tests {
+		if got := trimClosures(name); got != want {
+			t.Errorf("trimClosures(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
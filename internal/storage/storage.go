@@ -0,0 +1,47 @@
+// Package storage keeps files (posters, export archives, backups) either
+// on the local disk or in an S3 compatible bucket. Keys are slash separated
+// paths like posters/12/thumb.jpg, the same key works with every backend
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("storage: object not found")
+	ErrBadKey   = errors.New("storage: invalid key")
+
+	// ErrPresignNotSupported means the file has to be streamed through the API
+	ErrPresignNotSupported = errors.New("storage: backend can't presign URLs")
+)
+
+type Object struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+type Storage interface {
+	// Put stores size bytes from r under key, replacing what was there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound for missing keys. The caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete doesn't fail for missing keys
+	Delete(ctx context.Context, key string) error
+	// PresignGet returns a URL clients can download key from directly until ttl is over
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// checkKey rejects keys that could escape the storage root
+// or mean different things to different backends
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return ErrBadKey
+	}
+	return nil
+}
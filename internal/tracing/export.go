@@ -0,0 +1,239 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// LogExporter logs every span, handy in development and for shipping
+// spans with the rest of the logs
+type LogExporter struct {
+	Logger *slog.Logger
+}
+
+func (e LogExporter) Export(span *Span) {
+	attrs := make([]any, 0, len(span.Attributes))
+	for key, value := range span.Attributes {
+		attrs = append(attrs, slog.Any(key, value))
+	}
+
+	args := []any{
+		"trace_id", span.TraceID.String(),
+		"span_id", span.ID.String(),
+		"name", span.Name,
+		"duration", span.Duration(),
+		slog.Group("attributes", attrs...),
+	}
+	if !span.Parent.IsZero() {
+		args = append(args, "parent_id", span.Parent.String())
+	}
+	if span.Error != "" {
+		args = append(args, "error", span.Error)
+	}
+
+	e.Logger.Info("span", args...)
+}
+
+// Spans that wait for a flush, beyond that new ones are dropped. A
+// collector that's down mustn't have us run out of memory
+const maxQueuedSpans = 4096
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP/HTTP
+// in its JSON encoding, e.g. to http://localhost:4318/v1/traces. Spans
+// are queued and sent by Flush, the server calls it periodically
+type OTLPExporter struct {
+	url      string
+	resource map[string]string
+	client   *http.Client
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+}
+
+// NewOTLPExporter doesn't connect yet, the first Flush does. resource
+// describes the service, e.g. service.name, and goes with every batch
+func NewOTLPExporter(url string, resource map[string]string) *OTLPExporter {
+	return &OTLPExporter{url: url, resource: resource, client: &http.Client{}}
+}
+
+func (e *OTLPExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, span)
+}
+
+// Flush sends the queued spans in one request. Spans that couldn't be
+// sent are gone, tracing is best effort. The error says how many spans
+// were dropped since the last flush that worked
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	spans, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if len(spans) == 0 && dropped == 0 {
+		return nil
+	}
+
+	err := e.send(ctx, spans)
+	switch {
+	case err != nil:
+		return fmt.Errorf("otlp: sending %d spans: %w", len(spans), err)
+	case dropped > 0:
+		return fmt.Errorf("otlp: %d spans dropped, the queue was full", dropped)
+	}
+
+	return nil
+}
+
+func (e *OTLPExporter) send(ctx context.Context, spans []*Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	js, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+// The OTLP JSON types, only the fields we fill in. IDs are hex and
+// the 64 bit timestamps strings, the JSON encoding of the protobuf
+// messages says so
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is error
+	Message string `json:"message"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	resource := make([]otlpAttribute, 0, len(e.resource))
+	for key, value := range e.resource {
+		resource = append(resource, newOTLPAttribute(key, value))
+	}
+
+	converted := make([]otlpSpan, 0, len(spans))
+
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.ID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if !span.Parent.IsZero() {
+			s.ParentSpanID = span.Parent.String()
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, newOTLPAttribute(key, value))
+		}
+		if span.Error != "" {
+			s.Status = &otlpStatus{Code: 2, Message: span.Error}
+		}
+
+		converted = append(converted, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "greenlight"}, Spans: converted}},
+	}}}
+}
+
+func newOTLPAttribute(key string, value any) otlpAttribute {
+	var v map[string]any
+
+	switch value := value.(type) {
+	case string:
+		v = map[string]any{"stringValue": value}
+	case bool:
+		v = map[string]any{"boolValue": value}
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]any{"doubleValue": value}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,257 @@
+// Package tracing follows a request from the handler down to its queries.
+// The trace of the caller is picked up from the W3C traceparent header,
+// so our spans join it, and finished spans go to an Exporter: the log or
+// an OpenTelemetry collector over OTLP/HTTP JSON.
+//
+// It's the small part of OpenTelemetry we need, spans with attributes
+// and no metrics, baggage or span events. A nil *Span is a valid span
+// that does nothing, code that may run outside a trace doesn't have to
+// check
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// Kind is the OTLP span kind
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+type Span struct {
+	TraceID    TraceID
+	ID         SpanID
+	Parent     SpanID // zero for the root of a trace
+	Name       string
+	Kind       Kind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]any
+	Error      string // set when the operation failed
+
+	sampled bool
+	tracer  *Tracer
+}
+
+// Exporter gets every sampled span once it ended. Export is called on
+// the request's goroutine, it mustn't block
+type Exporter interface {
+	Export(span *Span)
+}
+
+type Tracer struct {
+	exporter    Exporter
+	sampleRatio float64
+}
+
+// New returns a tracer that samples sampleRatio of the traces that start
+// here. Traces that come with a traceparent keep the caller's decision
+func New(exporter Exporter, sampleRatio float64) *Tracer {
+	return &Tracer{exporter: exporter, sampleRatio: sampleRatio}
+}
+
+// StartRequest starts the server span of r, in the trace of its
+// traceparent header if there is a valid one
+func (t *Tracer) StartRequest(r *http.Request, name string) (context.Context, *Span) {
+	span := &Span{
+		Name:       name,
+		Kind:       KindServer,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		tracer:     t,
+	}
+
+	if traceID, parent, sampled, ok := ParseTraceparent(r.Header.Get("traceparent")); ok {
+		span.TraceID, span.Parent, span.sampled = traceID, parent, sampled
+	} else {
+		span.TraceID = newTraceID()
+		span.sampled = rand.Float64() < t.sampleRatio
+	}
+	span.ID = newSpanID()
+
+	return ContextWithSpan(r.Context(), span), span
+}
+
+// Start starts a span under the one in ctx. Without one there's no trace
+// to add it to, and the span is nil
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		TraceID:    parent.TraceID,
+		ID:         newSpanID(),
+		Parent:     parent.ID,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]any),
+		sampled:    parent.sampled,
+		tracer:     parent.tracer,
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+type contextKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, span)
+}
+
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.Name = name
+	}
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s != nil {
+		s.Attributes[key] = value
+	}
+}
+
+// SetError marks the span as failed, a nil err leaves it alone
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Finish ends the span and hands it to the exporter. A span mustn't be
+// changed after that
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.End = time.Now()
+
+	if s.sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+// Duration is how long the span took, 0 until it's finished
+func (s *Span) Duration() time.Duration {
+	if s == nil || s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Traceparent is the header that continues the trace under s, "" for a nil span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", s.TraceID, s.ID, flags)
+}
+
+// ParseTraceparent reads a version 00 traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01. Later versions
+// may append fields, the first four are read all the same
+func ParseTraceparent(header string) (TraceID, SpanID, bool, bool) {
+	var traceID TraceID
+	var parent SpanID
+
+	if len(header) < 55 || len(header) > 55 && header[55] != '-' {
+		return traceID, parent, false, false
+	}
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return traceID, parent, false, false
+	}
+
+	version, ok := decodeHex(header[0:2], 1)
+	if !ok || version[0] == 0xff || version[0] == 0 && len(header) != 55 {
+		return traceID, parent, false, false
+	}
+
+	id, ok := decodeHex(header[3:35], len(traceID))
+	if !ok {
+		return traceID, parent, false, false
+	}
+	copy(traceID[:], id)
+
+	id, ok = decodeHex(header[36:52], len(parent))
+	if !ok {
+		return traceID, parent, false, false
+	}
+	copy(parent[:], id)
+
+	flags, ok := decodeHex(header[53:55], 1)
+	if !ok || traceID.IsZero() || parent.IsZero() {
+		return traceID, parent, false, false
+	}
+
+	return traceID, parent, flags[0]&1 == 1, true
+}
+
+// decodeHex only takes lowercase hex, like the spec says
+func decodeHex(s string, n int) ([]byte, bool) {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return nil, false
+		}
+	}
+
+	b, err := hex.DecodeString(s)
+	return b, err == nil && len(b) == n
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		for i := range id {
+			id[i] = byte(rand.Uint32())
+		}
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		for i := range id {
+			id[i] = byte(rand.Uint32())
+		}
+	}
+	return id
+}
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{name: "sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantOK: true, wantSampled: true},
+		{name: "not sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", wantOK: true},
+		{name: "later version with more fields", header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what", wantOK: true, wantSampled: true},
+		{name: "version 00 with more fields", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what"},
+		{name: "version ff", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "uppercase", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "zero trace id", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero parent", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "short", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traceID, parent, sampled, ok := ParseTraceparent(tt.header)
+			if ok != tt.wantOK || sampled != tt.wantSampled {
+				t.Fatalf("got ok %t, sampled %t, want %t, %t", ok, sampled, tt.wantOK, tt.wantSampled)
+			}
+
+			if ok && (traceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || parent.String() != "00f067aa0ba902b7") {
+				t.Errorf("got trace %s, parent %s", traceID, parent)
+			}
+		})
+	}
+}
+
+type recorder struct {
+	spans []*Span
+}
+
+func (r *recorder) Export(span *Span) {
+	r.spans = append(r.spans, span)
+}
+
+func TestStartRequest(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, 1)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, server := tracer.StartRequest(r, "GET /v1/movies")
+	_, child := Start(ctx, "query", KindClient)
+	child.SetError(errors.New("boom"))
+	child.Finish()
+	server.Finish()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(exporter.spans))
+	}
+
+	if server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("server span isn't in the caller's trace: %s under %s", server.TraceID, server.Parent)
+	}
+
+	if child.TraceID != server.TraceID || child.Parent != server.ID || child.Error != "boom" {
+		t.Errorf("got child %+v of %+v", child, server)
+	}
+
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + server.ID.String() + "-01"; server.Traceparent() != want {
+		t.Errorf("got traceparent %s, want %s", server.Traceparent(), want)
+	}
+}
+
+func TestUnsampledTraces(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, 1)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	ctx, server := tracer.StartRequest(r, "GET /v1/movies")
+	_, child := Start(ctx, "query", KindClient)
+	child.Finish()
+	server.Finish()
+
+	if len(exporter.spans) != 0 {
+		t.Errorf("got %d spans of a trace the caller didn't sample", len(exporter.spans))
+	}
+
+	// Without a span to start under there's nothing to do
+	_, span := Start(context.Background(), "query", KindClient)
+	if span != nil {
+		t.Errorf("got a span outside any trace")
+	}
+	span.SetAttribute("ignored", true)
+	span.Finish()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got Content-Type %s", r.Header.Get("Content-Type"))
+		}
+
+		js, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(js, &body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	exporter := NewOTLPExporter(srv.URL, map[string]string{"service.name": "greenlight"})
+	tracer := New(exporter, 1)
+
+	ctx, server := tracer.StartRequest(httptest.NewRequest(http.MethodGet, "/", nil), "GET /")
+	server.SetAttribute("http.response.status_code", 500)
+	server.SetError(errors.New("Internal Server Error"))
+	_, child := Start(ctx, "data.MovieModel.Get", KindClient)
+	child.Finish()
+	server.Finish()
+
+	if err := exporter.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	js, _ := json.Marshal(body)
+	for _, want := range []string{
+		`"service.name"`,
+		`"traceId":"` + server.TraceID.String() + `"`,
+		`"parentSpanId":"` + server.ID.String() + `"`,
+		`"name":"data.MovieModel.Get"`,
+		`"kind":2`,
+		`"intValue":"500"`,
+		`"status":{"code":2,"message":"Internal Server Error"}`,
+	} {
+		if !strings.Contains(string(js), want) {
+			t.Errorf("request %s doesn't contain %s", js, want)
+		}
+	}
+
+	// Nothing queued, nothing sent
+	body = nil
+	if err := exporter.Flush(context.Background()); err != nil || body != nil {
+		t.Errorf("empty flush sent %v, %v", body, err)
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"slices"
 )
 
+// Errors stays nil until the first error, most requests are valid and
+// never need the map
 type Validator struct {
 	Errors map[string]string
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{}
 }
 
 func (v *Validator) Valid() bool {
@@ -18,6 +20,10 @@ func (v *Validator) Valid() bool {
 }
 
 func (v *Validator) AddError(key, message string) {
+	if v.Errors == nil {
+		v.Errors = make(map[string]string)
+	}
+
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Every instance runs a scheduler, but only the one holding this
+// advisory lock (the leader) actually runs the tasks
+const schedulerLockKey = 7_428_113
+
+// How often the scheduler checks for due tasks and tries to become leader.
+// Task intervals shorter than this don't make much sense
+const schedulerTick = 10 * time.Second
+
+type task struct {
+	name     string
+	interval time.Duration
+	fn       func(ctx context.Context) error
+	next     time.Time
+}
+
+type Scheduler struct {
+	db     *sql.DB
+	logger *slog.Logger
+	tasks  []*task
+
+	// Session advisory locks belong to a connection, so the leader
+	// keeps one connection for as long as it leads
+	conn *sql.Conn
+}
+
+// NewScheduler with a nil db runs the tasks without leader election,
+// for when there is no database and so only one instance anyway
+func NewScheduler(db *sql.DB, logger *slog.Logger) *Scheduler {
+	return &Scheduler{db: db, logger: logger}
+}
+
+// Every registers fn to run once per interval. Interval 0 disables the task.
+// fn gets a context that is cancelled after one interval
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	if interval <= 0 {
+		return
+	}
+
+	s.tasks = append(s.tasks, &task{name: name, interval: interval, fn: fn})
+}
+
+// Run blocks until done is closed
+func (s *Scheduler) Run(done <-chan struct{}) {
+	defer s.resign()
+
+	if len(s.tasks) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		if s.lead() {
+			s.runDue()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// lead makes sure we hold the lock, taking it if nobody else does.
+// A broken connection loses the lock, so the leader checks it every time
+func (s *Scheduler) lead() bool {
+	if s.db == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if s.conn != nil {
+		err := s.conn.PingContext(ctx)
+		if err == nil {
+			return true
+		}
+
+		s.logger.Error("scheduler lost its connection", "error", err)
+		s.resign()
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		s.logger.Error("scheduler connection", "error", err)
+		return false
+	}
+
+	var locked bool
+
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, schedulerLockKey).Scan(&locked)
+	if err != nil || !locked {
+		if err != nil {
+			s.logger.Error("taking scheduler lock", "error", err)
+		}
+		conn.Close()
+		return false
+	}
+
+	s.logger.Info("scheduler became leader")
+	s.conn = conn
+
+	// A new leader doesn't know when tasks last ran, run them all soon
+	for _, t := range s.tasks {
+		t.next = time.Now()
+	}
+
+	return true
+}
+
+func (s *Scheduler) resign() {
+	if s.conn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Closing the connection would release the lock too, but the pool
+	// may keep it open, so unlock explicitly
+	s.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, schedulerLockKey)
+	s.conn.Close()
+	s.conn = nil
+}
+
+// Tasks run one after another, a slow task just delays the rest
+func (s *Scheduler) runDue() {
+	for _, t := range s.tasks {
+		if time.Now().Before(t.next) {
+			continue
+		}
+		t.next = time.Now().Add(t.interval)
+
+		ctx, cancel := context.WithTimeout(context.Background(), t.interval)
+		start := time.Now()
+
+		err := t.fn(ctx)
+		cancel()
+
+		if err != nil {
+			s.logger.Error("scheduled task failed", "task", t.name, "error", err)
+			continue
+		}
+
+		s.logger.Info("scheduled task finished", "task", t.name, "duration", time.Since(start))
+	}
+}
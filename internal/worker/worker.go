@@ -0,0 +1,260 @@
+// Package worker runs slow jobs outside of request handlers.
+// Jobs are rows in the jobs table, so they survive restarts and
+// several API instances can share one queue
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// HandlerFunc does the actual work. Returning an error schedules a retry,
+// until the job runs out of attempts and becomes dead
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+type Config struct {
+	Workers      int
+	PollInterval time.Duration
+
+	// How long a claimed job stays hidden from other workers. If the worker
+	// dies in the middle, the job becomes visible again after this
+	VisibilityTimeout time.Duration
+}
+
+type Pool struct {
+	db       *sql.DB
+	logger   *slog.Logger
+	config   Config
+	handlers map[string]HandlerFunc
+	running  atomic.Int64
+}
+
+func New(db *sql.DB, logger *slog.Logger, config Config) *Pool {
+	return &Pool{
+		db:       db,
+		logger:   logger,
+		config:   config,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Handle registers fn for jobs of kind. It has to be called before Run
+func (p *Pool) Handle(kind string, fn HandlerFunc) {
+	p.handlers[kind] = fn
+}
+
+// Enqueue adds a job that runs as soon as a worker is free
+func (p *Pool) Enqueue(kind string, payload any) error {
+	return p.EnqueueAt(kind, payload, time.Now())
+}
+
+func (p *Pool) EnqueueAt(kind string, payload any, runAt time.Time) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO jobs (kind, payload, run_at) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// jsonb has to be passed as a string, pq would send []byte as bytea
+	_, err = p.db.ExecContext(ctx, query, kind, string(js), runAt)
+	return err
+}
+
+// Run starts the workers and blocks until done is closed and
+// every job that was already claimed has finished
+func (p *Pool) Run(done <-chan struct{}) {
+	var wg sync.WaitGroup
+
+	for range p.config.Workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			p.running.Add(1)
+			defer p.running.Add(-1)
+
+			p.work(done)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Running is how many workers are polling for jobs right now
+func (p *Pool) Running() int {
+	return int(p.running.Load())
+}
+
+func (p *Pool) work(done <-chan struct{}) {
+	ticker := time.NewTicker(p.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while there are jobs, only sleep when the queue is empty
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			job, err := p.claim()
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					p.logger.Error("claiming job", "error", err)
+				}
+				break
+			}
+
+			p.run(job)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// claim takes the oldest job that is due. Jobs whose worker went
+// past the visibility timeout count as due again, unless that was their
+// last attempt: fail never ran for them, so they're made dead here. A job
+// that kills or hangs its worker would be retried forever otherwise.
+// SKIP LOCKED lets workers claim different jobs at the same time
+func (p *Pool) claim() (*Job, error) {
+	query := `WITH abandoned AS (
+		UPDATE jobs
+		SET state = 'dead', locked_until = NULL, last_error = 'the worker stopped responding on the last attempt'
+		WHERE state = 'running' AND locked_until < NOW() AND attempts >= max_attempts
+	)
+	UPDATE jobs
+	SET state = 'running', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1)
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE (state = 'pending' AND run_at <= NOW())
+		OR (state = 'running' AND locked_until < NOW() AND attempts < max_attempts)
+		ORDER BY run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, kind, payload, attempts, max_attempts`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var job Job
+
+	err := p.db.QueryRowContext(ctx, query, p.config.VisibilityTimeout.Seconds()).
+		Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (p *Pool) run(job *Job) {
+	err := p.handle(job)
+	if err != nil {
+		p.logger.Error("running job", "id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "error", err)
+		err = p.fail(job, err)
+	} else {
+		err = p.finish(job)
+	}
+
+	if err != nil {
+		p.logger.Error("updating job", "id", job.ID, "error", err)
+	}
+}
+
+// handle runs the job handler. The handler doesn't get more time than the
+// visibility timeout, otherwise another worker could pick the job up too
+func (p *Pool) handle(job *Job) (err error) {
+	fn, ok := p.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.VisibilityTimeout)
+	defer cancel()
+
+	return fn(ctx, job)
+}
+
+func (p *Pool) finish(job *Job) error {
+	query := `UPDATE jobs SET state = 'done', locked_until = NULL, last_error = '' WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, query, job.ID)
+	return err
+}
+
+// fail schedules a retry with exponential backoff. Jobs that used up
+// all their attempts are kept as dead, so they can be looked at later
+func (p *Pool) fail(job *Job, jobErr error) error {
+	query := `UPDATE jobs
+	SET state = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+		run_at = NOW() + make_interval(secs => $2),
+		locked_until = NULL,
+		last_error = $3
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, query, job.ID, backoff(job.Attempts).Seconds(), jobErr.Error())
+	return err
+}
+
+// backoff doubles the wait after every attempt: 10s, 20s, 40s... up to an hour
+func backoff(attempts int) time.Duration {
+	wait := 10 * time.Second
+
+	for i := 1; i < attempts && wait < time.Hour; i++ {
+		wait *= 2
+	}
+
+	return min(wait, time.Hour)
+}
+
+// PurgeFinished deletes done jobs older than olderThan. Dead jobs are kept,
+// somebody should look at why they failed first
+func (p *Pool) PurgeFinished(ctx context.Context, olderThan time.Duration) (int64, error) {
+	query := `DELETE FROM jobs WHERE state = 'done' AND run_at < NOW() - make_interval(secs => $1)`
+
+	result, err := p.db.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
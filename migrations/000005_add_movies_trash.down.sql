@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS movie_undo_tokens;
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
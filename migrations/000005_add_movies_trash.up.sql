@@ -0,0 +1,7 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS movie_undo_tokens (
+    hash bytea PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,12 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS genres text[] NOT NULL DEFAULT '{}';
+
+UPDATE movies m SET genres = ARRAY(
+    SELECT g.name FROM movies_genres mg JOIN genres g ON g.id = mg.genre_id
+    WHERE mg.movie_id = m.id ORDER BY mg.position
+);
+
+CREATE INDEX IF NOT EXISTS movies_genres_idx ON movies USING GIN (genres);
+ALTER TABLE movies ADD CONSTRAINT genres_length_check CHECK (array_length(genres, 1) BETWEEN 1 AND 5);
+
+DROP TABLE IF EXISTS movies_genres;
+DROP TABLE IF EXISTS genres;
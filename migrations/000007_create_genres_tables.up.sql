@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS movies_genres (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS movies_genres_genre_id_idx ON movies_genres (genre_id);
+
+-- Move the existing text[] values over, keeping their order
+INSERT INTO genres (name)
+SELECT DISTINCT unnest(genres) FROM movies
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT m.id, g.id, min(t.position)
+FROM movies m
+CROSS JOIN LATERAL unnest(m.genres) WITH ORDINALITY AS t(name, position)
+JOIN genres g ON g.name = t.name
+GROUP BY m.id, g.id;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS genres_length_check;
+DROP INDEX IF EXISTS movies_genres_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS genres;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS movie_stats (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    day date NOT NULL,
+    views bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (movie_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS movie_stats_day_idx ON movie_stats (day);
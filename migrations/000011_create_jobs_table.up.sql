@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'running', 'done', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 5,
+    run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone,
+    last_error text NOT NULL DEFAULT ''
+);
+
+-- Workers only ever look for jobs that still have to run
+CREATE INDEX IF NOT EXISTS jobs_run_at_idx ON jobs (run_at) WHERE state IN ('pending', 'running');
@@ -0,0 +1,11 @@
+-- Events are written in the same transaction as the change they describe,
+-- a relay publishes them afterwards and sets published_at
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    type text NOT NULL,
+    payload jsonb NOT NULL,
+    published_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL;
@@ -0,0 +1,10 @@
+-- Long-lived keys for backend integrations. Only the hash of a key is kept,
+-- the plaintext is shown once when the key is created
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    rate_limit integer NOT NULL DEFAULT 0
+);
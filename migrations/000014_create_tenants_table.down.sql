@@ -0,0 +1,15 @@
+-- Only the default tenant's data can survive going back to a single catalog
+DELETE FROM movies WHERE tenant_id <> 1;
+DELETE FROM genres WHERE tenant_id <> 1;
+DELETE FROM collections WHERE tenant_id <> 1;
+DELETE FROM api_keys WHERE tenant_id <> 1;
+
+ALTER TABLE genres DROP CONSTRAINT IF EXISTS genres_tenant_id_name_key;
+ALTER TABLE genres ADD CONSTRAINT genres_name_key UNIQUE (name);
+
+ALTER TABLE movies DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE genres DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE collections DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,33 @@
+-- Every catalog belongs to a tenant. Requests pick theirs by subdomain
+-- or the X-Tenant header, rate_limit caps all of a tenant's requests
+-- per minute (0 means no cap)
+CREATE TABLE IF NOT EXISTS tenants (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    rate_limit integer NOT NULL DEFAULT 0
+);
+
+-- Everything that existed before goes to the default tenant, it gets id 1
+INSERT INTO tenants (slug, name) VALUES ('default', 'Default')
+ON CONFLICT (slug) DO NOTHING;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants ON DELETE CASCADE;
+ALTER TABLE genres ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants ON DELETE CASCADE;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants ON DELETE CASCADE;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants ON DELETE CASCADE;
+
+-- The defaults were only there to fill existing rows
+ALTER TABLE movies ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE genres ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE collections ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE api_keys ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Genre names only have to be unique within a tenant
+ALTER TABLE genres DROP CONSTRAINT IF EXISTS genres_name_key;
+ALTER TABLE genres ADD CONSTRAINT genres_tenant_id_name_key UNIQUE (tenant_id, name);
+
+CREATE INDEX IF NOT EXISTS movies_tenant_id_idx ON movies (tenant_id);
+CREATE INDEX IF NOT EXISTS collections_tenant_id_idx ON collections (tenant_id);
+CREATE INDEX IF NOT EXISTS api_keys_tenant_id_idx ON api_keys (tenant_id);
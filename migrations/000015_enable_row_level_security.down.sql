@@ -0,0 +1,37 @@
+DROP POLICY IF EXISTS tenant_isolation ON movie_undo_tokens;
+ALTER TABLE movie_undo_tokens NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE movie_undo_tokens DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON movie_stats;
+ALTER TABLE movie_stats NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE movie_stats DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON movie_revisions;
+ALTER TABLE movie_revisions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE movie_revisions DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON collections_movies;
+ALTER TABLE collections_movies NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE collections_movies DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON movies_genres;
+ALTER TABLE movies_genres NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE movies_genres DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON api_keys;
+ALTER TABLE api_keys NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE api_keys DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON collections;
+ALTER TABLE collections NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE collections DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON genres;
+ALTER TABLE genres NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE genres DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON movies;
+ALTER TABLE movies NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE movies DISABLE ROW LEVEL SECURITY;
+
+DROP FUNCTION IF EXISTS current_tenant_id();
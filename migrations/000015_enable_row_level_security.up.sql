@@ -0,0 +1,58 @@
+-- The tenant a transaction is limited to, NULL when none was set.
+-- After a SET LOCAL the setting goes back to '' instead of NULL
+CREATE OR REPLACE FUNCTION current_tenant_id() RETURNS bigint
+LANGUAGE sql STABLE AS $$
+    SELECT NULLIF(current_setting('greenlight.tenant_id', true), '')::bigint
+$$;
+
+-- Requests with -db-rls set greenlight.tenant_id, so even a query that
+-- forgets its tenant_id condition only sees the tenant's rows. Without a
+-- tenant everything is visible, which is what the cleanup jobs need.
+-- FORCE makes the policies apply to the table owner too, that's usually
+-- the role the API connects as
+ALTER TABLE movies ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movies FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movies
+    USING (current_tenant_id() IS NULL OR tenant_id = current_tenant_id());
+
+ALTER TABLE genres ENABLE ROW LEVEL SECURITY;
+ALTER TABLE genres FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON genres
+    USING (current_tenant_id() IS NULL OR tenant_id = current_tenant_id());
+
+ALTER TABLE collections ENABLE ROW LEVEL SECURITY;
+ALTER TABLE collections FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON collections
+    USING (current_tenant_id() IS NULL OR tenant_id = current_tenant_id());
+
+ALTER TABLE api_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE api_keys FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON api_keys
+    USING (current_tenant_id() IS NULL OR tenant_id = current_tenant_id());
+
+-- Tables without a tenant_id follow their parent. The subqueries go
+-- through the parent's policy, so they only see the tenant's rows
+ALTER TABLE movies_genres ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movies_genres FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movies_genres
+    USING (movie_id IN (SELECT id FROM movies));
+
+ALTER TABLE collections_movies ENABLE ROW LEVEL SECURITY;
+ALTER TABLE collections_movies FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON collections_movies
+    USING (collection_id IN (SELECT id FROM collections) AND movie_id IN (SELECT id FROM movies));
+
+ALTER TABLE movie_revisions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movie_revisions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movie_revisions
+    USING (movie_id IN (SELECT id FROM movies));
+
+ALTER TABLE movie_stats ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movie_stats FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movie_stats
+    USING (movie_id IN (SELECT id FROM movies));
+
+ALTER TABLE movie_undo_tokens ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movie_undo_tokens FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movie_undo_tokens
+    USING (movie_id IN (SELECT id FROM movies));
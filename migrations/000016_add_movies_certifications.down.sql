@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_certifications_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS content_warnings;
+ALTER TABLE movies DROP COLUMN IF EXISTS certifications;
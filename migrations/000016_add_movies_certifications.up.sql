@@ -0,0 +1,6 @@
+-- Age ratings per country, e.g. {"US": "PG-13", "DE": "12"}
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS certifications jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS content_warnings text[] NOT NULL DEFAULT '{}';
+
+-- jsonb_path_ops only supports @>, which is all the certification filter uses
+CREATE INDEX IF NOT EXISTS movies_certifications_idx ON movies USING GIN (certifications jsonb_path_ops);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS movies_revenue_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_revenue_check;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_budget_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS currency;
+ALTER TABLE movies DROP COLUMN IF EXISTS revenue;
+ALTER TABLE movies DROP COLUMN IF EXISTS budget;
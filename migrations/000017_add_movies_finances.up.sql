@@ -0,0 +1,10 @@
+-- Amounts are in cents of currency, 0 means unknown
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS budget bigint NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS revenue bigint NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT '';
+
+ALTER TABLE movies ADD CONSTRAINT movies_budget_check CHECK (budget >= 0);
+ALTER TABLE movies ADD CONSTRAINT movies_revenue_check CHECK (revenue >= 0);
+
+-- For sort=-revenue
+CREATE INDEX IF NOT EXISTS movies_revenue_idx ON movies (tenant_id, revenue);
@@ -0,0 +1,16 @@
+-- One date per movie, country and kind of release
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country text NOT NULL,
+    type text NOT NULL CHECK (type IN ('theatrical', 'streaming', 'physical')),
+    date date NOT NULL,
+    PRIMARY KEY (movie_id, country, type)
+);
+
+-- For the upcoming releases of a country
+CREATE INDEX IF NOT EXISTS movie_releases_country_date_idx ON movie_releases (country, date);
+
+ALTER TABLE movie_releases ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movie_releases FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movie_releases
+    USING (movie_id IN (SELECT id FROM movies));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_availability_synced_at_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS availability_synced_at;
+DROP TABLE IF EXISTS movie_availability;
@@ -0,0 +1,20 @@
+-- Where a movie can be streamed, rented or bought, as last synced
+-- from the availability API
+CREATE TABLE IF NOT EXISTS movie_availability (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    provider text NOT NULL,
+    country text NOT NULL,
+    type text NOT NULL CHECK (type IN ('stream', 'rent', 'buy')),
+    url text NOT NULL DEFAULT '',
+    PRIMARY KEY (movie_id, provider, country, type)
+);
+
+-- NULL until the first sync
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS availability_synced_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS movies_availability_synced_at_idx ON movies (availability_synced_at NULLS FIRST);
+
+ALTER TABLE movie_availability ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movie_availability FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movie_availability
+    USING (movie_id IN (SELECT id FROM movies));
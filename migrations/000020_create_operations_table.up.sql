@@ -0,0 +1,24 @@
+-- Long running work a client started, e.g. an import. The work itself
+-- runs as a job, this is what the client polls
+CREATE TABLE IF NOT EXISTS operations (
+    id bigserial PRIMARY KEY,
+    tenant_id bigint NOT NULL REFERENCES tenants ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    params jsonb NOT NULL DEFAULT '{}',
+    done bigint NOT NULL DEFAULT 0,
+    total bigint NOT NULL DEFAULT 0,
+    result jsonb,
+    error text NOT NULL DEFAULT '',
+    finished_at timestamp(0) with time zone
+);
+
+-- For the cleanup of old finished operations
+CREATE INDEX IF NOT EXISTS operations_finished_at_idx ON operations (finished_at) WHERE finished_at IS NOT NULL;
+
+ALTER TABLE operations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE operations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON operations
+    USING (current_tenant_id() IS NULL OR tenant_id = current_tenant_id());
@@ -0,0 +1,3 @@
+-- Opaque position an operation can be resumed from, e.g. the rows of
+-- an import that were committed. Written in the same transaction as the work
+ALTER TABLE operations ADD COLUMN IF NOT EXISTS checkpoint text NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DELETE FROM operations WHERE tenant_id IS NULL;
+ALTER TABLE operations ALTER COLUMN tenant_id SET NOT NULL;
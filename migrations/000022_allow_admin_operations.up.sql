@@ -0,0 +1,2 @@
+-- Operations the admin server starts, like backups, belong to no tenant
+ALTER TABLE operations ALTER COLUMN tenant_id DROP NOT NULL;
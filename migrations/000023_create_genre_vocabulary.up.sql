@@ -0,0 +1,13 @@
+-- The genres -genres-strict allows. Shared by every tenant, the names
+-- follow the lower case style of the genres already in use
+CREATE TABLE IF NOT EXISTS genre_vocabulary (
+    name text PRIMARY KEY
+);
+
+INSERT INTO genre_vocabulary (name) VALUES
+    ('action'), ('adventure'), ('animation'), ('biography'), ('comedy'),
+    ('crime'), ('documentary'), ('drama'), ('family'), ('fantasy'),
+    ('film-noir'), ('history'), ('horror'), ('music'), ('musical'),
+    ('mystery'), ('romance'), ('sci-fi'), ('short'), ('sport'),
+    ('thriller'), ('war'), ('western')
+ON CONFLICT DO NOTHING;
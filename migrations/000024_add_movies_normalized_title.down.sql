@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_normalized_title_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS normalized_title;
@@ -0,0 +1,9 @@
+-- Titles are stored in NFC from now on, the ones from before are brought
+-- in line. normalized_title is for matching titles that only differ in
+-- case or in compatibility characters, like the ligature in "ﬁve"
+UPDATE movies SET title = normalize(title, NFC) WHERE title IS NOT NFC NORMALIZED;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS normalized_title text
+    GENERATED ALWAYS AS (lower(normalize(title, NFKC))) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_normalized_title_idx ON movies (tenant_id, normalized_title);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS movies_tenant_year_runtime_idx;
@@ -0,0 +1,3 @@
+-- For ?year_min= and ?runtime_min= and friends, browsing by decade
+-- usually narrows the runtime down as well
+CREATE INDEX IF NOT EXISTS movies_tenant_year_runtime_idx ON movies (tenant_id, year, runtime) WHERE deleted_at IS NULL;
@@ -0,0 +1,4 @@
+DROP MATERIALIZED VIEW IF EXISTS catalog_stats_refreshed;
+DROP MATERIALIZED VIEW IF EXISTS catalog_trending;
+DROP MATERIALIZED VIEW IF EXISTS catalog_revenue_by_year;
+DROP MATERIALIZED VIEW IF EXISTS catalog_facets;
@@ -0,0 +1,42 @@
+-- Precomputed answers for the analytics endpoints when nothing narrows
+-- them down. The scheduler refreshes them, catalog_stats_refreshed says
+-- when that last happened. Materialized views ignore row level security,
+-- every query on them has to keep its own tenant_id condition
+CREATE MATERIALIZED VIEW IF NOT EXISTS catalog_facets AS
+    SELECT m.tenant_id, 'genres' AS facet, g.name AS value, count(*) AS count
+    FROM movies m
+    JOIN movies_genres mg ON mg.movie_id = m.id
+    JOIN genres g ON g.id = mg.genre_id
+    WHERE m.deleted_at IS NULL
+    GROUP BY m.tenant_id, g.name
+    UNION ALL
+    SELECT tenant_id, 'year_decade', ((year / 10) * 10)::text, count(*)
+    FROM movies
+    WHERE deleted_at IS NULL
+    GROUP BY 1, 3;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS catalog_revenue_by_year AS
+    SELECT tenant_id, year, currency, sum(revenue) AS revenue, count(*) AS movies
+    FROM movies
+    WHERE deleted_at IS NULL AND revenue > 0
+    GROUP BY tenant_id, year, currency;
+
+-- Views per trending window as of the refresh. Deleted movies and tenants
+-- are sorted out when reading, together with the movie columns
+CREATE MATERIALIZED VIEW IF NOT EXISTS catalog_trending AS
+    SELECT movie_id,
+        coalesce(sum(views) FILTER (WHERE day > CURRENT_DATE - 1), 0) AS day_views,
+        coalesce(sum(views) FILTER (WHERE day > CURRENT_DATE - 7), 0) AS week_views,
+        sum(views) AS month_views
+    FROM movie_stats
+    WHERE day > CURRENT_DATE - 30
+    GROUP BY movie_id;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS catalog_stats_refreshed AS
+    SELECT now() AS refreshed_at;
+
+-- REFRESH ... CONCURRENTLY needs a unique index, without it readers
+-- would wait for every refresh
+CREATE UNIQUE INDEX IF NOT EXISTS catalog_facets_key ON catalog_facets (tenant_id, facet, value);
+CREATE UNIQUE INDEX IF NOT EXISTS catalog_revenue_by_year_key ON catalog_revenue_by_year (tenant_id, year, currency);
+CREATE UNIQUE INDEX IF NOT EXISTS catalog_trending_key ON catalog_trending (movie_id);
@@ -0,0 +1,22 @@
+ALTER TABLE outbox_events RENAME TO outbox_events_partitioned;
+ALTER TABLE outbox_events_partitioned RENAME CONSTRAINT outbox_events_pkey TO outbox_events_partitioned_pkey;
+DROP INDEX IF EXISTS outbox_events_unpublished_idx;
+
+CREATE TABLE outbox_events (
+    id bigint PRIMARY KEY DEFAULT nextval('outbox_events_id_seq'),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    type text NOT NULL,
+    payload jsonb NOT NULL,
+    published_at timestamp(0) with time zone
+);
+
+ALTER SEQUENCE outbox_events_id_seq OWNED BY outbox_events.id;
+
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL;
+
+INSERT INTO outbox_events SELECT id, created_at, type, payload, published_at FROM outbox_events_partitioned;
+
+-- Takes every partition with it
+DROP TABLE outbox_events_partitioned;
+
+DROP FUNCTION IF EXISTS create_monthly_partition(regclass, date);
@@ -0,0 +1,61 @@
+-- outbox_events only ever grows at the end and is cleaned up by age, so
+-- it's split into one partition per month. Dropping a month is cheap,
+-- deleting its rows one by one left the table bloated.
+-- create_monthly_partition is what the rotate-event-partitions task uses
+-- to create the months ahead, it does nothing for months that exist
+CREATE OR REPLACE FUNCTION create_monthly_partition(parent regclass, month date) RETURNS text
+LANGUAGE plpgsql AS $$
+DECLARE
+    start date := date_trunc('month', month);
+    name text := parent::text || '_p' || to_char(start, 'YYYYMM');
+BEGIN
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF %s FOR VALUES FROM (%L) TO (%L)',
+        name, parent,
+        start::timestamp AT TIME ZONE 'UTC',
+        (start + interval '1 month')::timestamp AT TIME ZONE 'UTC');
+    RETURN name;
+END
+$$;
+
+ALTER TABLE outbox_events RENAME TO outbox_events_unpartitioned;
+ALTER TABLE outbox_events_unpartitioned RENAME CONSTRAINT outbox_events_pkey TO outbox_events_unpartitioned_pkey;
+DROP INDEX IF EXISTS outbox_events_unpublished_idx;
+
+-- The partition key has to be part of the primary key
+CREATE TABLE outbox_events (
+    id bigint NOT NULL DEFAULT nextval('outbox_events_id_seq'),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    type text NOT NULL,
+    payload jsonb NOT NULL,
+    published_at timestamp(0) with time zone,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- Keeps the sequence alive when the old table is dropped below
+ALTER SEQUENCE outbox_events_id_seq OWNED BY outbox_events.id;
+
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL;
+
+-- Catches events when the months ahead weren't created in time. Rows
+-- there are never dropped, and block creating the month they belong to
+CREATE TABLE IF NOT EXISTS outbox_events_default PARTITION OF outbox_events DEFAULT;
+
+DO $$
+DECLARE
+    month date;
+BEGIN
+    FOR month IN
+        SELECT generate_series(
+            date_trunc('month', coalesce((SELECT min(created_at) FROM outbox_events_unpartitioned), now()) AT TIME ZONE 'UTC'),
+            date_trunc('month', now() AT TIME ZONE 'UTC') + interval '2 months',
+            interval '1 month')
+    LOOP
+        PERFORM create_monthly_partition('outbox_events', month);
+    END LOOP;
+END
+$$;
+
+INSERT INTO outbox_events (id, created_at, type, payload, published_at)
+SELECT id, created_at, type, payload, published_at FROM outbox_events_unpartitioned;
+
+DROP TABLE outbox_events_unpartitioned;
@@ -0,0 +1,7 @@
+-- A movie merged into another is deleted and points at the one it was
+-- merged into, GET on it redirects there. It goes when that one is purged.
+-- Deferred, so restoring a backup can insert the movies in any order
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS merged_into bigint
+    REFERENCES movies ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED;
+
+CREATE INDEX IF NOT EXISTS movies_merged_into_idx ON movies (merged_into) WHERE merged_into IS NOT NULL;
@@ -0,0 +1,11 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS merged_into bigint
+    REFERENCES movies ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED;
+
+CREATE INDEX IF NOT EXISTS movies_merged_into_idx ON movies (merged_into) WHERE merged_into IS NOT NULL;
+
+-- Only redirects of movies that weren't purged yet have a row to go back to
+UPDATE movies SET merged_into = r.new_id
+FROM movie_redirects r
+WHERE movies.id = r.old_id;
+
+DROP TABLE IF EXISTS movie_redirects;
@@ -0,0 +1,26 @@
+-- Where the ids of movies that are gone went, e.g. the duplicates that
+-- were merged. GET and PATCH on an old id get a 308 to the new one.
+-- old_id has no foreign key, the movie it was can be purged and the
+-- redirect still works. It goes when the movie it points at goes
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    old_id bigint PRIMARY KEY,
+    new_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    tenant_id bigint NOT NULL REFERENCES tenants ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- For pointing the old redirects along when new_id moves too
+CREATE INDEX IF NOT EXISTS movie_redirects_new_id_idx ON movie_redirects (new_id);
+
+ALTER TABLE movie_redirects ENABLE ROW LEVEL SECURITY;
+ALTER TABLE movie_redirects FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON movie_redirects
+    USING (current_tenant_id() IS NULL OR tenant_id = current_tenant_id());
+
+-- movies.merged_into was the first take on this, merged movies only
+INSERT INTO movie_redirects (old_id, new_id, tenant_id)
+SELECT id, merged_into, tenant_id FROM movies WHERE merged_into IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+DROP INDEX IF EXISTS movies_merged_into_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS merged_into;
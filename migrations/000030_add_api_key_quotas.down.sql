@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS api_key_usage;
+
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_plan_check;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS plan;
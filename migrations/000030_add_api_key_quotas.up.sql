@@ -0,0 +1,12 @@
+-- Every key is on a plan, the plans' quotas are in the code
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS plan text NOT NULL DEFAULT 'free';
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_plan_check CHECK (plan IN ('free', 'pro'));
+
+-- Requests a key made per day, the month is the sum of its days.
+-- The API counts in memory and adds to these every few seconds
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    api_key_id bigint NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+    day date NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day)
+);
@@ -0,0 +1,11 @@
+-- Requests of every key rolled up per day and route, for the usage
+-- endpoint. Route is the registered pattern, e.g. GET /v1/movies/{id},
+-- errors are the responses with a status of 400 or more
+CREATE TABLE IF NOT EXISTS api_key_metrics (
+    api_key_id bigint NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+    day date NOT NULL,
+    route text NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    errors bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day, route)
+);
@@ -0,0 +1,6 @@
+-- Title searches match normalized_title, so "ﬁve" finds "Five". The
+-- indexes on title itself stay for type-ahead suggestions
+DROP INDEX IF EXISTS movies_title_idx;
+
+CREATE INDEX IF NOT EXISTS movies_normalized_title_fts_idx ON movies USING GIN (to_tsvector('simple', normalized_title));
+CREATE INDEX IF NOT EXISTS movies_normalized_title_trgm_idx ON movies USING GIN (normalized_title gin_trgm_ops);
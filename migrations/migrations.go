@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations so the binary can apply
+// them without the files on disk. They keep the golang-migrate layout,
+// so its CLI still works on this directory too
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS